@@ -105,6 +105,9 @@ func seedTestData(db *gorm.DB) {
 		if err := db.Create(&p).Error; err != nil {
 			log.Printf("Failed to create program: %v", err)
 		}
+		if err := db.Exec("INSERT INTO facility_programs (facility_id, program_id) SELECT id, ? FROM facilities", p.ID).Error; err != nil {
+			log.Printf("Failed to publish program: %v", err)
+		}
 	}
 	var milestones []models.Milestone
 	mstones, err := os.ReadFile("backend/tests/test_data/milestones.json")
@@ -126,7 +129,7 @@ func seedTestData(db *gorm.DB) {
 			// all test programs are open_enrollment
 			enrollment := models.Milestone{
 				ProgramID:   prog.ID,
-				Type:        models.Enrollment,
+				Type:        models.EnrollmentMilestone,
 				UserID:      user.ID,
 				IsCompleted: true,
 				ExternalID:  fmt.Sprintf("%d", rand.Intn(1000)),
@@ -136,6 +139,11 @@ func seedTestData(db *gorm.DB) {
 				log.Printf("Failed to create enrollment milestone: %v", err)
 				continue
 			}
+			started := prog.CreatedAt
+			rosterEntry := models.Enrollment{UserID: user.ID, ProgramID: prog.ID, Status: models.EnrollmentActive, StartDate: &started}
+			if err := db.Create(&rosterEntry).Error; err != nil {
+				log.Printf("Failed to create enrollment: %v", err)
+			}
 			startTime := 0
 			for i := 0; i < 365; i++ {
 				if i%5 == 0 {
@@ -169,6 +177,9 @@ func seedTestData(db *gorm.DB) {
 				if err := db.Create(&outcome).Error; err != nil {
 					log.Printf("Failed to create outcome: %v", err)
 				}
+				if err := db.Model(&rosterEntry).Update("status", models.EnrollmentCompleted).Error; err != nil {
+					log.Printf("Failed to complete enrollment: %v", err)
+				}
 			} else {
 				newMilestone := models.Milestone{
 					ProgramID:   prog.ID,
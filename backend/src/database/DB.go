@@ -35,6 +35,21 @@ var TableList = []interface{}{
 	&models.UserFavorite{},
 	&models.Facility{},
 	&models.OpenContentProvider{},
+	&models.SyncJob{},
+	&models.SyncJobRun{},
+	&models.SyncCursor{},
+	&models.ImportReport{},
+	&models.RoleAssignment{},
+	&models.Enrollment{},
+	&models.EnrollmentRequest{},
+	&models.AuditLog{},
+	&models.Transcript{},
+	&models.CertificateTemplate{},
+	&models.IssuedCertificate{},
+	&models.Provisioning{},
+	&models.ResidentEvent{},
+	&models.FacilityTransfer{},
+	&models.Notification{},
 }
 
 func InitDB(isTesting bool) *DB {
@@ -61,6 +76,7 @@ func InitDB(isTesting bool) *DB {
 		}
 		log.Println("Connected to the PostgreSQL database")
 	}
+	registerFacilityScope(db)
 	database = &DB{Conn: db}
 	Migrate(db)
 	SeedDefaultData(db, isTesting)
@@ -114,7 +130,7 @@ func SeedDefaultData(db *gorm.DB, isTesting bool) {
 			log.Fatalf("Failed to create left menu links: %v", err)
 		}
 	}
-	procedures := []string{DailyActivityProc, CreateOutcomeTriggerFunction}
+	procedures := []string{DailyActivityProc, CreateOutcomeTriggerFunction, AuditLogAppendOnlyTrigger}
 	if !isTesting {
 		for _, proc := range procedures {
 			if err := db.Exec(proc).Error; err != nil {
@@ -131,12 +147,105 @@ const defaultLeftMenuLinks = `[{"name":"Unlocked Labs","rank":1,"links":[{"Unloc
 * Register Migrations here
 **/
 func Migrate(db *gorm.DB) {
+	if err := prepareNaturalKeys(db); err != nil {
+		log.Fatal("Failed to remove duplicate rows before migrating: ", err)
+	}
+	publishedPrograms := db.Migrator().HasTable("facility_programs")
+	hasEnrollments := db.Migrator().HasTable(&models.Enrollment{})
+	hasUserStatus := db.Migrator().HasColumn(&models.User{}, "status")
 	for _, table := range TableList {
 		log.Printf("Migrating %T table...", table)
 		if err := db.AutoMigrate(table); err != nil {
 			log.Fatal("Failed to migrate table: ", err)
 		}
 	}
+	if !publishedPrograms {
+		// every program used to be in every facility's catalogue, so they stay published everywhere
+		if err := db.Exec(`INSERT INTO facility_programs (facility_id, program_id)
+			SELECT f.id, p.id FROM facilities f CROSS JOIN programs p WHERE p.deleted_at IS NULL`).Error; err != nil {
+			log.Fatal("Failed to publish existing programs: ", err)
+		}
+	}
+	if !hasEnrollments {
+		if err := backfillEnrollments(db); err != nil {
+			log.Fatal("Failed to create enrollments for existing activity: ", err)
+		}
+	}
+	if !hasUserStatus && db.Migrator().HasColumn(&models.User{}, "deactivated_at") {
+		// released users were marked with deactivated_at, which status replaced (the column is left, unused)
+		if err := db.Exec(`UPDATE users SET status = ?, status_changed_at = deactivated_at WHERE deactivated_at IS NOT NULL`, models.UserReleased).Error; err != nil {
+			log.Fatal("Failed to set the status of released users: ", err)
+		}
+	}
+	if err := (&DB{Conn: db}).BackfillDefaultSyncJobs(); err != nil {
+		log.Fatal("Failed to create default sync jobs: ", err)
+	}
+}
+
+/**
+* Enrollment used to be inferred from a user having activity or milestones in a
+* program, so those users are enrolled from their first record: completed if
+* they have an outcome for the program, otherwise active.
+**/
+func backfillEnrollments(db *gorm.DB) error {
+	return db.Exec(`INSERT INTO enrollments (user_id, program_id, status, start_date, created_at, updated_at)
+		SELECT r.user_id, r.program_id,
+			CASE WHEN EXISTS (SELECT 1 FROM outcomes o WHERE o.user_id = r.user_id AND o.program_id = r.program_id AND o.deleted_at IS NULL)
+				THEN ? ELSE ? END,
+			MIN(r.created_at), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM (SELECT user_id, program_id, created_at FROM activities WHERE deleted_at IS NULL
+			UNION ALL SELECT user_id, program_id, created_at FROM milestones WHERE deleted_at IS NULL) r
+		GROUP BY r.user_id, r.program_id`, models.EnrollmentCompleted, models.EnrollmentActive).Error
+}
+
+/**
+* Imports used to insert milestones and activity on every run, so an existing
+* database can have duplicates of the rows which are now unique. Before the
+* unique indexes are created, the duplicate milestones are removed (keeping the
+* latest), and the activity of each piece of content is merged into one row per day.
+* A provider could also be given the same sync job twice, the first one is kept.
+**/
+func prepareNaturalKeys(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if migrator.HasTable(&models.SyncJob{}) && !migrator.HasIndex(&models.SyncJob{}, "idx_sync_jobs_provider_type") {
+		log.Println("Removing duplicate sync jobs...")
+		if err := db.Exec(`UPDATE sync_jobs SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND id NOT IN
+			(SELECT MIN(id) FROM sync_jobs WHERE deleted_at IS NULL GROUP BY provider_platform_id, type)`).Error; err != nil {
+			return err
+		}
+	}
+	if migrator.HasTable(&models.Milestone{}) && !migrator.HasIndex(&models.Milestone{}, "idx_milestones_natural_key") {
+		log.Println("Removing duplicate milestones...")
+		if err := db.Exec(`DELETE FROM milestones WHERE id NOT IN
+			(SELECT MAX(id) FROM milestones GROUP BY user_id, program_id, external_id, type)`).Error; err != nil {
+			return err
+		}
+	}
+	if !migrator.HasTable(&models.Activity{}) || migrator.HasIndex(&models.Activity{}, "idx_activities_natural_key") {
+		return nil
+	}
+	if !migrator.HasColumn(&models.Activity{}, "ActivityDate") {
+		if err := migrator.AddColumn(&models.Activity{}, "ActivityDate"); err != nil {
+			return err
+		}
+	}
+	log.Println("Merging duplicate activity...")
+	backfill := `UPDATE activities SET activity_date = DATE_TRUNC('day', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' WHERE activity_date IS NULL`
+	if db.Dialector.Name() == "sqlite" {
+		backfill = `UPDATE activities SET activity_date = DATE(created_at) WHERE activity_date IS NULL`
+	}
+	if err := db.Exec(backfill).Error; err != nil {
+		return err
+	}
+	// the latest row of the day keeps the total, and the sum of the day's deltas
+	if err := db.Exec(`UPDATE activities SET time_delta = merged.time_delta, total_time = merged.total_time
+		FROM (SELECT MAX(id) AS id, SUM(time_delta) AS time_delta, MAX(total_time) AS total_time FROM activities
+			GROUP BY user_id, program_id, external_id, activity_date HAVING COUNT(*) > 1) AS merged
+		WHERE activities.id = merged.id`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`DELETE FROM activities WHERE id NOT IN
+		(SELECT MAX(id) FROM activities GROUP BY user_id, program_id, external_id, activity_date)`).Error
 }
 
 func (db *DB) SeedTestData() {
@@ -161,9 +270,10 @@ func (db *DB) SeedTestData() {
 	if err := json.Unmarshal(users, &user); err != nil {
 		log.Fatalf("Failed to unmarshal test data: %v", err)
 	}
-	for idx, u := range user {
+	for idx := range user {
+		u := &user[idx]
 		log.Printf("Creating user %s", u.Username)
-		if err := db.Conn.Create(&u).Error; err != nil {
+		if err := db.Conn.Create(u).Error; err != nil {
 			log.Fatalf("Failed to create user: %v", err)
 		}
 		for i := 0; i < len(platform); i++ {
@@ -186,10 +296,13 @@ func (db *DB) SeedTestData() {
 	if err := json.Unmarshal(progs, &programs); err != nil {
 		log.Fatalf("Failed to unmarshal test data: %v", err)
 	}
-	for _, p := range programs {
-		if err := db.Conn.Create(&p).Error; err != nil {
+	for idx := range programs {
+		if err := db.Conn.Create(&programs[idx]).Error; err != nil {
 			log.Fatalf("Failed to create program: %v", err)
 		}
+		if err := db.PublishProgram(int(programs[idx].ID), 1); err != nil {
+			log.Fatalf("Failed to publish program: %v", err)
+		}
 	}
 	var milestones []models.Milestone
 	mstones, err := os.ReadFile("test_data/milestones.json")
@@ -207,6 +320,11 @@ func (db *DB) SeedTestData() {
 	outcomes := []string{"completion", "grade", "certificate", "pathway_completion"}
 	for _, user := range user {
 		for _, prog := range programs {
+			started := time.Now().AddDate(-1, 0, 0)
+			enrollment := models.Enrollment{UserID: user.ID, ProgramID: prog.ID, Status: models.EnrollmentActive, StartDate: &started}
+			if err := db.Conn.Create(&enrollment).Error; err != nil {
+				log.Fatalf("Failed to create enrollment: %v", err)
+			}
 			for i := 0; i < 365; i++ {
 				if rand.Intn(100)%2 == 0 {
 					continue
@@ -243,6 +361,11 @@ func (db *DB) SeedTestData() {
 }
 
 const (
+	/**
+	* _total_time is the user's total time on the content (according to the provider), the
+	* delta is the time since the last activity of a previous day. Calling it again on the
+	* same day updates that day's activity, rather than recording the time twice.
+	**/
 	DailyActivityProc string = `CREATE OR REPLACE FUNCTION public.insert_daily_activity(
     _user_id INT,
     _program_id INT,
@@ -252,16 +375,19 @@ const (
     RETURNS VOID AS $$
     DECLARE
         prev_total_time INT;
+        today TIMESTAMPTZ := DATE_TRUNC('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
     BEGIN
         SELECT total_time INTO prev_total_time FROM activities
-        WHERE user_id = _user_id AND program_id = _program_id
-        ORDER BY created_at DESC LIMIT 1;
+        WHERE user_id = _user_id AND program_id = _program_id AND external_id = _external_id AND activity_date < today
+        ORDER BY activity_date DESC LIMIT 1;
 
         IF prev_total_time IS NULL THEN
             prev_total_time := 0;
         END IF;
-    INSERT INTO activities (user_id, program_id, type, total_time, time_delta, external_id, created_at, updated_at)
-    VALUES (_user_id, _program_id, _type, _total_time, _total_time - prev_total_time, _external_id, NOW(), NOW());
+    INSERT INTO activities (user_id, program_id, type, total_time, time_delta, external_id, activity_date, created_at, updated_at)
+    VALUES (_user_id, _program_id, _type, _total_time, GREATEST(_total_time - prev_total_time, 0), _external_id, today, NOW(), NOW())
+    ON CONFLICT (user_id, program_id, external_id, activity_date)
+    DO UPDATE SET total_time = EXCLUDED.total_time, time_delta = EXCLUDED.time_delta, updated_at = NOW();
     END;
     $$ LANGUAGE plpgsql;`
 
@@ -299,4 +425,20 @@ CREATE TRIGGER milestone_completion_trigger
 AFTER INSERT ON milestones
 FOR EACH ROW
 EXECUTE FUNCTION check_milestone_completion();`
+
+	// the audit log is append only, even for queries which don't go through the models
+	AuditLogAppendOnlyTrigger string = `
+CREATE OR REPLACE FUNCTION reject_audit_log_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit log entries cannot be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_logs;
+
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_logs
+FOR EACH STATEMENT
+EXECUTE FUNCTION reject_audit_log_change();`
 )
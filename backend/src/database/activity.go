@@ -256,6 +256,7 @@ func (db *DB) GetStudentDashboardInfo(userID int, facilityID uint) (models.UserD
             ELSE COUNT(milestones.id) * 100.0 / p.total_progress_milestones
         END as course_progress`).
 		Joins("LEFT JOIN provider_platforms pp ON p.provider_platform_id = pp.id").
+		Joins(publishedProgramsJoin, facilityID).
 		Joins("LEFT JOIN milestones ON milestones.program_id = p.id AND milestones.user_id = ?", userID).
 		Joins("LEFT JOIN outcomes o ON o.program_id = p.id AND o.user_id = ?", userID).
 		Where("p.id IN (SELECT program_id FROM activities WHERE user_id = ?)", userID).
@@ -274,6 +275,7 @@ func (db *DB) GetStudentDashboardInfo(userID int, facilityID uint) (models.UserD
 	err = db.Conn.Table("activities a").
 		Select("p.name as program_name").
 		Joins("JOIN programs p ON a.program_id = p.id").
+		Joins(publishedProgramsJoin, facilityID).
 		Joins("JOIN users u ON a.user_id = u.id").
 		Where("u.facility_id = ?", facilityID).
 		Group("p.id").
@@ -284,9 +286,7 @@ func (db *DB) GetStudentDashboardInfo(userID int, facilityID uint) (models.UserD
 		log.Fatalf("Query failed: %v", err)
 	}
 
-	// then get the users current enrollments
-	// which is the program.alt_name, program.name, provider_platform.name as provider_platform_name (join on provider_platform_id = provider_platform.id), program.external_url,
-	// and acitvity.total_activity_time for the last 7 days of activity where activity.user_id = userID and activity.program_id = program.id
+	// then get the users current enrollments, with their activity in each program over the last 7 days
 	results := []result{}
 
 	err = db.Conn.Table("programs p").
@@ -296,46 +296,17 @@ func (db *DB) GetStudentDashboardInfo(userID int, facilityID uint) (models.UserD
 				pp.name as provider_platform_name,
 				p.external_url,
 				DATE(a.created_at) as date,
-				SUM(a.time_delta) as time_delta`).
+				COALESCE(SUM(a.time_delta), 0) as time_delta`).
 		Joins("JOIN provider_platforms pp ON p.provider_platform_id = pp.id").
-		Joins("JOIN activities a ON a.program_id = p.id").
-		Joins("LEFT JOIN outcomes o ON o.program_id = p.id AND o.user_id = ?", userID).
-		Where("a.user_id = ? AND a.created_at >= ?", userID, time.Now().AddDate(0, 0, -7)).
-		Where("o.type IS NULL").
+		Joins(publishedProgramsJoin, facilityID).
+		Joins("JOIN enrollments e ON e.program_id = p.id AND e.user_id = ? AND e.status = ? AND e.deleted_at IS NULL", userID, models.EnrollmentActive).
+		Joins("LEFT JOIN activities a ON a.program_id = p.id AND a.user_id = ? AND a.created_at >= ?", userID, time.Now().AddDate(0, 0, -7)).
 		Group("p.id, DATE(a.created_at), pp.name").
 		Find(&results).Error
 	if err != nil {
 		log.Fatalf("Query failed: %v", err)
 	}
 	enrollments := dashboardHelper(results)
-	if len(enrollments) == 0 {
-		log.Println("No enrollments found")
-		var newEnrollments []struct {
-			ProgramID            uint
-			AltName              string
-			Name                 string
-			ProviderPlatformName string
-			ExternalURL          string
-		}
-		err = db.Conn.Table("programs p").Select(`p.id as program_id, p.alt_name, p.name, pp.name as provider_platform_name, p.external_url`).
-			Joins(`JOIN provider_platforms pp ON p.provider_platform_id = pp.id`).
-			Joins(`LEFT JOIN milestones m on m.program_id = p.id`).Where(`m.user_id`, userID).Find(&newEnrollments).Error
-		if err != nil {
-			log.Fatalf("Query failed: %v", err)
-		}
-		for idx, enrollment := range newEnrollments {
-			if idx == 7 {
-				break
-			}
-			enrollments = append(enrollments, models.CurrentEnrollment{
-				AltName:              enrollment.AltName,
-				Name:                 enrollment.Name,
-				ProviderPlatformName: enrollment.ProviderPlatformName,
-				ExternalURL:          enrollment.ExternalURL,
-			})
-			log.Printf("enrollments: %v", enrollments)
-		}
-	}
 
 	// get activity for past 7 days
 	var activities []models.RecentActivity
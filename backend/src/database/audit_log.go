@@ -0,0 +1,71 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditLogFilter struct {
+	Action     models.AuditAction
+	ActorID    uint
+	TargetType string
+	TargetID   uint
+	FacilityID uint
+	From       *time.Time
+	To         *time.Time
+}
+
+func (db *DB) CreateAuditLog(entry *models.AuditLog) error {
+	return db.Conn.Create(entry).Error
+}
+
+func (db *DB) auditLogQuery(filter AuditLogFilter) *gorm.DB {
+	query := db.Conn.Model(&models.AuditLog{})
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.FacilityID != 0 {
+		query = query.Where("facility_id = ?", filter.FacilityID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	return query
+}
+
+func (db *DB) GetAuditLogs(page, perPage int, filter AuditLogFilter) (int64, []models.AuditLog, error) {
+	var (
+		entries []models.AuditLog
+		total   int64
+	)
+	query := db.auditLogQuery(filter)
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&entries).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, entries, nil
+}
+
+// hands every matching entry (oldest first) to handle, in batches, for exports
+func (db *DB) EachAuditLog(filter AuditLogFilter, handle func(entries []models.AuditLog) error) error {
+	var entries []models.AuditLog
+	return db.auditLogQuery(filter).FindInBatches(&entries, 500, func(tx *gorm.DB, batch int) error {
+		return handle(entries)
+	}).Error
+}
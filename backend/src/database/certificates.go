@@ -0,0 +1,77 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the program's template, or the default one if it has none
+func (db *DB) GetCertificateTemplate(programID uint) (*models.CertificateTemplate, error) {
+	var template models.CertificateTemplate
+	err := db.Conn.First(&template, "program_id = ?", programID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.DefaultCertificateTemplate(programID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (db *DB) SaveCertificateTemplate(template *models.CertificateTemplate) error {
+	template.NormalizeOutcomeTypes()
+	return db.Conn.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "program_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"outcome_types", "title", "body", "signatory", "signatory_title", "updated_at"}),
+	}).Create(template).Error
+}
+
+/**
+* Outcomes which earn a certificate under their program's template (or, without
+* one, certificate outcomes) but don't have one yet, oldest first
+**/
+func (db *DB) GetOutcomesAwaitingCertificates() ([]models.Outcome, error) {
+	outcomes := []models.Outcome{}
+	err := db.Conn.Table("outcomes o").Select("o.*").
+		Joins("LEFT JOIN certificates c ON c.outcome_id = o.id").
+		Joins("LEFT JOIN certificate_templates t ON t.program_id = o.program_id AND t.deleted_at IS NULL").
+		Where("c.id IS NULL AND o.deleted_at IS NULL").
+		Where("(t.id IS NULL AND o.type = ?) OR (t.id IS NOT NULL AND ',' || t.outcome_types || ',' LIKE '%,' || o.type || ',%')", models.Certificate).
+		Order("o.id").
+		Find(&outcomes).Error
+	return outcomes, err
+}
+
+// a second certificate for the same outcome is ignored, so issuing twice is harmless
+func (db *DB) CreateCertificate(cert *models.IssuedCertificate) (bool, error) {
+	result := db.Conn.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "outcome_id"}}, DoNothing: true}).Create(cert)
+	return result.RowsAffected > 0, result.Error
+}
+
+// with its outcome, which is nil if the outcome has since been deleted
+func (db *DB) GetCertificateByCode(code string) (*models.IssuedCertificate, error) {
+	var cert models.IssuedCertificate
+	if err := db.Conn.Preload("Outcome").First(&cert, "code = ?", code).Error; err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (db *DB) GetCertificateByID(id uint) (*models.IssuedCertificate, error) {
+	var cert models.IssuedCertificate
+	if err := db.Conn.First(&cert, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (db *DB) GetCertificatesForUser(userID uint) ([]models.IssuedCertificate, error) {
+	certs := []models.IssuedCertificate{}
+	if err := db.Conn.Where("user_id = ?", userID).Order("issued_at DESC").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
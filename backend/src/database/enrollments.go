@@ -0,0 +1,185 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EnrollmentFilter struct {
+	UserID    uint
+	ProgramID uint
+	Status    models.EnrollmentStatus
+}
+
+func (db *DB) GetEnrollments(page, perPage int, filter EnrollmentFilter) (int64, []models.Enrollment, error) {
+	var (
+		enrollments []models.Enrollment
+		total       int64
+	)
+	query := db.Conn.Model(&models.Enrollment{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ProgramID != 0 {
+		query = query.Where("program_id = ?", filter.ProgramID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Preload("User").Preload("Program").Order("id").Offset((page - 1) * perPage).Limit(perPage).Find(&enrollments).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, enrollments, nil
+}
+
+func (db *DB) GetEnrollmentByID(id uint) (*models.Enrollment, error) {
+	var enrollment models.Enrollment
+	if err := db.Conn.First(&enrollment, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+func (db *DB) GetEnrollment(userID, programID uint) (*models.Enrollment, error) {
+	var enrollment models.Enrollment
+	if err := db.Conn.First(&enrollment, "user_id = ? AND program_id = ?", userID, programID).Error; err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+var ErrAlreadyEnrolled = errors.New("user is already enrolled in this program")
+
+func (db *DB) CreateEnrollment(enrollment *models.Enrollment) error {
+	var count int64
+	if err := db.Conn.Model(&models.Enrollment{}).Where("user_id = ? AND program_id = ?", enrollment.UserID, enrollment.ProgramID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyEnrolled
+	}
+	if enrollment.Status == "" {
+		enrollment.Status = models.EnrollmentPending
+	}
+	return db.Conn.Create(enrollment).Error
+}
+
+// only the status and dates of an enrollment change, the user and program are fixed
+func (db *DB) UpdateEnrollment(enrollment *models.Enrollment) error {
+	return db.Conn.Model(enrollment).Select("status", "start_date", "end_date").Updates(enrollment).Error
+}
+
+// records the outcome of pushing the enrollment to the program's provider
+func (db *DB) RecordEnrollmentSync(enrollment *models.Enrollment) error {
+	return db.Conn.Model(enrollment).Select("external_id", "synced_at", "sync_error").Updates(enrollment).Error
+}
+
+// enrollments are removed outright, so the user can be enrolled again later
+func (db *DB) DeleteEnrollment(enrollment *models.Enrollment) error {
+	return db.Conn.Unscoped().Delete(enrollment).Error
+}
+
+func (db *DB) GetEnrollmentRequests(page, perPage int, filter EnrollmentFilter, status models.EnrollmentRequestStatus) (int64, []models.EnrollmentRequest, error) {
+	var (
+		requests []models.EnrollmentRequest
+		total    int64
+	)
+	query := db.Conn.Model(&models.EnrollmentRequest{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ProgramID != 0 {
+		query = query.Where("program_id = ?", filter.ProgramID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Preload("User").Preload("Program").Order("created_at").Offset((page - 1) * perPage).Limit(perPage).Find(&requests).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, requests, nil
+}
+
+func (db *DB) GetEnrollmentRequestByID(id uint) (*models.EnrollmentRequest, error) {
+	var request models.EnrollmentRequest
+	if err := db.Conn.First(&request, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+var ErrRequestPending = errors.New("there is already a request to join this program waiting for review")
+
+/**
+* Users who are already on the roster (other than withdrawn) can't ask to join
+* again, and only one request per program may wait for review at a time
+**/
+func (db *DB) CreateEnrollmentRequest(request *models.EnrollmentRequest) error {
+	var count int64
+	if err := db.Conn.Model(&models.Enrollment{}).Where("user_id = ? AND program_id = ? AND status <> ?", request.UserID, request.ProgramID, models.EnrollmentWithdrawn).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyEnrolled
+	}
+	if err := db.Conn.Model(&models.EnrollmentRequest{}).Where("user_id = ? AND program_id = ? AND status = ?", request.UserID, request.ProgramID, models.RequestPending).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRequestPending
+	}
+	request.Status = models.RequestPending
+	return db.Conn.Create(request).Error
+}
+
+var ErrRequestDecided = errors.New("this request has already been decided")
+
+/**
+* Records the decision on a request which is waiting for review. An approval
+* puts the user on the roster as active (re-enrolling them, if they had been
+* withdrawn), in the same transaction, and returns their enrollment.
+**/
+func (db *DB) DecideEnrollmentRequest(request *models.EnrollmentRequest, status models.EnrollmentRequestStatus, reviewerID *uint, note string) (*models.Enrollment, error) {
+	var enrollment *models.Enrollment
+	err := db.Conn.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.EnrollmentRequest{}).Where("id = ? AND status = ?", request.ID, models.RequestPending).
+			Updates(map[string]interface{}{"status": status, "reviewer_id": reviewerID, "review_note": note, "reviewed_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRequestDecided
+		}
+		request.Status, request.ReviewerID, request.ReviewNote, request.ReviewedAt = status, reviewerID, note, &now
+		if status != models.RequestApproved {
+			return nil
+		}
+		existing, err := (&DB{Conn: tx}).GetEnrollment(request.UserID, request.ProgramID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			enrollment = &models.Enrollment{UserID: request.UserID, ProgramID: request.ProgramID, Status: models.EnrollmentActive, StartDate: &now}
+			return tx.Create(enrollment).Error
+		} else if err != nil {
+			return err
+		}
+		enrollment = existing
+		if !enrollment.Status.CanTransitionTo(models.EnrollmentActive) {
+			return ErrAlreadyEnrolled
+		}
+		enrollment.Status, enrollment.StartDate, enrollment.EndDate = models.EnrollmentActive, &now, nil
+		return tx.Model(enrollment).Select("status", "start_date", "end_date").Updates(enrollment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/**
+* The filters of the reporting exports. FacilityID limits the export to the users
+* of a facility (0 is every facility), From and To to records from that range
+* (To is exclusive).
+**/
+type ExportFilter struct {
+	FacilityID uint       `json:"facility_id,omitempty"`
+	UserID     uint       `json:"user_id,omitempty"`
+	ProgramID  uint       `json:"program_id,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+}
+
+func (filter ExportFilter) apply(query *gorm.DB, dateColumn string) *gorm.DB {
+	if filter.FacilityID != 0 {
+		query = query.Where("u.facility_id = ?", filter.FacilityID)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("u.id = ?", filter.UserID)
+	}
+	if filter.From != nil {
+		query = query.Where(dateColumn+" >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where(dateColumn+" < ?", *filter.To)
+	}
+	return query
+}
+
+func (filter ExportFilter) applyProgram(query *gorm.DB, dateColumn string) *gorm.DB {
+	if filter.ProgramID != 0 {
+		query = query.Where("p.id = ?", filter.ProgramID)
+	}
+	return filter.apply(query, dateColumn)
+}
+
+// reads the query's results one row at a time, rather than loading them all
+func eachRow[T any](query *gorm.DB, handle func(row *T) error) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row T
+		if err := query.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		if err := handle(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+/**
+* The result of MIN() or MAX() over a timestamp, which postgres returns as a time
+* but sqlite as text
+**/
+type AggregateTime struct {
+	time.Time
+}
+
+func (at *AggregateTime) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		at.Time = time.Time{}
+		return nil
+	case time.Time:
+		at.Time = value
+		return nil
+	case []byte:
+		return at.parse(string(value))
+	case string:
+		return at.parse(value)
+	}
+	return fmt.Errorf("unable to scan %T into a time", value)
+}
+
+func (at AggregateTime) Value() (driver.Value, error) {
+	return at.Time, nil
+}
+
+func (at *AggregateTime) parse(text string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			at.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to parse %q as a time", text)
+}
+
+type UserExportRow struct {
+	ID        uint
+	Username  string
+	NameFirst string
+	NameLast  string
+	Email     string
+	Role      string
+	Facility  string
+	CreatedAt time.Time
+}
+
+// the date filters are on when the user was created
+func (db *DB) ExportUsers(filter ExportFilter, handle func(row *UserExportRow) error) error {
+	query := db.Conn.Table("users u").
+		Select("u.id, u.username, u.name_first, u.name_last, u.email, u.role, f.name AS facility, u.created_at").
+		Joins("LEFT JOIN facilities f ON f.id = u.facility_id").
+		Where("u.deleted_at IS NULL").
+		Order("u.id")
+	return eachRow(filter.apply(query, "u.created_at"), handle)
+}
+
+type EnrollmentExportRow struct {
+	ID        uint
+	UserID    uint
+	Username  string
+	NameFirst string
+	NameLast  string
+	Facility  string
+	Program   string
+	Status    string
+	StartDate *time.Time
+	EndDate   *time.Time
+}
+
+// the date filters are on when the enrollment started
+func (db *DB) ExportEnrollments(filter ExportFilter, handle func(row *EnrollmentExportRow) error) error {
+	query := db.Conn.Table("enrollments e").
+		Select("e.id, u.id AS user_id, u.username, u.name_first, u.name_last, f.name AS facility, p.name AS program, e.status, e.start_date, e.end_date").
+		Joins("JOIN users u ON u.id = e.user_id").
+		Joins("JOIN programs p ON p.id = e.program_id").
+		Joins("LEFT JOIN facilities f ON f.id = u.facility_id").
+		Where("e.deleted_at IS NULL AND u.deleted_at IS NULL").
+		Order("e.id")
+	return eachRow(filter.applyProgram(query, "e.start_date"), handle)
+}
+
+type ActivityExportRow struct {
+	UserID        uint
+	Username      string
+	NameFirst     string
+	NameLast      string
+	Facility      string
+	Program       string
+	Hours         float64
+	ActiveDays    int
+	FirstActivity AggregateTime
+	LastActivity  AggregateTime
+}
+
+// the hours each user spent on each program, on the days in the date range
+func (db *DB) ExportActivityHours(filter ExportFilter, handle func(row *ActivityExportRow) error) error {
+	query := db.Conn.Table("activities a").
+		Select(`u.id AS user_id, u.username, u.name_first, u.name_last, f.name AS facility, p.name AS program,
+			SUM(a.time_delta) / 3600.0 AS hours, COUNT(DISTINCT a.activity_date) AS active_days,
+			MIN(a.activity_date) AS first_activity, MAX(a.activity_date) AS last_activity`).
+		Joins("JOIN users u ON u.id = a.user_id").
+		Joins("JOIN programs p ON p.id = a.program_id").
+		Joins("LEFT JOIN facilities f ON f.id = u.facility_id").
+		Where("a.deleted_at IS NULL AND u.deleted_at IS NULL").
+		Group("u.id, u.username, u.name_first, u.name_last, f.name, p.id, p.name").
+		Order("u.id, p.id")
+	return eachRow(filter.applyProgram(query, "a.activity_date"), handle)
+}
+
+type MilestoneExportRow struct {
+	ID          uint
+	UserID      uint
+	Username    string
+	Facility    string
+	Program     string
+	Type        string
+	ExternalID  string
+	IsCompleted bool
+	CreatedAt   time.Time
+}
+
+func (db *DB) ExportMilestones(filter ExportFilter, handle func(row *MilestoneExportRow) error) error {
+	query := db.Conn.Table("milestones m").
+		Select("m.id, u.id AS user_id, u.username, f.name AS facility, p.name AS program, m.type, m.external_id, m.is_completed, m.created_at").
+		Joins("JOIN users u ON u.id = m.user_id").
+		Joins("JOIN programs p ON p.id = m.program_id").
+		Joins("LEFT JOIN facilities f ON f.id = u.facility_id").
+		Where("m.deleted_at IS NULL AND u.deleted_at IS NULL").
+		Order("m.id")
+	return eachRow(filter.applyProgram(query, "m.created_at"), handle)
+}
+
+type OutcomeExportRow struct {
+	ID        uint
+	UserID    uint
+	Username  string
+	Facility  string
+	Program   string
+	Type      string
+	Value     string
+	CreatedAt time.Time
+}
+
+func (db *DB) ExportOutcomes(filter ExportFilter, handle func(row *OutcomeExportRow) error) error {
+	query := db.Conn.Table("outcomes o").
+		Select("o.id, u.id AS user_id, u.username, f.name AS facility, p.name AS program, o.type, o.value, o.created_at").
+		Joins("JOIN users u ON u.id = o.user_id").
+		Joins("JOIN programs p ON p.id = o.program_id").
+		Joins("LEFT JOIN facilities f ON f.id = u.facility_id").
+		Where("o.deleted_at IS NULL AND u.deleted_at IS NULL").
+		Order("o.id")
+	return eachRow(filter.applyProgram(query, "o.created_at"), handle)
+}
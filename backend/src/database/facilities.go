@@ -3,6 +3,7 @@ package database
 import (
 	"UnlockEdv2/src/models"
 	"fmt"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -23,6 +24,20 @@ func (db *DB) GetFacilityByID(id int) (*models.Facility, error) {
 	return &facility, nil
 }
 
+// the facility named, ignoring case, or with the ID
+func (db *DB) FindFacility(nameOrID string) (*models.Facility, error) {
+	var facility models.Facility
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		if err := db.Conn.First(&facility, "id = ?", id).Error; err == nil {
+			return &facility, nil
+		}
+	}
+	if err := db.Conn.First(&facility, "LOWER(name) = LOWER(?)", nameOrID).Error; err != nil {
+		return nil, err
+	}
+	return &facility, nil
+}
+
 func (db *DB) CreateFacility(name string) (*models.Facility, error) {
 	log.Infoln("Creating facility:" + name)
 	facility := models.Facility{Name: name}
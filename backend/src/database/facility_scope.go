@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type facilityScopeKey struct{}
+
+/**
+* Tables holding the data of individual users, and the condition limiting them
+* to the users of a facility (%[1]s is the table, or its alias, %[2]s the placeholder)
+**/
+var facilityScopedTables = map[string]string{
+	"users":                  "%[1]s.facility_id = %[2]s",
+	"activities":             "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"outcomes":               "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"milestones":             "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"user_activities":        "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"provider_user_mappings": "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"favorites":              "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"role_assignments":       "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"enrollments":            "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"enrollment_requests":    "%[1]s.user_id IN (SELECT id FROM users WHERE facility_id = %[2]s)",
+	"audit_logs":             "%[1]s.facility_id = %[2]s",
+}
+
+/**
+* WithFacility returns a handle whose queries (and updates + deletes) only ever see the
+* users of the facility, and their data. Handlers serving a request use this, rather
+* than the unscoped handle, so that a user can never read across facilities.
+* Raw SQL is not scoped, callers of those queries check the user's facility first.
+**/
+func (db *DB) WithFacility(facilityID uint) *DB {
+	return &DB{Conn: db.Conn.WithContext(context.WithValue(db.Conn.Statement.Context, facilityScopeKey{}, facilityID))}
+}
+
+func registerFacilityScope(db *gorm.DB) {
+	callbacks := db.Callback()
+	for name, err := range map[string]error{
+		"query":  callbacks.Query().Before("gorm:query").Register("facility:scope", applyFacilityScope),
+		"row":    callbacks.Row().Before("gorm:row").Register("facility:scope", applyFacilityScope),
+		"update": callbacks.Update().Before("gorm:update").Register("facility:scope", applyFacilityScope),
+		"delete": callbacks.Delete().Before("gorm:delete").Register("facility:scope", applyFacilityScope),
+	} {
+		if err != nil {
+			log.Fatalf("unable to register facility scope for %s callbacks: %v", name, err)
+		}
+	}
+}
+
+func applyFacilityScope(tx *gorm.DB) {
+	stmt := tx.Statement
+	if stmt.Context == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	facilityID, ok := stmt.Context.Value(facilityScopeKey{}).(uint)
+	if !ok {
+		return
+	}
+	table, ref := stmt.Table, stmt.Table
+	if stmt.TableExpr != nil {
+		// e.g. Table("activities a"), where stmt.Table is the alias
+		if fields := strings.Fields(stmt.TableExpr.SQL); len(fields) > 0 {
+			table, ref = strings.Trim(fields[0], "`\""), fields[len(fields)-1]
+		}
+	}
+	condition, ok := facilityScopedTables[table]
+	if !ok {
+		return
+	}
+	// statements which are executed more than once (Count, then Find) are only scoped once
+	if _, scoped := stmt.Settings.LoadOrStore("facility:scoped", true); scoped {
+		return
+	}
+	scope := clause.Expr{SQL: fmt.Sprintf(condition, ref, "?"), Vars: []interface{}{facilityID}}
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			// existing conditions may contain OR, so they're grouped before adding the scope
+			c.Expression = clause.Where{Exprs: []clause.Expression{clause.And(where.Exprs...), scope}}
+			stmt.Clauses["WHERE"] = c
+			return
+		}
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{scope}})
+}
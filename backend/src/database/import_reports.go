@@ -0,0 +1,48 @@
+package database
+
+import "UnlockEdv2/src/models"
+
+func (db *DB) GetImportReports(page, perPage int, providerID uint, jobType models.JobType) (int64, []models.ImportReport, error) {
+	var (
+		reports []models.ImportReport
+		total   int64
+	)
+	query := db.Conn.Model(&models.ImportReport{})
+	if providerID != 0 {
+		query = query.Where("provider_platform_id = ?", providerID)
+	}
+	if jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("created_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&reports).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, reports, nil
+}
+
+func (db *DB) GetImportReportByID(id uint) (*models.ImportReport, error) {
+	var report models.ImportReport
+	if err := db.Conn.First(&report, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (db *DB) GetImportReportForRun(runID uint) (*models.ImportReport, error) {
+	var report models.ImportReport
+	if err := db.Conn.First(&report, "sync_job_run_id = ?", runID).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (db *DB) CreateImportReport(report *models.ImportReport) error {
+	return db.Conn.Create(report).Error
+}
+
+func (db *DB) UpdateImportReport(report *models.ImportReport) error {
+	return db.Conn.Save(report).Error
+}
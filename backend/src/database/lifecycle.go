@@ -0,0 +1,65 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// archived users aren't listed with the facility's users, staff find them here to restore them
+func (db *DB) GetArchivedUsers(page, perPage int, facilityID uint) (int64, []models.User, error) {
+	var total int64
+	users := []models.User{}
+	tx := db.Conn.Model(&models.User{}).Where("facility_id = ? AND status = ?", facilityID, models.UserArchived)
+	if err := tx.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Order("status_changed_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&users).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, users, nil
+}
+
+// the users who were archived before the cutoff, whose data is due to be purged
+func (db *DB) GetUsersArchivedBefore(cutoff time.Time) ([]models.User, error) {
+	users := []models.User{}
+	if err := db.Conn.Where("status = ? AND status_changed_at < ?", models.UserArchived, cutoff).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+/**
+* Removes the user for good, together with everything recorded about them (their
+* enrollments, progress and accounts in the providers). The audit log is kept, it
+* records who did what rather than what a learner did, and so are the certificates
+* and transcripts they were issued, which hold the name they were issued to and must
+* still verify; they are only detached from the user (and certificates from their
+* outcomes, those already removed being marked revoked first).
+**/
+func (db *DB) PurgeUserData(id uint) error {
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		revoked := tx.Model(&models.Outcome{}).Unscoped().Select("id").Where("user_id = ? AND deleted_at IS NOT NULL", id)
+		if err := tx.Model(&models.IssuedCertificate{}).Where("user_id = ? AND outcome_id IN (?)", id, revoked).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.IssuedCertificate{}).Where("user_id = ?", id).Updates(map[string]interface{}{"user_id": nil, "outcome_id": nil}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Transcript{}).Where("user_id = ?", id).Update("user_id", nil).Error; err != nil {
+			return err
+		}
+		for _, table := range []interface{}{
+			&models.Activity{}, &models.Milestone{},
+			&models.Outcome{}, &models.Enrollment{}, &models.EnrollmentRequest{}, &models.UserFavorite{},
+			&models.UserActivity{}, &models.RoleAssignment{}, &models.ProviderUserMapping{},
+			&models.FacilityTransfer{}, &models.Notification{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.User{}, "id = ?", id).Error
+	})
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"time"
+)
+
+func (db *DB) CreateNotifications(notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	return db.Conn.Create(&notifications).Error
+}
+
+func (db *DB) GetNotifications(page, perPage int, userID uint, unreadOnly bool) (int64, []models.Notification, error) {
+	var (
+		notifications []models.Notification
+		total         int64
+	)
+	query := db.Conn.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("created_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&notifications).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, notifications, nil
+}
+
+// marks the user's notification read, returning it
+func (db *DB) MarkNotificationRead(userID, id uint) (*models.Notification, error) {
+	var notification models.Notification
+	if err := db.Conn.First(&notification, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, err
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := db.Conn.Model(&notification).Update("read_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &notification, nil
+}
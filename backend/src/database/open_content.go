@@ -15,14 +15,14 @@ func (db *DB) GetOpenContent(all bool) ([]models.OpenContentProvider, error) {
 	return content, tx.Find(&content).Error
 }
 
-func (db *DB) ToggleContentProvider(id int) error {
+func (db *DB) ToggleContentProvider(id int) (*models.OpenContentProvider, error) {
 	var provider models.OpenContentProvider
-	if err := db.Conn.Find(&provider, "id = ?", id).Error; err != nil {
+	if err := db.Conn.First(&provider, "id = ?", id).Error; err != nil {
 		log.Errorln("unable to find conent provider with that ID")
-		return err
+		return nil, err
 	}
 	provider.CurrentlyEnabled = !provider.CurrentlyEnabled
-	return db.Conn.Save(&provider).Error
+	return &provider, db.Conn.Save(&provider).Error
 }
 
 func (db *DB) CreateContentProvider(url, thumbnail, description string, id int) error {
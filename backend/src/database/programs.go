@@ -2,6 +2,8 @@ package database
 
 import (
 	"UnlockEdv2/src/models"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func (db *DB) GetProgramByID(id int) (*models.Program, error) {
@@ -57,3 +59,33 @@ func (db *DB) GetProgramByProviderPlatformID(id int) ([]models.Program, error) {
 	}
 	return content, nil
 }
+
+// joined onto "programs p", limits the query to the programs published at the facility
+const publishedProgramsJoin = "JOIN facility_programs fp ON fp.program_id = p.id AND fp.facility_id = ?"
+
+func (db *DB) GetProgramFacilities(programID int) ([]models.Facility, error) {
+	facilities := []models.Facility{}
+	if err := db.Conn.Model(&models.Program{DatabaseFields: models.DatabaseFields{ID: uint(programID)}}).Association("Facilities").Find(&facilities); err != nil {
+		return nil, err
+	}
+	return facilities, nil
+}
+
+func (db *DB) IsProgramPublished(programID int, facilityID uint) bool {
+	var count int64
+	if err := db.Conn.Table("facility_programs").Where("program_id = ? AND facility_id = ?", programID, facilityID).Count(&count).Error; err != nil {
+		log.Errorf("error checking if program is published: %v", err)
+		return false
+	}
+	return count > 0
+}
+
+// adds the program to the facility's catalogue, publishing a program twice has no effect
+func (db *DB) PublishProgram(programID int, facilityID uint) error {
+	return db.Conn.Exec(`INSERT INTO facility_programs (facility_id, program_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING`, facilityID, programID).Error
+}
+
+func (db *DB) UnpublishProgram(programID int, facilityID uint) error {
+	return db.Conn.Exec("DELETE FROM facility_programs WHERE facility_id = ? AND program_id = ?", facilityID, programID).Error
+}
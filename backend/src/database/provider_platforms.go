@@ -61,6 +61,9 @@ func (db *DB) CreateProviderPlatform(platform *models.ProviderPlatform) (*models
 			log.Errorln("unable to create relevant content provider for new kolibri instance")
 		}
 	}
+	if err := db.CreateDefaultSyncJobs(platform.ID); err != nil {
+		log.Errorln("unable to create default sync jobs for new provider platform")
+	}
 	newProv := models.ProviderPlatform{}
 	if err := db.Conn.Find(&newProv, "id = ?", platform.ID).Error; err != nil {
 		return nil, err
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 func (db *DB) CreateProviderUserMapping(providerUserMapping *models.ProviderUserMapping) error {
@@ -19,9 +20,10 @@ func (db *DB) GetProviderUserMappingByExternalUserID(externalUserID string, prov
 	return &providerUserMapping, nil
 }
 
+// the provider's mapped users whose activity is synced, suspended mappings are left out
 func (db *DB) GetUserMappingsForProvider(providerId uint) ([]models.ProviderUserMapping, error) {
 	var users []models.ProviderUserMapping
-	if err := db.Conn.Find(&users).Where("provider_platform_id = ?", providerId).Error; err != nil {
+	if err := db.Conn.Where("provider_platform_id = ? AND suspended_at IS NULL", providerId).Find(&users).Error; err != nil {
 		return nil, err
 	}
 	return users, nil
@@ -129,3 +131,8 @@ func (db *DB) DeleteProviderUserMappingByUserID(userID, providerID int) error {
 	}
 	return nil
 }
+
+// records when the account was deactivated in the provider, nil once it is reactivated
+func (db *DB) SetProviderUserMappingDeactivated(id uint, deactivatedAt *time.Time) error {
+	return db.Conn.Model(&models.ProviderUserMapping{}).Where("id = ?", id).Update("deactivated_at", deactivatedAt).Error
+}
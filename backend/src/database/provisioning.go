@@ -0,0 +1,72 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"time"
+)
+
+func (db *DB) GetProvisionings(page, perPage int, status models.ProvisioningStatus, providerID uint) (int64, []models.Provisioning, error) {
+	var (
+		provisionings []models.Provisioning
+		total         int64
+	)
+	query := db.Conn.Model(&models.Provisioning{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if providerID != 0 {
+		query = query.Where("provider_platform_id = ?", providerID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("updated_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&provisionings).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, provisionings, nil
+}
+
+func (db *DB) GetProvisioningByID(id uint) (*models.Provisioning, error) {
+	var provisioning models.Provisioning
+	if err := db.Conn.First(&provisioning, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &provisioning, nil
+}
+
+// the latest provisioning of the provider's user which hasn't succeeded, if any
+func (db *DB) GetUnfinishedProvisioning(providerID uint, externalUserID string) (*models.Provisioning, error) {
+	var provisioning models.Provisioning
+	err := db.Conn.Where("provider_platform_id = ? AND external_user_id = ? AND status <> ?", providerID, externalUserID, models.ProvisioningSucceeded).
+		Order("id DESC").First(&provisioning).Error
+	if err != nil {
+		return nil, err
+	}
+	return &provisioning, nil
+}
+
+func (db *DB) CreateProvisioning(provisioning *models.Provisioning) error {
+	return db.Conn.Create(provisioning).Error
+}
+
+func (db *DB) UpdateProvisioning(provisioning *models.Provisioning) error {
+	return db.Conn.Save(provisioning).Error
+}
+
+/**
+* Marks a failed or interrupted provisioning as running again, unless something
+* else has claimed it first, so that two retries can't run the same steps
+**/
+func (db *DB) ClaimProvisioning(provisioning *models.Provisioning) (bool, error) {
+	now := time.Now()
+	result := db.Conn.Model(&models.Provisioning{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", provisioning.ID,
+			models.ProvisioningFailed, models.ProvisioningRunning, now.Add(-models.ProvisioningTimeout)).
+		Updates(map[string]interface{}{"status": models.ProvisioningRunning, "updated_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	provisioning.Status = models.ProvisioningRunning
+	provisioning.UpdatedAt = now
+	return true, nil
+}
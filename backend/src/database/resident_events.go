@@ -0,0 +1,44 @@
+package database
+
+import "UnlockEdv2/src/models"
+
+func (db *DB) GetResidentEvents(page, perPage int, status models.ResidentEventStatus, residentID string) (int64, []models.ResidentEvent, error) {
+	var (
+		events []models.ResidentEvent
+		total  int64
+	)
+	query := db.Conn.Model(&models.ResidentEvent{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if residentID != "" {
+		query = query.Where("resident_id = ?", residentID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("updated_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&events).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, events, nil
+}
+
+func (db *DB) GetResidentEvent(eventID string) (*models.ResidentEvent, error) {
+	var event models.ResidentEvent
+	if err := db.Conn.First(&event, "event_id = ?", eventID).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (db *DB) SaveResidentEvent(event *models.ResidentEvent) error {
+	return db.Conn.Save(event).Error
+}
+
+func (db *DB) GetUserByResidentID(residentID string) (*models.User, error) {
+	var user models.User
+	if err := db.Conn.First(&user, "resident_id = ?", residentID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
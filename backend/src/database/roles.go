@@ -0,0 +1,86 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+func (db *DB) GetRoleAssignmentsForUser(userID uint) ([]models.RoleAssignment, error) {
+	var assignments []models.RoleAssignment
+	if err := db.Conn.Preload("Facility").Where("user_id = ?", userID).Order("id").Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// the user's assignments which apply at the facility (including those for every facility)
+func (db *DB) GetRoleAssignmentsAtFacility(userID, facilityID uint) ([]models.RoleAssignment, error) {
+	var assignments []models.RoleAssignment
+	if err := db.Conn.Where("user_id = ? AND (facility_id IS NULL OR facility_id = ?)", userID, facilityID).Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (db *DB) GetRoleAssignmentByID(userID, id uint) (*models.RoleAssignment, error) {
+	var assignment models.RoleAssignment
+	if err := db.Conn.First(&assignment, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+var ErrRoleAlreadyAssigned = errors.New("role already assigned to user at this facility")
+
+func (db *DB) CreateRoleAssignment(assignment *models.RoleAssignment) error {
+	// the unique index doesn't cover assignments to every facility (NULL facility_id)
+	query := db.Conn.Model(&models.RoleAssignment{}).Where("user_id = ? AND role = ?", assignment.UserID, assignment.Role)
+	if assignment.FacilityID == nil {
+		query = query.Where("facility_id IS NULL")
+	} else {
+		query = query.Where("facility_id = ?", *assignment.FacilityID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRoleAlreadyAssigned
+	}
+	return db.Conn.Create(assignment).Error
+}
+
+// assignments are removed outright, so the same role can be granted again later
+func (db *DB) DeleteRoleAssignment(assignment *models.RoleAssignment) error {
+	return db.Conn.Unscoped().Delete(assignment).Error
+}
+
+/**
+* Whether the user has the permission at the facility: through their own role
+* (admins everywhere, other roles only at the user's own facility) or a role
+* assigned to them at that facility.
+**/
+func (db *DB) UserHasPermission(userID, facilityID uint, permission models.Permission) (bool, error) {
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if user.Role == models.Admin || (user.FacilityID == facilityID && user.Role.Can(permission)) {
+		return true, nil
+	}
+	assignments, err := db.GetRoleAssignmentsAtFacility(userID, facilityID)
+	if err != nil {
+		return false, err
+	}
+	for _, assignment := range assignments {
+		if assignment.Role.Can(permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (db *DB) GetSyncJobs(page, perPage int, providerID uint) (int64, []models.SyncJob, error) {
+	var (
+		jobs  []models.SyncJob
+		total int64
+	)
+	query := db.Conn.Model(&models.SyncJob{})
+	if providerID != 0 {
+		query = query.Where("provider_platform_id = ?", providerID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Order("provider_platform_id, type").Offset((page - 1) * perPage).Limit(perPage).Find(&jobs).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, jobs, nil
+}
+
+func (db *DB) GetSyncJobByID(id uint) (*models.SyncJob, error) {
+	var job models.SyncJob
+	if err := db.Conn.First(&job, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (db *DB) GetSyncJobForProvider(providerID uint, jobType models.JobType) (*models.SyncJob, error) {
+	var job models.SyncJob
+	if err := db.Conn.First(&job, "provider_platform_id = ? AND type = ?", providerID, jobType).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// returns every active job which is due to run at the given time, or has yet to be scheduled
+func (db *DB) GetDueSyncJobs(now time.Time) ([]models.SyncJob, error) {
+	var jobs []models.SyncJob
+	if err := db.Conn.Where("status = ? AND (next_run_at IS NULL OR next_run_at <= ?)", models.JobActive, now).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (db *DB) CreateSyncJob(job *models.SyncJob) error {
+	return db.Conn.Create(job).Error
+}
+
+func (db *DB) UpdateSyncJob(job *models.SyncJob) error {
+	return db.Conn.Save(job).Error
+}
+
+// only touches the scheduling columns, so a job paused mid-run stays paused
+func (db *DB) UpdateSyncJobRunTimes(job *models.SyncJob) error {
+	return db.Conn.Model(&models.SyncJob{}).Where("id = ?", job.ID).
+		Updates(map[string]interface{}{"last_run_at": job.LastRunAt, "next_run_at": job.NextRunAt}).Error
+}
+
+func (db *DB) DeleteSyncJob(id uint) error {
+	return db.Conn.Delete(&models.SyncJob{}, "id = ?", id).Error
+}
+
+/**
+* Creates the default set of recurring jobs for a provider platform,
+* skipping any job types which already exist for it
+**/
+func (db *DB) CreateDefaultSyncJobs(providerID uint) error {
+	for jobType := range models.DefaultJobSchedules {
+		if _, err := db.GetSyncJobForProvider(providerID, jobType); err == nil {
+			continue
+		}
+		if err := db.createDefaultSyncJob(providerID, jobType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+* Providers registered before a type of job existed get the same default job
+* as new ones. Only types which no provider has yet are created, so a job an
+* admin has deleted isn't brought back.
+**/
+func (db *DB) BackfillDefaultSyncJobs() error {
+	var providerIDs []uint
+	if err := db.Conn.Model(&models.ProviderPlatform{}).Pluck("id", &providerIDs).Error; err != nil {
+		return err
+	}
+	for jobType := range models.DefaultJobSchedules {
+		var count int64
+		if err := db.Conn.Model(&models.SyncJob{}).Where("type = ?", jobType).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		for _, id := range providerIDs {
+			if err := db.createDefaultSyncJob(id, jobType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// user imports create accounts, so they start out paused until an admin opts in
+func (db *DB) createDefaultSyncJob(providerID uint, jobType models.JobType) error {
+	status := models.JobActive
+	if jobType == models.ImportUsersJob {
+		status = models.JobPaused
+	}
+	job := models.SyncJob{
+		ProviderPlatformID: providerID,
+		Type:               jobType,
+		Schedule:           models.DefaultJobSchedules[jobType],
+		Status:             status,
+	}
+	// the provider may have been given the job in the meantime
+	if err := db.Conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&job).Error; err != nil {
+		log.WithFields(log.Fields{"provider_platform_id": providerID, "type": jobType}).Errorln("error creating default sync job")
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetSyncJobRuns(page, perPage int, jobID uint) (int64, []models.SyncJobRun, error) {
+	var (
+		runs  []models.SyncJobRun
+		total int64
+	)
+	query := db.Conn.Model(&models.SyncJobRun{}).Where("sync_job_id = ?", jobID)
+	if err := query.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := query.Preload("Report").Order("created_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&runs).Error; err != nil {
+		return 0, nil, err
+	}
+	return total, runs, nil
+}
+
+func (db *DB) GetSyncJobRunByID(id uint) (*models.SyncJobRun, error) {
+	var run models.SyncJobRun
+	if err := db.Conn.First(&run, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (db *DB) CreateSyncJobRun(run *models.SyncJobRun) error {
+	return db.Conn.Create(run).Error
+}
+
+// the report of the run is saved by the runner which produced it
+func (db *DB) UpdateSyncJobRun(run *models.SyncJobRun) error {
+	return db.Conn.Omit(clause.Associations).Save(run).Error
+}
+
+func (db *DB) UpdateSyncJobRunProgress(run *models.SyncJobRun) error {
+	return db.Conn.Model(&models.SyncJobRun{}).Where("id = ?", run.ID).
+		Updates(map[string]interface{}{"total": run.Total, "completed": run.Completed}).Error
+}
+
+/**
+* Any run still marked as running when the server starts was interrupted
+* by a restart, so it is marked as failed rather than left dangling
+**/
+func (db *DB) FailInterruptedSyncJobRuns() error {
+	now := time.Now()
+	return db.Conn.Model(&models.SyncJobRun{}).Where("status IN ?", []models.RunStatus{models.RunPending, models.RunRunning}).
+		Updates(map[string]interface{}{"status": models.RunFailed, "finished_at": now, "error": "interrupted by server restart"}).Error
+}
+
+// returns the time of the last successful sync, or the zero time if there hasn't been one
+func (db *DB) GetSyncCursor(providerID uint, jobType models.JobType) (time.Time, error) {
+	var cursor models.SyncCursor
+	err := db.Conn.First(&cursor, "provider_platform_id = ? AND type = ?", providerID, jobType).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return cursor.Since, err
+}
+
+func (db *DB) SetSyncCursor(providerID uint, jobType models.JobType, since time.Time) error {
+	cursor := models.SyncCursor{ProviderPlatformID: providerID, Type: jobType, Since: since}
+	return db.Conn.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider_platform_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"since", "updated_at"}),
+	}).Create(&cursor).Error
+}
+
+// forces the next import of the type to fetch everything
+func (db *DB) DeleteSyncCursor(providerID uint, jobType models.JobType) error {
+	return db.Conn.Delete(&models.SyncCursor{}, "provider_platform_id = ? AND type = ?", providerID, jobType).Error
+}
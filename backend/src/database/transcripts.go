@@ -0,0 +1,63 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+	"time"
+)
+
+// a program on a learner's transcript, with what they have done in it
+type TranscriptProgram struct {
+	ProgramID           uint
+	Program             string
+	Provider            string
+	Hours               float64
+	CompletedMilestones int
+	FirstActivity       AggregateTime
+	LastActivity        AggregateTime
+	Status              models.EnrollmentStatus
+	EndDate             *time.Time
+}
+
+/**
+* Every program the user has been enrolled in, or has activity, milestones or
+* outcomes in, by name
+**/
+func (db *DB) GetTranscriptPrograms(userID uint) ([]TranscriptProgram, error) {
+	programs := []TranscriptProgram{}
+	err := db.Conn.Raw(`SELECT p.id AS program_id, p.name AS program, pp.name AS provider,
+			COALESCE((SELECT SUM(a.time_delta) FROM activities a WHERE a.program_id = p.id AND a.user_id = @user AND a.deleted_at IS NULL), 0) / 3600.0 AS hours,
+			(SELECT COUNT(*) FROM milestones m WHERE m.program_id = p.id AND m.user_id = @user AND m.is_completed AND m.deleted_at IS NULL) AS completed_milestones,
+			(SELECT MIN(a.activity_date) FROM activities a WHERE a.program_id = p.id AND a.user_id = @user AND a.deleted_at IS NULL) AS first_activity,
+			(SELECT MAX(a.activity_date) FROM activities a WHERE a.program_id = p.id AND a.user_id = @user AND a.deleted_at IS NULL) AS last_activity,
+			e.status, e.end_date
+		FROM programs p
+		LEFT JOIN provider_platforms pp ON pp.id = p.provider_platform_id
+		LEFT JOIN enrollments e ON e.program_id = p.id AND e.user_id = @user AND e.deleted_at IS NULL
+		WHERE p.deleted_at IS NULL AND (e.id IS NOT NULL
+			OR p.id IN (SELECT program_id FROM activities WHERE user_id = @user AND deleted_at IS NULL)
+			OR p.id IN (SELECT program_id FROM milestones WHERE user_id = @user AND deleted_at IS NULL)
+			OR p.id IN (SELECT program_id FROM outcomes WHERE user_id = @user AND deleted_at IS NULL))
+		ORDER BY p.name`, map[string]interface{}{"user": userID}).Scan(&programs).Error
+	return programs, err
+}
+
+// all of the user's outcomes, oldest first
+func (db *DB) GetAllOutcomesForUser(userID uint) ([]models.Outcome, error) {
+	outcomes := []models.Outcome{}
+	if err := db.Conn.Where("user_id = ?", userID).Order("created_at").Find(&outcomes).Error; err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+func (db *DB) CreateTranscript(transcript *models.Transcript) error {
+	return db.Conn.Create(transcript).Error
+}
+
+func (db *DB) GetTranscriptByDocumentID(documentID string) (*models.Transcript, error) {
+	var transcript models.Transcript
+	if err := db.Conn.Preload("User").Preload("Facility").First(&transcript, "document_id = ?", documentID).Error; err != nil {
+		return nil, err
+	}
+	return &transcript, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"UnlockEdv2/src/models"
+
+	"gorm.io/gorm"
+)
+
+func (db *DB) GetTransfersForUser(userID uint) ([]models.FacilityTransfer, error) {
+	transfers := []models.FacilityTransfer{}
+	if err := db.Conn.Where("user_id = ?", userID).Order("created_at DESC").Find(&transfers).Error; err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// the user's enrollments which are still under way (pending, waitlisted or active), with their programs
+func (db *DB) GetOpenEnrollmentsForUser(userID uint) ([]models.Enrollment, error) {
+	enrollments := []models.Enrollment{}
+	err := db.Conn.Preload("Program").
+		Where("user_id = ? AND status IN ?", userID, []models.EnrollmentStatus{models.EnrollmentPending, models.EnrollmentWaitlisted, models.EnrollmentActive}).
+		Order("id").Find(&enrollments).Error
+	return enrollments, err
+}
+
+// whether any of the provider's programs are published at the facility
+func (db *DB) ProviderOffersAtFacility(providerID, facilityID uint) bool {
+	offered := false
+	err := db.Conn.Raw(`SELECT EXISTS(SELECT 1 FROM programs p JOIN facility_programs fp ON fp.program_id = p.id
+		WHERE p.provider_platform_id = ? AND fp.facility_id = ? AND p.deleted_at IS NULL)`, providerID, facilityID).Scan(&offered).Error
+	return err == nil && offered
+}
+
+/**
+* Saves the transfer together with what it changed: the user's facility, their
+* provider mappings and their enrollments, so that either all of it is recorded
+* or none of it is
+**/
+func (db *DB) SaveFacilityTransfer(transfer *models.FacilityTransfer, user *models.User, mappings []models.ProviderUserMapping, enrollments []models.Enrollment) error {
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		for idx := range mappings {
+			if err := tx.Save(&mappings[idx]).Error; err != nil {
+				return err
+			}
+		}
+		for idx := range enrollments {
+			if err := tx.Omit("Program", "User").Save(&enrollments[idx]).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(transfer).Error
+	})
+}
+
+/**
+* The admins of the facility: admins and facility admins whose own facility it
+* is, and users assigned the facility admin role there
+**/
+func (db *DB) GetFacilityAdmins(facilityID uint) ([]models.User, error) {
+	admins := []models.User{}
+	assigned := db.Conn.Model(&models.RoleAssignment{}).Select("user_id").Where("role = ? AND facility_id = ?", models.FacilityAdmin, facilityID)
+	err := db.Conn.Where("(facility_id = ? AND role IN ?) OR id IN (?)", facilityID, []models.UserRole{models.Admin, models.FacilityAdmin}, assigned).
+		Order("id").Find(&admins).Error
+	return admins, err
+}
@@ -17,14 +17,20 @@ type UserCatalogueJoin struct {
 	Description  string `json:"description"`
 	IsFavorited  bool   `json:"is_favorited"`
 	OutcomeTypes string `json:"outcome_types"`
+	// the user's place on the roster (if any), and whether they have asked to join
+	EnrollmentStatus string `json:"enrollment_status"`
+	IsRequested      bool   `json:"is_requested"`
 }
 
-func (db *DB) GetUserCatalogue(userId int, tags []string, search, order string) ([]UserCatalogueJoin, error) {
+func (db *DB) GetUserCatalogue(userId int, facilityID uint, tags []string, search, order string) ([]UserCatalogueJoin, error) {
 	catalogue := []UserCatalogueJoin{}
 	tx := db.Conn.Table("programs p").
-		Select("p.id as program_id, p.thumbnail_url, p.name as program_name, pp.name as provider_name, p.external_url, p.type as program_type, p.description, p.outcome_types, f.user_id IS NOT NULL as is_favorited").
+		Select("p.id as program_id, p.thumbnail_url, p.name as program_name, pp.name as provider_name, p.external_url, p.type as program_type, p.description, p.outcome_types, f.user_id IS NOT NULL as is_favorited, COALESCE(e.status, '') as enrollment_status, er.id IS NOT NULL as is_requested").
 		Joins("LEFT JOIN provider_platforms pp ON p.provider_platform_id = pp.id").
 		Joins("LEFT JOIN favorites f ON f.program_id = p.id AND f.user_id = ?", userId).
+		Joins("LEFT JOIN enrollments e ON e.program_id = p.id AND e.user_id = ? AND e.deleted_at IS NULL", userId).
+		Joins("LEFT JOIN enrollment_requests er ON er.program_id = p.id AND er.user_id = ? AND er.status = ? AND er.deleted_at IS NULL", userId, models.RequestPending).
+		Joins(publishedProgramsJoin, facilityID).
 		Where("p.deleted_at IS NULL").
 		Where("pp.deleted_at IS NULL")
 	for i, tag := range tags {
@@ -55,6 +61,7 @@ type UserPrograms struct {
 	CourseProgress float64 `json:"course_progress"`
 	IsFavorited    bool    `json:"is_favorited"`
 	TotalTime      uint    `json:"total_time"`
+	Status         string  `json:"enrollment_status"`
 }
 
 func validOrder(str string) string {
@@ -64,7 +71,7 @@ func validOrder(str string) string {
 	return "desc"
 }
 
-func (db *DB) GetUserPrograms(userId uint, order string, orderBy string, search string, tags []string) ([]UserPrograms, uint, uint, error) {
+func (db *DB) GetUserPrograms(userId, facilityID uint, order string, orderBy string, search string, tags []string) ([]UserPrograms, uint, uint, error) {
 	programs := []UserPrograms{}
 	fieldMap := map[string]string{
 		"program_name":    "p.name",
@@ -91,8 +98,11 @@ func (db *DB) GetUserPrograms(userId uint, order string, orderBy string, search
               WHERE m.program_id = p.id AND m.user_id = ?) = 100 THEN 99.999
           ELSE (SELECT COUNT(m.id) * 100.0 / p.total_progress_milestones) END
     END as course_progress,
-    a.total_time`, userId, userId).
+    a.total_time, e.status`, userId, userId).
 		Joins("LEFT JOIN provider_platforms pp ON p.provider_platform_id = pp.id").
+		Joins(publishedProgramsJoin, facilityID).
+		Joins("JOIN enrollments e ON e.program_id = p.id AND e.user_id = ? AND e.status IN ? AND e.deleted_at IS NULL",
+			userId, []models.EnrollmentStatus{models.EnrollmentActive, models.EnrollmentCompleted}).
 		Joins("LEFT JOIN (SELECT * FROM milestones WHERE user_id = ?) as m ON m.program_id = p.id", userId).
 		Joins("LEFT JOIN favorites f ON f.program_id = p.id AND f.user_id = ?", userId).
 		Joins("LEFT JOIN outcomes o ON o.program_id = p.id AND o.user_id = ?", userId).
@@ -127,7 +137,7 @@ func (db *DB) GetUserPrograms(userId uint, order string, orderBy string, search
 		}
 	}
 
-	tx.Group("p.id, p.name, p.thumbnail_url, pp.name, p.external_url, f.user_id, p.total_progress_milestones, a.total_time")
+	tx.Group("p.id, p.name, p.thumbnail_url, pp.name, p.external_url, f.user_id, p.total_progress_milestones, a.total_time, e.status")
 	err := tx.Scan(&programs).Error
 	if err != nil {
 		return nil, 0, 0, err
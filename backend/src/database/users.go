@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func (db *DB) GetCurrentUsers(page, itemsPerPage int, facilityId uint, order string, search string) (int64, []models.User, error) {
@@ -20,7 +21,7 @@ func (db *DB) GetCurrentUsers(page, itemsPerPage int, facilityId uint, order str
 	var count int64
 	var users []models.User
 	if err := db.Conn.Model(models.User{}).
-		Where("facility_id = ?", facilityId).
+		Where("facility_id = ? AND status <> ?", facilityId, models.UserArchived).
 		Count(&count).
 		Offset(offset).
 		Limit(itemsPerPage).
@@ -41,7 +42,7 @@ func (db *DB) SearchCurrentUsers(page, itemsPerPage int, facilityId uint, order,
 	search = strings.TrimSpace(search)
 	likeSearch := "%" + search + "%"
 	if err := db.Conn.Model(models.User{}).
-		Where("facility_id = ?", fmt.Sprintf("%d", facilityId)).
+		Where("facility_id = ? AND status <> ?", fmt.Sprintf("%d", facilityId), models.UserArchived).
 		Where("name_first ILIKE ? OR username ILIKE ? OR name_last ILIKE ?", likeSearch, likeSearch, likeSearch).
 		Order(order).
 		Offset(offset).
@@ -56,7 +57,7 @@ func (db *DB) SearchCurrentUsers(page, itemsPerPage int, facilityId uint, order,
 			first := "%" + split[0] + "%"
 			last := "%" + split[1] + "%"
 			if err := db.Conn.Model(&models.User{}).
-				Where("facility_id = ?", fmt.Sprintf("%d", facilityId)).
+				Where("facility_id = ? AND status <> ?", fmt.Sprintf("%d", facilityId), models.UserArchived).
 				Where("(name_first ILIKE ? AND name_last ILIKE ?) OR (name_first ILIKE ? AND name_last ILIKE ?)", first, last, last, first).
 				Order(order).
 				Offset(offset).
@@ -159,6 +160,14 @@ func (db *DB) DeleteUser(id int) error {
 	return nil
 }
 
+/**
+* Removes the user for good, rather than soft deleting them, so their username
+* and email can be used again. Only for undoing a user that was just created.
+**/
+func (db *DB) PurgeUser(id uint) error {
+	return db.Conn.Unscoped().Delete(&models.User{}, "id = ?", id).Error
+}
+
 func (db *DB) GetUserByUsername(username string) *models.User {
 	var user models.User
 	if err := db.Conn.Model(models.User{}).Find(&user, "username = ?", username).Error; err != nil {
@@ -170,13 +179,42 @@ func (db *DB) GetUserByUsername(username string) *models.User {
 func (db *DB) UsernameExists(username string) bool {
 	userExists := false
 	err := db.Conn.Raw("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).
-		Scan(&userExists)
+		Scan(&userExists).Error
 	if err != nil {
 		log.Error("Error checking if username exists: ", err)
 	}
 	return userExists
 }
 
+func (db *DB) EmailExists(email string) bool {
+	emailExists := false
+	err := db.Conn.Raw("SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER(?))", email).
+		Scan(&emailExists).Error
+	if err != nil {
+		log.Error("Error checking if email exists: ", err)
+	}
+	return emailExists
+}
+
+/**
+* Creates each of the users as CreateUser does, in a single transaction, so that
+* either all of them are created or none are. Each user's Password is left as
+* their temporary password.
+**/
+func (db *DB) CreateUsers(users []*models.User) error {
+	return db.Conn.Transaction(func(tx *gorm.DB) error {
+		txDb := &DB{Conn: tx}
+		for idx, user := range users {
+			created, err := txDb.CreateUser(user)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", user.Username, err)
+			}
+			users[idx] = created
+		}
+		return nil
+	})
+}
+
 func (db *DB) UpdateUser(user *models.User) (*models.User, error) {
 	if user.ID == 0 {
 		return nil, errors.New("invalid user ID")
@@ -194,6 +232,9 @@ func (db *DB) AuthorizeUser(username, password string) (*models.User, error) {
 	if err := db.Conn.Where("username = ?", username).First(&user).Error; err != nil {
 		return nil, err
 	}
+	if !user.IsActive() {
+		return nil, fmt.Errorf("user is %s", user.Status)
+	}
 	log.Debug("Checking AuthorizeUser Password: ", password, user.Password)
 	if success := user.CheckPasswordHash(password); !success {
 		log.Printf("Password authentication failed for: %s", password)
@@ -0,0 +1,110 @@
+package exports
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, expected csv or xlsx")
+
+// the format of ?format=, CSV if none is given
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "", CSV:
+		return CSV, nil
+	case XLSX:
+		return XLSX, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+func (format Format) ContentType() string {
+	if format == XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+func (format Format) FileName(name string) string {
+	return name + "-" + time.Now().Format("2006-01-02") + "." + string(format)
+}
+
+/**
+* Writer writes a table one row at a time, straight to the response, so that
+* an export is never held in memory. Cells may be strings, numbers, bools,
+* times (or pointers to them, nil is an empty cell). Close must be called once
+* every row is written, to finish the file.
+**/
+type Writer interface {
+	Write(row ...interface{}) error
+	Close() error
+}
+
+func NewWriter(w io.Writer, format Format, sheet string) Writer {
+	if format == XLSX {
+		return newXLSXWriter(w, sheet)
+	}
+	return &csvWriter{csv.NewWriter(w)}
+}
+
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w *csvWriter) Write(row ...interface{}) error {
+	record := make([]string, len(row))
+	for idx, cell := range row {
+		record[idx] = formatCell(cell)
+		if _, ok := cell.(string); ok {
+			record[idx] = escapeFormula(record[idx])
+		}
+	}
+	return w.Writer.Write(record)
+}
+
+/**
+* Names and usernames come from OMS, rosters and providers, so a string that a
+* spreadsheet would read as a formula is prefixed with ' to keep it as text.
+* XLSX cells are written as inline strings, which are never evaluated.
+**/
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (w *csvWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+func formatCell(cell interface{}) string {
+	switch value := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if value == nil {
+			return ""
+		}
+		return value.UTC().Format(time.RFC3339)
+	case float64:
+		return fmt.Sprintf("%.2f", value)
+	}
+	return fmt.Sprint(cell)
+}
@@ -0,0 +1,157 @@
+package exports
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+* The parts of a workbook with a single sheet. The sheet is the last entry in
+* the zip, so its rows can be written as they come, there is no shared strings
+* table (strings are written inline) for the same reason.
+**/
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	// the second cell format is for dates and times (built in number format 22, "m/d/yy h:mm")
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="1"><fill><patternFill patternType="none"/></fill></fills>
+<borders count="1"><border/></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+</styleSheet>`},
+}
+
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+	err   error
+}
+
+func newXLSXWriter(w io.Writer, sheet string) *xlsxWriter {
+	writer := &xlsxWriter{zip: zip.NewWriter(w)}
+	parts := append(xlsxParts, struct{ name, content string }{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + escapeXML(sheetName(sheet)) + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`})
+	for _, part := range parts {
+		file, err := writer.zip.Create(part.name)
+		if err == nil {
+			_, err = io.WriteString(file, part.content)
+		}
+		if err != nil {
+			writer.err = err
+			return writer
+		}
+	}
+	file, err := writer.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		writer.err = err
+		return writer
+	}
+	writer.sheet = bufio.NewWriter(file)
+	_, writer.err = writer.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return writer
+}
+
+// sheet names are at most 31 characters, and can't contain []:*?/\
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
+
+func escapeXML(text string) string {
+	var builder strings.Builder
+	_ = xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
+
+// excel counts days from 1899-12-30
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func (w *xlsxWriter) Write(row ...interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.rows++
+	var builder strings.Builder
+	builder.WriteString(`<row r="` + strconv.Itoa(w.rows) + `">`)
+	for _, cell := range row {
+		if at, ok := cell.(*time.Time); ok {
+			if at == nil {
+				cell = nil
+			} else {
+				cell = *at
+			}
+		}
+		switch value := cell.(type) {
+		case nil:
+			builder.WriteString(`<c/>`)
+		case int, int64, uint, uint64, float64:
+			builder.WriteString(`<c><v>` + fmt.Sprint(value) + `</v></c>`)
+		case bool:
+			flag := "0"
+			if value {
+				flag = "1"
+			}
+			builder.WriteString(`<c t="b"><v>` + flag + `</v></c>`)
+		case time.Time:
+			days := value.UTC().Sub(excelEpoch).Hours() / 24
+			builder.WriteString(`<c s="1"><v>` + strconv.FormatFloat(days, 'f', -1, 64) + `</v></c>`)
+		default:
+			builder.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + escapeXML(formatCell(value)) + `</t></is></c>`)
+		}
+	}
+	builder.WriteString(`</row>`)
+	_, w.err = w.sheet.WriteString(builder.String())
+	return w.err
+}
+
+func (w *xlsxWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	return w.zip.Close()
+}
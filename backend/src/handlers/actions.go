@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"UnlockEdv2/src"
+	"UnlockEdv2/src/jobs"
 	"UnlockEdv2/src/models"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,6 +24,8 @@ func (srv *Server) registerActionsRoutes() {
 	srv.Mux.Handle("POST /api/actions/provider-platforms/{id}/import-programs", srv.ApplyAdminMiddleware(srv.HandleImportPrograms))
 	srv.Mux.Handle("POST /api/actions/provider-platforms/{id}/import-milestones", srv.ApplyAdminMiddleware(srv.HandleImportMilestones))
 	srv.Mux.Handle("POST /api/actions/provider-platforms/{id}/import-activity", srv.ApplyAdminMiddleware(srv.HandleImportActivity))
+	srv.Mux.Handle("POST /api/actions/provider-platforms/{id}/import-outcomes", srv.ApplyAdminMiddleware(srv.HandleImportOutcomes))
+	srv.Mux.Handle("POST /api/actions/provider-platforms/{id}/import-enrollments", srv.ApplyAdminMiddleware(srv.HandleImportEnrollments))
 }
 
 /****************************************************************************************************
@@ -35,73 +42,36 @@ type CachedProviderUsers struct {
 var cachedProviderUsers = make(map[uint]CachedProviderUsers)
 
 func (srv *Server) HandleImportUsers(w http.ResponseWriter, r *http.Request) {
-	fields := log.Fields{"handler": "HandleImportUsers", "file": "actions"}
-	service, err := srv.getService(r)
-	if err != nil {
-		fields["error"] = err.Error()
-		log.WithFields(fields).Errorf("Error getting provider service: %v", err)
-		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
-		return
-	}
+	srv.handleRunImportAction(w, r, models.ImportUsersJob)
+}
+
+func (srv *Server) importUsers(service *src.ProviderService, provider *models.ProviderPlatform, report *models.ImportReport) error {
+	fields := log.Fields{"func": "importUsers", "provider_platform_id": provider.ID}
 	users, err := service.GetUsers()
 	if err != nil {
 		fields["error"] = err.Error()
 		log.WithFields(fields).Error("Error getting provider service GetUsers action:" + err.Error())
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	provider, err := srv.Db.GetProviderPlatformByID(int(service.ProviderPlatformID))
-	if err != nil {
-		fields["error"] = err.Error()
-		log.WithFields(fields).Errorf("Error getting provider platform by id: %v", err)
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
+		return err
 	}
 	for _, user := range users {
 		// if this user was parsed improperly (happens randomly, unknown as to why), skip
 		if user.Username == "" && user.Email == "" && user.NameLast == "" {
-			fields["error"] = err.Error()
 			log.WithFields(fields).Debug("received user with null values from provider, skipping")
+			report.Skip()
 			continue
 		}
-		newUser := models.User{
-			Username:  user.Username,
-			Email:     user.Email,
-			NameFirst: user.NameFirst,
-			NameLast:  user.NameLast,
-		}
-		created, err := srv.Db.CreateUser(&newUser)
-		if err != nil {
-			log.Error("Error creating user:" + err.Error())
+		// already imported
+		if _, err := srv.Db.GetProviderUserMappingByExternalUserID(user.ExternalUserID, provider.ID); err == nil {
+			report.Skip()
 			continue
 		}
-		if !srv.isTesting(r) {
-			if err := srv.HandleCreateUserKratos(created.Username, created.Password); err != nil {
-				fields["error"] = err.Error()
-				log.WithFields(fields).Errorf("Error creating user in kratos: %v", err)
-				// FIXME: Error handling if we fail/handle atomicity
-			}
-		}
-		mapping := models.ProviderUserMapping{
-			UserID:             created.ID,
-			ProviderPlatformID: service.ProviderPlatformID,
-			ExternalUsername:   user.Username,
-			ExternalUserID:     user.ExternalUserID,
-		}
-		if err = srv.Db.CreateProviderUserMapping(&mapping); err != nil {
-			fields["error"] = err.Error()
-			log.WithFields(fields).Errorf("error creating a mapping between user %v and provider %d", user, provider.ID)
-			srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		if provider.OidcID != 0 {
-			// this should never be any other case, as it's triggered by the UI only (if oidc_id != 0). but we still check
-			if err := srv.registerProviderLogin(provider, &newUser); err != nil {
-				log.Errorln("Error registering provider login", err)
-			}
+		if err := srv.provisionProviderUser(provider, user); err != nil {
+			report.Fail(user.ExternalUserID, err)
+			continue
 		}
+		report.Create()
 	}
-	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
 func paginateUsers(users []models.ImportUser, page, perPage int) (int, []models.ImportUser) {
@@ -162,45 +132,54 @@ func (srv *Server) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func (srv *Server) HandleImportPrograms(w http.ResponseWriter, r *http.Request) {
-	service, err := srv.getService(r)
-	if err != nil {
-		log.Errorf("Error getting provider service: %v", err)
-		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	err = service.GetPrograms()
-	if err != nil {
-		log.Error("Error getting provider service GetPrograms:" + err.Error())
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	srv.WriteResponse(w, http.StatusOK, models.Resource[interface{}]{Message: "Successfully imported courses"})
+	srv.handleRunImportAction(w, r, models.ImportProgramsJob)
 }
 
 func (srv *Server) HandleImportMilestones(w http.ResponseWriter, r *http.Request) {
-	service, err := srv.getService(r)
-	if err != nil {
-		log.Errorf("Error getting provider service: %v", err)
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	srv.handleRunImportAction(w, r, models.ImportMilestonesJob)
+}
+
+type programUser struct {
+	program models.Program
+	mapping models.ProviderUserMapping
+}
+
+/**
+* Milestones are imported for every program/user pair, one request to the middleware
+* each, so the requests are made by a pool of workers (SYNC_JOB_WORKERS at once).
+* A pair which fails is recorded in the report, and doesn't stop the others.
+**/
+func (srv *Server) importMilestones(ctx context.Context, service *src.ProviderService, since time.Time, report *models.ImportReport, run *models.SyncJobRun) error {
 	programs, userMappings, err := srv.getProgramsAndMappingsForProvider(service.ProviderPlatformID)
 	if err != nil {
 		log.Errorf("Error getting programs and user mappings for provider: %v", err)
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
+		return err
 	}
-	log.Printf("Importing milestones")
+	pairs := make([]programUser, 0, len(programs)*len(userMappings))
 	for _, program := range programs {
 		for _, userMapping := range userMappings {
-			err := service.GetMilestonesForProgramUser(program.ID, userMapping.UserID)
-			if err != nil {
-				log.Errorf("Error getting provider service milestones: %v", err)
-				continue
-			}
+			pairs = append(pairs, programUser{program: program, mapping: userMapping})
 		}
 	}
-	srv.WriteResponse(w, http.StatusOK, models.Resource[interface{}]{Message: "Successfully imported Milestones"})
+	log.Printf("Importing milestones for %d program/user pairs", len(pairs))
+	progress := jobs.NewProgress(srv.Db, run, len(pairs))
+	var mutex sync.Mutex
+	return jobs.ForEach(ctx, jobs.Workers(), pairs, func(ctx context.Context, pair programUser) {
+		defer progress.Done()
+		milestones, err := service.GetMilestonesForProgramUser(ctx, pair.program.ID, pair.mapping.UserID, since)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			if ctx.Err() != nil {
+				// cancelled, rather than failed
+				return
+			}
+			log.Errorf("Error getting provider service milestones: %v", err)
+			report.Fail(fmt.Sprintf("program %s, user %s", pair.program.ExternalID, pair.mapping.ExternalUserID), err)
+			return
+		}
+		report.Merge(milestones)
+	})
 }
 
 func (srv *Server) getProgramsAndMappingsForProvider(providerID uint) ([]models.Program, []models.ProviderUserMapping, error) {
@@ -230,24 +209,213 @@ func (srv *Server) getService(r *http.Request) (*src.ProviderService, error) {
 }
 
 func (srv *Server) HandleImportActivity(w http.ResponseWriter, r *http.Request) {
-	service, err := srv.getService(r)
-	if err != nil {
-		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
-		return
-	}
+	srv.handleRunImportAction(w, r, models.ImportActivityJob)
+}
+
+func (srv *Server) importActivity(ctx context.Context, service *src.ProviderService, since time.Time, report *models.ImportReport, run *models.SyncJobRun) error {
 	programs, err := srv.Db.GetProgramByProviderPlatformID(int(service.ProviderPlatformID))
 	if err != nil {
 		log.Errorf("Error getting programs and user mappings for provider: %v", err)
-		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
-		return
+		return err
 	}
+	progress := jobs.NewProgress(srv.Db, run, len(programs))
 	for _, program := range programs {
-		log.Printf("Program ID: %d", program.ID)
-		err := service.GetActivityForProgram(program.ExternalID)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		activity, err := service.GetActivityForProgram(program.ExternalID, since)
+		progress.Done()
 		if err != nil {
 			log.Errorf("Error getting provider service activity: %v", err)
+			report.Fail("program "+program.ExternalID, err)
+			continue
+		}
+		report.Merge(activity)
+	}
+	return nil
+}
+
+func (srv *Server) HandleImportOutcomes(w http.ResponseWriter, r *http.Request) {
+	srv.handleRunImportAction(w, r, models.ImportOutcomesJob)
+}
+
+// final grades and completions, as recorded by the provider
+func (srv *Server) importOutcomes(ctx context.Context, service *src.ProviderService, since time.Time, report *models.ImportReport, run *models.SyncJobRun) error {
+	programs, err := srv.Db.GetProgramByProviderPlatformID(int(service.ProviderPlatformID))
+	if err != nil {
+		log.Errorf("Error getting programs for provider: %v", err)
+		return err
+	}
+	progress := jobs.NewProgress(srv.Db, run, len(programs))
+	for _, program := range programs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		outcomes, err := service.GetOutcomesForProgram(program.ExternalID, since)
+		progress.Done()
+		if err != nil {
+			log.Errorf("Error getting provider service outcomes: %v", err)
+			report.Fail("program "+program.ExternalID, err)
 			continue
 		}
+		report.Merge(outcomes)
+	}
+	return nil
+}
+
+func (srv *Server) HandleImportEnrollments(w http.ResponseWriter, r *http.Request) {
+	srv.handleRunImportAction(w, r, models.ImportEnrollmentsJob)
+}
+
+// the rosters of the provider's courses
+func (srv *Server) importEnrollments(ctx context.Context, service *src.ProviderService, since time.Time, report *models.ImportReport, run *models.SyncJobRun) error {
+	programs, err := srv.Db.GetProgramByProviderPlatformID(int(service.ProviderPlatformID))
+	if err != nil {
+		log.Errorf("Error getting programs for provider: %v", err)
+		return err
+	}
+	progress := jobs.NewProgress(srv.Db, run, len(programs))
+	for _, program := range programs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		enrollments, err := service.GetEnrollmentsForProgram(program.ExternalID, since)
+		progress.Done()
+		if err != nil {
+			log.Errorf("Error getting provider service enrollments: %v", err)
+			report.Fail("program "+program.ExternalID, err)
+			continue
+		}
+		report.Merge(enrollments)
+	}
+	return nil
+}
+
+/**
+* The import actions run through the same sync job as the scheduled imports,
+* so that every import (manual or not) is recorded in the job's run history.
+**/
+func (srv *Server) handleRunImportAction(w http.ResponseWriter, r *http.Request, jobType models.JobType) {
+	fields := log.Fields{"handler": "handleRunImportAction", "type": jobType}
+	providerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid provider platform id")
+		return
+	}
+	fields["provider_platform_id"] = providerID
+	job, err := srv.Db.GetSyncJobForProvider(uint(providerID), jobType)
+	if err != nil {
+		if err = srv.Db.CreateDefaultSyncJobs(uint(providerID)); err == nil {
+			job, err = srv.Db.GetSyncJobForProvider(uint(providerID), jobType)
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+			log.WithFields(fields).Error("error finding sync job for provider")
+			srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	// a long import can be started in the background (?async=true), and its run polled for progress
+	start := srv.Scheduler.Run
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		start = srv.Scheduler.Trigger
+	}
+	run, err := start(r.Context(), job, models.TriggerManual)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobRunning) {
+			srv.ErrorResponse(w, http.StatusConflict, "this import is already running")
+			return
+		}
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error running import")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if run.Status == models.RunRunning {
+		srv.WriteResponse(w, http.StatusAccepted, models.Resource[models.SyncJobRun]{Message: "Import started", Data: []models.SyncJobRun{*run}})
+		return
+	}
+	if run.Status == models.RunFailed {
+		srv.ErrorResponse(w, http.StatusInternalServerError, run.Error)
+		return
+	}
+	message := "Import completed successfully"
+	if run.Status == models.RunPartial {
+		message = "Import completed with errors, see the report for the rows which failed"
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.SyncJobRun]{Message: message, Data: []models.SyncJobRun{*run}})
+}
+
+/**
+* Runner for the job scheduler, performs the import described by the job
+* and saves the report of what was imported alongside the run.
+**/
+func (srv *Server) runSyncJob(ctx context.Context, job *models.SyncJob, run *models.SyncJobRun) error {
+	provider, err := srv.Db.GetProviderPlatformByID(int(job.ProviderPlatformID))
+	if err != nil {
+		return err
+	}
+	if provider.State == models.Disabled || provider.State == models.Archived {
+		return fmt.Errorf("provider platform %s is %s", provider.Name, provider.State)
+	}
+	service, err := src.GetProviderService(provider)
+	if err != nil {
+		return err
+	}
+	report := models.NewImportReport(provider.ID, job.Type)
+	report.SyncJobRunID = &run.ID
+	run.Report = report
+	// the report is kept even if the import fails part way through
+	defer func() {
+		if err := srv.Db.CreateImportReport(report); err != nil {
+			log.WithFields(log.Fields{"run_id": run.ID, "error": err.Error()}).Error("error saving import report")
+		}
+	}()
+	if job.Type == models.ImportUsersJob {
+		if err := srv.importUsers(service, provider, report); err != nil {
+			return err
+		}
+		return partialImportError(report)
+	}
+	since, err := srv.Db.GetSyncCursor(provider.ID, job.Type)
+	if err != nil {
+		return err
+	}
+	// the cursor is taken before the import, so changes made while it runs are picked up next time
+	started := time.Now()
+	var imported *models.ImportReport
+	switch job.Type {
+	case models.ImportProgramsJob:
+		imported, err = service.GetPrograms(since)
+		report.Merge(imported)
+	case models.ImportMilestonesJob:
+		err = srv.importMilestones(ctx, service, since, report, run)
+	case models.ImportActivityJob:
+		err = srv.importActivity(ctx, service, since, report, run)
+	case models.ImportOutcomesJob:
+		err = srv.importOutcomes(ctx, service, since, report, run)
+	case models.ImportEnrollmentsJob:
+		err = srv.importEnrollments(ctx, service, since, report, run)
+	default:
+		return fmt.Errorf("unknown job type: %s", job.Type)
+	}
+	if job.Type == models.ImportOutcomesJob || job.Type == models.ImportMilestonesJob {
+		// imported outcomes, and those recorded for completing every milestone, may earn certificates
+		srv.issuePendingCertificates()
+	}
+	if err != nil {
+		return err
+	}
+	// rows which failed are retried by the next run, so the cursor stays where it is
+	if err := partialImportError(report); err != nil {
+		return err
+	}
+	return srv.Db.SetSyncCursor(provider.ID, job.Type, started)
+}
+
+func partialImportError(report *models.ImportReport) error {
+	if report.Failed == 0 {
+		return nil
 	}
-	w.WriteHeader(http.StatusOK)
+	return fmt.Errorf("%w: %d of %d rows failed to import", jobs.ErrPartialImport, report.Failed, report.Total())
 }
@@ -14,8 +14,8 @@ import (
 func (srv *Server) registerActivityRoutes() {
 	srv.Mux.Handle("GET /api/users/{id}/activity", srv.applyMiddleware(srv.HandleGetActivityByUserID))
 	srv.Mux.Handle("GET /api/users/{id}/daily-activity", srv.applyMiddleware(srv.HandleGetDailyActivityByUserID))
-	srv.Mux.Handle("GET /api/programs/{id}/activity", srv.ApplyAdminMiddleware(srv.HandleGetProgramActivity))
-	srv.Mux.Handle("POST /api/users/{id}/activity", srv.ApplyAdminMiddleware(srv.HandleCreateActivity))
+	srv.Mux.Handle("GET /api/programs/{id}/activity", srv.ApplyPermissionMiddleware(models.ViewReports, srv.HandleGetProgramActivity))
+	srv.Mux.Handle("POST /api/users/{id}/activity", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleCreateActivity))
 }
 
 func (srv *Server) HandleGetActivityByUserID(w http.ResponseWriter, r *http.Request) {
@@ -33,7 +33,11 @@ func (srv *Server) HandleGetActivityByUserID(w http.ResponseWriter, r *http.Requ
 		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
-	activities, err := srv.Db.GetActivityByUserID(uint(userID), yearInt)
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's activities")
+		return
+	}
+	activities, err := srv.userDb(r, uint(userID)).GetActivityByUserID(uint(userID), yearInt)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, "Failed to get activities")
 		return
@@ -55,8 +59,7 @@ func (srv *Server) HandleGetDailyActivityByUserID(w http.ResponseWriter, r *http
 		return
 	}
 	fields["user_id"] = userID
-	requestingUser := int(srv.GetUserID(r))
-	if requestingUser != userID && !srv.UserIsAdmin(r) {
+	if !srv.canViewUserData(r) {
 		log.WithFields(fields).Error("Non admin requesting to view other student activities")
 		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's activities")
 		return
@@ -72,7 +75,7 @@ func (srv *Server) HandleGetDailyActivityByUserID(w http.ResponseWriter, r *http
 			return
 		}
 	}
-	activities, err := srv.Db.GetDailyActivityByUserID(userID, year)
+	activities, err := srv.userDb(r, uint(userID)).GetDailyActivityByUserID(userID, year)
 	if err != nil {
 		fields["error"] = err.Error()
 		log.WithFields(fields).Error("Failed to get activities")
@@ -91,7 +94,7 @@ func (srv *Server) HandleGetProgramActivity(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	page, perPage := srv.GetPaginationInfo(r)
-	count, activities, err := srv.Db.GetActivityByProgramID(page, perPage, programID)
+	count, activities, err := srv.facilityDb(r).GetActivityByProgramID(page, perPage, programID)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, "Failed to get activities")
 		return
@@ -108,6 +111,10 @@ func (srv *Server) HandleCreateActivity(w http.ResponseWriter, r *http.Request)
 		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if !srv.userInFacility(r, activity.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
 	if err := srv.Db.CreateActivity(activity); err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, "Failed to create activity")
 		return
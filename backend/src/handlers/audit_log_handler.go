@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/exports"
+	"UnlockEdv2/src/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+)
+
+func (srv *Server) registerAuditLogRoutes() {
+	srv.Mux.Handle("GET /api/audit-log", srv.ApplyPermissionMiddleware(models.ViewAuditLog, srv.HandleIndexAuditLog))
+	srv.Mux.Handle("GET /api/audit-log/export", srv.ApplyPermissionMiddleware(models.ViewAuditLog, srv.HandleExportAuditLog))
+}
+
+/**
+* Records an administrative action taken by the user making the request, in the
+* facility they are viewing. before and after are the target's state (nil if it
+* didn't exist), and must never include secrets. Failing to record the entry
+* doesn't fail the action, which has already been taken, but is logged as an error.
+**/
+func (srv *Server) audit(r *http.Request, action models.AuditAction, targetType string, targetID uint, before, after interface{}) {
+	claims := r.Context().Value(ClaimsKey).(*Claims)
+	entry := models.AuditLog{
+		ActorID:    claims.UserID,
+		FacilityID: claims.FacilityID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		IP:         clientIP(r),
+	}
+	if actor, err := srv.Db.GetUserByID(claims.UserID); err == nil {
+		entry.ActorUsername = actor.Username
+	}
+	if err := srv.Db.CreateAuditLog(&entry); err != nil {
+		log.WithFields(log.Fields{"action": action, "actor_id": claims.UserID, "target_type": targetType, "target_id": targetID, "error": err.Error()}).Error("error recording audit log entry")
+	}
+}
+
+func auditSnapshot(state interface{}) datatypes.JSON {
+	if state == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Errorf("error encoding audit log snapshot: %v", err)
+		return nil
+	}
+	return datatypes.JSON(data)
+}
+
+/**
+* nginx passes on the client's address as X-Real-IP, which is only taken from the
+* proxies listed in TRUSTED_PROXIES, otherwise it's the address of the connection
+**/
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && trustedProxy(host) {
+		return ip
+	}
+	return host
+}
+
+// TRUSTED_PROXIES is a comma separated list of addresses and/or CIDR ranges
+func trustedProxy(host string) bool {
+	addr := net.ParseIP(host)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* Access keys are never written to the audit log, only a fingerprint of the key,
+* which is enough to tell that (and when) it was changed
+**/
+func keyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+/**
+* Parses the filters shared by the log and its export. Admins see every facility's
+* entries (or one facility's, with ?facility_id=), anyone else those of the
+* facility they are viewing.
+**/
+func (srv *Server) auditLogFilter(r *http.Request) (*database.DB, database.AuditLogFilter, error) {
+	query := r.URL.Query()
+	filter := database.AuditLogFilter{Action: models.AuditAction(query.Get("action")), TargetType: query.Get("target_type")}
+	for param, value := range map[string]*uint{"actor_id": &filter.ActorID, "target_id": &filter.TargetID, "facility_id": &filter.FacilityID} {
+		if query.Get(param) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(query.Get(param))
+		if err != nil {
+			return nil, filter, errors.New("invalid " + param)
+		}
+		*value = uint(id)
+	}
+	var err error
+	if filter.From, filter.To, err = dateRange(r); err != nil {
+		return nil, filter, err
+	}
+	if srv.UserIsAdmin(r) {
+		return srv.Db, filter, nil
+	}
+	filter.FacilityID = 0
+	return srv.facilityDb(r), filter, nil
+}
+
+/**
+* GET: /api/audit-log
+* newest first
+* @Query Params:
+* ?action=, ?actor_id=, ?target_type=, ?target_id=, ?facility_id= (admins only)
+* ?from=, ?to=: YYYY-MM-DD
+**/
+func (srv *Server) HandleIndexAuditLog(w http.ResponseWriter, r *http.Request) {
+	db, filter, err := srv.auditLogFilter(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, perPage := srv.GetPaginationInfo(r)
+	total, entries, err := db.GetAuditLogs(page, perPage, filter)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleIndexAuditLog", "error": err.Error()}).Error("error fetching audit log")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.AuditLog]{
+		Meta: models.NewPaginationInfo(page, perPage, total),
+		Data: entries,
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+/**
+* GET: /api/audit-log/export
+* every entry matching the same filters as the log, oldest first
+* @Query Params:
+* ?format=: csv (default) or xlsx
+**/
+func (srv *Server) HandleExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	db, filter, err := srv.auditLogFilter(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format, err := exports.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writer := startExport(w, format, "audit-log")
+	err = writer.Write("id", "created_at", "actor_id", "actor_username", "facility_id", "action", "target_type", "target_id", "ip", "before", "after")
+	if err == nil {
+		err = db.EachAuditLog(filter, func(entries []models.AuditLog) error {
+			for _, entry := range entries {
+				if err := writer.Write(entry.ID, entry.CreatedAt, entry.ActorID, entry.ActorUsername, entry.FacilityID, string(entry.Action),
+					entry.TargetType, entry.TargetID, entry.IP, string(entry.Before), string(entry.After)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	finishExport(writer, "HandleExportAuditLog", err)
+}
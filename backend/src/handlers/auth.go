@@ -34,7 +34,7 @@ func (srv *Server) registerAuthRoutes() {
 	srv.Mux.Handle("POST /api/reset-password", srv.applyMiddleware(srv.handleResetPassword))
 	/* only use auth middleware, user activity bloats the database + results */
 	srv.Mux.Handle("GET /api/auth", srv.AuthMiddleware(http.HandlerFunc(srv.handleCheckAuth)))
-	srv.Mux.Handle("PUT /api/admin/facility-context/{id}", srv.ApplyAdminMiddleware(srv.handleChangeAdminFacility))
+	srv.Mux.Handle("PUT /api/admin/facility-context/{id}", srv.applyMiddleware(srv.handleChangeAdminFacility))
 }
 
 func (s *Server) AuthMiddleware(next http.Handler) http.HandlerFunc {
@@ -74,6 +74,16 @@ func (srv *Server) handleChangeAdminFacility(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	claims := r.Context().Value(ClaimsKey).(*Claims)
+	canView, err := srv.canViewFacility(claims, uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !canView {
+		srv.ErrorResponse(w, http.StatusForbidden, "no role assigned at this facility")
+		return
+	}
+	previous := claims.FacilityID
 	claims.FacilityID = uint(id)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := token.SignedString([]byte(os.Getenv("APP_KEY")))
@@ -89,6 +99,8 @@ func (srv *Server) handleChangeAdminFacility(w http.ResponseWriter, r *http.Requ
 		Secure:   true,
 		Path:     "/",
 	})
+	// recorded in the facility being switched to, so its admins can see who came in
+	srv.audit(r, models.AuditFacilitySwitched, "facility", uint(id), map[string]uint{"facility_id": previous}, map[string]uint{"facility_id": uint(id)})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -108,7 +120,64 @@ func (srv *Server) canViewUserData(r *http.Request) bool {
 		return false
 	}
 	claims := r.Context().Value(ClaimsKey).(*Claims)
-	return claims.Role == models.Admin || claims.UserID == uint(id)
+	if claims.UserID == uint(id) {
+		return true
+	}
+	return srv.HasPermission(r, models.ViewUsers) && srv.userInFacility(r, uint(id))
+}
+
+// whether the user has the permission at the facility they are currently viewing
+func (srv *Server) HasPermission(r *http.Request, permission models.Permission) bool {
+	claims, ok := r.Context().Value(ClaimsKey).(*Claims)
+	if !ok {
+		return false
+	}
+	return srv.hasPermissionAt(claims, claims.FacilityID, permission)
+}
+
+func (srv *Server) hasPermissionAt(claims *Claims, facilityID uint, permission models.Permission) bool {
+	if claims.Role == models.Admin {
+		return true
+	}
+	allowed, err := srv.Db.UserHasPermission(claims.UserID, facilityID, permission)
+	if err != nil {
+		log.WithFields(log.Fields{"user_id": claims.UserID, "facility_id": facilityID, "permission": permission, "error": err.Error()}).Error("error checking user permission")
+		return false
+	}
+	return allowed
+}
+
+// admins may view any facility, everyone else their own and those they've been assigned a role at
+func (srv *Server) canViewFacility(claims *Claims, facilityID uint) (bool, error) {
+	if claims.Role == models.Admin {
+		return true, nil
+	}
+	user, err := srv.Db.GetUserByID(claims.UserID)
+	if err != nil {
+		return false, err
+	}
+	if user.FacilityID == facilityID {
+		return true, nil
+	}
+	assignments, err := srv.Db.GetRoleAssignmentsAtFacility(claims.UserID, facilityID)
+	if err != nil {
+		return false, err
+	}
+	return len(assignments) > 0, nil
+}
+
+func (srv *Server) PermissionMiddleware(permission models.Permission, next func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ClaimsKey).(*Claims); !ok {
+			http.Error(w, "Unauthorized - no claims", http.StatusUnauthorized)
+			return
+		}
+		if !srv.HasPermission(r, permission) {
+			http.Error(w, "Forbidden - missing permission "+string(permission), http.StatusForbidden)
+			return
+		}
+		http.HandlerFunc(next).ServeHTTP(w, r)
+	})
 }
 
 func (srv *Server) adminMiddleware(next func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
@@ -138,12 +207,20 @@ func (srv *Server) handleCheckAuth(w http.ResponseWriter, r *http.Request) {
 	}
 	fields["user.username"] = user.Username
 	fields["facility_id"] = user.FacilityID
+	if !user.IsActive() {
+		log.WithFields(fields).Error("inactive user tried to use their session")
+		srv.ErrorResponse(w, http.StatusUnauthorized, "this account is "+string(user.Status))
+		return
+	}
 	if user.Role != models.Admin && user.FacilityID != claims.FacilityID {
 		// user isn't an admin, and has alternate facility_id in the JWT claims
 		fields["claims.facility_id"] = claims.FacilityID
-		log.WithFields(fields).Error("user viewing context for different facility. this should never happen")
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+		assignments, err := srv.Db.GetRoleAssignmentsAtFacility(user.ID, claims.FacilityID)
+		if err != nil || len(assignments) == 0 {
+			log.WithFields(fields).Error("user viewing context for facility they have no role at")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 	}
 	if err := srv.validateOrySession(r, user.ID); err != nil {
 		log.WithFields(fields).Errorln("invalid ory session found")
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"UnlockEdv2/src/pdf"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerCertificateRoutes() {
+	// public, for employers, parole officers etc. to check a certificate they've been shown
+	srv.Mux.HandleFunc("GET /verify/{code}", srv.HandleVerifyCertificate)
+	srv.Mux.Handle("GET /api/users/{id}/certificates", srv.applyMiddleware(srv.HandleUserCertificates))
+	srv.Mux.Handle("GET /api/users/{id}/certificates/{certificate_id}/pdf", srv.applyMiddleware(srv.HandleCertificatePDF))
+	srv.Mux.Handle("GET /api/programs/{id}/certificate-template", srv.ApplyPermissionMiddleware(models.ViewPrograms, srv.HandleShowCertificateTemplate))
+	srv.Mux.Handle("PUT /api/programs/{id}/certificate-template", srv.ApplyPermissionMiddleware(models.ManagePrograms, srv.HandleUpdateCertificateTemplate))
+}
+
+/**
+* Issues a certificate for the outcome if its program's template says it earns
+* one. Outcomes imported from providers are picked up by issuePendingCertificates
+* once the import has run.
+**/
+func (srv *Server) issueCertificate(outcome *models.Outcome) error {
+	template, err := srv.Db.GetCertificateTemplate(outcome.ProgramID)
+	if err != nil {
+		return err
+	}
+	if !template.IssuesFor(outcome.Type) {
+		return nil
+	}
+	return srv.createCertificate(outcome)
+}
+
+func (srv *Server) issuePendingCertificates() {
+	outcomes, err := srv.Db.GetOutcomesAwaitingCertificates()
+	if err != nil {
+		log.Errorln("error fetching outcomes awaiting certificates", err)
+		return
+	}
+	for idx := range outcomes {
+		if err := srv.createCertificate(&outcomes[idx]); err != nil {
+			log.WithFields(log.Fields{"outcome_id": outcomes[idx].ID, "error": err.Error()}).Error("error issuing certificate")
+		}
+	}
+}
+
+func (srv *Server) createCertificate(outcome *models.Outcome) error {
+	user, err := srv.Db.GetUserByID(outcome.UserID)
+	if err != nil {
+		return err
+	}
+	program, err := srv.Db.GetProgramByID(int(outcome.ProgramID))
+	if err != nil {
+		return err
+	}
+	cert := models.IssuedCertificate{
+		Code:        models.NewDocumentID("CE"),
+		UserID:      &user.ID,
+		ProgramID:   program.ID,
+		OutcomeID:   &outcome.ID,
+		HolderName:  user.NameFirst + " " + user.NameLast,
+		ProgramName: program.Name,
+		IssuedAt:    outcome.CreatedAt,
+	}
+	if err := cert.Sign(); err != nil {
+		log.WithFields(log.Fields{"user_id": user.ID, "outcome_id": outcome.ID}).Error("refusing to issue an unsigned certificate, APP_KEY is not set")
+		return err
+	}
+	created, err := srv.Db.CreateCertificate(&cert)
+	if created {
+		log.WithFields(log.Fields{"user_id": user.ID, "program_id": program.ID, "code": cert.Code}).Info("issued certificate")
+	}
+	return err
+}
+
+type CertificateVerification struct {
+	Code        string `json:"code"`
+	HolderName  string `json:"holder_name"`
+	ProgramName string `json:"program_name"`
+	IssuedAt    string `json:"issued_at"`
+	Valid       bool   `json:"valid"`
+	Revoked     bool   `json:"revoked"` // the outcome it was issued for has been removed
+}
+
+/**
+* GET: /verify/{code}
+* the holder, program and date of the certificate with the code. It is only valid
+* if what is recorded still matches its signature, and it hasn't been revoked.
+**/
+func (srv *Server) HandleVerifyCertificate(w http.ResponseWriter, r *http.Request) {
+	cert, err := srv.Db.GetCertificateByCode(models.NormalizeDocumentID(r.PathValue("code")))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "No certificate was issued with this code")
+		return
+	}
+	verification := CertificateVerification{
+		Code:        cert.Code,
+		HolderName:  cert.HolderName,
+		ProgramName: cert.ProgramName,
+		IssuedAt:    cert.IssuedAt.Format("2006-01-02"),
+		Revoked:     cert.IsRevoked(),
+	}
+	verification.Valid = cert.SignatureValid() && !verification.Revoked
+	if !cert.SignatureValid() {
+		log.WithField("code", cert.Code).Warn("certificate does not match its signature")
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]CertificateVerification{verification}))
+}
+
+func (srv *Server) HandleUserCertificates(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's certificates")
+		return
+	}
+	certs, err := srv.Db.GetCertificatesForUser(uint(userID))
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleUserCertificates", "error": err.Error()}).Error("error fetching certificates")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource(certs))
+}
+
+/**
+* GET: /api/users/{id}/certificates/{certificate_id}/pdf
+* the certificate, printed with its program's template
+**/
+func (srv *Server) HandleCertificatePDF(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	certID, err := strconv.Atoi(r.PathValue("certificate_id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid certificate ID")
+		return
+	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's certificates")
+		return
+	}
+	cert, err := srv.Db.GetCertificateByID(uint(certID))
+	if err != nil || cert.UserID == nil || *cert.UserID != uint(userID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+	template, err := srv.Db.GetCertificateTemplate(cert.ProgramID)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleCertificatePDF", "error": err.Error()}).Error("error fetching certificate template")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="certificate-`+cert.Code+`.pdf"`)
+	if _, err := w.Write(renderCertificate(cert, template)); err != nil {
+		log.Errorf("error writing certificate: %v", err)
+	}
+}
+
+func renderCertificate(cert *models.IssuedCertificate, template *models.CertificateTemplate) []byte {
+	doc := pdf.New(template.Title + ": " + cert.HolderName)
+	page := doc.AddPage(pdf.LetterLandscape)
+	center := page.Width / 2
+	page.Rect(30, 30, page.Width-60, page.Height-60, 3)
+	page.Rect(40, 40, page.Width-80, page.Height-80, 0.75)
+
+	page.CenteredText(center, 480, pdf.HelveticaBold, 34, template.Title)
+	page.CenteredText(center, 420, pdf.Helvetica, 14, "This certifies that")
+	page.CenteredText(center, 372, pdf.HelveticaBold, 30, cert.HolderName)
+	page.Line(center-200, 362, center+200, 362, 0.75)
+	y := 330.0
+	for _, line := range pdf.Wrap(pdf.Helvetica, 14, page.Width-200, template.Body) {
+		page.CenteredText(center, y, pdf.Helvetica, 14, line)
+		y -= 18
+	}
+	for _, line := range pdf.Wrap(pdf.HelveticaBold, 20, page.Width-200, cert.ProgramName) {
+		page.CenteredText(center, y-10, pdf.HelveticaBold, 20, line)
+		y -= 24
+	}
+
+	page.Line(110, 150, 310, 150, 0.75)
+	page.CenteredText(210, 136, pdf.Helvetica, 11, "Date")
+	page.CenteredText(210, 156, pdf.Helvetica, 12, cert.IssuedAt.Format("January 2, 2006"))
+	page.Line(page.Width-310, 150, page.Width-110, 150, 0.75)
+	if template.Signatory != "" {
+		page.CenteredText(page.Width-210, 156, pdf.Helvetica, 12, template.Signatory)
+	}
+	page.CenteredText(page.Width-210, 136, pdf.Helvetica, 11, orDefault(template.SignatoryTitle, "Signature"))
+
+	page.CenteredText(center, 70, pdf.Helvetica, 9, "Certificate "+cert.Code+" - verify at "+os.Getenv("APP_URL")+"/verify/"+cert.Code)
+	return doc.Bytes()
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func (srv *Server) HandleShowCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid program ID")
+		return
+	}
+	template, err := srv.Db.GetCertificateTemplate(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.CertificateTemplate{*template}))
+}
+
+/**
+* PUT: /api/programs/{id}/certificate-template
+* outcomes of the template's types earn a certificate, including those already
+* recorded, which are issued theirs straight away
+**/
+func (srv *Server) HandleUpdateCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid program ID")
+		return
+	}
+	if _, err := srv.Db.GetProgramByID(id); err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "Program not found")
+		return
+	}
+	var template models.CertificateTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid certificate template")
+		return
+	}
+	template.ProgramID = uint(id)
+	if template.Title == "" {
+		srv.ErrorResponse(w, http.StatusBadRequest, "A certificate template needs a title")
+		return
+	}
+	if template.NormalizeOutcomeTypes(); template.OutcomeTypes == "" {
+		srv.ErrorResponse(w, http.StatusBadRequest, "A certificate template needs at least one outcome type")
+		return
+	}
+	if err := srv.Db.SaveCertificateTemplate(&template); err != nil {
+		log.WithFields(log.Fields{"handler": "HandleUpdateCertificateTemplate", "error": err.Error()}).Error("error saving certificate template")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.issuePendingCertificates()
+	saved, err := srv.Db.GetCertificateTemplate(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.CertificateTemplate{*saved}))
+}
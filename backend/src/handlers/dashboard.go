@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"UnlockEdv2/src/models"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,7 +11,7 @@ import (
 
 func (srv *Server) registerDashboardRoutes() {
 	srv.Mux.Handle("GET /api/users/{id}/student-dashboard", srv.applyMiddleware(srv.HandleStudentDashboard))
-	srv.Mux.Handle("GET /api/users/{id}/admin-dashboard", srv.ApplyAdminMiddleware(srv.HandleAdminDashboard))
+	srv.Mux.Handle("GET /api/users/{id}/admin-dashboard", srv.ApplyPermissionMiddleware(models.ViewReports, srv.HandleAdminDashboard))
 	srv.Mux.Handle("GET /api/users/{id}/catalogue", srv.applyMiddleware(srv.HandleUserCatalogue))
 	srv.Mux.Handle("GET /api/users/{id}/programs", srv.applyMiddleware(srv.HandleUserPrograms))
 }
@@ -25,7 +26,11 @@ func (srv *Server) HandleStudentDashboard(w http.ResponseWriter, r *http.Request
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	studentDashboard, err := srv.Db.GetStudentDashboardInfo(userId, faciltiyId)
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's dashboard")
+		return
+	}
+	studentDashboard, err := srv.userDb(r, uint(userId)).GetStudentDashboardInfo(userId, faciltiyId)
 	if err != nil {
 		fields["error"] = err.Error()
 		log.WithFields(fields).Errorf("Error getting user dashboard info: %v", err)
@@ -38,7 +43,7 @@ func (srv *Server) HandleStudentDashboard(w http.ResponseWriter, r *http.Request
 func (srv *Server) HandleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 	fields := log.Fields{"handler": "HandleAdminDashboard"}
 	claims := r.Context().Value(ClaimsKey).(*Claims)
-	adminDashboard, err := srv.Db.GetAdminDashboardInfo(claims.FacilityID)
+	adminDashboard, err := srv.facilityDb(r).GetAdminDashboardInfo(claims.FacilityID)
 	if err != nil {
 		fields["error"] = err.Error()
 		log.WithFields(fields).Errorf("Error getting user dashboard info: %v", err)
@@ -62,10 +67,14 @@ func (srv *Server) HandleUserCatalogue(w http.ResponseWriter, r *http.Request) {
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's programs")
+		return
+	}
 	tags := r.URL.Query()["tags"]
 	search := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("search")))
 	order := r.URL.Query().Get("order")
-	userCatalogue, err := srv.Db.GetUserCatalogue(userId, tags, search, order)
+	userCatalogue, err := srv.userDb(r, uint(userId)).GetUserCatalogue(userId, srv.getFacilityID(r), tags, search, order)
 	if err != nil {
 		log.Errorf("Error getting user catalogue info: %v", err)
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
@@ -91,7 +100,7 @@ func (srv *Server) HandleUserPrograms(w http.ResponseWriter, r *http.Request) {
 	search = strings.ToLower(search)
 	search = strings.TrimSpace(search)
 	tags := r.URL.Query()["tags"]
-	userPrograms, numCompleted, totalTime, err := srv.Db.GetUserPrograms(uint(userId), order, orderBy, search, tags)
+	userPrograms, numCompleted, totalTime, err := srv.userDb(r, uint(userId)).GetUserPrograms(uint(userId), srv.getFacilityID(r), order, orderBy, search, tags)
 	if err != nil {
 		log.Errorf("Error getting user programs: %v", err)
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
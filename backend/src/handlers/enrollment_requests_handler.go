@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerEnrollmentRequestRoutes() {
+	srv.Mux.Handle("GET /api/enrollment-requests", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleIndexEnrollmentRequests))
+	srv.Mux.Handle("GET /api/users/{id}/enrollment-requests", srv.applyMiddleware(srv.HandleIndexUserEnrollmentRequests))
+	srv.Mux.Handle("POST /api/programs/{id}/enrollment-requests", srv.applyMiddleware(srv.HandleCreateEnrollmentRequest))
+	srv.Mux.Handle("POST /api/enrollment-requests/{id}/approve", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleApproveEnrollmentRequest))
+	srv.Mux.Handle("POST /api/enrollment-requests/{id}/deny", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleDenyEnrollmentRequest))
+	srv.Mux.Handle("POST /api/enrollment-requests/{id}/cancel", srv.applyMiddleware(srv.HandleCancelEnrollmentRequest))
+}
+
+/**
+* GET: /api/enrollment-requests
+* the review queue of the facility being viewed, oldest first
+* @Query Params:
+* ?status=: "requested" (default), "approved", "denied", "cancelled" or "all"
+* ?program_id=
+**/
+func (srv *Server) HandleIndexEnrollmentRequests(w http.ResponseWriter, r *http.Request) {
+	status := models.EnrollmentRequestStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = models.RequestPending
+	case "all":
+		status = ""
+	}
+	filter := database.EnrollmentFilter{}
+	if programID, err := strconv.Atoi(r.URL.Query().Get("program_id")); err == nil {
+		filter.ProgramID = uint(programID)
+	}
+	srv.writeEnrollmentRequests(w, r, srv.facilityDb(r), filter, status)
+}
+
+/**
+* GET: /api/users/{id}/enrollment-requests
+**/
+func (srv *Server) HandleIndexUserEnrollmentRequests(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's enrollment requests")
+		return
+	}
+	status := models.EnrollmentRequestStatus(r.URL.Query().Get("status"))
+	srv.writeEnrollmentRequests(w, r, srv.userDb(r, uint(id)), database.EnrollmentFilter{UserID: uint(id)}, status)
+}
+
+func (srv *Server) writeEnrollmentRequests(w http.ResponseWriter, r *http.Request, db *database.DB, filter database.EnrollmentFilter, status models.EnrollmentRequestStatus) {
+	page, perPage := srv.GetPaginationInfo(r)
+	total, requests, err := db.GetEnrollmentRequests(page, perPage, filter, status)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "writeEnrollmentRequests", "error": err.Error()}).Error("error fetching enrollment requests")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.EnrollmentRequest]{
+		Meta: models.NewPaginationInfo(page, perPage, total),
+		Data: requests,
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+type EnrollmentRequestForm struct {
+	Reason string `json:"reason"`
+}
+
+/**
+* POST: /api/programs/{id}/enrollment-requests
+* a learner asks to join a fixed enrollment program from their catalogue
+**/
+func (srv *Server) HandleCreateEnrollmentRequest(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleCreateEnrollmentRequest"}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid program id")
+		return
+	}
+	fields["program_id"] = id
+	var form EnrollmentRequestForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	program, err := srv.Db.GetProgramByID(id)
+	if err != nil || !srv.Db.IsProgramPublished(id, srv.getFacilityID(r)) {
+		srv.ErrorResponse(w, http.StatusNotFound, "program not found")
+		return
+	}
+	if program.Type != models.FixedEnrollment {
+		srv.ErrorResponse(w, http.StatusBadRequest, "only fixed enrollment programs need a request to join")
+		return
+	}
+	request := models.EnrollmentRequest{UserID: srv.GetUserID(r), ProgramID: program.ID, Reason: form.Reason}
+	if err := srv.Db.CreateEnrollmentRequest(&request); err != nil {
+		if errors.Is(err, database.ErrAlreadyEnrolled) || errors.Is(err, database.ErrRequestPending) {
+			srv.ErrorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating enrollment request")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusCreated, models.Resource[models.EnrollmentRequest]{Data: []models.EnrollmentRequest{request}})
+}
+
+type EnrollmentDecisionForm struct {
+	Note string `json:"note"`
+}
+
+/**
+* POST: /api/enrollment-requests/{id}/approve
+* The learner's account in the program's provider is created first (if they don't
+* have one), so that a request is only approved once they can actually take part.
+* If that fails the request stays in the queue, to be approved again later.
+**/
+func (srv *Server) HandleApproveEnrollmentRequest(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleApproveEnrollmentRequest"}
+	request, form, ok := srv.pendingEnrollmentRequest(w, r)
+	if !ok {
+		return
+	}
+	fields["request_id"] = request.ID
+	reviewerID := srv.GetUserID(r)
+	if request.UserID == reviewerID {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not review your own request")
+		return
+	}
+	program, err := srv.Db.GetProgramByID(int(request.ProgramID))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "program not found")
+		return
+	}
+	user, err := srv.facilityDb(r).GetUserByID(request.UserID)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	provider, err := srv.Db.GetProviderPlatformByID(int(program.ProviderPlatformID))
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error getting the program's provider platform")
+		srv.ErrorResponse(w, http.StatusInternalServerError, "unable to find the program's provider platform")
+		return
+	}
+	if err := srv.ensureProviderUserAccount(provider, user); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating provider account for approved enrollment")
+		srv.ErrorResponse(w, http.StatusBadGateway, "unable to create the user's account in "+provider.Name+", the request has not been approved")
+		return
+	}
+	enrollment, err := srv.facilityDb(r).DecideEnrollmentRequest(request, models.RequestApproved, &reviewerID, form.Note)
+	if err != nil {
+		srv.writeDecisionError(w, fields, err)
+		return
+	}
+	log.WithFields(log.Fields{"request_id": request.ID, "user_id": request.UserID, "program_id": request.ProgramID, "reviewer_id": reviewerID}).Info("enrollment request approved")
+	srv.pushEnrollment(enrollment)
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.Enrollment]{Data: []models.Enrollment{*enrollment}})
+}
+
+/**
+* POST: /api/enrollment-requests/{id}/deny
+**/
+func (srv *Server) HandleDenyEnrollmentRequest(w http.ResponseWriter, r *http.Request) {
+	request, form, ok := srv.pendingEnrollmentRequest(w, r)
+	if !ok {
+		return
+	}
+	reviewerID := srv.GetUserID(r)
+	if request.UserID == reviewerID {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not review your own request")
+		return
+	}
+	if _, err := srv.facilityDb(r).DecideEnrollmentRequest(request, models.RequestDenied, &reviewerID, form.Note); err != nil {
+		srv.writeDecisionError(w, log.Fields{"handler": "HandleDenyEnrollmentRequest", "request_id": request.ID}, err)
+		return
+	}
+	log.WithFields(log.Fields{"request_id": request.ID, "user_id": request.UserID, "program_id": request.ProgramID, "reviewer_id": reviewerID}).Info("enrollment request denied")
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.EnrollmentRequest]{Data: []models.EnrollmentRequest{*request}})
+}
+
+/**
+* POST: /api/enrollment-requests/{id}/cancel
+* learners may withdraw their own requests while they wait for review
+**/
+func (srv *Server) HandleCancelEnrollmentRequest(w http.ResponseWriter, r *http.Request) {
+	request, _, ok := srv.pendingEnrollmentRequest(w, r)
+	if !ok {
+		return
+	}
+	if request.UserID != srv.GetUserID(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "only the learner who made a request may cancel it")
+		return
+	}
+	if _, err := srv.Db.DecideEnrollmentRequest(request, models.RequestCancelled, nil, ""); err != nil {
+		srv.writeDecisionError(w, log.Fields{"handler": "HandleCancelEnrollmentRequest", "request_id": request.ID}, err)
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.EnrollmentRequest]{Data: []models.EnrollmentRequest{*request}})
+}
+
+// reads the request being decided, which must still be waiting for review
+func (srv *Server) pendingEnrollmentRequest(w http.ResponseWriter, r *http.Request) (*models.EnrollmentRequest, EnrollmentDecisionForm, bool) {
+	var form EnrollmentDecisionForm
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment request id")
+		return nil, form, false
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+			srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return nil, form, false
+		}
+		defer r.Body.Close()
+	}
+	request, err := srv.Db.GetEnrollmentRequestByID(uint(id))
+	if err != nil || !(request.UserID == srv.GetUserID(r) || srv.userInFacility(r, request.UserID)) {
+		srv.ErrorResponse(w, http.StatusNotFound, "enrollment request not found")
+		return nil, form, false
+	}
+	if request.Status != models.RequestPending {
+		srv.ErrorResponse(w, http.StatusConflict, database.ErrRequestDecided.Error())
+		return nil, form, false
+	}
+	return request, form, true
+}
+
+func (srv *Server) writeDecisionError(w http.ResponseWriter, fields log.Fields, err error) {
+	if errors.Is(err, database.ErrRequestDecided) || errors.Is(err, database.ErrAlreadyEnrolled) {
+		srv.ErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	fields["error"] = err.Error()
+	log.WithFields(fields).Error("error deciding enrollment request")
+	srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+}
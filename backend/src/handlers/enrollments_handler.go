@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"UnlockEdv2/src"
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerEnrollmentRoutes() {
+	srv.Mux.Handle("GET /api/enrollments", srv.ApplyPermissionMiddleware(models.ViewUsers, srv.HandleIndexEnrollments))
+	srv.Mux.Handle("GET /api/users/{id}/enrollments", srv.applyMiddleware(srv.HandleIndexUserEnrollments))
+	srv.Mux.Handle("GET /api/programs/{id}/enrollments", srv.ApplyPermissionMiddleware(models.ViewUsers, srv.HandleProgramRoster))
+	srv.Mux.Handle("POST /api/programs/{id}/enrollments", srv.applyMiddleware(srv.HandleCreateEnrollment))
+	srv.Mux.Handle("PATCH /api/enrollments/{id}", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleUpdateEnrollment))
+	srv.Mux.Handle("DELETE /api/enrollments/{id}", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleDeleteEnrollment))
+	srv.Mux.Handle("POST /api/enrollments/{id}/sync", srv.ApplyPermissionMiddleware(models.ManageEnrollments, srv.HandleSyncEnrollment))
+}
+
+/**
+* GET: /api/enrollments
+* @Query Params:
+* ?user_id=, ?program_id=, ?status=: "pending", "active", "completed", "withdrawn", "waitlisted"
+**/
+func (srv *Server) HandleIndexEnrollments(w http.ResponseWriter, r *http.Request) {
+	filter := database.EnrollmentFilter{Status: models.EnrollmentStatus(r.URL.Query().Get("status"))}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment status")
+		return
+	}
+	if userID, err := strconv.Atoi(r.URL.Query().Get("user_id")); err == nil {
+		filter.UserID = uint(userID)
+	}
+	if programID, err := strconv.Atoi(r.URL.Query().Get("program_id")); err == nil {
+		filter.ProgramID = uint(programID)
+	}
+	srv.writeEnrollments(w, r, srv.facilityDb(r), filter)
+}
+
+/**
+* GET: /api/users/{id}/enrollments
+**/
+func (srv *Server) HandleIndexUserEnrollments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's enrollments")
+		return
+	}
+	filter := database.EnrollmentFilter{UserID: uint(id), Status: models.EnrollmentStatus(r.URL.Query().Get("status"))}
+	srv.writeEnrollments(w, r, srv.userDb(r, uint(id)), filter)
+}
+
+/**
+* GET: /api/programs/{id}/enrollments
+* the program's roster at the facility being viewed
+**/
+func (srv *Server) HandleProgramRoster(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid program id")
+		return
+	}
+	filter := database.EnrollmentFilter{ProgramID: uint(id), Status: models.EnrollmentStatus(r.URL.Query().Get("status"))}
+	srv.writeEnrollments(w, r, srv.facilityDb(r), filter)
+}
+
+func (srv *Server) writeEnrollments(w http.ResponseWriter, r *http.Request, db *database.DB, filter database.EnrollmentFilter) {
+	page, perPage := srv.GetPaginationInfo(r)
+	total, enrollments, err := db.GetEnrollments(page, perPage, filter)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "writeEnrollments", "error": err.Error()}).Error("error fetching enrollments")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.Enrollment]{
+		Meta: models.NewPaginationInfo(page, perPage, total),
+		Data: enrollments,
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+type EnrollmentRequest struct {
+	UserID    uint                    `json:"user_id"`
+	Status    models.EnrollmentStatus `json:"status"`
+	StartDate *time.Time              `json:"start_date"`
+	EndDate   *time.Time              `json:"end_date"`
+}
+
+/**
+* POST: /api/programs/{id}/enrollments
+* Staff who can manage enrollments place users of their facility on the roster
+* of a program published there. Students may only enroll themselves, and only
+* in open enrollment programs, fixed enrollment programs are staffed rosters.
+**/
+func (srv *Server) HandleCreateEnrollment(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleCreateEnrollment"}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid program id")
+		return
+	}
+	fields["program_id"] = id
+	var form EnrollmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	program, err := srv.Db.GetProgramByID(id)
+	if err != nil || !srv.Db.IsProgramPublished(id, srv.getFacilityID(r)) {
+		srv.ErrorResponse(w, http.StatusNotFound, "program not found")
+		return
+	}
+	if form.UserID == 0 {
+		form.UserID = srv.GetUserID(r)
+	}
+	if srv.HasPermission(r, models.ManageEnrollments) {
+		if form.Status == "" {
+			form.Status = models.EnrollmentActive
+		}
+	} else if form.UserID != srv.GetUserID(r) || program.Type == models.FixedEnrollment {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not enroll users in this program")
+		return
+	} else {
+		form.Status, form.EndDate = models.EnrollmentActive, nil
+	}
+	if !form.Status.IsValid() {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment status")
+		return
+	}
+	if !srv.userInFacility(r, form.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if form.StartDate == nil && form.Status == models.EnrollmentActive {
+		now := time.Now()
+		form.StartDate = &now
+	}
+	enrollment := models.Enrollment{UserID: form.UserID, ProgramID: program.ID, Status: form.Status, StartDate: form.StartDate, EndDate: form.EndDate}
+	if err := srv.Db.CreateEnrollment(&enrollment); err != nil {
+		if errors.Is(err, database.ErrAlreadyEnrolled) {
+			srv.ErrorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating enrollment")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.pushEnrollment(&enrollment)
+	srv.WriteResponse(w, http.StatusCreated, models.Resource[models.Enrollment]{Data: []models.Enrollment{enrollment}})
+}
+
+/**
+* PATCH: /api/enrollments/{id}
+* moves the enrollment through its lifecycle, and/or changes its dates
+**/
+func (srv *Server) HandleUpdateEnrollment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment id")
+		return
+	}
+	var form EnrollmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	db := srv.facilityDb(r)
+	enrollment, err := db.GetEnrollmentByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "enrollment not found")
+		return
+	}
+	if form.Status != "" {
+		if !form.Status.IsValid() || !enrollment.Status.CanTransitionTo(form.Status) {
+			srv.ErrorResponse(w, http.StatusBadRequest, "an enrollment which is "+string(enrollment.Status)+" cannot become "+string(form.Status))
+			return
+		}
+		// finishing (either way) ends the enrollment, unless the date was given
+		if form.EndDate == nil && enrollment.EndDate == nil && (form.Status == models.EnrollmentCompleted || form.Status == models.EnrollmentWithdrawn) {
+			now := time.Now()
+			form.EndDate = &now
+		}
+		if form.Status == models.EnrollmentActive && form.StartDate == nil && enrollment.StartDate == nil {
+			now := time.Now()
+			form.StartDate = &now
+		}
+		enrollment.Status = form.Status
+	}
+	if form.StartDate != nil {
+		enrollment.StartDate = form.StartDate
+	}
+	if form.EndDate != nil {
+		enrollment.EndDate = form.EndDate
+	}
+	if err := db.UpdateEnrollment(enrollment); err != nil {
+		log.WithFields(log.Fields{"handler": "HandleUpdateEnrollment", "enrollment_id": id, "error": err.Error()}).Error("error updating enrollment")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.pushEnrollment(enrollment)
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.Enrollment]{Data: []models.Enrollment{*enrollment}})
+}
+
+/**
+* DELETE: /api/enrollments/{id}
+* for enrollments made by mistake, a learner leaving a program is withdrawn instead.
+* The user is taken off the course's roster in the provider as well, if that fails
+* the enrollment is still deleted, and has to be removed in the provider by hand.
+**/
+func (srv *Server) HandleDeleteEnrollment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment id")
+		return
+	}
+	db := srv.facilityDb(r)
+	enrollment, err := db.GetEnrollmentByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "enrollment not found")
+		return
+	}
+	if err := srv.removeProviderEnrollment(enrollment); err != nil {
+		log.WithFields(log.Fields{"handler": "HandleDeleteEnrollment", "enrollment_id": id, "error": err.Error()}).Warn("error removing enrollment in the provider")
+	}
+	if err := db.DeleteEnrollment(enrollment); err != nil {
+		log.WithFields(log.Fields{"handler": "HandleDeleteEnrollment", "enrollment_id": id, "error": err.Error()}).Error("error deleting enrollment")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+* POST: /api/enrollments/{id}/sync
+* pushes the enrollment to the provider again, e.g. after the last attempt failed
+**/
+func (srv *Server) HandleSyncEnrollment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid enrollment id")
+		return
+	}
+	enrollment, err := srv.facilityDb(r).GetEnrollmentByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "enrollment not found")
+		return
+	}
+	srv.pushEnrollment(enrollment)
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.Enrollment]{Data: []models.Enrollment{*enrollment}})
+}
+
+// the provider service of the program's provider, nil if its rosters aren't managed from UnlockEd
+func (srv *Server) enrollmentProviderService(programID uint) (*src.ProviderService, *models.Program, error) {
+	program, err := srv.Db.GetProgramByID(int(programID))
+	if err != nil {
+		return nil, nil, err
+	}
+	// programs made in UnlockEd have no course in a provider
+	if program.ExternalID == "" {
+		return nil, program, nil
+	}
+	provider, err := srv.Db.GetProviderPlatformByID(int(program.ProviderPlatformID))
+	if err != nil {
+		return nil, nil, err
+	}
+	// moodle rosters are managed in moodle, and imported from there
+	if provider.Type == models.Moodle || provider.State != models.Enabled {
+		return nil, program, nil
+	}
+	service, err := src.GetProviderService(provider)
+	return service, program, err
+}
+
+/**
+* Applies a change to an enrollment in the program's provider, so the provider's
+* roster follows UnlockEd's. A failure doesn't undo the change, it is recorded on
+* the enrollment (as its sync_error) for staff to retry.
+**/
+func (srv *Server) pushEnrollment(enrollment *models.Enrollment) {
+	fields := log.Fields{"enrollment_id": enrollment.ID, "user_id": enrollment.UserID, "program_id": enrollment.ProgramID}
+	service, program, err := srv.enrollmentProviderService(enrollment.ProgramID)
+	if err == nil && service == nil {
+		return
+	}
+	var externalID string
+	if err == nil {
+		externalID, err = service.PushEnrollment(program.ExternalID, enrollment)
+	}
+	switch {
+	case errors.Is(err, src.ErrPushUnsupported):
+		return
+	case err != nil:
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error pushing enrollment to provider")
+		enrollment.SyncError = err.Error()
+	default:
+		now := time.Now()
+		enrollment.SyncedAt, enrollment.SyncError = &now, ""
+		if externalID != "" {
+			enrollment.ExternalID = externalID
+		}
+	}
+	if err := srv.Db.RecordEnrollmentSync(enrollment); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error recording enrollment sync")
+	}
+}
+
+func (srv *Server) removeProviderEnrollment(enrollment *models.Enrollment) error {
+	service, program, err := srv.enrollmentProviderService(enrollment.ProgramID)
+	if err != nil || service == nil {
+		return err
+	}
+	if err := service.RemoveEnrollment(program.ExternalID, enrollment); err != nil && !errors.Is(err, src.ErrPushUnsupported) {
+		return err
+	}
+	return nil
+}
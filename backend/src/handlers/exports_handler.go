@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/exports"
+	"UnlockEdv2/src/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerExportRoutes() {
+	srv.Mux.Handle("GET /api/exports/{dataset}", srv.ApplyPermissionMiddleware(models.ViewReports, srv.HandleExport))
+}
+
+// ?from= and ?to= are whole days (YYYY-MM-DD), both ends included
+func dateRange(r *http.Request) (from, to *time.Time, err error) {
+	for param, value := range map[string]**time.Time{"from": &from, "to": &to} {
+		if r.URL.Query().Get(param) == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", r.URL.Query().Get(param))
+		if err != nil {
+			return nil, nil, errors.New("invalid " + param + " date, expected YYYY-MM-DD")
+		}
+		if param == "to" {
+			day = day.AddDate(0, 0, 1)
+		}
+		*value = &day
+	}
+	return from, to, nil
+}
+
+func startExport(w http.ResponseWriter, format exports.Format, name string) exports.Writer {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+format.FileName(name)+`"`)
+	return exports.NewWriter(w, format, name)
+}
+
+func finishExport(writer exports.Writer, handler string, err error) {
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// the response has started, so the export is cut short rather than replaced with an error
+		log.WithFields(log.Fields{"handler": handler, "error": err.Error()}).Error("error writing export")
+	}
+}
+
+type exportDataset func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error
+
+/**
+* The datasets of /api/exports/{dataset}: each writes its header row, then streams
+* its rows to the writer as they are read from the database
+**/
+var exportDatasets = map[string]exportDataset{
+	"users": func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error {
+		if err := writer.Write("id", "username", "name_first", "name_last", "email", "role", "facility", "created_at"); err != nil {
+			return err
+		}
+		return db.ExportUsers(filter, func(row *database.UserExportRow) error {
+			return writer.Write(row.ID, row.Username, row.NameFirst, row.NameLast, row.Email, row.Role, row.Facility, row.CreatedAt)
+		})
+	},
+	"enrollments": func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error {
+		if err := writer.Write("id", "user_id", "username", "name_first", "name_last", "facility", "program", "status", "start_date", "end_date"); err != nil {
+			return err
+		}
+		return db.ExportEnrollments(filter, func(row *database.EnrollmentExportRow) error {
+			return writer.Write(row.ID, row.UserID, row.Username, row.NameFirst, row.NameLast, row.Facility, row.Program, row.Status, row.StartDate, row.EndDate)
+		})
+	},
+	"activity": func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error {
+		if err := writer.Write("user_id", "username", "name_first", "name_last", "facility", "program", "hours", "active_days", "first_activity", "last_activity"); err != nil {
+			return err
+		}
+		return db.ExportActivityHours(filter, func(row *database.ActivityExportRow) error {
+			return writer.Write(row.UserID, row.Username, row.NameFirst, row.NameLast, row.Facility, row.Program, row.Hours, row.ActiveDays, row.FirstActivity.Time, row.LastActivity.Time)
+		})
+	},
+	"milestones": func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error {
+		if err := writer.Write("id", "user_id", "username", "facility", "program", "type", "external_id", "is_completed", "created_at"); err != nil {
+			return err
+		}
+		return db.ExportMilestones(filter, func(row *database.MilestoneExportRow) error {
+			return writer.Write(row.ID, row.UserID, row.Username, row.Facility, row.Program, row.Type, row.ExternalID, row.IsCompleted, row.CreatedAt)
+		})
+	},
+	"outcomes": func(db *database.DB, filter database.ExportFilter, writer exports.Writer) error {
+		if err := writer.Write("id", "user_id", "username", "facility", "program", "type", "value", "created_at"); err != nil {
+			return err
+		}
+		return db.ExportOutcomes(filter, func(row *database.OutcomeExportRow) error {
+			return writer.Write(row.ID, row.UserID, row.Username, row.Facility, row.Program, row.Type, row.Value, row.CreatedAt)
+		})
+	},
+}
+
+// what was exported, as recorded in the audit log
+type exportAudit struct {
+	Dataset string                `json:"dataset"`
+	Format  exports.Format        `json:"format"`
+	Filter  database.ExportFilter `json:"filter"`
+}
+
+/**
+* GET: /api/exports/{dataset}
+* dataset: users, enrollments, activity (hours per user and program), milestones or outcomes
+* @Query Params:
+* ?format=: csv (default) or xlsx
+* ?from=, ?to=: YYYY-MM-DD
+* ?user_id=, ?program_id=, ?facility_id= (admins only, everyone else exports the facility they are viewing)
+**/
+func (srv *Server) HandleExport(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := exportDatasets[r.PathValue("dataset")]
+	if !ok {
+		srv.ErrorResponse(w, http.StatusNotFound, "unknown export, expected one of users, enrollments, activity, milestones or outcomes")
+		return
+	}
+	format, err := exports.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := database.ExportFilter{}
+	if filter.From, filter.To, err = dateRange(r); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for param, value := range map[string]*uint{"user_id": &filter.UserID, "program_id": &filter.ProgramID, "facility_id": &filter.FacilityID} {
+		if r.URL.Query().Get(param) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil {
+			srv.ErrorResponse(w, http.StatusBadRequest, "invalid "+param)
+			return
+		}
+		*value = uint(id)
+	}
+	if !srv.UserIsAdmin(r) {
+		filter.FacilityID = srv.getFacilityID(r)
+	}
+	srv.audit(r, models.AuditDataExported, "export", 0, nil, exportAudit{Dataset: r.PathValue("dataset"), Format: format, Filter: filter})
+	writer := startExport(w, format, r.PathValue("dataset"))
+	finishExport(writer, "HandleExport", dataset(srv.Db, filter, writer))
+}
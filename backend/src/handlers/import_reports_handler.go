@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerImportReportRoutes() {
+	srv.Mux.Handle("GET /api/import-reports", srv.ApplyAdminMiddleware(srv.HandleIndexImportReports))
+	srv.Mux.Handle("GET /api/import-reports/{id}", srv.ApplyAdminMiddleware(srv.HandleShowImportReport))
+	srv.Mux.Handle("GET /api/sync-jobs/{id}/runs/{run_id}/report", srv.ApplyAdminMiddleware(srv.HandleShowSyncJobRunReport))
+}
+
+func (srv *Server) HandleIndexImportReports(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleIndexImportReports"}
+	page, perPage := srv.GetPaginationInfo(r)
+	providerID, _ := strconv.Atoi(r.URL.Query().Get("provider_platform_id"))
+	jobType := models.JobType(r.URL.Query().Get("type"))
+	if jobType != "" && !jobType.IsValid() {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid import type")
+		return
+	}
+	total, reports, err := srv.Db.GetImportReports(page, perPage, uint(providerID), jobType)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching import reports")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.ImportReport]{
+		Message: "import reports fetched successfully",
+		Data:    reports,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+func (srv *Server) HandleShowImportReport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid import report id")
+		return
+	}
+	report, err := srv.Db.GetImportReportByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "import report not found")
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.ImportReport{*report}))
+}
+
+func (srv *Server) HandleShowSyncJobRunReport(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	runID, err := strconv.Atoi(r.PathValue("run_id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid sync job run id")
+		return
+	}
+	run, err := srv.Db.GetSyncJobRunByID(uint(runID))
+	if err != nil || run.SyncJobID != job.ID {
+		srv.ErrorResponse(w, http.StatusNotFound, "sync job run not found")
+		return
+	}
+	report, err := srv.Db.GetImportReportForRun(run.ID)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "no report was saved for this run")
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.ImportReport{*report}))
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"UnlockEdv2/src"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerLifecycleRoutes() {
+	srv.Mux.Handle("PUT /api/users/{id}/status", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleSetUserStatus))
+}
+
+type UserStatusRequest struct {
+	Status models.UserStatus `json:"status"`
+}
+
+// the user, and the providers in which their account couldn't be changed (setting the status again retries them)
+type UserStatusResult struct {
+	models.User
+	Errors []string `json:"errors"`
+}
+
+/**
+* Moves the user to the status, and applies its side effects. Their login is
+* disabled in kratos (and their sessions revoked) unless they are active, which is
+* done first so a failure leaves them as they were. A released or archived user's
+* accounts in the providers are deactivated, and they are reactivated with the
+* user, whose open enrollments are pushed to them again. A provider which can't
+* be reached doesn't stop the change, it is returned for staff to retry.
+**/
+func (srv *Server) setUserStatus(user *models.User, status models.UserStatus) ([]string, error) {
+	if !status.IsValid() {
+		return nil, fmt.Errorf("unknown status %q", status)
+	}
+	if srv.OryClient != nil && user.KratosID != "" {
+		if err := srv.setIdentityActiveInKratos(user.KratosID, status == models.UserActive); err != nil {
+			return nil, err
+		}
+	}
+	problems, restored := []string{}, []uint{}
+	switch {
+	case status == models.UserActive:
+		problems, restored = srv.setProviderAccountsActive(user, true)
+	case status.DeactivatesProviders():
+		problems, _ = srv.setProviderAccountsActive(user, false)
+	}
+	if user.Status != status {
+		now := time.Now()
+		user.Status, user.StatusChangedAt = status, &now
+	}
+	if _, err := srv.Db.UpdateUser(user); err != nil {
+		return problems, err
+	}
+	log.WithFields(log.Fields{"user_id": user.ID, "status": status}).Info("changed status of user")
+	if len(restored) > 0 {
+		srv.pushEnrollmentsToProviders(user, restored)
+	}
+	return problems, nil
+}
+
+// deactivates or reactivates the user's accounts which aren't already, returning the providers that failed and those that were reactivated
+func (srv *Server) setProviderAccountsActive(user *models.User, active bool) ([]string, []uint) {
+	problems, changed := []string{}, []uint{}
+	mappings, err := srv.Db.GetAllProviderMappingsForUser(int(user.ID))
+	if err != nil {
+		return append(problems, "unable to find the user's accounts in the providers: "+err.Error()), changed
+	}
+	for idx := range mappings {
+		mapping := &mappings[idx]
+		if (mapping.DeactivatedAt == nil) == active {
+			continue
+		}
+		provider, err := srv.Db.GetProviderPlatformByID(int(mapping.ProviderPlatformID))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("provider %d: %v", mapping.ProviderPlatformID, err))
+			continue
+		}
+		if provider.State != models.Enabled {
+			continue
+		}
+		service, err := src.GetProviderService(provider)
+		if err == nil {
+			err = service.SetUserActive(user.ID, active)
+		}
+		switch {
+		case errors.Is(err, src.ErrPushUnsupported):
+			continue
+		case err != nil:
+			log.WithFields(log.Fields{"user_id": user.ID, "provider_platform_id": provider.ID, "active": active, "error": err.Error()}).Error("error changing the user's account in the provider")
+			problems = append(problems, fmt.Sprintf("%s: %v", provider.Name, err))
+			continue
+		}
+		var deactivatedAt *time.Time
+		if !active {
+			now := time.Now()
+			deactivatedAt = &now
+		}
+		if err := srv.Db.SetProviderUserMappingDeactivated(mapping.ID, deactivatedAt); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", provider.Name, err))
+			continue
+		}
+		changed = append(changed, provider.ID)
+	}
+	return problems, changed
+}
+
+// e.g. a kolibri learner is put back in the classrooms they were taken out of when they were deactivated
+func (srv *Server) pushEnrollmentsToProviders(user *models.User, providerIDs []uint) {
+	enrollments, err := srv.Db.GetOpenEnrollmentsForUser(user.ID)
+	if err != nil {
+		log.WithFields(log.Fields{"user_id": user.ID, "error": err.Error()}).Error("error fetching enrollments to push to reactivated accounts")
+		return
+	}
+	for idx := range enrollments {
+		program := enrollments[idx].Program
+		if program != nil && program.ExternalID != "" && slices.Contains(providerIDs, program.ProviderPlatformID) {
+			srv.pushEnrollment(&enrollments[idx])
+		}
+	}
+}
+
+// removes an archived user for good, their login first so a failure leaves them to be purged again
+func (srv *Server) purgeUser(user *models.User) error {
+	if srv.OryClient != nil && user.KratosID != "" {
+		if err := srv.deleteIdentityInKratos(&user.KratosID); err != nil {
+			return err
+		}
+	}
+	if err := srv.Db.PurgeUserData(user.ID); err != nil {
+		return err
+	}
+	log.WithField("user_id", user.ID).Info("purged archived user")
+	return nil
+}
+
+/**
+* PUT: /api/users/{id}/status
+* suspends, releases, archives or reactivates the user (see setUserStatus)
+**/
+func (srv *Server) HandleSetUserStatus(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleSetUserStatus"}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	var request UserStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Status.IsValid() {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+	user, err := srv.facilityDb(r).GetUserByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.ID == srv.GetUserID(r) && request.Status != models.UserActive {
+		srv.ErrorResponse(w, http.StatusBadRequest, "you may not deactivate yourself")
+		return
+	}
+	// only admins may change the status of an admin
+	if !srv.canManageUser(r, user) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not change the status of this user")
+		return
+	}
+	before := *user
+	problems, err := srv.setUserStatus(user, request.Status)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error changing status of user")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.audit(r, models.AuditUserStatusChanged, "user", user.ID, before, user)
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]UserStatusResult{{User: *user, Errors: problems}}))
+}
+
+/**
+* GET: /api/users?include=only_archived
+* the facility's archived users, most recently archived first
+**/
+func (srv *Server) HandleGetArchivedUsers(w http.ResponseWriter, r *http.Request) {
+	page, perPage := srv.GetPaginationInfo(r)
+	total, users, err := srv.Db.GetArchivedUsers(page, perPage, srv.getFacilityID(r))
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleGetArchivedUsers", "error": err.Error()}).Error("error fetching archived users")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.User]{
+		Message: "archived users fetched successfully",
+		Data:    users,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
@@ -12,9 +12,9 @@ import (
 
 func (srv *Server) registerMilestonesRoutes() {
 	srv.Mux.Handle("GET /api/milestones", srv.applyMiddleware(srv.HandleIndexMilestones))
-	srv.Mux.Handle("POST /api/milestones", srv.ApplyAdminMiddleware(srv.HandleCreateMilestone))
-	srv.Mux.Handle("DELETE /api/milestones", srv.ApplyAdminMiddleware(srv.HandleDeleteMilestone))
-	srv.Mux.Handle("PATCH /api/milestones/{id}", srv.ApplyAdminMiddleware(srv.HandleUpdateMilestone))
+	srv.Mux.Handle("POST /api/milestones", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleCreateMilestone))
+	srv.Mux.Handle("DELETE /api/milestones", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleDeleteMilestone))
+	srv.Mux.Handle("PATCH /api/milestones/{id}", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleUpdateMilestone))
 }
 
 func (srv *Server) HandleIndexMilestones(w http.ResponseWriter, r *http.Request) {
@@ -24,16 +24,16 @@ func (srv *Server) HandleIndexMilestones(w http.ResponseWriter, r *http.Request)
 	var milestones []database.MilestoneResponse
 	err := error(nil)
 	total := int64(0)
-	if !srv.UserIsAdmin(r) {
+	if !srv.HasPermission(r, models.ViewReports) {
 		userId := r.Context().Value(ClaimsKey).(*Claims).UserID
-		total, milestones, err = srv.Db.GetMilestonesForUser(page, perPage, userId)
+		total, milestones, err = srv.userDb(r, userId).GetMilestonesForUser(page, perPage, userId)
 		if err != nil {
 			log.Debug("IndexMilestones Database Error: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	} else {
-		total, milestones, err = srv.Db.GetMilestones(page, perPage, search, orderBy)
+		total, milestones, err = srv.facilityDb(r).GetMilestones(page, perPage, search, orderBy)
 		if err != nil {
 			log.Debug("IndexMilestones Database Error: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -61,6 +61,10 @@ func (srv *Server) HandleCreateMilestone(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer r.Body.Close()
+	if !srv.userInFacility(r, miles.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
 	if _, err := srv.Db.CreateMilestone(miles); err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
@@ -74,7 +78,7 @@ func (srv *Server) HandleDeleteMilestone(w http.ResponseWriter, r *http.Request)
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if err := srv.Db.DeleteMilestone(id); err != nil {
+	if err := srv.facilityDb(r).DeleteMilestone(id); err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -100,13 +104,17 @@ func (srv *Server) HandleUpdateMilestone(w http.ResponseWriter, r *http.Request)
 	} else {
 		srv.ErrorResponse(w, http.StatusBadRequest, "No ID provided in URL or request body")
 	}
-	toUpdate, err := srv.Db.GetMilestoneByID(msId)
+	toUpdate, err := srv.facilityDb(r).GetMilestoneByID(msId)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	models.UpdateStruct(&toUpdate, &miles)
-	if _, err := srv.Db.UpdateMilestone(toUpdate); err != nil {
+	if !srv.userInFacility(r, toUpdate.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if _, err := srv.facilityDb(r).UpdateMilestone(toUpdate); err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerNotificationRoutes() {
+	srv.Mux.Handle("GET /api/notifications", srv.applyMiddleware(srv.HandleIndexNotifications))
+	srv.Mux.Handle("PUT /api/notifications/{id}/read", srv.applyMiddleware(srv.HandleReadNotification))
+}
+
+// notifies each of the admins of the facilities, once each, failures are only logged
+func (srv *Server) notifyFacilityAdmins(facilityIDs []uint, title, body, link string) {
+	notified := map[uint]bool{}
+	notifications := []models.Notification{}
+	for _, facilityID := range facilityIDs {
+		admins, err := srv.Db.GetFacilityAdmins(facilityID)
+		if err != nil {
+			log.WithFields(log.Fields{"facility_id": facilityID, "error": err.Error()}).Error("error fetching facility admins to notify")
+			continue
+		}
+		for _, admin := range admins {
+			if notified[admin.ID] {
+				continue
+			}
+			notified[admin.ID] = true
+			notifications = append(notifications, models.Notification{UserID: admin.ID, Title: title, Body: body, Link: link})
+		}
+	}
+	if err := srv.Db.CreateNotifications(notifications); err != nil {
+		log.WithFields(log.Fields{"title": title, "error": err.Error()}).Error("error creating notifications")
+	}
+}
+
+/**
+* GET: /api/notifications
+* the notifications of the user logged in, newest first
+* @Query Params:
+* ?unread=true
+**/
+func (srv *Server) HandleIndexNotifications(w http.ResponseWriter, r *http.Request) {
+	page, perPage := srv.GetPaginationInfo(r)
+	total, notifications, err := srv.Db.GetNotifications(page, perPage, srv.GetUserID(r), r.URL.Query().Get("unread") == "true")
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleIndexNotifications", "error": err.Error()}).Error("error fetching notifications")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.Notification]{
+		Message: "notifications fetched successfully",
+		Data:    notifications,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+func (srv *Server) HandleReadNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid notification id")
+		return
+	}
+	notification, err := srv.Db.MarkNotificationRead(srv.GetUserID(r), uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "notification not found")
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.Notification{*notification}))
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"UnlockEdv2/src/oms"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxFeedSize = 10 << 20
+
+func (srv *Server) registerOmsRoutes() {
+	// public, the offender management system signs its requests instead (see HandleOmsWebhook)
+	srv.Mux.HandleFunc("POST /api/integrations/oms/events", srv.HandleOmsWebhook)
+	srv.Mux.Handle("GET /api/integrations/oms/events", srv.ApplyAdminMiddleware(srv.HandleIndexResidentEvents))
+}
+
+type ResidentFeedResult struct {
+	Received int                  `json:"received"`
+	Applied  int                  `json:"applied"`
+	Skipped  int                  `json:"skipped"` // applied before
+	Failed   int                  `json:"failed"`
+	Errors   []models.ImportError `json:"errors"` // by event_id
+}
+
+/**
+* POST: /api/integrations/oms/events
+* receives a feed of admissions, transfers and releases (see oms.Parse) from the
+* offender management system. It is only enabled when OMS_WEBHOOK_SECRET is set,
+* and requests must be signed with it in the X-OMS-Signature header.
+**/
+func (srv *Server) HandleOmsWebhook(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleOmsWebhook"}
+	secret := os.Getenv("OMS_WEBHOOK_SECRET")
+	if secret == "" {
+		srv.ErrorResponse(w, http.StatusNotFound, "the offender management system feed is not enabled")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFeedSize))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "unable to read the feed")
+		return
+	}
+	if !oms.VerifySignature(body, r.Header.Get(oms.SignatureHeader), secret) {
+		log.WithFields(fields).Warn("offender management system feed with an invalid signature")
+		srv.ErrorResponse(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	events, err := oms.Parse(bytes.NewReader(body))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := srv.applyResidentEvents("webhook", events)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error applying offender management system feed")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]ResidentFeedResult{*result}))
+}
+
+// applies a feed dropped in OMS_DROP_DIR (see oms.Watcher)
+func (srv *Server) ApplyResidentFeed(source string, events []oms.Event) error {
+	_, err := srv.applyResidentEvents(source, events)
+	return err
+}
+
+/**
+* Applies the events in the order they were sent, recording each one with whether
+* it was applied. An event which was applied before is skipped. The error is only
+* for when events can't be recorded at all.
+**/
+func (srv *Server) applyResidentEvents(source string, events []oms.Event) (*ResidentFeedResult, error) {
+	result := &ResidentFeedResult{Received: len(events), Errors: []models.ImportError{}}
+	for idx := range events {
+		event := &events[idx]
+		record, err := srv.Db.GetResidentEvent(event.EventID)
+		if err != nil {
+			record = &models.ResidentEvent{EventID: event.EventID}
+		} else if record.Status == models.ResidentEventApplied {
+			result.Skipped++
+			continue
+		}
+		payload, _ := json.Marshal(event)
+		record.Type, record.ResidentID, record.Source, record.Payload = event.Type, event.ResidentID, source, payload
+		record.OccurredAt = event.OccurredAt
+		record.Attempts++
+		user, err := srv.applyResidentEvent(event)
+		if err != nil {
+			log.WithFields(log.Fields{"event_id": event.EventID, "resident_id": event.ResidentID, "error": err.Error()}).Error("unable to apply offender management system event")
+			record.Status, record.Error = models.ResidentEventFailed, err.Error()
+			result.Failed++
+			if len(result.Errors) < models.MaxImportErrors {
+				result.Errors = append(result.Errors, models.ImportError{ExternalID: event.EventID, Reason: err.Error()})
+			}
+		} else {
+			record.Status, record.Error, record.UserID = models.ResidentEventApplied, "", &user.ID
+			result.Applied++
+		}
+		// without an ID there is nothing to record it by, and it can't have been applied
+		if event.EventID == "" {
+			continue
+		}
+		if err := srv.Db.SaveResidentEvent(record); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+/**
+* Admits, transfers or releases the resident. Someone admitted for the first time
+* is created as a student, with their resident ID as their username, and staff
+* give them a temporary password to log in. A resident who is admitted again, after
+* being released (or archived), is reactivated along with their provider accounts.
+**/
+func (srv *Server) applyResidentEvent(event *oms.Event) (*models.User, error) {
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	releaseDate, _ := event.ParseReleaseDate()
+	var facility *models.Facility
+	if event.Facility != "" {
+		found, err := srv.Db.FindFacility(event.Facility)
+		if err != nil {
+			return nil, fmt.Errorf("facility %s does not exist", event.Facility)
+		}
+		facility = found
+	}
+	user, err := srv.Db.GetUserByResidentID(event.ResidentID)
+	if err != nil {
+		if event.Type != models.ResidentAdmitted {
+			return nil, fmt.Errorf("resident %s has not been admitted", event.ResidentID)
+		}
+		return srv.admitResident(event, facility, releaseDate)
+	}
+	switch event.Type {
+	case models.ResidentAdmitted:
+		if err := srv.transferResident(user, facility, event); err != nil {
+			return nil, err
+		}
+		user.NameFirst, user.NameLast = event.NameFirst, event.NameLast
+		user.HousingUnit, user.ReleaseDate = event.HousingUnit, releaseDate
+		if user.Status.DeactivatesProviders() {
+			if _, err := srv.setUserStatus(user, models.UserActive); err != nil {
+				return nil, err
+			}
+		}
+	case models.ResidentTransferred:
+		if user.Status.DeactivatesProviders() {
+			return nil, fmt.Errorf("resident %s is %s", event.ResidentID, user.Status)
+		}
+		if err := srv.transferResident(user, facility, event); err != nil {
+			return nil, err
+		}
+		user.HousingUnit = event.HousingUnit
+		if releaseDate != nil {
+			user.ReleaseDate = releaseDate
+		}
+	case models.ResidentReleased:
+		if releaseDate == nil {
+			released := time.Now()
+			if event.OccurredAt != nil {
+				released = *event.OccurredAt
+			}
+			releaseDate = &released
+		}
+		user.ReleaseDate, user.HousingUnit = releaseDate, ""
+		if !user.Status.DeactivatesProviders() {
+			if _, err := srv.setUserStatus(user, models.UserReleased); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return srv.Db.UpdateUser(user)
+}
+
+// a resident moved to another facility goes through the same transfer as one moved by staff
+func (srv *Server) transferResident(user *models.User, facility *models.Facility, event *oms.Event) error {
+	if facility.ID == user.FacilityID {
+		return nil
+	}
+	reason := fmt.Sprintf("%s event %s", event.Type, event.EventID)
+	_, err := srv.transferUser(user, facility, models.TransferByOms, nil, TransferRequest{FacilityID: facility.ID, Reason: reason})
+	return err
+}
+
+func (srv *Server) admitResident(event *oms.Event, facility *models.Facility, releaseDate *time.Time) (*models.User, error) {
+	username := strings.ToLower(removeChars(event.ResidentID, disallowedChars))
+	if srv.Db.UsernameExists(username) {
+		return nil, fmt.Errorf("username %s is already taken, if this is the resident set their resident ID instead", username)
+	}
+	residentID := event.ResidentID
+	user, err := srv.Db.CreateUser(&models.User{
+		Username:    username,
+		NameFirst:   event.NameFirst,
+		NameLast:    event.NameLast,
+		Role:        models.Student,
+		FacilityID:  facility.ID,
+		ResidentID:  &residentID,
+		HousingUnit: event.HousingUnit,
+		ReleaseDate: releaseDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// kratos is not configured when testing
+	if srv.OryClient != nil {
+		if err := srv.HandleCreateUserKratos(user.Username, user.Password); err != nil {
+			if err := srv.Db.PurgeUser(user.ID); err != nil {
+				log.WithField("user_id", user.ID).Errorln("unable to remove admitted resident without a login", err)
+			}
+			return nil, err
+		}
+	}
+	log.WithFields(log.Fields{"user_id": user.ID, "facility_id": facility.ID}).Info("admitted resident from offender management system")
+	return user, nil
+}
+
+/**
+* GET: /api/integrations/oms/events
+* @Query Params:
+* ?status=: applied or failed
+* ?resident_id=
+**/
+func (srv *Server) HandleIndexResidentEvents(w http.ResponseWriter, r *http.Request) {
+	page, perPage := srv.GetPaginationInfo(r)
+	status := models.ResidentEventStatus(r.URL.Query().Get("status"))
+	total, events, err := srv.Db.GetResidentEvents(page, perPage, status, r.URL.Query().Get("resident_id"))
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleIndexResidentEvents", "error": err.Error()}).Error("error fetching resident events")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.ResidentEvent]{
+		Message: "resident events fetched successfully",
+		Data:    events,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
@@ -40,11 +40,14 @@ func (srv *Server) ToggleOpenContent(w http.ResponseWriter, r *http.Request) {
 		srv.ErrorResponse(w, http.StatusBadRequest, "unable to parse ID from path")
 		return
 	}
-	if err := srv.Db.ToggleContentProvider(id); err != nil {
+	provider, err := srv.Db.ToggleContentProvider(id)
+	if err != nil {
 		log.WithFields(fields).Errorln("unable to find or toggle content provider, please make sure id is correct")
 		srv.ErrorResponse(w, http.StatusInternalServerError, "Unable to find records from DB")
 		return
 	}
+	srv.audit(r, models.AuditOpenContentToggled, "open_content_provider", provider.ID,
+		map[string]bool{"currently_enabled": !provider.CurrentlyEnabled}, map[string]bool{"currently_enabled": provider.CurrentlyEnabled})
 	w.WriteHeader(http.StatusOK)
 }
 
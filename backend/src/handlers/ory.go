@@ -4,6 +4,7 @@ import (
 	"UnlockEdv2/src/models"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 
 	client "github.com/ory/kratos-client-go"
@@ -59,6 +60,43 @@ func (srv *Server) deleteIdentityInKratos(kratosId *string) error {
 	return nil
 }
 
+func (srv *Server) discardIdentityInKratos(kratosId string) {
+	if err := srv.deleteIdentityInKratos(&kratosId); err != nil {
+		log.WithField("identity", kratosId).Errorln("identity was left in Ory Kratos without a user")
+	}
+}
+
+/**
+* Activates or deactivates the user's login. Kratos refuses to log in an inactive
+* identity, and their sessions are revoked so that they are logged out now.
+**/
+func (srv *Server) setIdentityActiveInKratos(kratosId string, active bool) error {
+	state := "inactive"
+	if active {
+		state = "active"
+	}
+	patch := client.NewJsonPatch("replace", "/state")
+	patch.Value = state
+	_, resp, err := srv.OryClient.IdentityAPI.PatchIdentity(context.Background(), kratosId).JsonPatch([]client.JsonPatch{*patch}).Execute()
+	if err != nil {
+		log.WithField("identity", kratosId).Errorln("unable to change the state of identity in Ory Kratos")
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to change the state of identity in Ory Kratos: %d", resp.StatusCode)
+	}
+	if active {
+		return nil
+	}
+	resp, err = srv.OryClient.IdentityAPI.DeleteIdentitySessions(context.Background(), kratosId).Execute()
+	// kratos answers 404 when the identity has no sessions to revoke
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		log.WithField("identity", kratosId).Errorln("unable to revoke sessions of identity in Ory Kratos")
+		return err
+	}
+	return nil
+}
+
 func (srv *Server) handleFindKratosIdentities() ([]client.Identity, error) {
 	identities, resp, err := srv.OryClient.IdentityAPI.ListIdentities(context.Background()).Execute()
 	if err != nil {
@@ -97,20 +135,24 @@ func (srv *Server) HandleCreateUserKratos(username, password string) error {
 		log.Errorf("Error creating identity: %v", resp.StatusCode)
 		return errors.New("error creating identity")
 	}
+	// the identity is deleted again if it can't be finished, rather than left orphaned
 	user := srv.Db.GetUserByUsername(username)
 	if user == nil {
 		log.Error("user not found immediately after creation, this should not happen")
+		srv.discardIdentityInKratos(created.GetId())
 		return errors.New("user not found")
 	}
 	user.KratosID = created.GetId()
 	err = srv.handleUpdatePasswordKratos(user, password)
 	if err != nil {
 		log.Error("Error updating password for new kratos user")
+		srv.discardIdentityInKratos(user.KratosID)
 		return err
 	}
 	updated, err := srv.Db.UpdateUser(user)
 	if err != nil {
 		log.Error("Error updating user")
+		srv.discardIdentityInKratos(user.KratosID)
 		return err
 	}
 	log.Infof("user created successfully + identity registered with kratos: %v", updated)
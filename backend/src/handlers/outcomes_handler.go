@@ -11,9 +11,9 @@ import (
 
 func (srv *Server) registerOutcomesRoutes() {
 	srv.Mux.Handle("GET /api/users/{id}/outcomes", srv.applyMiddleware(http.HandlerFunc(srv.HandleGetOutcomes)))
-	srv.Mux.Handle("POST /api/users/{id}/outcomes", srv.applyMiddleware(http.HandlerFunc(srv.HandleCreateOutcome)))
-	srv.Mux.Handle("PATCH /api/users/{id}/outcomes/{oid}", srv.applyMiddleware(http.HandlerFunc(srv.HandleUpdateOutcome)))
-	srv.Mux.Handle("DELETE /api/users/{id}/outcomes/{oid}", srv.applyMiddleware(http.HandlerFunc(srv.HandleDeleteOutcome)))
+	srv.Mux.Handle("POST /api/users/{id}/outcomes", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleCreateOutcome))
+	srv.Mux.Handle("PATCH /api/users/{id}/outcomes/{oid}", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleUpdateOutcome))
+	srv.Mux.Handle("DELETE /api/users/{id}/outcomes/{oid}", srv.ApplyPermissionMiddleware(models.ManageProgress, srv.HandleDeleteOutcome))
 }
 
 /****
@@ -27,6 +27,10 @@ func (srv *Server) HandleGetOutcomes(w http.ResponseWriter, r *http.Request) {
 		log.Error("handler: getOutcomes: ", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's outcomes")
+		return
+	}
 
 	// Get vars from query param
 	order := r.URL.Query().Get("order")
@@ -34,7 +38,7 @@ func (srv *Server) HandleGetOutcomes(w http.ResponseWriter, r *http.Request) {
 	typeString := r.URL.Query().Get("type")
 	outcomeType := models.OutcomeType(typeString)
 
-	total, outcome, err := srv.Db.GetOutcomesForUser(uint(id), page, perPage, order, orderBy, outcomeType)
+	total, outcome, err := srv.userDb(r, uint(id)).GetOutcomesForUser(uint(id), page, perPage, order, orderBy, outcomeType)
 	if err != nil {
 		log.Error("handler: getOutcomes: ", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -63,11 +67,19 @@ func (srv *Server) HandleCreateOutcome(w http.ResponseWriter, r *http.Request) {
 	if outcome.UserID == 0 {
 		outcome.UserID = uint(id)
 	}
+	if !srv.userInFacility(r, outcome.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
 	if outcome, err = srv.Db.CreateOutcome(outcome); err != nil {
 		log.Error("handler: createOutcome: ", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// the outcome is recorded either way, a failed certificate is issued by the next import
+	if err := srv.issueCertificate(outcome); err != nil {
+		log.Error("handler: createOutcome: error issuing certificate: ", err.Error())
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -94,7 +106,11 @@ func (srv *Server) HandleUpdateOutcome(w http.ResponseWriter, r *http.Request) {
 	if outcome.UserID == 0 {
 		outcome.UserID = uint(uid)
 	}
-	updatedOutcome, err := srv.Db.UpdateOutcome(&outcome, uint(id))
+	if !srv.userInFacility(r, outcome.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	updatedOutcome, err := srv.facilityDb(r).UpdateOutcome(&outcome, uint(id))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -110,7 +126,7 @@ func (srv *Server) HandleDeleteOutcome(w http.ResponseWriter, r *http.Request) {
 		log.Error("handler: deleteOutcome: ", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
-	if err = srv.Db.DeleteOutcome(uint(id)); err != nil {
+	if err = srv.facilityDb(r).DeleteOutcome(uint(id)); err != nil {
 		log.Error("handler: deleteOutcome: ", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
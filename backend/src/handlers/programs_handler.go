@@ -10,12 +10,15 @@ import (
 )
 
 func (srv *Server) registerProgramsRoutes() {
-	srv.Mux.Handle("GET /api/programs", srv.ApplyAdminMiddleware(http.HandlerFunc(srv.HandleIndexPrograms)))
+	srv.Mux.Handle("GET /api/programs", srv.ApplyPermissionMiddleware(models.ViewPrograms, http.HandlerFunc(srv.HandleIndexPrograms)))
 	srv.Mux.Handle("GET /api/programs/{id}", srv.applyMiddleware(http.HandlerFunc(srv.HandleShowProgram)))
-	srv.Mux.Handle("POST /api/programs", srv.ApplyAdminMiddleware(http.HandlerFunc(srv.HandleCreateProgram)))
-	srv.Mux.Handle("DELETE /api/programs/{id}", srv.ApplyAdminMiddleware(http.HandlerFunc(srv.HandleDeleteProgram)))
-	srv.Mux.Handle("PATCH /api/programs/{id}", srv.ApplyAdminMiddleware(http.HandlerFunc(srv.HandleUpdateProgram)))
+	srv.Mux.Handle("POST /api/programs", srv.ApplyPermissionMiddleware(models.ManagePrograms, http.HandlerFunc(srv.HandleCreateProgram)))
+	srv.Mux.Handle("DELETE /api/programs/{id}", srv.ApplyPermissionMiddleware(models.ManagePrograms, http.HandlerFunc(srv.HandleDeleteProgram)))
+	srv.Mux.Handle("PATCH /api/programs/{id}", srv.ApplyPermissionMiddleware(models.ManagePrograms, http.HandlerFunc(srv.HandleUpdateProgram)))
 	srv.Mux.Handle("PUT /api/programs/{id}/save", srv.applyMiddleware(http.HandlerFunc(srv.HandleFavoriteProgram)))
+	srv.Mux.Handle("GET /api/programs/{id}/facilities", srv.ApplyPermissionMiddleware(models.ViewPrograms, srv.HandleIndexProgramFacilities))
+	srv.Mux.Handle("PUT /api/programs/{id}/facilities/{facility_id}", srv.applyMiddleware(srv.HandlePublishProgram))
+	srv.Mux.Handle("DELETE /api/programs/{id}/facilities/{facility_id}", srv.applyMiddleware(srv.HandleUnpublishProgram))
 }
 
 /*
@@ -56,6 +59,10 @@ func (srv *Server) HandleShowProgram(w http.ResponseWriter, r *http.Request) {
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if !srv.HasPermission(r, models.ViewPrograms) && !srv.Db.IsProgramPublished(id, srv.getFacilityID(r)) {
+		srv.ErrorResponse(w, http.StatusNotFound, "program not found")
+		return
+	}
 	program, err := srv.Db.GetProgramByID(id)
 	if err != nil {
 		log.Debug("GET Program handler Error: ", err)
@@ -80,6 +87,12 @@ func (srv *Server) HandleCreateProgram(w http.ResponseWriter, r *http.Request) {
 		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	// a new program is only in the catalogue of the facility it was created at
+	if err := srv.Db.PublishProgram(int(program.ID), srv.getFacilityID(r)); err != nil {
+		log.Error("Error publishing program:" + err.Error())
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -155,3 +168,77 @@ func (srv *Server) HandleFavoriteProgram(w http.ResponseWriter, r *http.Request)
 	}
 	srv.WriteResponse(w, http.StatusOK, nil)
 }
+
+/**
+* GET: /api/programs/{id}/facilities
+* the facilities whose catalogue the program is published in
+**/
+func (srv *Server) HandleIndexProgramFacilities(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid program id")
+		return
+	}
+	facilities, err := srv.Db.GetProgramFacilities(id)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleIndexProgramFacilities", "program_id": id, "error": err.Error()}).Error("error fetching program facilities")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.Facility]{Data: facilities})
+}
+
+/**
+* PUT: /api/programs/{id}/facilities/{facility_id}
+**/
+func (srv *Server) HandlePublishProgram(w http.ResponseWriter, r *http.Request) {
+	srv.setProgramPublished(w, r, true)
+}
+
+/**
+* DELETE: /api/programs/{id}/facilities/{facility_id}
+**/
+func (srv *Server) HandleUnpublishProgram(w http.ResponseWriter, r *http.Request) {
+	srv.setProgramPublished(w, r, false)
+}
+
+func (srv *Server) setProgramPublished(w http.ResponseWriter, r *http.Request, published bool) {
+	fields := log.Fields{"handler": "setProgramPublished", "published": published}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid program id")
+		return
+	}
+	facilityID, err := strconv.Atoi(r.PathValue("facility_id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid facility id")
+		return
+	}
+	fields["program_id"] = id
+	fields["facility_id"] = facilityID
+	// the catalogue belongs to the facility, so it's managed by whoever manages programs there
+	if !srv.hasPermissionAt(r.Context().Value(ClaimsKey).(*Claims), uint(facilityID), models.ManagePrograms) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not manage programs at this facility")
+		return
+	}
+	if _, err := srv.Db.GetProgramByID(id); err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "program not found")
+		return
+	}
+	if _, err := srv.Db.GetFacilityByID(facilityID); err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "facility not found")
+		return
+	}
+	if published {
+		err = srv.Db.PublishProgram(id, uint(facilityID))
+	} else {
+		err = srv.Db.UnpublishProgram(id, uint(facilityID))
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error updating program catalogue")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
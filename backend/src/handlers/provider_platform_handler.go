@@ -99,12 +99,29 @@ func (srv *Server) HandleUpdateProvider(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer r.Body.Close()
+	before, err := srv.Db.GetProviderPlatformByID(id)
+	if err != nil {
+		log.Error("Error getting provider platform: ", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	beforeKey, afterKey := before.AccessKey, platform.AccessKey
+	if afterKey == "" {
+		afterKey = beforeKey
+	}
 	updated, err := srv.Db.UpdateProviderPlatform(&platform, uint(id))
 	if err != nil {
 		log.Error("Error updating provider platform: ", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	action := models.AuditProviderUpdated
+	if afterKey != beforeKey {
+		action = models.AuditProviderKeyChanged
+	}
+	after := *updated
+	before.AccessKey, after.AccessKey = keyFingerprint(beforeKey), keyFingerprint(afterKey)
+	srv.audit(r, action, "provider_platform", updated.ID, before, after)
 	response := models.Resource[models.ProviderPlatform]{
 		Data: make([]models.ProviderPlatform, 0),
 	}
@@ -3,11 +3,14 @@ package handlers
 import (
 	"UnlockEdv2/src/models"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func (srv *Server) registerProviderUserRoutes() {
@@ -246,3 +249,20 @@ func (srv *Server) createAndRegisterProviderUserAccount(provider *models.Provide
 		return srv.CreateUserInKolibri(user, provider)
 	}
 }
+
+/**
+* Creates the user's account in the provider of a program they are joining,
+* unless they already have one. Accounts can't be created in moodle from here,
+* so its users have to be mapped by an admin.
+**/
+func (srv *Server) ensureProviderUserAccount(provider *models.ProviderPlatform, user *models.User) error {
+	if _, err := srv.Db.GetProviderUserMapping(int(user.ID), int(provider.ID)); err == nil {
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if provider.Type == models.Moodle {
+		return fmt.Errorf("user %s has no account in %s, and moodle accounts must be mapped by an admin", user.Username, provider.Name)
+	}
+	return srv.createAndRegisterProviderUserAccount(provider, user)
+}
@@ -20,7 +20,7 @@ func (srv *Server) handleGetMappingsForUser(w http.ResponseWriter, r *http.Reque
 		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid user id")
 		return
 	}
-	mappings, err := srv.Db.GetAllProviderMappingsForUser(id)
+	mappings, err := srv.facilityDb(r).GetAllProviderMappingsForUser(id)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 	}
@@ -35,6 +35,10 @@ func (srv *Server) handleCreateProviderUserMapping(w http.ResponseWriter, r *htt
 		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	if !srv.userInFacility(r, mapping.UserID) {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
 	err = srv.Db.CreateProviderUserMapping(&mapping)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
@@ -53,7 +57,7 @@ func (srv *Server) handleDeleteProviderUserMapping(w http.ResponseWriter, r *htt
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid mapping id")
 	}
-	err = srv.Db.DeleteProviderUserMappingByUserID(userId, providerId)
+	err = srv.facilityDb(r).DeleteProviderUserMappingByUserID(userId, providerId)
 	if err != nil {
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
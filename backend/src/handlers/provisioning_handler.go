@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerProvisioningRoutes() {
+	srv.Mux.Handle("GET /api/provisioning", srv.ApplyAdminMiddleware(srv.HandleIndexProvisioning))
+	srv.Mux.Handle("GET /api/provisioning/{id}", srv.ApplyAdminMiddleware(srv.HandleShowProvisioning))
+	srv.Mux.Handle("POST /api/provisioning/{id}/retry", srv.ApplyAdminMiddleware(srv.HandleRetryProvisioning))
+}
+
+/**
+* A step of provisioning a user. Compensate undoes a completed run, and is nil
+* if there is nothing to undo. A step is skipped when it doesn't apply.
+**/
+type provisioningStep struct {
+	run        func(*provisioningRun) error
+	compensate func(*provisioningRun) error
+	skip       func(*provisioningRun) bool
+}
+
+type provisioningRun struct {
+	*models.Provisioning
+	provider *models.ProviderPlatform // nil for a roster user's login
+	user     *models.User
+	password string // the user's temporary password, if it was set during this run
+}
+
+func (srv *Server) provisioningSteps() map[string]provisioningStep {
+	return map[string]provisioningStep{
+		models.StepCreateUser: {
+			run: func(run *provisioningRun) error {
+				created, err := srv.Db.CreateUser(&models.User{
+					Username:  run.Username,
+					Email:     run.Email,
+					NameFirst: run.NameFirst,
+					NameLast:  run.NameLast,
+				})
+				if err != nil {
+					return err
+				}
+				run.user, run.password, run.UserID = created, created.Password, &created.ID
+				return nil
+			},
+			compensate: func(run *provisioningRun) error {
+				if err := srv.Db.PurgeUser(*run.UserID); err != nil {
+					return err
+				}
+				run.user, run.UserID = nil, nil
+				return nil
+			},
+		},
+		models.StepCreateLogin: {
+			// kratos is not configured when testing
+			skip: func(*provisioningRun) bool { return srv.OryClient == nil },
+			run: func(run *provisioningRun) error {
+				// resuming, the password set when the user was created is lost, so they get a new one
+				if run.password == "" {
+					password, err := srv.Db.AssignTempPasswordToUser(run.user.ID)
+					if err != nil {
+						return err
+					}
+					run.password = password
+				}
+				if err := srv.HandleCreateUserKratos(run.user.Username, run.password); err != nil {
+					return err
+				}
+				user, err := srv.Db.GetUserByID(run.user.ID)
+				if err != nil {
+					return err
+				}
+				run.user = user
+				return nil
+			},
+			compensate: func(run *provisioningRun) error {
+				if run.user.KratosID == "" {
+					return nil
+				}
+				return srv.deleteIdentityInKratos(&run.user.KratosID)
+			},
+		},
+		models.StepCreateMapping: {
+			run: func(run *provisioningRun) error {
+				if _, err := srv.Db.GetProviderUserMappingByExternalUserID(run.ExternalUserID, run.provider.ID); err == nil {
+					return fmt.Errorf("account %s in %s is already mapped to another user", run.ExternalUserID, run.provider.Name)
+				}
+				return srv.Db.CreateProviderUserMapping(&models.ProviderUserMapping{
+					UserID:             run.user.ID,
+					ProviderPlatformID: run.provider.ID,
+					ExternalUsername:   run.ExternalUsername,
+					ExternalUserID:     run.ExternalUserID,
+				})
+			},
+			compensate: func(run *provisioningRun) error {
+				return srv.Db.DeleteProviderUserMappingByUserID(int(run.user.ID), int(run.provider.ID))
+			},
+		},
+		models.StepCreateAccount: {
+			run: func(run *provisioningRun) error {
+				// a copy, as kolibri accounts are created without the user's password
+				account := *run.user
+				return srv.ensureProviderUserAccount(run.provider, &account)
+			},
+		},
+		models.StepRegisterLogin: {
+			skip: func(run *provisioningRun) bool { return run.provider.OidcID == 0 },
+			run: func(run *provisioningRun) error {
+				return srv.registerProviderLogin(run.provider, run.user)
+			},
+		},
+	}
+}
+
+/**
+* Runs the provisioning's steps in order, from the first that hasn't completed,
+* saving its progress after each so that an interrupted run can be resumed. If a
+* step fails, the steps completed before it are undone in reverse, and running
+* the provisioning again starts over from the first step that was undone.
+* The password is the user's temporary password, if it was already set (as for
+* users imported from a roster). Returns the user's temporary password, if one was set.
+**/
+func (srv *Server) runProvisioning(provisioning *models.Provisioning, password string) (string, error) {
+	fields := log.Fields{"func": "runProvisioning", "provisioning_id": provisioning.ID, "username": provisioning.Username}
+	run := &provisioningRun{Provisioning: provisioning, password: password}
+	provisioning.Attempts++
+	provisioning.Error = ""
+	fail := func(err error) (string, error) {
+		provisioning.Status = models.ProvisioningFailed
+		provisioning.Error = err.Error()
+		srv.saveProvisioning(provisioning)
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("provisioning user failed")
+		return run.password, err
+	}
+	if provisioning.ProviderPlatformID != nil {
+		provider, err := srv.Db.GetProviderPlatformByID(int(*provisioning.ProviderPlatformID))
+		if err != nil {
+			return fail(errors.New("provider platform not found"))
+		}
+		run.provider = provider
+	}
+	var err error
+	if provisioning.UserID != nil {
+		if run.user, err = srv.Db.GetUserByID(*provisioning.UserID); err != nil {
+			return fail(fmt.Errorf("user %d was created but can no longer be found", *provisioning.UserID))
+		}
+	}
+	steps := srv.provisioningSteps()
+	for idx := range provisioning.Steps {
+		step := &provisioning.Steps[idx]
+		if step.Status == models.StepDone {
+			continue
+		}
+		definition := steps[step.Name]
+		step.Error = ""
+		if definition.skip != nil && definition.skip(run) {
+			step.Status = models.StepSkipped
+			continue
+		}
+		if err := definition.run(run); err != nil {
+			step.Status = models.StepFailed
+			step.Error = err.Error()
+			srv.compensateProvisioning(run, steps, idx)
+			return fail(fmt.Errorf("%s: %w", step.Name, err))
+		}
+		completed := time.Now()
+		step.Status, step.CompletedAt = models.StepDone, &completed
+		srv.saveProvisioning(provisioning)
+	}
+	provisioning.Status = models.ProvisioningSucceeded
+	srv.saveProvisioning(provisioning)
+	log.WithFields(fields).Info("provisioned user")
+	return run.password, nil
+}
+
+/**
+* Undoes the completed steps before the one that failed, latest first. If one
+* can't be undone, those before it are left in place, as it depends on them,
+* and a retry carries on from after it.
+**/
+func (srv *Server) compensateProvisioning(run *provisioningRun, steps map[string]provisioningStep, failed int) {
+	for idx := failed - 1; idx >= 0; idx-- {
+		step := &run.Steps[idx]
+		if step.Status != models.StepDone {
+			continue
+		}
+		if compensate := steps[step.Name].compensate; compensate != nil {
+			if err := compensate(run); err != nil {
+				log.WithFields(log.Fields{"provisioning_id": run.ID, "step": step.Name, "error": err.Error()}).Error("unable to undo provisioning step")
+				step.Error = "unable to undo: " + err.Error()
+				return
+			}
+		}
+		step.Status, step.CompletedAt = models.StepCompensated, nil
+	}
+}
+
+func (srv *Server) saveProvisioning(provisioning *models.Provisioning) {
+	if err := srv.Db.UpdateProvisioning(provisioning); err != nil {
+		log.WithFields(log.Fields{"provisioning_id": provisioning.ID, "error": err.Error()}).Error("error saving provisioning")
+	}
+}
+
+/**
+* Provisions a user imported from a provider. A provisioning of the same account
+* that failed before is retried rather than started again.
+**/
+func (srv *Server) provisionProviderUser(provider *models.ProviderPlatform, user models.ImportUser) error {
+	provisioning, err := srv.Db.GetUnfinishedProvisioning(provider.ID, user.ExternalUserID)
+	if err == nil {
+		claimed, err := srv.Db.ClaimProvisioning(provisioning)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errors.New("this user is already being provisioned")
+		}
+	} else {
+		provisioning = models.NewProvisioning(provider, user)
+		if err := srv.Db.CreateProvisioning(provisioning); err != nil {
+			return err
+		}
+	}
+	_, err = srv.runProvisioning(provisioning, "")
+	return err
+}
+
+/**
+* GET: /api/provisioning
+* @Query Params:
+* ?status=: running, succeeded or failed
+* ?provider_platform_id=
+**/
+func (srv *Server) HandleIndexProvisioning(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleIndexProvisioning"}
+	page, perPage := srv.GetPaginationInfo(r)
+	providerID, _ := strconv.Atoi(r.URL.Query().Get("provider_platform_id"))
+	status := models.ProvisioningStatus(r.URL.Query().Get("status"))
+	total, provisionings, err := srv.Db.GetProvisionings(page, perPage, status, uint(providerID))
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching provisionings")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.Provisioning]{
+		Message: "provisionings fetched successfully",
+		Data:    provisionings,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+func (srv *Server) HandleShowProvisioning(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid provisioning id")
+		return
+	}
+	provisioning, err := srv.Db.GetProvisioningByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "provisioning not found")
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.Provisioning{*provisioning}))
+}
+
+type ProvisioningRetry struct {
+	models.Provisioning
+	TempPassword string `json:"temp_password,omitempty"` // set if the user was given a new one
+}
+
+/**
+* POST: /api/provisioning/{id}/retry
+* resumes a failed or interrupted provisioning from its first incomplete step.
+* The response is the provisioning as it ended, with the user's temporary
+* password if they were given one.
+**/
+func (srv *Server) HandleRetryProvisioning(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid provisioning id")
+		return
+	}
+	provisioning, err := srv.Db.GetProvisioningByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "provisioning not found")
+		return
+	}
+	claimed, err := srv.Db.ClaimProvisioning(provisioning)
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleRetryProvisioning", "error": err.Error()}).Error("error claiming provisioning")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !claimed {
+		srv.ErrorResponse(w, http.StatusConflict, "only a failed or interrupted provisioning can be retried")
+		return
+	}
+	password, err := srv.runProvisioning(provisioning, "")
+	srv.audit(r, models.AuditProvisioningRetried, "provisioning", provisioning.ID, nil, provisioning)
+	response := models.DefaultResource([]ProvisioningRetry{{Provisioning: *provisioning, TempPassword: password}})
+	response.Message = "user provisioned successfully"
+	if err != nil {
+		response.Message = "provisioning failed: " + err.Error()
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
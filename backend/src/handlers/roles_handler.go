@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerRoleRoutes() {
+	srv.Mux.Handle("GET /api/roles", srv.applyMiddleware(srv.HandleIndexRoles))
+	srv.Mux.Handle("GET /api/users/{id}/roles", srv.ApplyPermissionMiddleware(models.ViewUsers, srv.HandleIndexRoleAssignments))
+	srv.Mux.Handle("POST /api/users/{id}/roles", srv.ApplyPermissionMiddleware(models.ManageRoles, srv.HandleCreateRoleAssignment))
+	srv.Mux.Handle("DELETE /api/users/{id}/roles/{role_id}", srv.ApplyPermissionMiddleware(models.ManageRoles, srv.HandleDeleteRoleAssignment))
+}
+
+/**
+* GET: /api/roles
+* returns every role, with the permissions it grants
+**/
+func (srv *Server) HandleIndexRoles(w http.ResponseWriter, r *http.Request) {
+	srv.WriteResponse(w, http.StatusOK, models.RolePermissions)
+}
+
+/**
+* GET: /api/users/{id}/roles
+**/
+func (srv *Server) HandleIndexRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	assignments, err := srv.facilityDb(r).GetRoleAssignmentsForUser(uint(id))
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleIndexRoleAssignments", "user_id": id, "error": err.Error()}).Error("error fetching role assignments")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.RoleAssignment]{Data: assignments})
+}
+
+type RoleAssignmentRequest struct {
+	Role       models.UserRole `json:"role"`
+	FacilityID *uint           `json:"facility_id"`
+}
+
+/**
+* POST: /api/users/{id}/roles
+* assigns a role at a facility, or at every facility when facility_id is null
+**/
+func (srv *Server) HandleCreateRoleAssignment(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleCreateRoleAssignment"}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	fields["user_id"] = id
+	var form RoleAssignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	if !form.Role.IsValid() || form.Role == models.Student {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid role")
+		return
+	}
+	if _, err := srv.facilityDb(r).GetUserByID(uint(id)); err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	claims := r.Context().Value(ClaimsKey).(*Claims)
+	if !srv.canGrantRole(claims, form.Role, form.FacilityID) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not grant this role at this facility")
+		return
+	}
+	assignment := models.RoleAssignment{UserID: uint(id), Role: form.Role, FacilityID: form.FacilityID}
+	if err := srv.Db.CreateRoleAssignment(&assignment); err != nil {
+		if errors.Is(err, database.ErrRoleAlreadyAssigned) {
+			srv.ErrorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating role assignment")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.audit(r, models.AuditRoleGranted, "role_assignment", assignment.ID, nil, assignment)
+	srv.WriteResponse(w, http.StatusCreated, models.Resource[models.RoleAssignment]{Data: []models.RoleAssignment{assignment}})
+}
+
+/**
+* DELETE: /api/users/{id}/roles/{role_id}
+**/
+func (srv *Server) HandleDeleteRoleAssignment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	roleID, err := strconv.Atoi(r.PathValue("role_id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid role assignment id")
+		return
+	}
+	assignment, err := srv.facilityDb(r).GetRoleAssignmentByID(uint(id), uint(roleID))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "role assignment not found")
+		return
+	}
+	claims := r.Context().Value(ClaimsKey).(*Claims)
+	if !srv.canGrantRole(claims, assignment.Role, assignment.FacilityID) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not revoke this role at this facility")
+		return
+	}
+	if err := srv.facilityDb(r).DeleteRoleAssignment(assignment); err != nil {
+		log.WithFields(log.Fields{"handler": "HandleDeleteRoleAssignment", "user_id": id, "error": err.Error()}).Error("error deleting role assignment")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.audit(r, models.AuditRoleRevoked, "role_assignment", assignment.ID, assignment, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+* Admins may grant (or revoke) any role anywhere. Everyone else may only grant roles
+* at a single facility, where they can manage roles, and only roles whose
+* permissions they hold there themselves, so no one can escalate their own access.
+**/
+func (srv *Server) canGrantRole(claims *Claims, role models.UserRole, facilityID *uint) bool {
+	if claims.Role == models.Admin {
+		return true
+	}
+	if role == models.Admin || facilityID == nil || !srv.hasPermissionAt(claims, *facilityID, models.ManageRoles) {
+		return false
+	}
+	for _, permission := range models.RolePermissions[role] {
+		if !srv.hasPermissionAt(claims, *facilityID, permission) {
+			return false
+		}
+	}
+	return true
+}
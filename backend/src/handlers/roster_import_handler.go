@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/exports"
+	"UnlockEdv2/src/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxRosterRows = 5000
+	maxRosterSize = 5 << 20
+)
+
+func (srv *Server) registerRosterImportRoutes() {
+	srv.Mux.Handle("POST /api/users/import", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleImportRoster))
+}
+
+/**
+* A row of a roster, as read from the file and validated. Facility is as written
+* in the file (a name or an ID, or empty for the facility being viewed), FacilityID
+* what it was resolved to.
+**/
+type RosterRow struct {
+	Row        int             `json:"row"` // the line in the file, the header is line 1
+	Username   string          `json:"username"`
+	NameFirst  string          `json:"name_first"`
+	NameLast   string          `json:"name_last"`
+	Email      string          `json:"email"`
+	Facility   string          `json:"facility"`
+	FacilityID uint            `json:"facility_id"`
+	Role       models.UserRole `json:"role"`
+	Providers  []int           `json:"provider_platforms"`
+	Errors     []string        `json:"errors"`
+}
+
+type RosterReport struct {
+	Valid    bool        `json:"valid"`
+	Total    int         `json:"total"`
+	ToCreate int         `json:"to_create"`
+	Invalid  int         `json:"invalid"`
+	Rows     []RosterRow `json:"rows"`
+}
+
+var errRosterColumns = errors.New("the roster must have username, name_first and name_last columns")
+
+/**
+* Reads the roster from the "file" field of a multipart form, or from the body
+* if it is sent as text/csv. Columns are found by their header, in any order:
+* username, name_first and name_last are required, and email, facility, role and
+* providers (provider platform IDs separated by ;) are optional.
+**/
+func readRoster(r *http.Request) ([]RosterRow, error) {
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		upload, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("no roster file was uploaded")
+		}
+		defer upload.Close()
+		file = upload
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("unable to read the roster's header row")
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		// spreadsheet programs often start the file with a byte order mark
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = idx
+	}
+	for _, required := range []string{"username", "name_first", "name_last"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errRosterColumns
+		}
+	}
+	rows := []RosterRow{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read line %d of the roster: %w", line, err)
+		}
+		field := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		row := RosterRow{
+			Row:       line,
+			Username:  field("username"),
+			NameFirst: field("name_first"),
+			NameLast:  field("name_last"),
+			Email:     field("email"),
+			Facility:  field("facility"),
+			Role:      models.UserRole(strings.ToLower(field("role"))),
+			Errors:    []string{},
+		}
+		for _, id := range strings.Split(field("providers"), ";") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+			providerID, err := strconv.Atoi(id)
+			if err != nil {
+				row.Errors = append(row.Errors, "invalid provider platform ID "+id)
+				continue
+			}
+			row.Providers = append(row.Providers, providerID)
+		}
+		rows = append(rows, row)
+		if len(rows) > maxRosterRows {
+			return nil, fmt.Errorf("a roster may have at most %d users", maxRosterRows)
+		}
+	}
+	return rows, nil
+}
+
+/**
+* Checks every row, recording what's wrong with each rather than stopping at the
+* first problem, so the whole file can be fixed at once
+**/
+func (srv *Server) validateRoster(r *http.Request, rows []RosterRow) (*RosterReport, error) {
+	facilities, err := srv.Db.GetAllFacilities()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]uint{}
+	byID := map[uint]bool{}
+	for _, facility := range facilities {
+		byName[strings.ToLower(facility.Name)] = facility.ID
+		byID[facility.ID] = true
+	}
+	providers := map[int]*models.ProviderPlatform{}
+	usernames := map[string]int{}
+	emails := map[string]int{}
+	report := &RosterReport{Total: len(rows), Rows: rows}
+	for idx := range rows {
+		row := &rows[idx]
+		fail := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+		if row.NameFirst == "" || row.NameLast == "" {
+			fail("first and last names are required")
+		}
+		row.Username = removeChars(row.Username, disallowedChars)
+		if row.Username == "" {
+			fail("username is required")
+		} else if line, ok := usernames[strings.ToLower(row.Username)]; ok {
+			fail("username %s is also on line %d", row.Username, line)
+		} else if srv.Db.UsernameExists(row.Username) {
+			fail("username %s is already taken", row.Username)
+		} else {
+			usernames[strings.ToLower(row.Username)] = row.Row
+		}
+		if row.Email != "" {
+			if address, err := mail.ParseAddress(row.Email); err != nil || address.Address != row.Email {
+				fail("%s is not a valid email address", row.Email)
+			} else if line, ok := emails[strings.ToLower(row.Email)]; ok {
+				fail("email %s is also on line %d", row.Email, line)
+			} else if srv.Db.EmailExists(row.Email) {
+				fail("email %s is already in use", row.Email)
+			} else {
+				emails[strings.ToLower(row.Email)] = row.Row
+			}
+		}
+		row.FacilityID = srv.getFacilityID(r)
+		if row.Facility != "" {
+			if id, err := strconv.Atoi(row.Facility); err == nil && byID[uint(id)] {
+				row.FacilityID = uint(id)
+			} else if id, ok := byName[strings.ToLower(row.Facility)]; ok {
+				row.FacilityID = id
+			} else {
+				fail("facility %s does not exist", row.Facility)
+			}
+		}
+		// everyone but admins imports into the facility they are viewing
+		if row.FacilityID != srv.getFacilityID(r) && !srv.UserIsAdmin(r) {
+			fail("you may only import users into your own facility")
+		}
+		if row.Role == "" {
+			row.Role = models.Student
+		}
+		if !srv.canSetUserRole(r, row.Role, row.FacilityID) {
+			fail("you may not create a user with the role %s", row.Role)
+		}
+		for _, providerID := range row.Providers {
+			provider, ok := providers[providerID]
+			if !ok {
+				if provider, err = srv.Db.GetProviderPlatformByID(providerID); err != nil {
+					provider = nil
+				}
+				providers[providerID] = provider
+			}
+			switch {
+			case provider == nil:
+				fail("provider platform %d does not exist", providerID)
+			case provider.State != models.Enabled:
+				fail("provider platform %s is %s", provider.Name, provider.State)
+			case provider.Type == models.Moodle:
+				fail("accounts can't be created in %s, moodle users must be mapped by an admin", provider.Name)
+			}
+		}
+		if len(row.Errors) > 0 {
+			report.Invalid++
+		}
+	}
+	report.ToCreate = report.Total - report.Invalid
+	report.Valid = report.Invalid == 0 && report.Total > 0
+	return report, nil
+}
+
+/**
+* POST: /api/users/import
+* imports a roster of users from a CSV file (see readRoster). With ?dry_run=true
+* nothing is created: every row is validated, and the report says which users
+* would be created, and what is wrong with the rest. Otherwise, if every row is
+* valid, the users are all created in one transaction (if any row is invalid,
+* none are, and the report is returned), then their login and any provider
+* accounts are created, and the response is a file of their temporary passwords.
+* @Query Params:
+* ?dry_run=true
+* ?format=: of the passwords file, csv (default) or xlsx
+**/
+func (srv *Server) HandleImportRoster(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleImportRoster"}
+	format, err := exports.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRosterSize)
+	rows, err := readRoster(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := srv.validateRoster(r, rows)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error validating roster")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		srv.WriteResponse(w, http.StatusOK, report)
+		return
+	}
+	if !report.Valid {
+		srv.WriteResponse(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	users := make([]*models.User, len(rows))
+	for idx, row := range rows {
+		users[idx] = &models.User{
+			Username:   row.Username,
+			NameFirst:  row.NameFirst,
+			NameLast:   row.NameLast,
+			Email:      row.Email,
+			Role:       row.Role,
+			FacilityID: row.FacilityID,
+		}
+	}
+	if err := srv.Db.CreateUsers(users); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating roster users, none were created")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.WithFields(fields).Infof("imported %d users from a roster", len(users))
+
+	writer := startExport(w, format, "roster-passwords")
+	err = writer.Write("username", "name_first", "name_last", "facility_id", "temp_password", "notes")
+	for idx, user := range users {
+		if err != nil {
+			break
+		}
+		// the temporary password is replaced by its hash once the user is stored
+		password := user.Password
+		srv.audit(r, models.AuditUserCreated, "user", user.ID, nil, user)
+		notes := srv.provisionRosterUser(user, password, rows[idx].Providers)
+		err = writer.Write(user.Username, user.NameFirst, user.NameLast, user.FacilityID, password, strings.Join(notes, "; "))
+	}
+	finishExport(writer, "HandleImportRoster", err)
+}
+
+/**
+* Provisions the user's login, and their accounts in the roster's providers. The
+* user is already created, so failures are noted in the passwords file for staff
+* to follow up, rather than failing the import, and can be retried from the
+* provisioning page.
+**/
+func (srv *Server) provisionRosterUser(user *models.User, password string, providerIDs []int) []string {
+	notes := []string{}
+	provisionings := []*models.Provisioning{models.NewRosterProvisioning(user, nil)}
+	for _, providerID := range providerIDs {
+		id := uint(providerID)
+		provisionings = append(provisionings, models.NewRosterProvisioning(user, &id))
+	}
+	for _, provisioning := range provisionings {
+		if err := srv.Db.CreateProvisioning(provisioning); err != nil {
+			log.WithFields(log.Fields{"handler": "HandleImportRoster", "user_id": user.ID, "error": err.Error()}).Error("error recording provisioning of roster user")
+			notes = append(notes, "unable to provision: "+err.Error())
+			continue
+		}
+		if _, err := srv.runProvisioning(provisioning, password); err != nil {
+			notes = append(notes, fmt.Sprintf("provisioning %d failed, it can be retried: %v", provisioning.ID, err))
+		}
+	}
+	return notes
+}
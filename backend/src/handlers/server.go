@@ -2,7 +2,9 @@ package handlers
 
 import (
 	database "UnlockEdv2/src/database"
+	"UnlockEdv2/src/jobs"
 	"UnlockEdv2/src/models"
+	"UnlockEdv2/src/oms"
 	"context"
 	"encoding/json"
 	"math"
@@ -19,6 +21,7 @@ type Server struct {
 	Mux       *http.ServeMux
 	OryClient *ory.APIClient
 	Client    *http.Client
+	Scheduler *jobs.Scheduler
 }
 
 /**
@@ -27,6 +30,7 @@ type Server struct {
 func (srv *Server) RegisterRoutes() {
 	srv.registerAuthRoutes()
 	srv.registerUserRoutes()
+	srv.registerRoleRoutes()
 	srv.registerProviderPlatformRoutes()
 	srv.registerUserActivityRoutes()
 	srv.registerProviderMappingRoutes()
@@ -36,6 +40,8 @@ func (srv *Server) RegisterRoutes() {
 	srv.registerProgramsRoutes()
 	srv.registerMilestonesRoutes()
 	srv.registerOutcomesRoutes()
+	srv.registerEnrollmentRoutes()
+	srv.registerEnrollmentRequestRoutes()
 	srv.registerActivityRoutes()
 	srv.registerOidcRoutes()
 	srv.registerDashboardRoutes()
@@ -44,16 +50,32 @@ func (srv *Server) RegisterRoutes() {
 	srv.registerOryRoutes()
 	srv.registerFacilitiesRoutes()
 	srv.registerOpenContentRoutes()
+	srv.registerSyncJobRoutes()
+	srv.registerImportReportRoutes()
+	srv.registerAuditLogRoutes()
+	srv.registerExportRoutes()
+	srv.registerTranscriptRoutes()
+	srv.registerCertificateRoutes()
+	srv.registerRosterImportRoutes()
+	srv.registerProvisioningRoutes()
+	srv.registerOmsRoutes()
+	srv.registerTransferRoutes()
+	srv.registerNotificationRoutes()
+	srv.registerLifecycleRoutes()
 }
 
 func ServerWithDBHandle(db *database.DB) *Server {
-	return &Server{Db: db, Mux: http.NewServeMux()}
+	server := &Server{Db: db, Mux: http.NewServeMux()}
+	server.Scheduler = jobs.NewScheduler(db, server.runSyncJob)
+	return server
 }
 
 func NewServer(isTesting bool) *Server {
 	if isTesting {
 		db := database.InitDB(true)
-		return &Server{Db: db, Mux: http.NewServeMux(), OryClient: nil, Client: nil}
+		server := &Server{Db: db, Mux: http.NewServeMux(), OryClient: nil, Client: nil}
+		server.Scheduler = jobs.NewScheduler(db, server.runSyncJob)
+		return server
 	} else {
 		configuration := ory.Configuration{
 			Servers: ory.ServerConfigurations{
@@ -70,10 +92,18 @@ func NewServer(isTesting bool) *Server {
 		db := database.InitDB(false)
 		mux := http.NewServeMux()
 		server := Server{Db: db, Mux: mux, OryClient: apiClient, Client: &http.Client{}}
+		server.Scheduler = jobs.NewScheduler(db, server.runSyncJob)
 		server.RegisterRoutes()
 		if err := server.setupDefaultAdminInKratos(); err != nil {
 			log.Fatal("Error setting up default admin in Kratos")
 		}
+		go server.Scheduler.Start(context.Background())
+		if dir := os.Getenv("OMS_DROP_DIR"); dir != "" {
+			go oms.NewWatcher(dir, oms.PollInterval(), server.ApplyResidentFeed).Start(context.Background())
+		}
+		if retention := jobs.ArchiveRetention(); retention > 0 {
+			go jobs.StartRetention(context.Background(), db, retention, server.purgeUser)
+		}
 		return &server
 	}
 }
@@ -86,6 +116,11 @@ func (srv *Server) ApplyAdminMiddleware(h func(http.ResponseWriter, *http.Reques
 	return http.HandlerFunc(srv.applyMiddleware(srv.adminMiddleware(h)))
 }
 
+// requires the permission at the facility the user is viewing (see models.RolePermissions)
+func (srv *Server) ApplyPermissionMiddleware(permission models.Permission, h func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return http.HandlerFunc(srv.applyMiddleware(srv.PermissionMiddleware(permission, h)))
+}
+
 func CorsMiddleware(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
@@ -220,6 +255,24 @@ func (srv *Server) getFacilityID(r *http.Request) uint {
 	return r.Context().Value(ClaimsKey).(*Claims).FacilityID
 }
 
+// the database, limited to the users (and their data) of the facility being viewed
+func (srv *Server) facilityDb(r *http.Request) *database.DB {
+	return srv.Db.WithFacility(srv.getFacilityID(r))
+}
+
+// users may always see their own data, everyone else's is limited to the facility being viewed
+func (srv *Server) userDb(r *http.Request, userID uint) *database.DB {
+	if srv.GetUserID(r) == userID {
+		return srv.Db
+	}
+	return srv.facilityDb(r)
+}
+
+func (srv *Server) userInFacility(r *http.Request, userID uint) bool {
+	_, err := srv.facilityDb(r).GetUserByID(userID)
+	return err == nil
+}
+
 type TestClaims string
 
 const TestingClaimsKey = TestClaims("test_claims")
@@ -298,13 +351,18 @@ func (srv *Server) WriteResponse(w http.ResponseWriter, status int, data interfa
 	}
 }
 
+/**
+* Responds with the status, and the message as JSON. Error responses used to be
+* sent with a 200, leaving clients to find the error in the message.
+**/
 func (srv *Server) ErrorResponse(w http.ResponseWriter, status int, message string) {
 	log.Error(message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	resource := models.Resource[interface{}]{Message: message}
-	err := json.NewEncoder(w).Encode(resource)
-	if err != nil {
+	// the status has been sent, so a failure here can only be logged
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
 		log.Error("error writing error response: ", err)
-		http.Error(w, message, status)
 	}
 }
 
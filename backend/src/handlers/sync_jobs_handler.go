@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/jobs"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerSyncJobRoutes() {
+	srv.Mux.Handle("GET /api/sync-jobs", srv.ApplyAdminMiddleware(srv.HandleIndexSyncJobs))
+	srv.Mux.Handle("POST /api/sync-jobs", srv.ApplyAdminMiddleware(srv.HandleCreateSyncJob))
+	srv.Mux.Handle("GET /api/sync-jobs/{id}", srv.ApplyAdminMiddleware(srv.HandleShowSyncJob))
+	srv.Mux.Handle("PATCH /api/sync-jobs/{id}", srv.ApplyAdminMiddleware(srv.HandleUpdateSyncJob))
+	srv.Mux.Handle("DELETE /api/sync-jobs/{id}", srv.ApplyAdminMiddleware(srv.HandleDeleteSyncJob))
+	srv.Mux.Handle("PUT /api/sync-jobs/{id}/pause", srv.ApplyAdminMiddleware(srv.HandlePauseSyncJob))
+	srv.Mux.Handle("PUT /api/sync-jobs/{id}/resume", srv.ApplyAdminMiddleware(srv.HandleResumeSyncJob))
+	srv.Mux.Handle("POST /api/sync-jobs/{id}/run", srv.ApplyAdminMiddleware(srv.HandleTriggerSyncJob))
+	srv.Mux.Handle("DELETE /api/sync-jobs/{id}/run", srv.ApplyAdminMiddleware(srv.HandleCancelSyncJob))
+	srv.Mux.Handle("GET /api/sync-jobs/{id}/runs", srv.ApplyAdminMiddleware(srv.HandleIndexSyncJobRuns))
+	srv.Mux.Handle("GET /api/sync-jobs/{id}/runs/{run_id}", srv.ApplyAdminMiddleware(srv.HandleShowSyncJobRun))
+	srv.Mux.Handle("DELETE /api/sync-jobs/{id}/cursor", srv.ApplyAdminMiddleware(srv.HandleResetSyncJobCursor))
+}
+
+func (srv *Server) HandleIndexSyncJobs(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleIndexSyncJobs"}
+	page, perPage := srv.GetPaginationInfo(r)
+	providerID, _ := strconv.Atoi(r.URL.Query().Get("provider_platform_id"))
+	total, syncJobs, err := srv.Db.GetSyncJobs(page, perPage, uint(providerID))
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching sync jobs")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for idx := range syncJobs {
+		syncJobs[idx].IsRunning = srv.Scheduler.IsRunning(syncJobs[idx].ID)
+	}
+	response := models.PaginatedResource[models.SyncJob]{
+		Message: "sync jobs fetched successfully",
+		Data:    syncJobs,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+func (srv *Server) HandleShowSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.SyncJob{*job}))
+}
+
+func (srv *Server) HandleCreateSyncJob(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleCreateSyncJob"}
+	var job models.SyncJob
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error decoding request body")
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	defer r.Body.Close()
+	if !job.Type.IsValid() {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid job type")
+		return
+	}
+	if _, err := srv.Db.GetProviderPlatformByID(int(job.ProviderPlatformID)); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "provider platform not found")
+		return
+	}
+	if _, err := srv.Db.GetSyncJobForProvider(job.ProviderPlatformID, job.Type); err == nil {
+		srv.ErrorResponse(w, http.StatusConflict, "a job of this type already exists for the provider platform")
+		return
+	}
+	if job.Schedule == "" {
+		job.Schedule = models.DefaultJobSchedules[job.Type]
+	}
+	if err := setNextRun(&job); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if job.Status != models.JobPaused {
+		job.Status = models.JobActive
+	}
+	if err := srv.Db.CreateSyncJob(&job); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error creating sync job")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusCreated, models.Resource[models.SyncJob]{Message: "sync job created successfully", Data: []models.SyncJob{job}})
+}
+
+// only the schedule of a job may be changed, use pause/resume to change its status
+func (srv *Server) HandleUpdateSyncJob(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleUpdateSyncJob"}
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	form := struct {
+		Schedule string `json:"schedule"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	defer r.Body.Close()
+	job.Schedule = form.Schedule
+	if err := setNextRun(job); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := srv.Db.UpdateSyncJob(job); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error updating sync job")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.SyncJob]{Message: "sync job updated successfully", Data: []models.SyncJob{*job}})
+}
+
+func (srv *Server) HandleDeleteSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	srv.Scheduler.Cancel(job.ID)
+	if err := srv.Db.DeleteSyncJob(job.ID); err != nil {
+		log.WithField("job_id", job.ID).Error("error deleting sync job")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) HandlePauseSyncJob(w http.ResponseWriter, r *http.Request) {
+	srv.setSyncJobStatus(w, r, models.JobPaused)
+}
+
+func (srv *Server) HandleResumeSyncJob(w http.ResponseWriter, r *http.Request) {
+	srv.setSyncJobStatus(w, r, models.JobActive)
+}
+
+func (srv *Server) setSyncJobStatus(w http.ResponseWriter, r *http.Request, status models.JobStatus) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	job.Status = status
+	if status == models.JobActive {
+		// resuming shouldn't immediately fire every run that was missed while paused
+		if err := setNextRun(job); err != nil {
+			srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := srv.Db.UpdateSyncJob(job); err != nil {
+		log.WithFields(log.Fields{"job_id": job.ID, "error": err.Error()}).Error("error updating sync job status")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.Resource[models.SyncJob]{Message: "sync job " + string(status), Data: []models.SyncJob{*job}})
+}
+
+func (srv *Server) HandleTriggerSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	run, err := srv.Scheduler.Trigger(r.Context(), job, models.TriggerManual)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobRunning) {
+			srv.ErrorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.WithFields(log.Fields{"job_id": job.ID, "error": err.Error()}).Error("error triggering sync job")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusAccepted, models.Resource[models.SyncJobRun]{Message: "sync job started", Data: []models.SyncJobRun{*run}})
+}
+
+func (srv *Server) HandleCancelSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !srv.Scheduler.Cancel(job.ID) {
+		srv.ErrorResponse(w, http.StatusConflict, "sync job is not running")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) HandleIndexSyncJobRuns(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	page, perPage := srv.GetPaginationInfo(r)
+	total, runs, err := srv.Db.GetSyncJobRuns(page, perPage, job.ID)
+	if err != nil {
+		log.WithFields(log.Fields{"job_id": job.ID, "error": err.Error()}).Error("error fetching sync job runs")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.PaginatedResource[models.SyncJobRun]{
+		Message: "sync job runs fetched successfully",
+		Data:    runs,
+		Meta:    models.NewPaginationInfo(page, perPage, total),
+	}
+	srv.WriteResponse(w, http.StatusOK, response)
+}
+
+// polled for the progress (completed of total) of a running import
+func (srv *Server) HandleShowSyncJobRun(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	runID, err := strconv.Atoi(r.PathValue("run_id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid sync job run id")
+		return
+	}
+	run, err := srv.Db.GetSyncJobRunByID(uint(runID))
+	if err != nil || run.SyncJobID != job.ID {
+		srv.ErrorResponse(w, http.StatusNotFound, "sync job run not found")
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.SyncJobRun{*run}))
+}
+
+// the next run of the job will re-import everything from the provider
+func (srv *Server) HandleResetSyncJobCursor(w http.ResponseWriter, r *http.Request) {
+	job, err := srv.getSyncJobFromPath(r)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err := srv.Db.DeleteSyncCursor(job.ProviderPlatformID, job.Type); err != nil {
+		log.WithFields(log.Fields{"job_id": job.ID, "error": err.Error()}).Error("error resetting sync cursor")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) getSyncJobFromPath(r *http.Request) (*models.SyncJob, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, errors.New("invalid sync job id")
+	}
+	job, err := srv.Db.GetSyncJobByID(uint(id))
+	if err != nil {
+		return nil, errors.New("sync job not found")
+	}
+	job.IsRunning = srv.Scheduler.IsRunning(job.ID)
+	if since, err := srv.Db.GetSyncCursor(job.ProviderPlatformID, job.Type); err == nil && !since.IsZero() {
+		job.Since = &since
+	}
+	return job, nil
+}
+
+func setNextRun(job *models.SyncJob) error {
+	sched, err := jobs.ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+	next := sched.Next(time.Now())
+	job.NextRunAt = &next
+	return nil
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"UnlockEdv2/src/pdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerTranscriptRoutes() {
+	srv.Mux.Handle("GET /api/users/{id}/transcript.pdf", srv.applyMiddleware(srv.HandleUserTranscript))
+	// public, so that whoever a transcript is given to can check it
+	srv.Mux.HandleFunc("GET /api/transcripts/{document_id}", srv.HandleVerifyTranscript)
+}
+
+/**
+* GET: /api/users/{id}/transcript.pdf
+* prints a transcript of the user's programs, hours, milestones and outcomes. Each
+* one printed is recorded with a new document ID, printed on every page.
+**/
+func (srv *Server) HandleUserTranscript(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleUserTranscript"}
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	fields["user_id"] = userID
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's transcript")
+		return
+	}
+	user, err := srv.Db.GetUserByID(uint(userID))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	}
+	facility, err := srv.Db.GetFacilityByID(int(user.FacilityID))
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching the user's facility")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	programs, err := srv.Db.GetTranscriptPrograms(user.ID)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching transcript programs")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	outcomes, err := srv.Db.GetAllOutcomesForUser(user.ID)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error fetching transcript outcomes")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	transcript := models.Transcript{
+		DocumentID: models.NewDocumentID("TR"),
+		UserID:     &user.ID,
+		FacilityID: facility.ID,
+		IssuedByID: srv.GetUserID(r),
+		NameFirst:  user.NameFirst,
+		NameLast:   user.NameLast,
+	}
+	document := renderTranscript(transcript.DocumentID, user, facility, programs, outcomes)
+	sum := sha256.Sum256(document)
+	transcript.Checksum = hex.EncodeToString(sum[:])
+	if err := srv.Db.CreateTranscript(&transcript); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error recording transcript")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="transcript-`+user.Username+`.pdf"`)
+	w.Header().Set("X-Document-ID", transcript.DocumentID)
+	if _, err := w.Write(document); err != nil {
+		log.WithFields(fields).Errorf("error writing transcript: %v", err)
+	}
+}
+
+type TranscriptVerification struct {
+	DocumentID string    `json:"document_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	NameFirst  string    `json:"name_first"`
+	NameLast   string    `json:"name_last"`
+	Facility   string    `json:"facility"`
+	Checksum   string    `json:"checksum"` // sha256 of the issued pdf, to compare with the copy being checked
+}
+
+/**
+* GET: /api/transcripts/{document_id}
+* confirms that a transcript was issued, and to whom
+**/
+func (srv *Server) HandleVerifyTranscript(w http.ResponseWriter, r *http.Request) {
+	transcript, err := srv.Db.GetTranscriptByDocumentID(models.NormalizeDocumentID(r.PathValue("document_id")))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "No transcript was issued with this document ID")
+		return
+	}
+	verification := TranscriptVerification{
+		DocumentID: transcript.DocumentID,
+		IssuedAt:   transcript.CreatedAt,
+		NameFirst:  transcript.NameFirst,
+		NameLast:   transcript.NameLast,
+		Checksum:   transcript.Checksum,
+	}
+	// transcripts printed before the name was recorded with them
+	if verification.NameFirst == "" && verification.NameLast == "" && transcript.User != nil {
+		verification.NameFirst, verification.NameLast = transcript.User.NameFirst, transcript.User.NameLast
+	}
+	if transcript.Facility != nil {
+		verification.Facility = transcript.Facility.Name
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]TranscriptVerification{verification}))
+}
+
+const (
+	transcriptMargin = 54.0
+	transcriptBottom = 90.0 // the footer is below this
+)
+
+// where each column of the programs table starts (or for numbers, ends)
+var transcriptColumns = struct{ program, provider, hours, milestones, status, completed float64 }{54, 232, 372, 432, 446, 516}
+
+/**
+* Lays the transcript out over as many pages as it needs: the learner and facility,
+* a table of their programs, then each of their outcomes
+**/
+func renderTranscript(documentID string, user *models.User, facility *models.Facility, programs []database.TranscriptProgram, outcomes []models.Outcome) []byte {
+	doc := pdf.New("Transcript for " + user.NameFirst + " " + user.NameLast)
+	verifyURL := os.Getenv("APP_URL") + "/api/transcripts/" + documentID
+	var page *pdf.Page
+	var y float64
+	newPage := func() {
+		page = doc.AddPage(pdf.Letter)
+		page.Text(transcriptMargin, page.Height-60, pdf.HelveticaBold, 16, facility.Name)
+		page.RightText(page.Width-transcriptMargin, page.Height-60, pdf.Helvetica, 10, "Document ID: "+documentID)
+		page.Line(transcriptMargin, page.Height-70, page.Width-transcriptMargin, page.Height-70, 1)
+		page.Line(transcriptMargin, 60, page.Width-transcriptMargin, 60, 0.5)
+		page.Text(transcriptMargin, 46, pdf.Helvetica, 8, "Verify this transcript at "+verifyURL)
+		y = page.Height - 100
+	}
+	// starts a new page if the next lines won't fit
+	space := func(height float64) {
+		if y-height < transcriptBottom {
+			newPage()
+		}
+	}
+	completed := map[uint]time.Time{}
+	for _, outcome := range outcomes {
+		completed[outcome.ProgramID] = outcome.CreatedAt
+	}
+
+	newPage()
+	page.Text(transcriptMargin, y, pdf.HelveticaBold, 20, "Learner Transcript")
+	y -= 28
+	for _, line := range [][2]string{
+		{"Name", user.NameFirst + " " + user.NameLast},
+		{"Username", user.Username},
+		{"Issued", time.Now().Format("January 2, 2006")},
+	} {
+		page.Text(transcriptMargin, y, pdf.HelveticaBold, 11, line[0]+":")
+		page.Text(transcriptMargin+70, y, pdf.Helvetica, 11, line[1])
+		y -= 16
+	}
+
+	y -= 16
+	page.Text(transcriptMargin, y, pdf.HelveticaBold, 14, "Programs")
+	y -= 20
+	header := func() {
+		columns := transcriptColumns
+		page.Text(columns.program, y, pdf.HelveticaBold, 9, "Program")
+		page.Text(columns.provider, y, pdf.HelveticaBold, 9, "Provider")
+		page.RightText(columns.hours, y, pdf.HelveticaBold, 9, "Hours")
+		page.RightText(columns.milestones, y, pdf.HelveticaBold, 9, "Milestones")
+		page.Text(columns.status, y, pdf.HelveticaBold, 9, "Status")
+		page.Text(columns.completed, y, pdf.HelveticaBold, 9, "Completed")
+		page.Line(transcriptMargin, y-5, page.Width-transcriptMargin, y-5, 0.5)
+		y -= 18
+	}
+	header()
+	if len(programs) == 0 {
+		page.Text(transcriptMargin, y, pdf.Helvetica, 10, "No programs have been recorded for this learner.")
+		y -= 16
+	}
+	var totalHours float64
+	for _, program := range programs {
+		names := pdf.Wrap(pdf.Helvetica, 10, transcriptColumns.provider-transcriptColumns.program-10, program.Program)
+		if y-float64(len(names))*12 < transcriptBottom {
+			newPage()
+			header()
+		}
+		columns := transcriptColumns
+		page.Text(columns.provider, y, pdf.Helvetica, 10, truncate(program.Provider, pdf.Helvetica, 10, columns.hours-columns.provider-40))
+		page.RightText(columns.hours, y, pdf.Helvetica, 10, fmt.Sprintf("%.1f", program.Hours))
+		page.RightText(columns.milestones, y, pdf.Helvetica, 10, strconv.Itoa(program.CompletedMilestones))
+		page.Text(columns.status, y, pdf.Helvetica, 10, transcriptStatus(program))
+		if at, ok := completed[program.ProgramID]; ok {
+			page.Text(columns.completed, y, pdf.Helvetica, 10, at.Format("01/02/2006"))
+		} else if program.Status == models.EnrollmentCompleted && program.EndDate != nil {
+			page.Text(columns.completed, y, pdf.Helvetica, 10, program.EndDate.Format("01/02/2006"))
+		}
+		for _, name := range names {
+			page.Text(columns.program, y, pdf.Helvetica, 10, name)
+			y -= 12
+		}
+		y -= 4
+		totalHours += program.Hours
+	}
+	page.Line(transcriptMargin, y+8, page.Width-transcriptMargin, y+8, 0.5)
+	page.Text(transcriptColumns.provider, y-6, pdf.HelveticaBold, 10, "Total")
+	page.RightText(transcriptColumns.hours, y-6, pdf.HelveticaBold, 10, fmt.Sprintf("%.1f", totalHours))
+	y -= 40
+
+	space(60)
+	page.Text(transcriptMargin, y, pdf.HelveticaBold, 14, "Outcomes")
+	y -= 20
+	if len(outcomes) == 0 {
+		page.Text(transcriptMargin, y, pdf.Helvetica, 10, "No outcomes have been recorded for this learner.")
+	}
+	for _, outcome := range outcomes {
+		space(16)
+		line := outcomeLabel(outcome.Type)
+		if outcome.Value != "" {
+			line += ": " + outcome.Value
+		}
+		page.Text(transcriptMargin, y, pdf.Helvetica, 10, outcome.CreatedAt.Format("01/02/2006"))
+		page.Text(transcriptMargin+70, y, pdf.HelveticaBold, 10, truncate(outcome.ProgramName, pdf.HelveticaBold, 10, 200))
+		page.Text(transcriptMargin+280, y, pdf.Helvetica, 10, truncate(line, pdf.Helvetica, 10, page.Width-transcriptMargin*2-280))
+		y -= 16
+	}
+	return doc.Bytes()
+}
+
+func transcriptStatus(program database.TranscriptProgram) string {
+	if program.Status == "" {
+		return "Self-paced"
+	}
+	return strings.ToUpper(string(program.Status[:1])) + string(program.Status[1:])
+}
+
+func outcomeLabel(outcomeType models.OutcomeType) string {
+	words := strings.Split(string(outcomeType), "_")
+	for idx, word := range words {
+		if word != "" {
+			words[idx] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// shortens the text with an ellipsis to fit the width
+func truncate(text string, font pdf.Font, size, width float64) string {
+	if pdf.TextWidth(font, size, text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.TextWidth(font, size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
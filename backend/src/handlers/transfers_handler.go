@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) registerTransferRoutes() {
+	srv.Mux.Handle("POST /api/users/{id}/transfer", srv.ApplyAdminMiddleware(srv.HandleTransferUser))
+	srv.Mux.Handle("GET /api/users/{id}/transfers", srv.applyMiddleware(srv.HandleUserTransfers))
+}
+
+// what to do with the user's account in a provider, ExternalUserID is the account to re-map to
+type MappingDecision struct {
+	ProviderPlatformID uint                 `json:"provider_platform_id"`
+	Action             models.MappingAction `json:"action"`
+	ExternalUserID     string               `json:"external_user_id"`
+	ExternalUsername   string               `json:"external_username"`
+}
+
+type TransferRequest struct {
+	FacilityID uint              `json:"facility_id"`
+	Reason     string            `json:"reason"`
+	Providers  []MappingDecision `json:"providers"`
+}
+
+/**
+* Moves the user to the facility. Their account in each provider is kept, suspended
+* or re-mapped as decided, and those without a decision are kept if the provider
+* has programs at the destination, and suspended otherwise. Their open enrollments
+* in programs the destination offers are carried over (and pushed to the new
+* account, where it was re-mapped), the rest are flagged for staff to review. The
+* admins of both facilities are notified.
+**/
+func (srv *Server) transferUser(user *models.User, to *models.Facility, source models.TransferSource, actorID *uint, request TransferRequest) (*models.FacilityTransfer, error) {
+	if user.FacilityID == to.ID {
+		return nil, errors.New("user is already at this facility")
+	}
+	decisions := map[uint]MappingDecision{}
+	for _, decision := range request.Providers {
+		if !decision.Action.IsValid() {
+			return nil, fmt.Errorf("unknown action %q for provider %d", decision.Action, decision.ProviderPlatformID)
+		}
+		if decision.Action == models.RemapMapping && decision.ExternalUserID == "" {
+			return nil, fmt.Errorf("the account to re-map provider %d to is required", decision.ProviderPlatformID)
+		}
+		decisions[decision.ProviderPlatformID] = decision
+	}
+	mappings, err := srv.Db.GetAllProviderMappingsForUser(int(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	transfer := &models.FacilityTransfer{
+		UserID:          user.ID,
+		FromFacilityID:  user.FacilityID,
+		ToFacilityID:    to.ID,
+		Source:          source,
+		TransferredByID: actorID,
+		Reason:          request.Reason,
+		Mappings:        []models.TransferMapping{},
+		Flagged:         []models.FlaggedEnrollment{},
+	}
+	actions := map[uint]models.MappingAction{}
+	now := time.Now()
+	for idx := range mappings {
+		mapping := &mappings[idx]
+		decision, ok := decisions[mapping.ProviderPlatformID]
+		if !ok {
+			decision.Action = models.SuspendMapping
+			if srv.Db.ProviderOffersAtFacility(mapping.ProviderPlatformID, to.ID) {
+				decision.Action = models.KeepMapping
+			}
+		}
+		record := models.TransferMapping{ProviderPlatformID: mapping.ProviderPlatformID, Action: decision.Action, ExternalUserID: mapping.ExternalUserID}
+		switch decision.Action {
+		case models.KeepMapping:
+			mapping.SuspendedAt = nil
+		case models.SuspendMapping:
+			if mapping.SuspendedAt == nil {
+				mapping.SuspendedAt = &now
+			}
+		case models.RemapMapping:
+			if other, err := srv.Db.GetProviderUserMappingByExternalUserID(decision.ExternalUserID, mapping.ProviderPlatformID); err == nil && other.UserID != user.ID {
+				return nil, fmt.Errorf("account %s in provider %d is already mapped to another user", decision.ExternalUserID, mapping.ProviderPlatformID)
+			}
+			mapping.ExternalUserID, mapping.ExternalLoginID, mapping.SuspendedAt = decision.ExternalUserID, "", nil
+			if decision.ExternalUsername != "" {
+				mapping.ExternalUsername = decision.ExternalUsername
+			}
+			record.NewExternalUserID, record.NewExternalName = mapping.ExternalUserID, mapping.ExternalUsername
+		}
+		actions[mapping.ProviderPlatformID] = decision.Action
+		transfer.Mappings = append(transfer.Mappings, record)
+	}
+	enrollments, err := srv.Db.GetOpenEnrollmentsForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	toPush := []*models.Enrollment{}
+	for idx := range enrollments {
+		enrollment := &enrollments[idx]
+		program := enrollment.Program
+		// programs made in UnlockEd aren't in a provider, so the user's account there doesn't matter
+		inProvider := program.ExternalID != ""
+		switch {
+		case !srv.Db.IsProgramPublished(int(program.ID), to.ID):
+			enrollment.TransferFlag = fmt.Sprintf("%s does not offer this program", to.Name)
+		case inProvider && actions[program.ProviderPlatformID] == models.SuspendMapping:
+			enrollment.TransferFlag = "the learner's account in this program's provider was suspended on transfer"
+		default:
+			enrollment.TransferFlag = ""
+			transfer.CarriedOver++
+			// the enrollment is in the old account, so it is made again in the new one
+			if inProvider && actions[program.ProviderPlatformID] == models.RemapMapping {
+				enrollment.ExternalID, enrollment.SyncedAt = "", nil
+				toPush = append(toPush, enrollment)
+			}
+			continue
+		}
+		transfer.Flagged = append(transfer.Flagged, models.FlaggedEnrollment{
+			EnrollmentID: enrollment.ID,
+			ProgramID:    program.ID,
+			ProgramName:  program.Name,
+			Reason:       enrollment.TransferFlag,
+		})
+	}
+	from, err := srv.Db.GetFacilityByID(int(user.FacilityID))
+	if err != nil {
+		from = &models.Facility{Name: "an unknown facility"}
+	}
+	user.FacilityID = to.ID
+	if err := srv.Db.SaveFacilityTransfer(transfer, user, mappings, enrollments); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{"user_id": user.ID, "from_facility_id": transfer.FromFacilityID, "to_facility_id": to.ID}).Info("transferred user")
+
+	for _, enrollment := range toPush {
+		srv.pushEnrollment(enrollment)
+	}
+	for idx := range mappings {
+		if actions[mappings[idx].ProviderPlatformID] != models.RemapMapping {
+			continue
+		}
+		provider, err := srv.Db.GetProviderPlatformByID(int(mappings[idx].ProviderPlatformID))
+		if err == nil && provider.OidcID != 0 {
+			err = srv.registerProviderLogin(provider, user)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"user_id": user.ID, "provider_platform_id": mappings[idx].ProviderPlatformID, "error": err.Error()}).Error("error registering login for re-mapped account")
+		}
+	}
+	body := fmt.Sprintf("%s %s (%s) was transferred from %s to %s. %d enrollments were carried over, and %d need review.",
+		user.NameFirst, user.NameLast, user.Username, from.Name, to.Name, transfer.CarriedOver, len(transfer.Flagged))
+	srv.notifyFacilityAdmins([]uint{transfer.FromFacilityID, to.ID}, "Learner transferred", body, "/users")
+	return transfer, nil
+}
+
+/**
+* POST: /api/users/{id}/transfer
+* moves the user to another facility (see transferUser), with the body's decisions
+* for their provider accounts
+**/
+func (srv *Server) HandleTransferUser(w http.ResponseWriter, r *http.Request) {
+	fields := log.Fields{"handler": "HandleTransferUser"}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	var request TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid transfer")
+		return
+	}
+	user, err := srv.Db.GetUserByID(uint(id))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	to, err := srv.Db.GetFacilityByID(int(request.FacilityID))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "facility not found")
+		return
+	}
+	before := *user
+	actorID := srv.GetUserID(r)
+	transfer, err := srv.transferUser(user, to, models.TransferByStaff, &actorID, request)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Error("error transferring user")
+		srv.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	srv.audit(r, models.AuditUserTransferred, "user", user.ID, before, user)
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource([]models.FacilityTransfer{*transfer}))
+}
+
+/**
+* GET: /api/users/{id}/transfers
+* the user's transfers between facilities, latest first
+**/
+func (srv *Server) HandleUserTransfers(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if !srv.canViewUserData(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "You do not have permission to view this user's transfers")
+		return
+	}
+	transfers, err := srv.Db.GetTransfersForUser(uint(id))
+	if err != nil {
+		log.WithFields(log.Fields{"handler": "HandleUserTransfers", "error": err.Error()}).Error("error fetching transfers")
+		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.WriteResponse(w, http.StatusOK, models.DefaultResource(transfers))
+}
@@ -11,7 +11,7 @@ import (
 )
 
 func (srv *Server) registerUserActivityRoutes() {
-	srv.Mux.Handle("GET /api/users/activity-log", srv.ApplyAdminMiddleware(srv.handleGetAllUserActivities))
+	srv.Mux.Handle("GET /api/users/activity-log", srv.ApplyPermissionMiddleware(models.ViewReports, srv.handleGetAllUserActivities))
 	srv.Mux.Handle("GET /api/users/{id}/activity-log", srv.applyMiddleware(srv.handleGetUserActivityByID))
 }
 
@@ -77,14 +77,14 @@ func (srv *Server) handleGetAllUserActivities(w http.ResponseWriter, r *http.Req
 	var activities []database.UserAcitivityJoin
 	err := error(nil)
 	if search != "" {
-		total, activities, err = srv.Db.SearchUserActivity(search, order, page, perPage)
+		total, activities, err = srv.facilityDb(r).SearchUserActivity(search, order, page, perPage)
 		if err != nil {
 			log.Debug("Error fetching user activities: ", err)
 			srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 	} else {
-		total, activities, err = srv.Db.GetAllUserActivity(order, page, perPage)
+		total, activities, err = srv.facilityDb(r).GetAllUserActivity(order, page, perPage)
 		if err != nil {
 			log.Debug("Error fetching user activities: ", err)
 			srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
@@ -110,7 +110,7 @@ func (srv *Server) handleGetUserActivityByID(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	page, perPage := srv.GetPaginationInfo(r)
-	total, activity, err := srv.Db.GetActivityForUser(id, page, perPage)
+	total, activity, err := srv.userDb(r, uint(id)).GetActivityForUser(id, page, perPage)
 	if err != nil {
 		log.Debug("Error fetching user activity: ", err)
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
@@ -14,12 +14,12 @@ import (
 )
 
 func (srv *Server) registerUserRoutes() {
-	srv.Mux.Handle("GET /api/users", srv.ApplyAdminMiddleware(srv.HandleIndexUsers))
+	srv.Mux.Handle("GET /api/users", srv.ApplyPermissionMiddleware(models.ViewUsers, srv.HandleIndexUsers))
 	srv.Mux.Handle("GET /api/users/{id}", srv.applyMiddleware(srv.HandleShowUser))
-	srv.Mux.Handle("POST /api/users", srv.ApplyAdminMiddleware(srv.HandleCreateUser))
-	srv.Mux.Handle("DELETE /api/users/{id}", srv.ApplyAdminMiddleware(srv.HandleDeleteUser))
-	srv.Mux.Handle("PATCH /api/users/{id}", srv.ApplyAdminMiddleware(srv.HandleUpdateUser))
-	srv.Mux.Handle("POST /api/users/student-password", srv.ApplyAdminMiddleware(srv.HandleResetStudentPassword))
+	srv.Mux.Handle("POST /api/users", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleCreateUser))
+	srv.Mux.Handle("DELETE /api/users/{id}", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleDeleteUser))
+	srv.Mux.Handle("PATCH /api/users/{id}", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleUpdateUser))
+	srv.Mux.Handle("POST /api/users/student-password", srv.ApplyPermissionMiddleware(models.ManageUsers, srv.HandleResetStudentPassword))
 }
 
 /**
@@ -32,6 +32,10 @@ func (srv *Server) HandleIndexUsers(w http.ResponseWriter, r *http.Request) {
 		srv.HandleGetUsersWithLogins(w, r.WithContext(r.Context()))
 		return
 	}
+	if slices.Contains(include, "only_archived") {
+		srv.HandleGetArchivedUsers(w, r)
+		return
+	}
 	if slices.Contains(include, "only_unmapped") {
 		providerId := r.URL.Query().Get("provider_id")
 		srv.HandleGetUnmappedUsers(w, r, providerId)
@@ -41,7 +45,7 @@ func (srv *Server) HandleIndexUsers(w http.ResponseWriter, r *http.Request) {
 	search := strings.ToLower(r.URL.Query().Get("search"))
 	search = strings.TrimSpace(search)
 	facilityId := srv.getFacilityID(r)
-	total, users, err := srv.Db.GetCurrentUsers(page, perPage, facilityId, order, search)
+	total, users, err := srv.facilityDb(r).GetCurrentUsers(page, perPage, facilityId, order, search)
 	if err != nil {
 		log.Error("IndexUsers Database Error: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -65,7 +69,7 @@ func (srv *Server) HandleGetUnmappedUsers(w http.ResponseWriter, r *http.Request
 	facilityId := srv.getFacilityID(r)
 	page, perPage := srv.GetPaginationInfo(r)
 	search := r.URL.Query()["search"]
-	total, users, err := srv.Db.GetUnmappedUsers(page, perPage, providerId, search, facilityId)
+	total, users, err := srv.facilityDb(r).GetUnmappedUsers(page, perPage, providerId, search, facilityId)
 	if err != nil {
 		log.Error("Database Error getting unmapped users: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -90,7 +94,7 @@ func (srv *Server) HandleGetUnmappedUsers(w http.ResponseWriter, r *http.Request
 func (srv *Server) HandleGetUsersWithLogins(w http.ResponseWriter, r *http.Request) {
 	facilityId := srv.getFacilityID(r)
 	page, perPage := srv.GetPaginationInfo(r)
-	total, users, err := srv.Db.GetUsersWithLogins(page, perPage, facilityId)
+	total, users, err := srv.facilityDb(r).GetUsersWithLogins(page, perPage, facilityId)
 	if err != nil {
 		log.Error("IndexUsers Database Error: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -122,7 +126,7 @@ func (srv *Server) HandleShowUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := models.Resource[models.User]{}
-	user, err := srv.Db.GetUserByID(uint(id))
+	user, err := srv.userDb(r, uint(id)).GetUserByID(uint(id))
 	if err != nil {
 		log.Info("Error: ", err)
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -154,9 +158,13 @@ func (srv *Server) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	if user.User.FacilityID == 0 {
+	if user.User.FacilityID == 0 || !srv.UserIsAdmin(r) {
 		user.User.FacilityID = srv.getFacilityID(r)
 	}
+	if !srv.canSetUserRole(r, user.User.Role, user.User.FacilityID) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not create a user with this role")
+		return
+	}
 	userNameExists := srv.Db.UsernameExists(user.User.Username)
 	if userNameExists {
 		srv.ErrorResponse(w, http.StatusBadRequest, "userexists")
@@ -168,6 +176,7 @@ func (srv *Server) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	srv.audit(r, models.AuditUserCreated, "user", newUser.ID, nil, newUser)
 	for _, providerID := range user.Providers {
 		provider, err := srv.Db.GetProviderPlatformByID(providerID)
 		if err != nil {
@@ -214,21 +223,29 @@ func (srv *Server) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	user, err := srv.Db.GetUserByID(uint(id))
+	user, err := srv.facilityDb(r).GetUserByID(uint(id))
 	if err != nil {
 		log.WithFields(fields).Error("unable to find user to be deleted")
 		srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if err := srv.deleteIdentityInKratos(&user.KratosID); err != nil {
-		log.WithFields(fields).Error("error deleting user in kratos")
-		srv.ErrorResponse(w, http.StatusInternalServerError, "error deleting user in kratos")
+	if user.ID == srv.GetUserID(r) {
+		srv.ErrorResponse(w, http.StatusBadRequest, "you may not delete yourself")
 		return
 	}
-	if err := srv.Db.DeleteUser(id); err != nil {
-		log.WithFields(fields).Errorln("unable to delete user")
-		srv.ErrorResponse(w, http.StatusInternalServerError, "error deleting user in database")
+	if !srv.canManageUser(r, user) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not delete this user")
+		return
 	}
+	// the user is archived, and purged once the retention period has passed
+	before := *user
+	if _, err := srv.setUserStatus(user, models.UserArchived); err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Errorln("unable to archive user")
+		srv.ErrorResponse(w, http.StatusInternalServerError, "error archiving user")
+		return
+	}
+	srv.audit(r, models.AuditUserDeleted, "user", user.ID, before, user)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -249,12 +266,16 @@ func (srv *Server) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	toUpdate, err := srv.Db.GetUserByID(uint(id))
+	toUpdate, err := srv.facilityDb(r).GetUserByID(uint(id))
 	if err != nil {
 		log.Error("Error getting user by ID:" + fmt.Sprintf("%d", id))
 		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
 		return
 	}
+	if !srv.canManageUser(r, toUpdate) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not update this user")
+		return
+	}
 	if toUpdate.Username != user.Username && user.Username != "" {
 		userNameExists := srv.Db.UsernameExists(user.Username)
 		if userNameExists {
@@ -262,18 +283,84 @@ func (srv *Server) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if user.FacilityID != 0 && user.FacilityID != toUpdate.FacilityID && !srv.UserIsAdmin(r) {
+		srv.ErrorResponse(w, http.StatusForbidden, "only admins may move users between facilities")
+		return
+	}
+	if user.Role != "" && user.Role != toUpdate.Role && !srv.canSetUserRole(r, user.Role, toUpdate.FacilityID) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not assign this role")
+		return
+	}
+	// the status is changed through PUT /api/users/{id}/status, which applies its side effects
+	user.Status, user.StatusChangedAt = "", nil
+	// a new facility is a transfer, with the default decisions for the user's provider accounts
+	var transferTo *models.Facility
+	if user.FacilityID != 0 && user.FacilityID != toUpdate.FacilityID {
+		transferTo, err = srv.Db.GetFacilityByID(int(user.FacilityID))
+		if err != nil {
+			srv.ErrorResponse(w, http.StatusBadRequest, "facility not found")
+			return
+		}
+		user.FacilityID = 0
+	}
+	before := *toUpdate
 	models.UpdateStruct(&toUpdate, &user)
 
-	updatedUser, err := srv.Db.UpdateUser(toUpdate)
+	updatedUser, err := srv.facilityDb(r).UpdateUser(toUpdate)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	srv.audit(r, models.AuditUserUpdated, "user", updatedUser.ID, before, updatedUser)
+	if transferTo != nil {
+		moved := *updatedUser
+		actorID := srv.GetUserID(r)
+		if _, err := srv.transferUser(updatedUser, transferTo, models.TransferByStaff, &actorID, TransferRequest{FacilityID: transferTo.ID}); err != nil {
+			log.WithFields(log.Fields{"handler": "HandleUpdateUser", "user_id": updatedUser.ID, "error": err.Error()}).Error("error transferring user")
+			srv.ErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.audit(r, models.AuditUserTransferred, "user", updatedUser.ID, moved, updatedUser)
+	}
 	response := models.Resource[models.User]{}
 	response.Data = append(response.Data, *updatedUser)
 	srv.WriteResponse(w, http.StatusOK, response)
 }
 
+/**
+* Staff may only manage users whose roles they could grant themselves, i.e. the
+* user's own role and each of their assignments at this facility, so that no one
+* can take over (or lock out) an account with more access than their own
+**/
+func (srv *Server) canManageUser(r *http.Request, user *models.User) bool {
+	claims := r.Context().Value(ClaimsKey).(*Claims)
+	if claims.Role == models.Admin {
+		return true
+	}
+	if user.Role == models.Admin || !srv.canSetUserRole(r, user.Role, user.FacilityID) {
+		return false
+	}
+	assignments, err := srv.Db.GetRoleAssignmentsAtFacility(user.ID, claims.FacilityID)
+	if err != nil {
+		log.WithFields(log.Fields{"user_id": user.ID, "error": err.Error()}).Error("error fetching role assignments of user")
+		return false
+	}
+	for _, assignment := range assignments {
+		if !srv.canGrantRole(claims, assignment.Role, assignment.FacilityID) {
+			return false
+		}
+	}
+	return true
+}
+
+// a user's own role is granted at their facility, the same as a role assignment there
+func (srv *Server) canSetUserRole(r *http.Request, role models.UserRole, facilityID uint) bool {
+	if role == "" || role == models.Student {
+		return true
+	}
+	return role.IsValid() && srv.canGrantRole(r.Context().Value(ClaimsKey).(*Claims), role, &facilityID)
+}
+
 type TempPasswordRequest struct {
 	UserID uint `json:"user_id"`
 }
@@ -288,8 +375,17 @@ func (srv *Server) HandleResetStudentPassword(w http.ResponseWriter, r *http.Req
 		return
 	}
 	defer r.Body.Close()
+	target, err := srv.facilityDb(r).GetUserByID(temp.UserID)
+	if err != nil {
+		srv.ErrorResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if !srv.canManageUser(r, target) {
+		srv.ErrorResponse(w, http.StatusForbidden, "you may not reset this user's password")
+		return
+	}
 	response := make(map[string]string)
-	newPass, err := srv.Db.AssignTempPasswordToUser(uint(temp.UserID))
+	newPass, err := srv.facilityDb(r).AssignTempPasswordToUser(uint(temp.UserID))
 	if err != nil {
 		response["message"] = err.Error()
 		fields["error"] = err.Error()
@@ -299,7 +395,7 @@ func (srv *Server) HandleResetStudentPassword(w http.ResponseWriter, r *http.Req
 	}
 	response["temp_password"] = newPass
 	response["message"] = "Temporary password assigned"
-	user, err := srv.Db.GetUserByID(uint(temp.UserID))
+	user, err := srv.facilityDb(r).GetUserByID(uint(temp.UserID))
 	if err != nil {
 		fields["error"] = err.Error()
 		log.WithFields(fields).Errorf("Exising user not found, this should never happen: %v", temp.UserID)
@@ -322,5 +418,7 @@ func (srv *Server) HandleResetStudentPassword(w http.ResponseWriter, r *http.Req
 			return
 		}
 	}
+	// the temporary password itself is never logged
+	srv.audit(r, models.AuditPasswordReset, "user", user.ID, nil, nil)
 	srv.WriteResponse(w, http.StatusOK, response)
 }
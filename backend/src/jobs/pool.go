@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultWorkers = 4
+
+// the number of requests an import makes to the middleware at once (SYNC_JOB_WORKERS)
+func Workers() int {
+	if workers, err := strconv.Atoi(os.Getenv("SYNC_JOB_WORKERS")); err == nil && workers > 0 {
+		return workers
+	}
+	return defaultWorkers
+}
+
+/**
+* ForEach calls work for every item, on at most `workers` goroutines at a time,
+* and returns once they have all finished. Once the context is cancelled no
+* more items are started, and the context's error is returned. Failures of
+* individual items are for work to record (e.g. in the run's report).
+**/
+func ForEach[T any](ctx context.Context, workers int, items []T, work func(ctx context.Context, item T)) error {
+	queue := make(chan T)
+	var wg sync.WaitGroup
+	for range max(min(workers, len(items)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				work(ctx, item)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+	for _, item := range items {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
+// how often the progress of a run is written to the database
+const progressInterval = 2 * time.Second
+
+/**
+* Progress counts the completed units of work (e.g. program/user pairs) of a run,
+* and saves the counts every few seconds so that admins can poll the run.
+* It is safe for concurrent use by the workers of a run.
+**/
+type Progress struct {
+	db    *database.DB
+	run   *models.SyncJobRun
+	mutex sync.Mutex
+	saved time.Time
+}
+
+func NewProgress(db *database.DB, run *models.SyncJobRun, total int) *Progress {
+	progress := &Progress{db: db, run: run}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	run.Total = total
+	run.Completed = 0
+	progress.save()
+	return progress
+}
+
+func (p *Progress) Done() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.run.Completed++
+	if p.run.Completed == p.run.Total || time.Since(p.saved) >= progressInterval {
+		p.save()
+	}
+}
+
+func (p *Progress) save() {
+	p.saved = time.Now()
+	if err := p.db.UpdateSyncJobRunProgress(p.run); err != nil {
+		log.WithFields(log.Fields{"run_id": p.run.ID, "error": err.Error()}).Warn("error saving sync job run progress")
+	}
+}
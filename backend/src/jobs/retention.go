@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const retentionInterval = 24 * time.Hour
+
+/**
+* How long an archived user's data is kept before it is purged, in days
+* (ARCHIVE_RETENTION_DAYS). Zero, the default, keeps it forever.
+**/
+func ArchiveRetention() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("ARCHIVE_RETENTION_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 0
+}
+
+// Purge removes an archived user, and their login, for good. It is provided by the handlers package.
+type Purge func(user *models.User) error
+
+// PurgeArchivedUsers purges the users archived for longer than the retention period, returning how many were purged
+func PurgeArchivedUsers(db *database.DB, retention time.Duration, now time.Time, purge Purge) (int, error) {
+	users, err := db.GetUsersArchivedBefore(now.Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for idx := range users {
+		if err := purge(&users[idx]); err != nil {
+			log.WithFields(log.Fields{"user_id": users[idx].ID, "error": err.Error()}).Error("error purging archived user")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartRetention purges archived users once a day until the context is cancelled
+func StartRetention(ctx context.Context, db *database.DB, retention time.Duration, purge Purge) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	log.WithField("retention", retention).Info("archived user retention started")
+	for {
+		purged, err := PurgeArchivedUsers(db, retention, time.Now(), purge)
+		if err != nil {
+			log.Errorln("error fetching archived users to purge", err)
+		} else if purged > 0 {
+			log.WithField("purged", purged).Info("purged archived users")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
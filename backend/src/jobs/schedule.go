@@ -0,0 +1,195 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule interface {
+	// Next returns the first activation time strictly after t
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	every time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.every).Truncate(time.Second)
+}
+
+/**
+* cronSchedule is a standard 5 field cron expression, each field is stored
+* as a bitset of the values it matches.
+* minute hour day-of-month month day-of-week
+**/
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// when both dom and dow are restricted, a day matches if either does (as in cron(8))
+	domStar, dowStar bool
+}
+
+type bounds struct {
+	min, max uint
+}
+
+var (
+	minuteBounds = bounds{0, 59}
+	hourBounds   = bounds{0, 23}
+	domBounds    = bounds{1, 31}
+	monthBounds  = bounds{1, 12}
+	dowBounds    = bounds{0, 7}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in schedule %q: %w", spec, err)
+		}
+		if every < time.Minute {
+			return nil, errors.New("schedules must be at least one minute apart")
+		}
+		return intervalSchedule{every: every}, nil
+	}
+	if expr, ok := descriptors[spec]; ok {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, found %d", spec, len(fields))
+	}
+	var err error
+	sched := cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	if sched.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if sched.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if sched.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if sched.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if sched.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+	// both 0 and 7 are sunday
+	if sched.dow&(1<<7) > 0 {
+		sched.dow |= 1
+	}
+	return &sched, nil
+}
+
+// parses a comma separated list of values, ranges (a-b) and steps (*/n, a-b/n)
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeAndStep := strings.Split(part, "/")
+		if len(rangeAndStep) > 2 {
+			return 0, fmt.Errorf("invalid schedule field %q", field)
+		}
+		start, end := b.min, b.max
+		switch lowAndHigh := strings.Split(rangeAndStep[0], "-"); {
+		case rangeAndStep[0] == "*" || rangeAndStep[0] == "?":
+		case len(lowAndHigh) == 1:
+			val, err := parseValue(lowAndHigh[0], b)
+			if err != nil {
+				return 0, err
+			}
+			start = val
+			if len(rangeAndStep) == 1 {
+				end = val
+			}
+		case len(lowAndHigh) == 2:
+			low, err := parseValue(lowAndHigh[0], b)
+			if err != nil {
+				return 0, err
+			}
+			high, err := parseValue(lowAndHigh[1], b)
+			if err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in schedule", rangeAndStep[0])
+			}
+			start, end = low, high
+		default:
+			return 0, fmt.Errorf("invalid schedule field %q", field)
+		}
+		step := uint(1)
+		if len(rangeAndStep) == 2 {
+			parsed, err := strconv.Atoi(rangeAndStep[1])
+			if err != nil || parsed <= 0 {
+				return 0, fmt.Errorf("invalid step %q in schedule", rangeAndStep[1])
+			}
+			step = uint(parsed)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(val string, b bounds) (uint, error) {
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed < int(b.min) || parsed > int(b.max) {
+		return 0, fmt.Errorf("schedule value %q out of range [%d-%d]", val, b.min, b.max)
+	}
+	return uint(parsed), nil
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	// a valid expression always matches within a few years, so bail out rather than spin forever
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) > 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) > 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrJobRunning = errors.New("job is already running")
+
+// returned (wrapped) by a runner whose import finished, but failed to import some of the rows
+var ErrPartialImport = errors.New("import finished with errors")
+
+// Runner performs the actual import for a job. It is provided by the handlers
+// package, which owns the provider services and the user provisioning logic.
+type Runner func(ctx context.Context, job *models.SyncJob, run *models.SyncJobRun) error
+
+type Scheduler struct {
+	db       *database.DB
+	runner   Runner
+	interval time.Duration
+	mutex    sync.Mutex
+	running  map[uint]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewScheduler(db *database.DB, runner Runner) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		runner:   runner,
+		interval: time.Minute,
+		running:  make(map[uint]context.CancelFunc),
+	}
+}
+
+/**
+* Start polls for due jobs until the context is cancelled. Jobs are run
+* in their own goroutine, a job is never run twice concurrently.
+**/
+func (s *Scheduler) Start(ctx context.Context) {
+	if err := s.db.FailInterruptedSyncJobRuns(); err != nil {
+		log.Errorln("error cleaning up interrupted sync job runs", err)
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	log.Info("sync job scheduler started")
+	s.tick(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			s.Wait()
+			log.Info("sync job scheduler stopped")
+			return
+		case now := <-ticker.C:
+			s.tick(ctx, now)
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	jobs, err := s.db.GetDueSyncJobs(now)
+	if err != nil {
+		log.Errorln("error fetching due sync jobs", err)
+		return
+	}
+	for idx := range jobs {
+		job := &jobs[idx]
+		if job.NextRunAt == nil {
+			// newly created job, wait for its first scheduled time
+			if err := s.scheduleNext(job, now); err != nil {
+				log.WithField("job_id", job.ID).Errorln("error scheduling sync job", err)
+			}
+			continue
+		}
+		if _, err := s.Trigger(ctx, job, models.TriggerSchedule); err != nil && !errors.Is(err, ErrJobRunning) {
+			log.WithField("job_id", job.ID).Errorln("error starting scheduled sync job", err)
+		}
+	}
+}
+
+func (s *Scheduler) scheduleNext(job *models.SyncJob, from time.Time) error {
+	sched, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+	next := sched.Next(from)
+	job.NextRunAt = &next
+	return s.db.UpdateSyncJobRunTimes(job)
+}
+
+/**
+* Trigger starts the job in the background and returns the newly created run,
+* as it was when it started (the run itself is updated as the job progresses)
+**/
+func (s *Scheduler) Trigger(ctx context.Context, job *models.SyncJob, trigger models.RunTrigger) (*models.SyncJobRun, error) {
+	// runs outlive the request which triggered them
+	run, jobCtx, err := s.begin(context.WithoutCancel(ctx), job, trigger)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(jobCtx, job, run)
+	}()
+	return &started, nil
+}
+
+// Run executes the job synchronously, returning once the run has finished
+func (s *Scheduler) Run(ctx context.Context, job *models.SyncJob, trigger models.RunTrigger) (*models.SyncJobRun, error) {
+	run, jobCtx, err := s.begin(ctx, job, trigger)
+	if err != nil {
+		return nil, err
+	}
+	s.execute(jobCtx, job, run)
+	return run, nil
+}
+
+// Cancel stops the running instance of a job, if there is one
+func (s *Scheduler) Cancel(jobID uint) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cancel, ok := s.running[jobID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *Scheduler) IsRunning(jobID uint) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.running[jobID]
+	return ok
+}
+
+// Wait blocks until all background runs have finished
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) begin(ctx context.Context, job *models.SyncJob, trigger models.RunTrigger) (*models.SyncJobRun, context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.running[job.ID]; ok {
+		return nil, nil, ErrJobRunning
+	}
+	now := time.Now()
+	run := &models.SyncJobRun{
+		SyncJobID: job.ID,
+		Status:    models.RunRunning,
+		Trigger:   trigger,
+		StartedAt: &now,
+	}
+	if err := s.db.CreateSyncJobRun(run); err != nil {
+		return nil, nil, err
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	s.running[job.ID] = cancel
+	return run, jobCtx, nil
+}
+
+func (s *Scheduler) execute(ctx context.Context, job *models.SyncJob, run *models.SyncJobRun) {
+	fields := log.Fields{"job_id": job.ID, "run_id": run.ID, "type": job.Type, "provider_platform_id": job.ProviderPlatformID}
+	log.WithFields(fields).Info("starting sync job")
+	err := s.safeRun(ctx, job, run)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	if errors.Is(err, ErrPartialImport) {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Warnln("sync job finished with errors")
+		run.Status = models.RunPartial
+		run.Error = err.Error()
+	} else if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Errorln("sync job failed")
+		run.Status = models.RunFailed
+		run.Error = err.Error()
+	} else {
+		log.WithFields(fields).Info("sync job finished")
+		run.Status = models.RunSucceeded
+	}
+	if err := s.db.UpdateSyncJobRun(run); err != nil {
+		log.WithFields(fields).Errorln("error saving sync job run", err)
+	}
+	job.LastRunAt = run.StartedAt
+	if err := s.scheduleNext(job, finished); err != nil {
+		log.WithFields(fields).Errorln("error scheduling next sync job run", err)
+	}
+	s.mutex.Lock()
+	if cancel, ok := s.running[job.ID]; ok {
+		cancel()
+		delete(s.running, job.ID)
+	}
+	s.mutex.Unlock()
+}
+
+// a panicking import should fail its run, not take the whole server down with it
+func (s *Scheduler) safeRun(ctx context.Context, job *models.SyncJob, run *models.SyncJobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sync job panicked: %v", r)
+		}
+	}()
+	return s.runner(ctx, job, run)
+}
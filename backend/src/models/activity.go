@@ -18,14 +18,16 @@ type Activity struct {
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	ProgramID uint           `gorm:"not null" json:"program_id"`
-	UserID    uint           `gorm:"not null" json:"user_id"`
+	ProgramID uint           `gorm:"not null;uniqueIndex:idx_activities_natural_key,priority:2" json:"program_id"`
+	UserID    uint           `gorm:"not null;uniqueIndex:idx_activities_natural_key,priority:1" json:"user_id"`
 	Type      ActivityType   `gorm:"size:255;not null" json:"type"`
 	TotalTime uint           `json:"total_time"`
 	TimeDelta uint           `json:"time_delta"`
 
 	// is this a url perhaps?
-	ExternalID string `gorm:"size:255;not null" json:"external_content_id"`
+	ExternalID string `gorm:"size:255;not null;uniqueIndex:idx_activities_natural_key,priority:3" json:"external_content_id"`
+	// midnight (UTC) of the day the activity happened, there is one row per day for each piece of content
+	ActivityDate time.Time `gorm:"not null;uniqueIndex:idx_activities_natural_key,priority:4" json:"activity_date"`
 
 	User    *User    `gorm:"foreignKey:UserID" json:"-"`
 	Program *Program `gorm:"foreignKey:ProgramID" json:"-"`
@@ -35,6 +37,25 @@ func (Activity) TableName() string {
 	return "activities"
 }
 
+// the columns an activity is upserted on
+var ActivityKey = []string{"user_id", "program_id", "external_id", "activity_date"}
+
+// the day (as stored in activity_date) which the time falls on
+func ActivityDay(at time.Time) time.Time {
+	return at.UTC().Truncate(24 * time.Hour)
+}
+
+func (activity *Activity) BeforeCreate(tx *gorm.DB) error {
+	if activity.ActivityDate.IsZero() {
+		at := activity.CreatedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		activity.ActivityDate = ActivityDay(at)
+	}
+	return nil
+}
+
 type ImportActivity struct {
 	ExternalUserID    string `json:"external_user_id"`
 	ExternalProgramID string `json:"external_program_id"`
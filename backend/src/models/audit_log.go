@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditUserCreated         AuditAction = "user.created"
+	AuditUserUpdated         AuditAction = "user.updated"
+	AuditUserDeleted         AuditAction = "user.deleted"
+	AuditPasswordReset       AuditAction = "user.password_reset"
+	AuditProviderUpdated     AuditAction = "provider_platform.updated"
+	AuditProviderKeyChanged  AuditAction = "provider_platform.access_key_changed"
+	AuditOpenContentToggled  AuditAction = "open_content.toggled"
+	AuditFacilitySwitched    AuditAction = "facility_context.switched"
+	AuditProvisioningRetried AuditAction = "provisioning.retried"
+	AuditUserTransferred     AuditAction = "user.transferred"
+	AuditUserStatusChanged   AuditAction = "user.status_changed"
+	AuditRoleGranted         AuditAction = "role.granted"
+	AuditRoleRevoked         AuditAction = "role.revoked"
+	AuditDataExported        AuditAction = "data.exported"
+)
+
+/**
+* AuditLog records an administrative action: who took it (and from where), in
+* which facility, on what, and the target's state before and after. Entries are
+* only ever added, never changed or deleted (the hooks below refuse to, as does
+* a trigger in postgres), so that they can be relied on in compliance reviews.
+* The actor's username is kept with the entry, in case the user is deleted.
+**/
+type AuditLog struct {
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time      `gorm:"index" json:"created_at"`
+	ActorID       uint           `gorm:"index" json:"actor_id"`
+	ActorUsername string         `gorm:"size:255" json:"actor_username"`
+	FacilityID    uint           `gorm:"index" json:"facility_id"`
+	Action        AuditAction    `gorm:"size:64;not null;index" json:"action"`
+	TargetType    string         `gorm:"size:64" json:"target_type"`
+	TargetID      uint           `gorm:"index" json:"target_id"`
+	Before        datatypes.JSON `json:"before"`
+	After         datatypes.JSON `json:"after"`
+	IP            string         `gorm:"size:64" json:"ip"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+var ErrAuditLogImmutable = errors.New("audit log entries cannot be changed or deleted")
+
+func (AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+func (AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+* How a program's certificates are worded, and which of its outcomes earn one.
+* Programs without a template issue certificates for `certificate` outcomes,
+* worded as in DefaultCertificateTemplate.
+**/
+type CertificateTemplate struct {
+	DatabaseFields
+	ProgramID      uint   `gorm:"not null;uniqueIndex" json:"program_id"`
+	OutcomeTypes   string `gorm:"size:255;not null" json:"outcome_types"` // comma separated, e.g. "certificate,grade"
+	Title          string `gorm:"size:255;not null" json:"title"`
+	Body           string `gorm:"size:510" json:"body"` // printed between the holder's name and the program's
+	Signatory      string `gorm:"size:255" json:"signatory"`
+	SignatoryTitle string `gorm:"size:255" json:"signatory_title"`
+
+	Program *Program `gorm:"foreignKey:ProgramID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (CertificateTemplate) TableName() string {
+	return "certificate_templates"
+}
+
+func DefaultCertificateTemplate(programID uint) *CertificateTemplate {
+	return &CertificateTemplate{
+		ProgramID:    programID,
+		OutcomeTypes: string(Certificate),
+		Title:        "Certificate of Completion",
+		Body:         "has successfully completed",
+	}
+}
+
+// the outcome types, without spaces, so that they can be matched as ",type," in queries
+func (template *CertificateTemplate) NormalizeOutcomeTypes() {
+	types := []string{}
+	for _, outcomeType := range strings.Split(template.OutcomeTypes, ",") {
+		if outcomeType = strings.TrimSpace(outcomeType); outcomeType != "" {
+			types = append(types, outcomeType)
+		}
+	}
+	template.OutcomeTypes = strings.Join(types, ",")
+}
+
+func (template *CertificateTemplate) IssuesFor(outcomeType OutcomeType) bool {
+	for _, issued := range strings.Split(template.OutcomeTypes, ",") {
+		if strings.TrimSpace(issued) == string(outcomeType) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* A certificate issued for an outcome. The holder's name, the program and the date
+* are kept as they were printed, and signed, so that the certificate still verifies
+* (and can't be altered) if the user or program later change. When an archived
+* user is purged their certificates are kept, without the user or outcome.
+**/
+type IssuedCertificate struct {
+	DatabaseFields
+	Code        string     `gorm:"size:32;uniqueIndex;not null" json:"code"`
+	UserID      *uint      `gorm:"index" json:"user_id"`
+	ProgramID   uint       `gorm:"not null" json:"program_id"`
+	OutcomeID   *uint      `gorm:"uniqueIndex" json:"outcome_id"`
+	HolderName  string     `gorm:"size:255;not null" json:"holder_name"`
+	ProgramName string     `gorm:"size:255;not null" json:"program_name"`
+	IssuedAt    time.Time  `gorm:"not null" json:"issued_at"`
+	Signature   string     `gorm:"size:64;not null" json:"-"`
+	RevokedAt   *time.Time `json:"revoked_at"` // set when its user is purged after the outcome was removed
+
+	User    *User    `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-"`
+	Program *Program `gorm:"foreignKey:ProgramID;constraint:OnDelete:CASCADE" json:"-"`
+	Outcome *Outcome `gorm:"foreignKey:OutcomeID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+func (IssuedCertificate) TableName() string {
+	return "certificates"
+}
+
+// an HMAC of what the certificate states, keyed with APP_KEY
+func (cert *IssuedCertificate) ComputeSignature() string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("APP_KEY")))
+	mac.Write([]byte(strings.Join([]string{
+		cert.Code,
+		cert.HolderName,
+		cert.ProgramName,
+		strconv.FormatInt(cert.IssuedAt.Unix(), 10),
+	}, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var ErrNoSigningKey = errors.New("APP_KEY is not set, certificates can't be signed")
+
+// refuses without APP_KEY, as anyone could sign a certificate with an empty key
+func (cert *IssuedCertificate) Sign() error {
+	if os.Getenv("APP_KEY") == "" {
+		return ErrNoSigningKey
+	}
+	cert.Signature = cert.ComputeSignature()
+	return nil
+}
+
+// the outcome it was issued for has been removed (a purged user's certificates have no outcome, but stand)
+func (cert *IssuedCertificate) IsRevoked() bool {
+	return cert.RevokedAt != nil || (cert.OutcomeID != nil && cert.Outcome == nil)
+}
+
+func (cert *IssuedCertificate) SignatureValid() bool {
+	return os.Getenv("APP_KEY") != "" && hmac.Equal([]byte(cert.Signature), []byte(cert.ComputeSignature()))
+}
@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+type EnrollmentStatus string
+
+const (
+	EnrollmentPending    EnrollmentStatus = "pending"
+	EnrollmentActive     EnrollmentStatus = "active"
+	EnrollmentCompleted  EnrollmentStatus = "completed"
+	EnrollmentWithdrawn  EnrollmentStatus = "withdrawn"
+	EnrollmentWaitlisted EnrollmentStatus = "waitlisted"
+)
+
+func (status EnrollmentStatus) IsValid() bool {
+	_, ok := enrollmentTransitions[status]
+	return ok
+}
+
+/**
+* The states an enrollment may move to from each state. A withdrawn learner can
+* be re-enrolled, and a completed enrollment can be reopened (e.g. a regraded course)
+**/
+var enrollmentTransitions = map[EnrollmentStatus][]EnrollmentStatus{
+	EnrollmentPending:    {EnrollmentActive, EnrollmentWaitlisted, EnrollmentWithdrawn},
+	EnrollmentWaitlisted: {EnrollmentPending, EnrollmentActive, EnrollmentWithdrawn},
+	EnrollmentActive:     {EnrollmentCompleted, EnrollmentWithdrawn},
+	EnrollmentCompleted:  {EnrollmentActive},
+	EnrollmentWithdrawn:  {EnrollmentPending, EnrollmentActive, EnrollmentWaitlisted},
+}
+
+func (status EnrollmentStatus) CanTransitionTo(next EnrollmentStatus) bool {
+	if status == next {
+		return true
+	}
+	for _, allowed := range enrollmentTransitions[status] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* An Enrollment places a user on the roster of a program. ExternalID is the
+* provider's ID for the enrollment, for imported enrollments and those pushed to
+* the provider. SyncedAt is when the enrollment was last applied in the provider,
+* SyncError why the last attempt failed (empty once it succeeds). TransferFlag
+* is set when the learner is transferred to a facility where the enrollment can't
+* carry on, for staff to review.
+**/
+type Enrollment struct {
+	DatabaseFields
+	UserID       uint             `gorm:"not null;uniqueIndex:idx_enrollments_user_program,priority:1" json:"user_id"`
+	ProgramID    uint             `gorm:"not null;uniqueIndex:idx_enrollments_user_program,priority:2" json:"program_id"`
+	Status       EnrollmentStatus `gorm:"size:32;not null;default:pending" json:"status"`
+	StartDate    *time.Time       `json:"start_date"`
+	EndDate      *time.Time       `json:"end_date"`
+	ExternalID   string           `gorm:"size:255" json:"external_id"`
+	SyncedAt     *time.Time       `json:"synced_at"`
+	SyncError    string           `gorm:"size:510" json:"sync_error"`
+	TransferFlag string           `gorm:"size:510" json:"transfer_flag"`
+
+	User    *User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	Program *Program `gorm:"foreignKey:ProgramID;constraint:OnDelete:CASCADE" json:"program,omitempty"`
+}
+
+func (Enrollment) TableName() string {
+	return "enrollments"
+}
+
+type EnrollmentRequestStatus string
+
+const (
+	RequestPending   EnrollmentRequestStatus = "requested"
+	RequestApproved  EnrollmentRequestStatus = "approved"
+	RequestDenied    EnrollmentRequestStatus = "denied"
+	RequestCancelled EnrollmentRequestStatus = "cancelled"
+)
+
+/**
+* A learner's request to join a fixed enrollment program. Requests are never
+* edited once decided, or deleted, so together they are the record of who asked
+* to join, and who approved or denied them (and why). Asking again after a denial
+* makes a new request.
+**/
+type EnrollmentRequest struct {
+	DatabaseFields
+	UserID     uint                    `gorm:"not null;index" json:"user_id"`
+	ProgramID  uint                    `gorm:"not null;index" json:"program_id"`
+	Status     EnrollmentRequestStatus `gorm:"size:32;not null;default:requested" json:"status"`
+	Reason     string                  `gorm:"size:510" json:"reason"`
+	ReviewerID *uint                   `json:"reviewer_id"`
+	ReviewNote string                  `gorm:"size:510" json:"review_note"`
+	ReviewedAt *time.Time              `json:"reviewed_at"`
+
+	User    *User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+	Program *Program `gorm:"foreignKey:ProgramID;constraint:OnDelete:CASCADE" json:"program,omitempty"`
+}
+
+func (EnrollmentRequest) TableName() string {
+	return "enrollment_requests"
+}
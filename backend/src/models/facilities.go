@@ -4,7 +4,8 @@ type Facility struct {
 	DatabaseFields
 	Name string `gorm:"size:255;not null" json:"name"`
 
-	Users []User `gorm:"foreignKey:FacilityID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	Users    []User    `gorm:"foreignKey:FacilityID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	Programs []Program `gorm:"many2many:facility_programs;" json:"-"` // the programs published at the facility
 }
 
 func (Facility) TableName() string {
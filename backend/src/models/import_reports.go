@@ -0,0 +1,78 @@
+package models
+
+import "gorm.io/datatypes"
+
+// only the first errors are kept, the Failed count is always the full total
+const MaxImportErrors = 100
+
+type ImportError struct {
+	ExternalID string `json:"external_id"`
+	Reason     string `json:"reason"`
+}
+
+/**
+* ImportReport is the result of importing a type of record from a provider.
+* The middleware returns one for each request, and the backend merges them
+* into a single report for the sync job run that made the requests.
+**/
+type ImportReport struct {
+	DatabaseFields
+	ProviderPlatformID uint                             `json:"provider_platform_id"`
+	SyncJobRunID       *uint                            `gorm:"index" json:"sync_job_run_id"`
+	Type               JobType                          `gorm:"size:64" json:"type"`
+	Created            int                              `json:"created"`
+	Updated            int                              `json:"updated"`
+	Skipped            int                              `json:"skipped"`
+	Failed             int                              `json:"failed"`
+	Errors             datatypes.JSONSlice[ImportError] `json:"errors"`
+
+	ProviderPlatform *ProviderPlatform `gorm:"foreignKey:ProviderPlatformID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (ImportReport) TableName() string {
+	return "import_reports"
+}
+
+func NewImportReport(providerID uint, jobType JobType) *ImportReport {
+	return &ImportReport{ProviderPlatformID: providerID, Type: jobType, Errors: make([]ImportError, 0)}
+}
+
+func (report *ImportReport) Create() {
+	report.Created++
+}
+
+func (report *ImportReport) Update() {
+	report.Updated++
+}
+
+func (report *ImportReport) Skip() {
+	report.Skipped++
+}
+
+func (report *ImportReport) Fail(externalID string, err error) {
+	report.Failed++
+	if len(report.Errors) < MaxImportErrors {
+		report.Errors = append(report.Errors, ImportError{ExternalID: externalID, Reason: err.Error()})
+	}
+}
+
+func (report *ImportReport) Total() int {
+	return report.Created + report.Updated + report.Skipped + report.Failed
+}
+
+// adds the counts and errors of another report to this one
+func (report *ImportReport) Merge(other *ImportReport) {
+	if other == nil {
+		return
+	}
+	report.Created += other.Created
+	report.Updated += other.Updated
+	report.Skipped += other.Skipped
+	report.Failed += other.Failed
+	for _, importErr := range other.Errors {
+		if len(report.Errors) >= MaxImportErrors {
+			break
+		}
+		report.Errors = append(report.Errors, importErr)
+	}
+}
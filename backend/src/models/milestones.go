@@ -3,25 +3,31 @@ package models
 type MilestoneType string
 
 const (
-	Enrollment           MilestoneType = "enrollment"
+	EnrollmentMilestone  MilestoneType = "enrollment"
 	QuizSubmission       MilestoneType = "quiz_submission"
 	AssignmentSubmission MilestoneType = "assignment_submission"
 	GradeReceived        MilestoneType = "grade_received"
 	DiscussionPost       MilestoneType = "discussion_post"
+	ActivityCompletion   MilestoneType = "activity_completion"
+	ExerciseMastery      MilestoneType = "exercise_mastery"
+	ContentCompletion    MilestoneType = "content_completion"
 )
 
 type Milestone struct {
 	DatabaseFields               // ID, CreatedAt, UpdatedAt, DeletedAt
-	UserID         uint          `gorm:"not null" json:"user_id"`
-	ProgramID      uint          `gorm:"not null" json:"program_id"`
-	ExternalID     string        `gorm:"size:255;not null" json:"external_id"`
-	Type           MilestoneType `gorm:"size:255;not null" json:"type"`
+	UserID         uint          `gorm:"not null;uniqueIndex:idx_milestones_natural_key,priority:1" json:"user_id"`
+	ProgramID      uint          `gorm:"not null;uniqueIndex:idx_milestones_natural_key,priority:2" json:"program_id"`
+	ExternalID     string        `gorm:"size:255;not null;uniqueIndex:idx_milestones_natural_key,priority:3" json:"external_id"`
+	Type           MilestoneType `gorm:"size:255;not null;uniqueIndex:idx_milestones_natural_key,priority:4" json:"type"`
 	IsCompleted    bool          `gorm:"default:false" json:"is_completed"`
 
 	User    *User    `gorm:"foreignKey:UserID" json:"-"`
 	Program *Program `gorm:"foreignKey:ProgramID" json:"-"`
 }
 
+// the columns a milestone is upserted on, a provider's record is imported once per user and type
+var MilestoneKey = []string{"user_id", "program_id", "external_id", "type"}
+
 type ImportMilestone struct {
 	UserID            int    `json:"user_id"`
 	ExternalProgramID string `json:"external_program_id"`
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+/**
+* A Notification is a message for a user within the app, e.g. to tell a facility's
+* admins that a learner has been transferred in or out. Link is the page of the
+* app it is about, if any.
+**/
+type Notification struct {
+	DatabaseFields
+	UserID uint       `gorm:"not null;index" json:"user_id"`
+	Title  string     `gorm:"size:255;not null" json:"title"`
+	Body   string     `json:"body"`
+	Link   string     `gorm:"size:255" json:"link"`
+	ReadAt *time.Time `json:"read_at"`
+
+	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
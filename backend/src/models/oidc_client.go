@@ -68,6 +68,9 @@ func OidcClientFromProvider(prov *ProviderPlatform, autoRegister bool, client *h
 	case Kolibri:
 		body["token_endpoint_auth_method"] = "client_secret_basic"
 		body["subject_type"] = "public"
+	case Moodle:
+		body["token_endpoint_auth_method"] = "client_secret_post"
+		body["subject_type"] = "public"
 	}
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
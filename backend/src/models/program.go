@@ -15,6 +15,7 @@ type Program struct {
 	ProviderPlatform        *ProviderPlatform `gorm:"foreignKey:ProviderPlatformID;constraint:OnDelete SET NULL" json:"-"`
 	Milestones              []Milestone       `gorm:"foreignKey:ProgramID;constraint:OnDelete SET NULL" json:"-"`
 	Outcomes                []Outcome         `gorm:"foreignKey:ProgramID;constraint:OnDelete SET NULL" json:"-"`
+	Facilities              []Facility        `gorm:"many2many:facility_programs;" json:"-"`
 }
 
 type ProgramType string
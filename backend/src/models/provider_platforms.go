@@ -17,6 +17,7 @@ const (
 	CanvasOSS   ProviderPlatformType = "canvas_oss"
 	CanvasCloud ProviderPlatformType = "canvas_cloud"
 	Kolibri     ProviderPlatformType = "kolibri"
+	Moodle      ProviderPlatformType = "moodle"
 )
 
 type ProviderPlatformState string
@@ -96,6 +97,9 @@ func (provider *ProviderPlatform) GetDefaultRedirectURI() []string {
 		defaultUri := provider.BaseUrl + "/oidccallback/"
 		stripped := strings.Replace(defaultUri, "https", "http", 1)
 		return []string{defaultUri, stripped}
+	case Moodle:
+		// the callback of moodle's OpenID Connect plugin (auth_oidc)
+		return []string{provider.BaseUrl + "/auth/oidc/"}
 	}
 	return []string{}
 }
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type AuthProviderStatus string
 
 const (
@@ -16,6 +18,8 @@ type ProviderUserMapping struct {
 	ExternalUsername             string             `gorm:"size:255;not null" json:"external_username"`
 	AuthenticationProviderStatus AuthProviderStatus `gorm:"size:255;not null;default:none" json:"authentication_provider_status"`
 	ExternalLoginID              string             `gorm:"size:255" json:"external_login_id"`
+	SuspendedAt                  *time.Time         `json:"suspended_at"`   // e.g. on transfer, the user's activity in the provider is no longer synced
+	DeactivatedAt                *time.Time         `json:"deactivated_at"` // the account was deactivated in the provider, when the user was released
 
 	/*    Relations    */
 	User             *User             `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+type ProvisioningStatus string
+
+const (
+	ProvisioningRunning   ProvisioningStatus = "running"
+	ProvisioningSucceeded ProvisioningStatus = "succeeded"
+	ProvisioningFailed    ProvisioningStatus = "failed"
+)
+
+/**
+* The steps of provisioning a user, in the order they are run. Each one that
+* completes is undone, in reverse, if a later one fails.
+**/
+const (
+	StepCreateUser    = "create_user"
+	StepCreateLogin   = "create_login"   // the user's identity in kratos
+	StepCreateMapping = "create_mapping" // maps the user to their account in the provider
+	StepRegisterLogin = "register_login" // lets the user log in to the provider through us
+	StepCreateAccount = "create_account" // creates an account in the provider for a user imported from a roster
+)
+
+type StepStatus string
+
+const (
+	StepPending     StepStatus = "pending"
+	StepDone        StepStatus = "done"
+	StepSkipped     StepStatus = "skipped"
+	StepFailed      StepStatus = "failed"
+	StepCompensated StepStatus = "compensated"
+)
+
+type ProvisioningStep struct {
+	Name        string     `json:"name"`
+	Status      StepStatus `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// a provisioning that has been running for longer than this was interrupted
+const ProvisioningTimeout = 10 * time.Minute
+
+/**
+* Provisioning records a user being created across the database, kratos and a
+* provider, so that one which fails part way can be seen and retried. The user's
+* details are kept here, as the user itself is removed when a failure is undone.
+**/
+type Provisioning struct {
+	DatabaseFields
+	Status             ProvisioningStatus                    `gorm:"size:32;index" json:"status"`
+	Username           string                                `gorm:"size:255" json:"username"`
+	NameFirst          string                                `gorm:"size:255" json:"name_first"`
+	NameLast           string                                `gorm:"size:255" json:"name_last"`
+	Email              string                                `gorm:"size:255" json:"email"`
+	UserID             *uint                                 `json:"user_id"`
+	ProviderPlatformID *uint                                 `gorm:"index" json:"provider_platform_id"` // nil for a roster user's login
+	ExternalUserID     string                                `gorm:"size:255" json:"external_user_id"`
+	ExternalUsername   string                                `gorm:"size:255" json:"external_username"`
+	Steps              datatypes.JSONSlice[ProvisioningStep] `json:"steps"`
+	Attempts           int                                   `json:"attempts"`
+	Error              string                                `json:"error"`
+
+	ProviderPlatform *ProviderPlatform `gorm:"foreignKey:ProviderPlatformID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Provisioning) TableName() string {
+	return "provisionings"
+}
+
+// provisions a user imported from a provider, mapped to their account there
+func NewProvisioning(provider *ProviderPlatform, user ImportUser) *Provisioning {
+	steps := []ProvisioningStep{}
+	for _, name := range []string{StepCreateUser, StepCreateLogin, StepCreateMapping, StepRegisterLogin} {
+		steps = append(steps, ProvisioningStep{Name: name, Status: StepPending})
+	}
+	return &Provisioning{
+		Status:             ProvisioningRunning,
+		Username:           user.Username,
+		NameFirst:          user.NameFirst,
+		NameLast:           user.NameLast,
+		Email:              user.Email,
+		ProviderPlatformID: &provider.ID,
+		ExternalUserID:     user.ExternalUserID,
+		ExternalUsername:   user.Username,
+		Steps:              steps,
+	}
+}
+
+/**
+* provisions a user imported from a roster, who is already created: their login,
+* or with a provider, their account there. Failures leave the user in place.
+**/
+func NewRosterProvisioning(user *User, providerID *uint) *Provisioning {
+	step := StepCreateLogin
+	if providerID != nil {
+		step = StepCreateAccount
+	}
+	return &Provisioning{
+		Status:             ProvisioningRunning,
+		Username:           user.Username,
+		NameFirst:          user.NameFirst,
+		NameLast:           user.NameLast,
+		Email:              user.Email,
+		UserID:             &user.ID,
+		ProviderPlatformID: providerID,
+		Steps:              []ProvisioningStep{{Name: step, Status: StepPending}},
+	}
+}
+
+// failed, or interrupted while running
+func (p *Provisioning) Resumable() bool {
+	return p.Status == ProvisioningFailed || (p.Status == ProvisioningRunning && time.Since(p.UpdatedAt) > ProvisioningTimeout)
+}
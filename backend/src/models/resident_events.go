@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+type ResidentEventType string
+
+const (
+	ResidentAdmitted    ResidentEventType = "admission"
+	ResidentTransferred ResidentEventType = "transfer"
+	ResidentReleased    ResidentEventType = "release"
+)
+
+func (t ResidentEventType) IsValid() bool {
+	switch t {
+	case ResidentAdmitted, ResidentTransferred, ResidentReleased:
+		return true
+	}
+	return false
+}
+
+type ResidentEventStatus string
+
+const (
+	ResidentEventApplied ResidentEventStatus = "applied"
+	ResidentEventFailed  ResidentEventStatus = "failed"
+)
+
+/**
+* ResidentEvent is an admission, transfer or release received from the offender
+* management system, kept with whether it was applied. Events are identified by
+* the OMS's own ID, so one that is sent again is only applied once, and one that
+* failed is tried again.
+**/
+type ResidentEvent struct {
+	DatabaseFields
+	EventID    string              `gorm:"size:128;uniqueIndex" json:"event_id"`
+	Type       ResidentEventType   `gorm:"size:32" json:"type"`
+	ResidentID string              `gorm:"size:64;index" json:"resident_id"`
+	Source     string              `gorm:"size:255" json:"source"` // webhook, or the name of the dropped file
+	Payload    datatypes.JSON      `json:"payload"`
+	Status     ResidentEventStatus `gorm:"size:32;index" json:"status"`
+	Error      string              `json:"error"`
+	Attempts   int                 `json:"attempts"`
+	UserID     *uint               `json:"user_id"`
+	OccurredAt *time.Time          `json:"occurred_at"`
+}
+
+func (ResidentEvent) TableName() string {
+	return "resident_events"
+}
@@ -0,0 +1,77 @@
+package models
+
+import "slices"
+
+/* roles beyond admin + student, which are granted within a facility */
+const (
+	FacilityAdmin   UserRole = "facility_admin"
+	DepartmentAdmin UserRole = "department_admin"
+	Teacher         UserRole = "teacher"
+	CaseManager     UserRole = "case_manager"
+	Auditor         UserRole = "auditor"
+)
+
+func (role UserRole) IsValid() bool {
+	switch role {
+	case Admin, Student, FacilityAdmin, DepartmentAdmin, Teacher, CaseManager, Auditor:
+		return true
+	}
+	return false
+}
+
+type Permission string
+
+const (
+	ViewUsers         Permission = "users:view"
+	ManageUsers       Permission = "users:manage"
+	ManageRoles       Permission = "roles:manage"
+	ViewPrograms      Permission = "programs:view"
+	ManagePrograms    Permission = "programs:manage"
+	ManageEnrollments Permission = "enrollments:manage"
+	ManageProgress    Permission = "progress:manage"
+	ViewReports       Permission = "reports:view"
+	ViewAuditLog      Permission = "audit:view"
+)
+
+/**
+* The permissions granted by each role. Admins are granted every permission at
+* every facility, and so aren't listed here.
+**/
+var RolePermissions = map[UserRole][]Permission{
+	FacilityAdmin:   {ViewUsers, ManageUsers, ManageRoles, ViewPrograms, ManagePrograms, ManageEnrollments, ManageProgress, ViewReports, ViewAuditLog},
+	DepartmentAdmin: {ViewUsers, ManageUsers, ViewPrograms, ManagePrograms, ManageEnrollments, ManageProgress, ViewReports},
+	Teacher:         {ViewUsers, ViewPrograms, ManageEnrollments, ManageProgress, ViewReports},
+	CaseManager:     {ViewUsers, ViewPrograms, ManageEnrollments, ViewReports},
+	Auditor:         {ViewUsers, ViewPrograms, ViewReports, ViewAuditLog},
+	Student:         {},
+}
+
+func (role UserRole) Can(permission Permission) bool {
+	return role == Admin || slices.Contains(RolePermissions[role], permission)
+}
+
+/**
+* RoleAssignment grants a user a role at a facility (or at every facility, when
+* FacilityID is nil), on top of the role on the user itself, which applies only
+* at the user's own facility. Each assignment corresponds to a relation tuple in
+* the Role namespace of config/keto.yml (Role:<facility>#<role>@<user>), they are
+* checked in-process rather than against a keto server
+**/
+type RoleAssignment struct {
+	DatabaseFields
+	UserID     uint     `gorm:"not null;uniqueIndex:idx_role_assignments_user_role" json:"user_id"`
+	Role       UserRole `gorm:"size:64;not null;uniqueIndex:idx_role_assignments_user_role" json:"role"`
+	FacilityID *uint    `gorm:"uniqueIndex:idx_role_assignments_user_role" json:"facility_id"`
+
+	User     *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Facility *Facility `gorm:"foreignKey:FacilityID;constraint:OnDelete:CASCADE" json:"facility,omitempty"`
+}
+
+func (RoleAssignment) TableName() string {
+	return "role_assignments"
+}
+
+// whether the assignment applies at the facility
+func (ra *RoleAssignment) AppliesAt(facilityID uint) bool {
+	return ra.FacilityID == nil || *ra.FacilityID == facilityID
+}
@@ -0,0 +1,120 @@
+package models
+
+import "time"
+
+type JobType string
+
+const (
+	ImportUsersJob       JobType = "import_users"
+	ImportProgramsJob    JobType = "import_programs"
+	ImportMilestonesJob  JobType = "import_milestones"
+	ImportActivityJob    JobType = "import_activity"
+	ImportOutcomesJob    JobType = "import_outcomes"
+	ImportEnrollmentsJob JobType = "import_enrollments"
+)
+
+func (jt JobType) IsValid() bool {
+	switch jt {
+	case ImportUsersJob, ImportProgramsJob, ImportMilestonesJob, ImportActivityJob, ImportOutcomesJob, ImportEnrollmentsJob:
+		return true
+	}
+	return false
+}
+
+type JobStatus string
+
+const (
+	JobActive JobStatus = "active"
+	JobPaused JobStatus = "paused"
+)
+
+/**
+* A SyncJob is a recurring import from a single provider platform.
+* Schedule is a standard 5 field cron expression ("0 2 * * *"), or one of the
+* descriptors @hourly, @daily, @weekly, @monthly or @every <duration>.
+* A provider has at most one job of each type.
+**/
+type SyncJob struct {
+	DatabaseFields
+	ProviderPlatformID uint       `gorm:"not null;uniqueIndex:idx_sync_jobs_provider_type,priority:1,where:deleted_at IS NULL" json:"provider_platform_id"`
+	Type               JobType    `gorm:"size:64;not null;uniqueIndex:idx_sync_jobs_provider_type,priority:2,where:deleted_at IS NULL" json:"type"`
+	Schedule           string     `gorm:"size:64;not null" json:"schedule"`
+	Status             JobStatus  `gorm:"size:32;default:active" json:"status"`
+	LastRunAt          *time.Time `json:"last_run_at"`
+	NextRunAt          *time.Time `json:"next_run_at"`
+	IsRunning          bool       `gorm:"-" json:"is_running"`
+	Since              *time.Time `gorm:"-" json:"since"`
+
+	ProviderPlatform *ProviderPlatform `gorm:"foreignKey:ProviderPlatformID;constraint:OnDelete:CASCADE" json:"-"`
+	Runs             []SyncJobRun      `gorm:"foreignKey:SyncJobID;references:ID" json:"runs,omitempty"`
+}
+
+func (SyncJob) TableName() string {
+	return "sync_jobs"
+}
+
+type RunStatus string
+
+const (
+	RunPending   RunStatus = "pending"
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	// the import finished, but some rows failed (see the run's report)
+	RunPartial RunStatus = "partial"
+	RunFailed  RunStatus = "failed"
+)
+
+type RunTrigger string
+
+const (
+	TriggerSchedule RunTrigger = "schedule"
+	TriggerManual   RunTrigger = "manual"
+)
+
+type SyncJobRun struct {
+	DatabaseFields
+	SyncJobID  uint       `gorm:"not null" json:"sync_job_id"`
+	Status     RunStatus  `gorm:"size:32;not null" json:"status"`
+	Trigger    RunTrigger `gorm:"size:32;not null" json:"trigger"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Error      string     `gorm:"type:text" json:"error"`
+	// units of work (e.g. program/user pairs) done so far, updated while the run is in progress
+	Total     int `gorm:"default:0" json:"total"`
+	Completed int `gorm:"default:0" json:"completed"`
+
+	SyncJob *SyncJob      `gorm:"foreignKey:SyncJobID;constraint:OnDelete:CASCADE" json:"-"`
+	Report  *ImportReport `gorm:"foreignKey:SyncJobRunID;constraint:OnDelete:SET NULL" json:"report,omitempty"`
+}
+
+func (SyncJobRun) TableName() string {
+	return "sync_job_runs"
+}
+
+/**
+* SyncCursor is the watermark of the last successful import of a type from a
+* provider. The next import only requests records changed after it.
+**/
+type SyncCursor struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	ProviderPlatformID uint      `gorm:"not null;uniqueIndex:idx_sync_cursor_provider_type" json:"provider_platform_id"`
+	Type               JobType   `gorm:"size:64;not null;uniqueIndex:idx_sync_cursor_provider_type" json:"type"`
+	Since              time.Time `gorm:"not null" json:"since"`
+	UpdatedAt          time.Time `json:"updated_at"`
+
+	ProviderPlatform *ProviderPlatform `gorm:"foreignKey:ProviderPlatformID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (SyncCursor) TableName() string {
+	return "sync_cursors"
+}
+
+// the schedules assigned to a newly registered provider platform
+var DefaultJobSchedules = map[JobType]string{
+	ImportUsersJob:       "0 0 * * *",
+	ImportProgramsJob:    "0 1 * * *",
+	ImportActivityJob:    "0 2 * * *",
+	ImportMilestonesJob:  "0 3 * * *",
+	ImportOutcomesJob:    "0 4 * * *",
+	ImportEnrollmentsJob: "30 1 * * *",
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+/**
+* A record of each transcript printed, so that anyone handed one can look its
+* document ID up and confirm that it was issued, to whom, and (by comparing the
+* checksum with the file's) that it hasn't been altered since.
+**/
+type Transcript struct {
+	DatabaseFields
+	DocumentID string `gorm:"size:32;uniqueIndex;not null" json:"document_id"`
+	UserID     *uint  `gorm:"index" json:"user_id"` // nil once the user is purged, the transcript can still be verified
+	FacilityID uint   `json:"facility_id"`
+	IssuedByID uint   `json:"issued_by_id"`
+	NameFirst  string `gorm:"size:255" json:"name_first"` // as printed, so it can be verified after the user's details change
+	NameLast   string `gorm:"size:255" json:"name_last"`
+	Checksum   string `gorm:"size:64;not null" json:"checksum"` // sha256 of the pdf
+
+	User     *User     `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-"`
+	Facility *Facility `gorm:"foreignKey:FacilityID" json:"-"`
+}
+
+func (Transcript) TableName() string {
+	return "transcripts"
+}
+
+// 15 random base32 characters in groups of 5: there are no 0s or 1s to mistake for Os and Is when typed from paper
+func NewDocumentID(prefix string) string {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	encoded := base32.StdEncoding.EncodeToString(random)[:15]
+	return prefix + "-" + encoded[:5] + "-" + encoded[5:10] + "-" + encoded[10:]
+}
+
+// the document ID as typed in: case and surrounding spaces don't matter
+func NormalizeDocumentID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
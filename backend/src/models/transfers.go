@@ -0,0 +1,73 @@
+package models
+
+import "gorm.io/datatypes"
+
+type MappingAction string
+
+/**
+* What becomes of a transferred user's account in a provider: kept as it is,
+* suspended (it stays mapped, so it isn't imported again, but the user's activity
+* in it is no longer synced), or re-mapped to another account in the provider,
+* e.g. one in the destination facility's part of it.
+**/
+const (
+	KeepMapping    MappingAction = "keep"
+	SuspendMapping MappingAction = "suspend"
+	RemapMapping   MappingAction = "remap"
+)
+
+func (action MappingAction) IsValid() bool {
+	switch action {
+	case KeepMapping, SuspendMapping, RemapMapping:
+		return true
+	}
+	return false
+}
+
+type TransferMapping struct {
+	ProviderPlatformID uint          `json:"provider_platform_id"`
+	Action             MappingAction `json:"action"`
+	ExternalUserID     string        `json:"external_user_id"`               // before the transfer
+	NewExternalUserID  string        `json:"new_external_user_id,omitempty"` // when re-mapped
+	NewExternalName    string        `json:"new_external_username,omitempty"`
+}
+
+// an open enrollment the destination doesn't offer, which staff should review
+type FlaggedEnrollment struct {
+	EnrollmentID uint   `json:"enrollment_id"`
+	ProgramID    uint   `json:"program_id"`
+	ProgramName  string `json:"program_name"`
+	Reason       string `json:"reason"`
+}
+
+type TransferSource string
+
+const (
+	TransferByStaff TransferSource = "staff"
+	TransferByOms   TransferSource = "oms" // from the offender management system's feed
+)
+
+/**
+* FacilityTransfer records a user being moved from one facility to another, what
+* was decided for each of their provider accounts, and which of their enrollments
+* were carried over and which were flagged. TransferredByID is nil when the move
+* came from the offender management system.
+**/
+type FacilityTransfer struct {
+	DatabaseFields
+	UserID          uint                                   `gorm:"not null;index" json:"user_id"`
+	FromFacilityID  uint                                   `json:"from_facility_id"`
+	ToFacilityID    uint                                   `json:"to_facility_id"`
+	Source          TransferSource                         `gorm:"size:32" json:"source"`
+	TransferredByID *uint                                  `json:"transferred_by_id"`
+	Reason          string                                 `gorm:"size:510" json:"reason"`
+	Mappings        datatypes.JSONSlice[TransferMapping]   `json:"mappings"`
+	CarriedOver     int                                    `json:"carried_over"`
+	Flagged         datatypes.JSONSlice[FlaggedEnrollment] `json:"flagged"`
+
+	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (FacilityTransfer) TableName() string {
+	return "facility_transfers"
+}
@@ -22,6 +22,34 @@ type DatabaseFields struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+type UserStatus string
+
+/**
+* Only active users can log in. A suspended user is kept out for a while, and their
+* accounts in the providers are left as they are. A released user's provider
+* accounts are deactivated as well, and an archived user is one who has left for
+* good, whose data is purged once the retention period has passed.
+**/
+const (
+	UserActive    UserStatus = "active"
+	UserSuspended UserStatus = "suspended"
+	UserReleased  UserStatus = "released"
+	UserArchived  UserStatus = "archived"
+)
+
+func (status UserStatus) IsValid() bool {
+	switch status {
+	case UserActive, UserSuspended, UserReleased, UserArchived:
+		return true
+	}
+	return false
+}
+
+// whether the user's accounts in the providers are deactivated in this status
+func (status UserStatus) DeactivatesProviders() bool {
+	return status == UserReleased || status == UserArchived
+}
+
 type User struct {
 	DatabaseFields
 	Username      string   `gorm:"size:255;not null;unique" json:"username"`
@@ -34,6 +62,14 @@ type User struct {
 	KratosID      string   `gorm:"size:255" json:"kratos_id"`
 	FacilityID    uint     `json:"facility_id"`
 
+	/* from the offender management system, for residents it has admitted */
+	ResidentID  *string    `gorm:"size:64;uniqueIndex" json:"resident_id"`
+	HousingUnit string     `gorm:"size:64" json:"housing_unit"`
+	ReleaseDate *time.Time `json:"release_date"`
+
+	Status          UserStatus `gorm:"size:32;not null;default:active;index" json:"status"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
+
 	/* foreign keys */
 	Mappings    []ProviderUserMapping `json:"-"`
 	ActivityLog []UserActivity        `json:"-"`
@@ -57,6 +93,9 @@ func (usr *User) BeforeCreate(tx *gorm.DB) error {
 	if usr.Email == "" {
 		usr.Email = usr.Username + "@unlocked.v2"
 	}
+	if usr.Status == "" {
+		usr.Status = UserActive
+	}
 	return nil
 }
 
@@ -79,6 +118,11 @@ func (user *User) HashPassword() error {
 	return nil
 }
 
+// a user who hasn't been saved yet has no status, and is active
+func (user *User) IsActive() bool {
+	return user.Status == "" || user.Status == UserActive
+}
+
 func (user *User) GetExternalIDFromProvider(db *gorm.DB, providerId uint) (string, error) {
 	var mapping ProviderUserMapping
 	err := db.Model(ProviderUserMapping{}).Where("provider_platform_id = ?", providerId).Where("user_id = ?", user.ID).Find(&mapping).Error
@@ -0,0 +1,106 @@
+package oms
+
+import (
+	"UnlockEdv2/src/models"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// the header the offender management system signs webhook requests with, as sha256=<hex hmac of the body>
+const SignatureHeader = "X-OMS-Signature"
+
+/**
+* Event is a resident's admission, transfer or release, as sent by the offender
+* management system. Facility is the facility's name (or ID) here, and dates are
+* YYYY-MM-DD. Names are required to admit someone, a facility to admit or
+* transfer them.
+**/
+type Event struct {
+	EventID     string                   `json:"event_id"`
+	Type        models.ResidentEventType `json:"type"`
+	ResidentID  string                   `json:"resident_id"`
+	NameFirst   string                   `json:"name_first"`
+	NameLast    string                   `json:"name_last"`
+	Facility    string                   `json:"facility"`
+	HousingUnit string                   `json:"housing_unit"`
+	ReleaseDate string                   `json:"release_date"`
+	OccurredAt  *time.Time               `json:"occurred_at"`
+}
+
+func (event *Event) Validate() error {
+	switch {
+	case event.EventID == "":
+		return errors.New("event_id is required")
+	case !event.Type.IsValid():
+		return fmt.Errorf("unknown event type %q", event.Type)
+	case event.ResidentID == "":
+		return errors.New("resident_id is required")
+	case event.Type == models.ResidentAdmitted && (event.NameFirst == "" || event.NameLast == ""):
+		return errors.New("name_first and name_last are required to admit a resident")
+	case event.Type != models.ResidentReleased && event.Facility == "":
+		return errors.New("facility is required")
+	}
+	if _, err := event.ParseReleaseDate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (event *Event) ParseReleaseDate() (*time.Time, error) {
+	if event.ReleaseDate == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", event.ReleaseDate)
+	if err != nil {
+		return nil, fmt.Errorf("release_date %s is not a YYYY-MM-DD date", event.ReleaseDate)
+	}
+	return &date, nil
+}
+
+/**
+* Parse reads a feed of events, which is either a JSON array of them, or one
+* JSON object per line (which a single event also is). Blank lines are ignored.
+**/
+func Parse(feed io.Reader) ([]Event, error) {
+	data, err := io.ReadAll(feed)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\ufeff")))
+	events := []Event{}
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, fmt.Errorf("unable to read the feed: %w", err)
+		}
+		return events, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var event Event
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read event %d of the feed: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+}
+
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifySignature(body []byte, signature, secret string) bool {
+	return secret != "" && hmac.Equal([]byte(signature), []byte(Sign(body, secret)))
+}
@@ -0,0 +1,120 @@
+package oms
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultInterval = time.Minute
+
+// how often the drop directory is checked for new feeds (OMS_DROP_INTERVAL, e.g. 30s)
+func PollInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("OMS_DROP_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultInterval
+}
+
+/**
+* Apply applies a feed's events, source being where it came from. It returns an
+* error only if the feed couldn't be applied at all, the failure of an event is
+* for it to record.
+**/
+type Apply func(source string, events []Event) error
+
+/**
+* Watcher applies feeds dropped in a directory (OMS_DROP_DIR) as .json or .jsonl
+* files, oldest name first, and then moves them to processed/ within it. A file
+* that can't be read is moved to failed/ instead. The offender management
+* system should write a feed under another name (e.g. with .tmp) and rename it
+* once it is complete, so it isn't picked up half written.
+**/
+type Watcher struct {
+	dir      string
+	interval time.Duration
+	apply    Apply
+}
+
+func NewWatcher(dir string, interval time.Duration, apply Apply) *Watcher {
+	return &Watcher{dir: dir, interval: interval, apply: apply}
+}
+
+func (w *Watcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	log.WithField("dir", w.dir).Info("watching for offender management system feeds")
+	for {
+		if err := w.Scan(); err != nil {
+			log.WithField("dir", w.dir).Errorln("error checking for offender management system feeds", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applies the feeds in the directory now
+func (w *Watcher) Scan() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.Type().IsRegular() && (ext == ".json" || ext == ".jsonl") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields := log.Fields{"dir": w.dir, "file": name}
+		events, err := w.read(name)
+		if err != nil {
+			log.WithFields(fields).Errorln("unable to read offender management system feed", err)
+			w.move(name, "failed")
+			continue
+		}
+		if err := w.apply(name, events); err != nil {
+			// left in place, to be tried again
+			log.WithFields(fields).Errorln("unable to apply offender management system feed", err)
+			continue
+		}
+		log.WithFields(fields).Infof("applied %d events from offender management system feed", len(events))
+		w.move(name, "processed")
+	}
+	return nil
+}
+
+func (w *Watcher) read(name string) ([]Event, error) {
+	file, err := os.Open(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func (w *Watcher) move(name, to string) {
+	dir := filepath.Join(w.dir, to)
+	dest := filepath.Join(dir, name)
+	if _, err := os.Stat(dest); err == nil {
+		dest = filepath.Join(dir, fmt.Sprintf("%s-%s", time.Now().Format("20060102T150405"), name))
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		err = os.Rename(filepath.Join(w.dir, name), dest)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"dir": w.dir, "file": name, "error": err.Error()}).Errorf("unable to move feed to %s", to)
+	}
+}
@@ -0,0 +1,180 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/**
+* A minimal PDF writer for the documents the server prints (transcripts and
+* certificates): pages of text and lines, in the standard Helvetica fonts, which
+* every PDF reader has, so nothing needs embedding. Coordinates are in points
+* (1/72 inch) from the bottom left of the page.
+**/
+type Document struct {
+	title string
+	pages []*Page
+}
+
+type Size struct {
+	Width, Height float64
+}
+
+var (
+	Letter          = Size{612, 792}
+	LetterLandscape = Size{792, 612}
+)
+
+type Font string
+
+const (
+	Helvetica     Font = "F1"
+	HelveticaBold Font = "F2"
+)
+
+var fontNames = map[Font]string{Helvetica: "Helvetica", HelveticaBold: "Helvetica-Bold"}
+
+type Page struct {
+	Size
+	content bytes.Buffer
+}
+
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+func (doc *Document) AddPage(size Size) *Page {
+	page := &Page{Size: size}
+	doc.pages = append(doc.pages, page)
+	return page
+}
+
+func (page *Page) Text(x, y float64, font Font, size float64, text string) {
+	fmt.Fprintf(&page.content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, encode(text))
+}
+
+// text centered on x
+func (page *Page) CenteredText(x, y float64, font Font, size float64, text string) {
+	page.Text(x-TextWidth(font, size, text)/2, y, font, size, text)
+}
+
+// text ending at x
+func (page *Page) RightText(x, y float64, font Font, size float64, text string) {
+	page.Text(x-TextWidth(font, size, text), y, font, size, text)
+}
+
+func (page *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&page.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+func (page *Page) Rect(x, y, width, height, lineWidth float64) {
+	fmt.Fprintf(&page.content, "%.2f w %.2f %.2f %.2f %.2f re S\n", lineWidth, x, y, width, height)
+}
+
+// the standard fonts are WinAnsi encoded, which covers latin-1, anything else is printed as ?
+func encode(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case r < 32 || r > 255 || (r >= 127 && r < 160):
+			builder.WriteByte('?')
+		case r >= 160:
+			fmt.Fprintf(&builder, "\\%03o", r)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// the widths of Helvetica's printable ascii characters, in thousandths of the font size
+var helveticaWidths = [95]float64{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+/**
+* The width of the text in points. Other characters are counted as the width of
+* an "n", and bold as slightly wider than regular, which is close enough to lay
+* text out.
+**/
+func TextWidth(font Font, size float64, text string) float64 {
+	width := 0.0
+	for _, r := range text {
+		if r >= 32 && r < 127 {
+			width += helveticaWidths[r-32]
+		} else {
+			width += 556
+		}
+	}
+	if font == HelveticaBold {
+		width *= 1.06
+	}
+	return width * size / 1000
+}
+
+// splits the text into lines no wider than width, breaking between words
+func Wrap(font Font, size, width float64, text string) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && TextWidth(font, size, line+" "+word) > width {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+/**
+* Writes out the document: the catalog, page tree and fonts, then each page and
+* its content, followed by the cross reference table of where each object starts
+**/
+func (doc *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// objects 1 to 5 are fixed, each page is then a page object followed by its content stream
+	kids := make([]string, len(doc.pages))
+	for idx := range doc.pages {
+		kids[idx] = fmt.Sprintf("%d 0 R", 6+idx*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	for _, font := range []Font{Helvetica, HelveticaBold} {
+		object("<< /Type /Font /Subtype /Type1 /BaseFont /" + fontNames[font] + " /Encoding /WinAnsiEncoding >>")
+	}
+	object(fmt.Sprintf("<< /Title (%s) /Producer (UnlockEd) /CreationDate (D:%s) >>", encode(doc.title), time.Now().UTC().Format("20060102150405Z")))
+	for idx, page := range doc.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", page.Width, page.Height, 7+idx*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
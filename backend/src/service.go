@@ -2,9 +2,12 @@ package src
 
 import (
 	"UnlockEdv2/src/models"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -112,54 +115,223 @@ func (serv *ProviderService) GetUsers() ([]models.ImportUser, error) {
 	return users, nil
 }
 
-func (serv *ProviderService) GetPrograms() error {
+/**
+* Limits the import to records which changed after since, the zero time
+* requests a full import
+**/
+func withSince(req *http.Request, since time.Time) *http.Request {
+	if since.IsZero() {
+		return req
+	}
+	query := req.URL.Query()
+	query.Set("since", since.UTC().Format(time.RFC3339))
+	req.URL.RawQuery = query.Encode()
+	return req
+}
+
+/**
+* The import endpoints of the middleware respond with a report of the rows
+* they created, updated, skipped or failed to import
+**/
+func decodeImportReport(resp *http.Response) (*models.ImportReport, error) {
+	report := &models.ImportReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, fmt.Errorf("error decoding import report from middleware: %w", err)
+	}
+	return report, nil
+}
+
+// includes the reason given by the middleware in the error for a failed request
+func responseError(resp *http.Response, msg string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if reason := strings.TrimSpace(string(body)); reason != "" {
+		return fmt.Errorf("%s: %s", msg, reason)
+	}
+	return errors.New(msg)
+}
+
+func (serv *ProviderService) GetPrograms(since time.Time) (*models.ImportReport, error) {
 	fields := log.Fields{"handler": "GetPrograms", "provider_platform_id": serv.ProviderPlatformID}
 	log.WithFields(fields).Info("Getting programs from middleware")
-	req := serv.Request("/api/programs")
+	req := withSince(serv.Request("/api/programs"), since)
 	resp, err := serv.Client.Do(req)
 	if err != nil {
 		log.WithFields(fields).Errorln("error getting content from middleware")
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		log.WithFields(log.Fields{"handler": "GetPrograms", "status": resp.StatusCode}).Error("Failed to get programs")
-		return errors.New("failed to get programs")
+		return nil, responseError(resp, "failed to get programs")
 	}
-	return nil
+	return decodeImportReport(resp)
 }
 
-func (serv *ProviderService) GetMilestonesForProgramUser(programID, userID uint) error {
+func (serv *ProviderService) GetMilestonesForProgramUser(ctx context.Context, programID, userID uint, since time.Time) (*models.ImportReport, error) {
 	fields := log.Fields{"handler": "GetMilestonesForProgramUser", "UserID": userID, "ProgramID": programID}
-	req := serv.Request(fmt.Sprintf("/api/users/%d/programs/%d/milestones", userID, programID))
+	req := withSince(serv.Request(fmt.Sprintf("/api/users/%d/programs/%d/milestones", userID, programID)), since).WithContext(ctx)
 	resp, err := serv.Client.Do(req)
 	if err != nil {
 		fields["error"] = err
 		log.WithFields(fields).Errorln("Error getting milestones for program user")
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		fields["status_code"] = resp.StatusCode
 		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
-		return errors.New("failed to get milestones for program user")
+		return nil, responseError(resp, "failed to get milestones for program user")
 	}
-	return nil
+	return decodeImportReport(resp)
 }
 
-func (serv *ProviderService) GetActivityForProgram(programID string) error {
+func (serv *ProviderService) GetActivityForProgram(programID string, since time.Time) (*models.ImportReport, error) {
 	fields := log.Fields{"handler": "GetActivityForProgram", "ProgramID": programID}
-	req := serv.Request("/api/programs/" + programID + "/activity")
+	req := withSince(serv.Request("/api/programs/"+programID+"/activity"), since)
 	resp, err := serv.Client.Do(req)
 	if err != nil {
 		fields["error"] = err
 		log.WithFields(fields).Errorln("Error getting activity for program in service.go")
-		return err
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
+		return nil, responseError(resp, "failed to get activity for program")
+	}
+	return decodeImportReport(resp)
+}
+
+func (serv *ProviderService) GetOutcomesForProgram(programID string, since time.Time) (*models.ImportReport, error) {
+	fields := log.Fields{"handler": "GetOutcomesForProgram", "ProgramID": programID}
+	req := withSince(serv.Request("/api/programs/"+programID+"/outcomes"), since)
+	resp, err := serv.Client.Do(req)
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Errorln("Error getting outcomes for program in service.go")
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		fields["status_code"] = resp.StatusCode
 		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
-		return errors.New("failed to get activity for program")
+		return nil, responseError(resp, "failed to get outcomes for program")
+	}
+	return decodeImportReport(resp)
+}
+
+func (serv *ProviderService) GetEnrollmentsForProgram(programID string, since time.Time) (*models.ImportReport, error) {
+	fields := log.Fields{"handler": "GetEnrollmentsForProgram", "ProgramID": programID}
+	req := withSince(serv.Request("/api/programs/"+programID+"/enrollments"), since)
+	resp, err := serv.Client.Do(req)
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Errorln("Error getting enrollments for program in service.go")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
+		return nil, responseError(resp, "failed to get enrollments for program")
+	}
+	return decodeImportReport(resp)
+}
+
+var ErrPushUnsupported = errors.New("the provider's rosters can't be changed from UnlockEd")
+
+/**
+* Applies the user's enrollment to the course in the provider, returning the
+* provider's id for the enrollment (empty if the provider has none)
+**/
+func (serv *ProviderService) PushEnrollment(programID string, enrollment *models.Enrollment) (string, error) {
+	fields := log.Fields{"handler": "PushEnrollment", "ProgramID": programID, "UserID": enrollment.UserID}
+	body, err := json.Marshal(enrollment)
+	if err != nil {
+		return "", err
+	}
+	req := serv.Request(fmt.Sprintf("/api/programs/%s/enrollments/%d", programID, enrollment.UserID))
+	req.Method, req.Body = http.MethodPut, io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serv.Client.Do(req)
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Errorln("Error pushing enrollment to the middleware")
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return "", ErrPushUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
+		return "", responseError(resp, "failed to push enrollment")
+	}
+	var pushed struct {
+		ExternalID string `json:"external_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pushed); err != nil {
+		return "", fmt.Errorf("error decoding pushed enrollment from middleware: %w", err)
+	}
+	return pushed.ExternalID, nil
+}
+
+// takes the user off the course's roster in the provider
+func (serv *ProviderService) RemoveEnrollment(programID string, enrollment *models.Enrollment) error {
+	fields := log.Fields{"handler": "RemoveEnrollment", "ProgramID": programID, "UserID": enrollment.UserID}
+	req := serv.Request(fmt.Sprintf("/api/programs/%s/enrollments/%d", programID, enrollment.UserID))
+	req.Method = http.MethodDelete
+	if enrollment.ExternalID != "" {
+		query := req.URL.Query()
+		query.Set("external_id", enrollment.ExternalID)
+		req.URL.RawQuery = query.Encode()
+	}
+	resp, err := serv.Client.Do(req)
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Errorln("Error removing enrollment in the middleware")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return ErrPushUnsupported
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
+		return responseError(resp, "failed to remove enrollment")
+	}
+	return nil
+}
+
+// deactivates (or reactivates) the user's account in the provider
+func (serv *ProviderService) SetUserActive(userID uint, active bool) error {
+	fields := log.Fields{"handler": "SetUserActive", "UserID": userID, "active": active}
+	body, err := json.Marshal(map[string]bool{"active": active})
+	if err != nil {
+		return err
+	}
+	req := serv.Request(fmt.Sprintf("/api/users/%d/active", userID))
+	req.Method, req.Body = http.MethodPut, io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serv.Client.Do(req)
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Errorln("Error changing the user's account in the middleware")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return ErrPushUnsupported
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		log.WithFields(fields).Errorln("Request failed with status code", resp.StatusCode)
+		return responseError(resp, "failed to change the user's account")
 	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"UnlockEdv2/src/models"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	facility, other := seedFacility(t, "Audited Facility"), seedFacility(t, "Unaudited Facility")
+	student := seedUser(t, facility, "audited_student", models.Student)
+	staff := seedUser(t, facility, "audit_staff", models.FacilityAdmin)
+	auditor := seedUser(t, other, "audit_auditor", models.Auditor)
+	listEntries := func(user *models.User, facilityID uint) models.PaginatedResource[models.AuditLog] {
+		rr := serve(t, asUser(user, facilityID, server.PermissionMiddleware(models.ViewAuditLog, server.HandleIndexAuditLog)),
+			http.MethodGet, "/api/audit-log?target_type=user&target_id="+strconv.Itoa(int(student.ID)), "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response models.PaginatedResource[models.AuditLog]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unable to read audit log: %v", err)
+		}
+		return response
+	}
+
+	t.Run("TestUpdateIsAudited", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPatch, "/api/users/"+strconv.Itoa(int(student.ID)), bytes.NewBufferString(`{"name_last":"Renamed"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetPathValue("id", strconv.Itoa(int(student.ID)))
+		t.Setenv("TRUSTED_PROXIES", "127.0.0.1, 172.18.0.0/16")
+		req.RemoteAddr = "172.18.0.5:41022"
+		req.Header.Set("X-Real-IP", "10.1.2.3")
+		rr := httptest.NewRecorder()
+		asUser(staff, facility.ID, http.HandlerFunc(server.HandleUpdateUser)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		response := listEntries(staff, facility.ID)
+		if len(response.Data) != 1 {
+			t.Fatalf("expected one audit log entry, got %+v", response.Data)
+		}
+		entry := response.Data[0]
+		if entry.Action != models.AuditUserUpdated || entry.ActorID != staff.ID || entry.ActorUsername != staff.Username || entry.IP != "10.1.2.3" ||
+			!strings.Contains(string(entry.Before), `"name_last":"Student"`) || !strings.Contains(string(entry.After), `"name_last":"Renamed"`) {
+			t.Errorf("unexpected audit log entry: %+v", entry)
+		}
+		if err := server.Db.Conn.Model(&entry).Update("action", "tampered").Error; err == nil {
+			t.Error("expected audit log entries to be immutable")
+		}
+	})
+
+	t.Run("TestAuditLogIsFacilityScoped", func(t *testing.T) {
+		if response := listEntries(auditor, other.ID); len(response.Data) != 0 {
+			t.Errorf("expected no entries from another facility, got %+v", response.Data)
+		}
+	})
+
+	t.Run("TestExportAuditLog", func(t *testing.T) {
+		rr := serve(t, asUser(staff, facility.ID, server.PermissionMiddleware(models.ViewAuditLog, server.HandleExportAuditLog)), http.MethodGet, "/api/audit-log/export?action=user.updated", "", nil)
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if rr.Code != http.StatusOK || len(lines) != 2 || !strings.Contains(lines[1], staff.Username) {
+			t.Errorf("expected a header and one entry in the export, got %v: %q", rr.Code, lines)
+		}
+	})
+
+	t.Run("TestForgedAddressIsIgnored", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "172.18.0.0/16")
+		req, err := http.NewRequest(http.MethodPatch, "/api/users/"+strconv.Itoa(int(student.ID)), bytes.NewBufferString(`{"name_last":"Forged"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetPathValue("id", strconv.Itoa(int(student.ID)))
+		// straight to the backend's port, not through the proxy
+		req.RemoteAddr = "192.168.1.20:52000"
+		req.Header.Set("X-Real-IP", "10.1.2.3")
+		rr := httptest.NewRecorder()
+		asUser(staff, facility.ID, http.HandlerFunc(server.HandleUpdateUser)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		response := listEntries(staff, facility.ID)
+		if len(response.Data) != 2 || response.Data[0].IP != "192.168.1.20" {
+			t.Errorf("expected the address of the connection to be logged, got %+v", response.Data)
+		}
+	})
+}
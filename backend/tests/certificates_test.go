@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCertificates(t *testing.T) {
+	facility := seedFacility(t, "Certificate Facility")
+	student := seedUser(t, facility, "certificate_student", models.Student)
+	staff := seedUser(t, facility, "certificate_staff", models.FacilityAdmin)
+	program, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: 1, Name: "Certified Program"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordOutcome := func(outcomeType models.OutcomeType) {
+		body, _ := json.Marshal(models.Outcome{Type: outcomeType, ProgramID: program.ID, ProgramName: program.Name, Value: "A"})
+		h := server.PermissionMiddleware(models.ManageProgress, server.HandleCreateOutcome)
+		if rr := serve(t, asUser(staff, facility.ID, h), http.MethodPost, "/api/users/{id}/outcomes", string(body), map[string]string{"id": strconv.Itoa(int(student.ID))}); rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	}
+	verify := func(code string) handlers.CertificateVerification {
+		rr := serve(t, http.HandlerFunc(server.HandleVerifyCertificate), http.MethodGet, "/verify/{code}", "", map[string]string{"code": code})
+		var response models.Resource[handlers.CertificateVerification]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("unable to read verification: %v %s", err, rr.Body.String())
+		}
+		return response.Data[0]
+	}
+
+	t.Run("TestCertificateOutcomeIssuesCertificate", func(t *testing.T) {
+		recordOutcome(models.ProgramCompletion)
+		recordOutcome(models.Certificate)
+		certs, err := server.Db.GetCertificatesForUser(student.ID)
+		if err != nil || len(certs) != 1 {
+			t.Fatalf("expected only the certificate outcome to issue a certificate, got %+v (%v)", certs, err)
+		}
+		verified := verify(certs[0].Code)
+		if !verified.Valid || verified.HolderName != "Certificate Student" || verified.ProgramName != program.Name {
+			t.Errorf("expected the certificate to verify, got %+v", verified)
+		}
+		rr := serve(t, asUser(staff, facility.ID, http.HandlerFunc(server.HandleCertificatePDF)), http.MethodGet, "/api/users/{id}/certificates/{certificate_id}/pdf", "", map[string]string{"id": strconv.Itoa(int(student.ID)), "certificate_id": strconv.Itoa(int(certs[0].ID))})
+		if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) {
+			t.Errorf("expected the certificate as a pdf, got %v", rr.Code)
+		}
+	})
+
+	t.Run("TestTemplateIssuesForRecordedOutcomes", func(t *testing.T) {
+		h := server.PermissionMiddleware(models.ManagePrograms, server.HandleUpdateCertificateTemplate)
+		body := `{"title":"Certificate of Achievement","outcome_types":"certificate, grade","signatory":"Warden"}`
+		if rr := serve(t, asUser(staff, facility.ID, h), http.MethodPut, "/api/programs/{id}/certificate-template", body, map[string]string{"id": strconv.Itoa(int(program.ID))}); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		certs, err := server.Db.GetCertificatesForUser(student.ID)
+		if err != nil || len(certs) != 2 {
+			t.Errorf("expected the grade recorded earlier to be issued a certificate, got %+v (%v)", certs, err)
+		}
+	})
+
+	t.Run("TestAlteredCertificateIsInvalid", func(t *testing.T) {
+		certs, err := server.Db.GetCertificatesForUser(student.ID)
+		if err != nil || len(certs) == 0 {
+			t.Fatalf("expected certificates, got %v", err)
+		}
+		if err := server.Db.Conn.Model(&certs[0]).Update("holder_name", "Someone Else").Error; err != nil {
+			t.Fatal(err)
+		}
+		if verified := verify(certs[0].Code); verified.Valid {
+			t.Errorf("expected an altered certificate not to verify, got %+v", verified)
+		}
+		if rr := serve(t, http.HandlerFunc(server.HandleVerifyCertificate), http.MethodGet, "/verify/{code}", "", map[string]string{"code": "CE-AAAAA-AAAAA-AAAAA"}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected an unknown code not to be found, got %v", rr.Code)
+		}
+	})
+
+	t.Run("TestNoCertificateWithoutAppKey", func(t *testing.T) {
+		t.Setenv("APP_KEY", "")
+		before, err := server.Db.GetCertificatesForUser(student.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordOutcome(models.Certificate)
+		if after, err := server.Db.GetCertificatesForUser(student.ID); err != nil || len(after) != len(before) {
+			t.Errorf("expected no certificate to be issued without a key to sign it, got %d (%v)", len(after), err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestEnrollmentLifecycle(t *testing.T) {
+	facility := seedFacility(t, "Enrollment Facility")
+	student := seedUser(t, facility, "enrollment_student", models.Student)
+	staff := seedUser(t, facility, "enrollment_staff", models.CaseManager)
+	program, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: 1, Name: "Fixed Roster", Type: models.FixedEnrollment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Db.PublishProgram(int(program.ID), facility.ID); err != nil {
+		t.Fatal(err)
+	}
+	programID := map[string]string{"id": strconv.Itoa(int(program.ID))}
+	body := `{"user_id": ` + strconv.Itoa(int(student.ID)) + `}`
+	var enrollment models.Enrollment
+
+	t.Run("TestStudentCannotJoinFixedEnrollment", func(t *testing.T) {
+		rr := serve(t, asUser(student, facility.ID, http.HandlerFunc(server.HandleCreateEnrollment)), http.MethodPost, "/api/programs/{id}/enrollments", body, programID)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("TestStaffEnrollStudent", func(t *testing.T) {
+		enroll := asUser(staff, facility.ID, http.HandlerFunc(server.HandleCreateEnrollment))
+		rr := serve(t, enroll, http.MethodPost, "/api/programs/{id}/enrollments", body, programID)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var response models.Resource[models.Enrollment]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("unable to read enrollment: %v", err)
+		}
+		enrollment = response.Data[0]
+		if enrollment.Status != models.EnrollmentActive || enrollment.StartDate == nil {
+			t.Errorf("expected an active enrollment with a start date, got %+v", enrollment)
+		}
+		if rr := serve(t, enroll, http.MethodPost, "/api/programs/{id}/enrollments", body, programID); rr.Code != http.StatusConflict {
+			t.Errorf("expected enrolling twice to conflict, got %v", rr.Code)
+		}
+		programs, _, _, err := server.Db.GetUserPrograms(student.ID, facility.ID, "", "", "", nil)
+		if err != nil || len(programs) != 1 || programs[0].ID != program.ID {
+			t.Errorf("expected the program in the student's programs, got %+v (%v)", programs, err)
+		}
+	})
+
+	t.Run("TestEnrollmentTransitions", func(t *testing.T) {
+		for _, test := range []struct {
+			status models.EnrollmentStatus
+			want   int
+		}{{models.EnrollmentWaitlisted, http.StatusBadRequest}, {models.EnrollmentCompleted, http.StatusOK}} {
+			rr := serve(t, asUser(staff, facility.ID, server.PermissionMiddleware(models.ManageEnrollments, server.HandleUpdateEnrollment)),
+				http.MethodPatch, "/api/enrollments/{id}", `{"status": "`+string(test.status)+`"}`, map[string]string{"id": strconv.Itoa(int(enrollment.ID))})
+			if rr.Code != test.want {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", test.status, rr.Code, test.want)
+			}
+		}
+		updated, err := server.Db.GetEnrollmentByID(enrollment.ID)
+		if err != nil || updated.Status != models.EnrollmentCompleted || updated.EndDate == nil {
+			t.Errorf("expected a completed enrollment with an end date, got %+v (%v)", updated, err)
+		}
+	})
+}
+
+func TestEnrollmentRequests(t *testing.T) {
+	facility := seedFacility(t, "Request Facility")
+	student := seedUser(t, facility, "request_student", models.Student)
+	staff := seedUser(t, facility, "request_staff", models.CaseManager)
+	program, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: 1, Name: "Requested Roster", Type: models.FixedEnrollment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Db.PublishProgram(int(program.ID), facility.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the student already has an account in the provider, so approval doesn't create one
+	mapping := models.ProviderUserMapping{UserID: student.ID, ProviderPlatformID: 1, ExternalUserID: "request_student", ExternalUsername: "request_student"}
+	if err := server.Db.CreateProviderUserMapping(&mapping); err != nil {
+		t.Fatal(err)
+	}
+	programID := map[string]string{"id": strconv.Itoa(int(program.ID))}
+	var request models.EnrollmentRequest
+
+	t.Run("TestRequestToJoin", func(t *testing.T) {
+		create := asUser(student, facility.ID, http.HandlerFunc(server.HandleCreateEnrollmentRequest))
+		rr := serve(t, create, http.MethodPost, "/api/programs/{id}/enrollment-requests", `{"reason":"I would like to take this class"}`, programID)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var response models.Resource[models.EnrollmentRequest]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("unable to read enrollment request: %v", err)
+		}
+		request = response.Data[0]
+		if rr := serve(t, create, http.MethodPost, "/api/programs/{id}/enrollment-requests", `{}`, programID); rr.Code != http.StatusConflict {
+			t.Errorf("expected a second request to conflict, got %v", rr.Code)
+		}
+	})
+
+	t.Run("TestApproveRequest", func(t *testing.T) {
+		requestID := map[string]string{"id": strconv.Itoa(int(request.ID))}
+		rr := serve(t, asUser(student, facility.ID, http.HandlerFunc(server.HandleApproveEnrollmentRequest)), http.MethodPost, "/api/enrollment-requests/{id}/approve", `{}`, requestID)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected a learner to be unable to approve their own request, got %v", rr.Code)
+		}
+		rr = serve(t, asUser(staff, facility.ID, http.HandlerFunc(server.HandleApproveEnrollmentRequest)), http.MethodPost, "/api/enrollment-requests/{id}/approve", `{"note":"space available"}`, requestID)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		enrollment, err := server.Db.GetEnrollment(student.ID, program.ID)
+		if err != nil || enrollment.Status != models.EnrollmentActive {
+			t.Errorf("expected the student to be enrolled, got %+v (%v)", enrollment, err)
+		}
+		decided, err := server.Db.GetEnrollmentRequestByID(request.ID)
+		if err != nil || decided.Status != models.RequestApproved || decided.ReviewerID == nil || *decided.ReviewerID != staff.ID || decided.ReviewNote != "space available" {
+			t.Errorf("expected the decision to be recorded, got %+v (%v)", decided, err)
+		}
+		rr = serve(t, asUser(staff, facility.ID, http.HandlerFunc(server.HandleDenyEnrollmentRequest)), http.MethodPost, "/api/enrollment-requests/{id}/deny", `{}`, requestID)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected a decided request to conflict, got %v", rr.Code)
+		}
+	})
+}
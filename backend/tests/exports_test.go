@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/exports"
+	"UnlockEdv2/src/models"
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExports(t *testing.T) {
+	facility := seedFacility(t, "Export Facility")
+	student := seedUser(t, facility, "export_student", models.Student)
+	staff := seedUser(t, facility, "export_staff", models.Teacher)
+	outsider := seedUser(t, seedFacility(t, "Unexported Facility"), "export_outsider", models.Student)
+	program, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: 1, Name: "Exported Program"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	for idx, delta := range []uint{1800, 5400} {
+		activity := models.Activity{UserID: student.ID, ProgramID: program.ID, Type: models.ContentInteraction, TimeDelta: delta, ExternalID: "export_content", ActivityDate: day.AddDate(0, 0, idx)}
+		if err := server.Db.Conn.Create(&activity).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	export := asUser(staff, facility.ID, server.PermissionMiddleware(models.ViewReports, server.HandleExport))
+
+	t.Run("TestExportActivityHours", func(t *testing.T) {
+		rr := serve(t, export, http.MethodGet, "/api/exports/activity?from=2024-03-01&to=2024-03-31", "", map[string]string{"dataset": "activity"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil || len(records) != 2 {
+			t.Fatalf("expected a header and one row, got %q (%v)", records, err)
+		}
+		if row := records[1]; row[1] != student.Username || row[6] != "2.00" || row[7] != "2" {
+			t.Errorf("expected two hours over two days for the student, got %q", row)
+		}
+		rr = serve(t, export, http.MethodGet, "/api/exports/activity?from=2024-03-05&user_id="+strconv.Itoa(int(student.ID)), "", map[string]string{"dataset": "activity"})
+		if records, _ := csv.NewReader(rr.Body).ReadAll(); len(records) != 2 || records[1][6] != "1.50" || records[1][7] != "1" {
+			t.Errorf("expected only the second day's activity, got %q", records)
+		}
+		_, entries, err := server.Db.GetAuditLogs(1, 10, database.AuditLogFilter{Action: models.AuditDataExported, ActorID: staff.ID})
+		if err != nil || len(entries) != 2 || !strings.Contains(string(entries[0].After), `"dataset":"activity"`) {
+			t.Errorf("expected both exports to be audited, got %+v %v", entries, err)
+		}
+	})
+
+	t.Run("TestExportUsersAsXLSX", func(t *testing.T) {
+		rr := serve(t, export, http.MethodGet, "/api/exports/users?format=xlsx", "", map[string]string{"dataset": "users"})
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+			t.Fatalf("handler returned wrong status code or content type: got %v %q", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatalf("expected a valid workbook: %v", err)
+		}
+		for _, file := range archive.File {
+			if file.Name != "xl/worksheets/sheet1.xml" {
+				continue
+			}
+			sheet, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var content bytes.Buffer
+			_, _ = content.ReadFrom(sheet)
+			if !bytes.Contains(content.Bytes(), []byte(student.Username)) || !bytes.Contains(content.Bytes(), []byte(staff.Username)) {
+				t.Errorf("expected the facility's users in the sheet, got %s", content.String())
+			}
+			if bytes.Contains(content.Bytes(), []byte(outsider.Username)) {
+				t.Error("expected users of other facilities to be left out of the export")
+			}
+			return
+		}
+		t.Error("expected the workbook to have a sheet")
+	})
+
+	t.Run("TestUnknownExport", func(t *testing.T) {
+		if rr := serve(t, export, http.MethodGet, "/api/exports/passwords", "", map[string]string{"dataset": "passwords"}); rr.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestCSVEscapesFormulas(t *testing.T) {
+	t.Parallel()
+	var body bytes.Buffer
+	writer := exports.NewWriter(&body, exports.CSV, "users")
+	if err := writer.Write("=HYPERLINK(\"http://example.com\")", "+1", "-1", "@SUM(A1)", "\tname", "plain", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&body).ReadAll()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one row, got %q (%v)", records, err)
+	}
+	want := []string{"'=HYPERLINK(\"http://example.com\")", "'+1", "'-1", "'@SUM(A1)", "'\tname", "plain", "-1"}
+	if strings.Join(records[0], ",") != strings.Join(want, ",") {
+		t.Errorf("expected strings read as formulas to be kept as text, got %q", records[0])
+	}
+}
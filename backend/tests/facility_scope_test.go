@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+type facilityFixture struct {
+	staffA, studentA, studentB *models.User
+}
+
+// a facility admin and a student at one facility, and a student with activity + outcomes at another
+func seedFacilityFixture(t *testing.T) facilityFixture {
+	facilityA, facilityB := seedFacility(t, "Scope Facility A"), seedFacility(t, "Scope Facility B")
+	fixture := facilityFixture{
+		staffA:   seedUser(t, facilityA, "scope_staff_a", models.FacilityAdmin),
+		studentA: seedUser(t, facilityA, "scope_student_a", models.Student),
+		studentB: seedUser(t, facilityB, "scope_student_b", models.Student),
+	}
+	records := []interface{}{
+		&models.Activity{UserID: fixture.studentB.ID, ProgramID: 1, Type: models.ProgramInteraction, TotalTime: 60, TimeDelta: 60, ExternalID: "scope_content"},
+		&models.Outcome{UserID: fixture.studentB.ID, ProgramID: 1, ProgramName: "scoped", Type: models.OutcomeType("grade"), Value: "A"},
+		&models.UserActivity{UserID: fixture.studentB.ID, ClickedUrl: "/scope-b"},
+	}
+	for _, record := range records {
+		if err := server.Db.Conn.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fixture
+}
+
+// the user, at their own facility, asking for the record with the id
+func getAs(t *testing.T, user *models.User, h http.Handler, id uint) *httptest.ResponseRecorder {
+	return serve(t, asUser(user, user.FacilityID, h), http.MethodGet, "/api/scoped", "", map[string]string{"id": strconv.Itoa(int(id))})
+}
+
+func TestFacilityIsolation(t *testing.T) {
+	fixture := seedFacilityFixture(t)
+	studentB := fixture.studentB.ID
+
+	for _, viewer := range []*models.User{fixture.staffA, fixture.studentA} {
+		t.Run("TestCantReadOtherFacilityUser/"+viewer.Username, func(t *testing.T) {
+			for name, handler := range map[string]func(http.ResponseWriter, *http.Request){
+				"user":           server.HandleShowUser,
+				"outcomes":       server.HandleGetOutcomes,
+				"activity":       server.HandleGetActivityByUserID,
+				"daily activity": server.HandleGetDailyActivityByUserID,
+				"dashboard":      server.HandleStudentDashboard,
+				"programs":       server.HandleUserPrograms,
+			} {
+				if rr := getAs(t, viewer, http.HandlerFunc(handler), studentB); rr.Code == http.StatusOK {
+					t.Errorf("%s of a user at another facility was returned to %s", name, viewer.Username)
+				}
+			}
+		})
+	}
+
+	t.Run("TestStaffCanReadOwnFacilityUser", func(t *testing.T) {
+		if rr := getAs(t, fixture.staffA, http.HandlerFunc(server.HandleGetOutcomes), fixture.studentA.ID); rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("TestUserListIsScoped", func(t *testing.T) {
+		rr := getAs(t, fixture.staffA, server.PermissionMiddleware(models.ViewUsers, server.HandleIndexUsers), 0)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		resp := models.PaginatedResource[models.User]{}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range resp.Data {
+			if user.FacilityID != fixture.staffA.FacilityID {
+				t.Errorf("user %s from facility %d was listed", user.Username, user.FacilityID)
+			}
+		}
+	})
+
+	t.Run("TestProgramActivityIsScoped", func(t *testing.T) {
+		rr := getAs(t, fixture.staffA, server.PermissionMiddleware(models.ViewReports, server.HandleGetProgramActivity), 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		resp := struct {
+			Activities []models.Activity `json:"activities"`
+		}{}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, activity := range resp.Activities {
+			if activity.UserID == studentB {
+				t.Error("activity of a user at another facility was returned")
+			}
+		}
+	})
+
+	t.Run("TestScopedQueries", func(t *testing.T) {
+		scoped := server.Db.WithFacility(fixture.staffA.FacilityID)
+		if total, _, err := scoped.GetOutcomesForUser(studentB, 1, 10, "", "", ""); err != nil || total != 0 {
+			t.Errorf("expected no outcomes for a user at another facility, got %d (%v)", total, err)
+		}
+		_, activities, err := scoped.GetAllUserActivity("", 1, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.ContainsFunc(activities, func(activity database.UserAcitivityJoin) bool { return activity.ClickedUrl == "/scope-b" }) {
+			t.Error("user activity of a user at another facility was returned")
+		}
+		if _, err := scoped.GetUserByID(studentB); err == nil {
+			t.Error("expected a user at another facility not to be found")
+		}
+		if err := scoped.DeleteUser(int(studentB)); err == nil {
+			t.Error("expected a user at another facility not to be deleted")
+		}
+		if _, err := server.Db.GetUserByID(studentB); err != nil {
+			t.Error("user at another facility was deleted")
+		}
+	})
+}
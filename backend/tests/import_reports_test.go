@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"UnlockEdv2/src/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestImportReport(t *testing.T) {
+	t.Parallel()
+	t.Run("TestFailKeepsCountingPastTheErrorCap", func(t *testing.T) {
+		report := models.NewImportReport(1, models.ImportProgramsJob)
+		for idx := range models.MaxImportErrors + 5 {
+			report.Fail(strconv.Itoa(idx), errors.New("invalid row"))
+		}
+		report.Create()
+		if report.Failed != models.MaxImportErrors+5 || len(report.Errors) != models.MaxImportErrors || report.Total() != models.MaxImportErrors+6 {
+			t.Errorf("expected every failure to be counted, and only the first %d kept, got %d failed with %d errors", models.MaxImportErrors, report.Failed, len(report.Errors))
+		}
+		if report.Errors[0].ExternalID != "0" || report.Errors[0].Reason != "invalid row" {
+			t.Errorf("expected the first failure to be kept, got %+v", report.Errors[0])
+		}
+	})
+
+	t.Run("TestMergeAddsCountsAndErrors", func(t *testing.T) {
+		report := models.NewImportReport(1, models.ImportMilestonesJob)
+		report.Create()
+		report.Fail("a", errors.New("first"))
+		other := models.NewImportReport(1, models.ImportMilestonesJob)
+		other.Create()
+		other.Update()
+		other.Skip()
+		other.Fail("b", errors.New("second"))
+		report.Merge(other)
+		report.Merge(nil)
+		if report.Created != 2 || report.Updated != 1 || report.Skipped != 1 || report.Failed != 2 || len(report.Errors) != 2 || report.Errors[1].ExternalID != "b" {
+			t.Errorf("expected the reports to be added together, got %+v", report)
+		}
+	})
+
+	t.Run("TestMergeRespectsTheErrorCap", func(t *testing.T) {
+		report, other := models.NewImportReport(1, models.ImportActivityJob), models.NewImportReport(1, models.ImportActivityJob)
+		for idx := range models.MaxImportErrors - 1 {
+			report.Fail(strconv.Itoa(idx), errors.New("invalid row"))
+		}
+		other.Fail("x", errors.New("invalid row"))
+		other.Fail("y", errors.New("invalid row"))
+		report.Merge(other)
+		if report.Failed != models.MaxImportErrors+1 || len(report.Errors) != models.MaxImportErrors || report.Errors[len(report.Errors)-1].ExternalID != "x" {
+			t.Errorf("expected the errors to stop at the cap, got %d failed with %d errors", report.Failed, len(report.Errors))
+		}
+	})
+}
+
+func TestSyncJobImportReport(t *testing.T) {
+	// the middleware responds with the report of its import
+	middleware := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/programs" {
+			t.Errorf("unexpected request to the middleware: %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"created": 2, "updated": 1, "skipped": 3, "failed": 1, "errors": [{"external_id": "course-9", "reason": "missing name"}]}`))
+	}))
+	t.Cleanup(middleware.Close)
+	t.Setenv("PROVIDER_SERVICE_URL", middleware.URL)
+	provider := &models.ProviderPlatform{Name: "Reporting Canvas", Type: models.CanvasCloud, State: models.Enabled}
+	if err := server.Db.Conn.Create(provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	job := &models.SyncJob{ProviderPlatformID: provider.ID, Type: models.ImportProgramsJob, Schedule: "0 3 * * *"}
+	if err := server.Db.CreateSyncJob(job); err != nil {
+		t.Fatal(err)
+	}
+	run, err := server.Scheduler.Run(context.Background(), job, models.TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != models.RunPartial {
+		t.Errorf("expected a run with a failed row to be partial, got %s (%s)", run.Status, run.Error)
+	}
+	report, err := server.Db.GetImportReportForRun(run.ID)
+	if err != nil {
+		t.Fatalf("expected the report to be saved with the run: %v", err)
+	}
+	if report.ProviderPlatformID != provider.ID || report.Type != models.ImportProgramsJob || report.Created != 2 || report.Updated != 1 || report.Skipped != 3 || report.Failed != 1 {
+		t.Errorf("expected the middleware's counts, got %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0] != (models.ImportError{ExternalID: "course-9", Reason: "missing name"}) {
+		t.Errorf("expected the middleware's errors, got %+v", report.Errors)
+	}
+	// and as it is served
+	body, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.ImportReport
+	if err := json.Unmarshal(body, &decoded); err != nil || decoded.Total() != 7 || len(decoded.Errors) != 1 || decoded.SyncJobRunID == nil || *decoded.SyncJobRunID != run.ID {
+		t.Errorf("expected the report to round trip, got %+v (%v)", decoded, err)
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/jobs"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUserLifecycle(t *testing.T) {
+	// the provider middleware, recording the accounts it is asked to deactivate or reactivate
+	changes := []string{}
+	middleware := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Active bool `json:"active"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		changes = append(changes, r.Method+" "+r.URL.Path+" "+strconv.FormatBool(body.Active))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(middleware.Close)
+	t.Setenv("PROVIDER_SERVICE_URL", middleware.URL)
+
+	facility := seedFacility(t, "Lifecycle Facility")
+	admin := seedUser(t, facility, "lifecycle_admin", models.Admin)
+	learner := seedUser(t, facility, "lifecycle_learner", models.Student)
+	learner.Password = "password"
+	if err := learner.HashPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Db.Conn.Model(learner).Update("password", learner.Password).Error; err != nil {
+		t.Fatal(err)
+	}
+	provider := &models.ProviderPlatform{Name: "Lifecycle Canvas", Type: models.CanvasCloud, State: models.Enabled}
+	if err := server.Db.Conn.Create(provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Db.CreateProviderUserMapping(&models.ProviderUserMapping{UserID: learner.ID, ProviderPlatformID: provider.ID, ExternalUserID: "lifecycle-1", ExternalUsername: "learner"}); err != nil {
+		t.Fatal(err)
+	}
+	outcome, err := server.Db.CreateOutcome(&models.Outcome{Type: models.Certificate, ProgramID: 1, ProgramName: "Lifecycle Program", UserID: learner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &models.IssuedCertificate{Code: models.NewDocumentID("CE"), UserID: &learner.ID, ProgramID: 1, OutcomeID: &outcome.ID, HolderName: "Lifecycle Learner", ProgramName: outcome.ProgramName, IssuedAt: time.Now()}
+	if err := cert.Sign(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Db.CreateCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	learnerID := map[string]string{"id": strconv.Itoa(int(learner.ID))}
+	setStatus := func(status models.UserStatus) handlers.UserStatusResult {
+		rr := serve(t, asUser(admin, facility.ID, http.HandlerFunc(server.HandleSetUserStatus)), http.MethodPut, "/api/users/{id}/status", `{"status": "`+string(status)+`"}`, learnerID)
+		var response models.Resource[handlers.UserStatusResult]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK || len(response.Data) != 1 {
+			t.Fatalf("unable to set status of user: %v %v %s", rr.Code, err, rr.Body.String())
+		}
+		return response.Data[0]
+	}
+	deactivated := func() bool {
+		mapping, err := server.Db.GetProviderUserMapping(int(learner.ID), int(provider.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mapping.DeactivatedAt != nil
+	}
+	accountPath := "PUT /api/users/" + strconv.Itoa(int(learner.ID)) + "/active "
+
+	t.Run("TestCannotDeactivateUserWithMoreAccess", func(t *testing.T) {
+		manager := seedUser(t, facility, "lifecycle_manager", models.DepartmentAdmin)
+		rr := serve(t, asUser(manager, facility.ID, http.HandlerFunc(server.HandleSetUserStatus)), http.MethodPut, "/api/users/{id}/status", `{"status": "archived"}`,
+			map[string]string{"id": strconv.Itoa(int(admin.ID))})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("TestReleaseDeactivatesAccounts", func(t *testing.T) {
+		result := setStatus(models.UserReleased)
+		if result.Status != models.UserReleased || len(result.Errors) != 0 || !deactivated() {
+			t.Errorf("expected the learner and their account to be deactivated, got %+v", result)
+		}
+		if len(changes) != 1 || changes[0] != accountPath+"false" {
+			t.Errorf("expected the account to be deactivated in the provider, got %v", changes)
+		}
+		if _, err := server.Db.AuthorizeUser(learner.Username, "password"); err == nil {
+			t.Error("expected a released learner not to be able to log in")
+		}
+		// releasing them again doesn't deactivate the account twice
+		setStatus(models.UserReleased)
+		if len(changes) != 1 {
+			t.Errorf("expected the account to be left as it is, got %v", changes)
+		}
+	})
+
+	t.Run("TestReactivationRestoresAccounts", func(t *testing.T) {
+		result := setStatus(models.UserActive)
+		if result.Status != models.UserActive || deactivated() || len(changes) != 2 || changes[1] != accountPath+"true" {
+			t.Errorf("expected the learner and their account to be reactivated, got %+v %v", result, changes)
+		}
+		if _, err := server.Db.AuthorizeUser(learner.Username, "password"); err != nil {
+			t.Errorf("expected the learner to be able to log in, got %v", err)
+		}
+	})
+
+	t.Run("TestDeleteArchivesUser", func(t *testing.T) {
+		rr := serve(t, asUser(admin, facility.ID, http.HandlerFunc(server.HandleDeleteUser)), http.MethodDelete, "/api/users/{id}", "", learnerID)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		_, current, err := server.Db.GetCurrentUsers(1, 10, facility.ID, "", "")
+		if err != nil || slices.ContainsFunc(current, func(user models.User) bool { return user.ID == learner.ID }) {
+			t.Errorf("expected the archived learner not to be listed, got %+v %v", current, err)
+		}
+		_, archived, err := server.Db.GetArchivedUsers(1, 10, facility.ID)
+		if err != nil || len(archived) != 1 || archived[0].ID != learner.ID || !deactivated() {
+			t.Errorf("expected the learner to be archived, got %+v %v", archived, err)
+		}
+	})
+
+	t.Run("TestRetentionPurgesArchivedUsers", func(t *testing.T) {
+		purge := func(user *models.User) error { return server.Db.PurgeUserData(user.ID) }
+		if purged, err := jobs.PurgeArchivedUsers(server.Db, 24*time.Hour, time.Now(), purge); err != nil || purged != 0 {
+			t.Errorf("expected the learner to be kept until the retention period has passed, got %d %v", purged, err)
+		}
+		if purged, err := jobs.PurgeArchivedUsers(server.Db, 24*time.Hour, time.Now().Add(48*time.Hour), purge); err != nil || purged != 1 {
+			t.Errorf("expected the learner to be purged, got %d %v", purged, err)
+		}
+		if _, err := server.Db.GetUserByID(learner.ID); err == nil {
+			t.Error("expected the learner to be removed")
+		}
+		if _, err := server.Db.GetProviderUserMapping(int(learner.ID), int(provider.ID)); err == nil {
+			t.Error("expected the learner's account in the provider to be removed")
+		}
+		// the certificate they were issued is kept, and still verifies
+		rr := serve(t, http.HandlerFunc(server.HandleVerifyCertificate), http.MethodGet, "/verify/{code}", "", map[string]string{"code": cert.Code})
+		var response models.Resource[handlers.CertificateVerification]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 || !response.Data[0].Valid || response.Data[0].HolderName != "Lifecycle Learner" {
+			t.Errorf("expected the certificate to still verify, got %v %s", rr.Code, rr.Body.String())
+		}
+	})
+}
@@ -2,7 +2,13 @@ package tests
 
 import (
 	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -15,7 +21,75 @@ import (
 var server *handlers.Server
 
 func TestMain(m *testing.M) {
+	os.Setenv("APP_KEY", "test-key")
 	server = handlers.NewServer(true)
 	exitVal := m.Run()
 	os.Exit(exitVal)
 }
+
+/*
+* Tests which need users of their own create them at a facility of their own, so
+* that what one test writes is never seen by another
+ */
+func seedFacility(t *testing.T, name string) *models.Facility {
+	facility, err := server.Db.CreateFacility(name)
+	if err != nil {
+		t.Fatalf("error creating facility: %v", err)
+	}
+	return facility
+}
+
+/*
+* Creates a user at the facility, named after their username ("transfer_learner" is
+* Transfer Learner). Staff are learners who have been granted the role at the
+* facility, the way roles are assigned, while an admin is an admin everywhere.
+ */
+func seedUser(t *testing.T, facility *models.Facility, username string, role models.UserRole) *models.User {
+	name := strings.Split(username, "_")
+	for idx := range name {
+		name[idx] = strings.ToUpper(name[idx][:1]) + name[idx][1:]
+	}
+	user := &models.User{Username: username, NameFirst: name[0], NameLast: strings.Join(name[1:], " "), Role: models.Student, FacilityID: facility.ID}
+	if role == models.Admin {
+		user.Role = models.Admin
+	}
+	if err := server.Db.Conn.Create(user).Error; err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+	if role != models.Admin && role != models.Student {
+		assignRole(t, user, role, facility.ID)
+	}
+	return user
+}
+
+func assignRole(t *testing.T, user *models.User, role models.UserRole, facilityID uint) {
+	if err := server.Db.CreateRoleAssignment(&models.RoleAssignment{UserID: user.ID, Role: role, FacilityID: &facilityID}); err != nil {
+		t.Fatalf("error assigning role: %v", err)
+	}
+}
+
+// serves the request as the user, viewing the facility
+func asUser(user *models.User, facilityID uint, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := &handlers.Claims{UserID: user.ID, Role: user.Role, FacilityID: facilityID}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), handlers.ClaimsKey, claims)))
+	})
+}
+
+// a request to the handler, with the wildcards of its path set as the mux would
+func serve(t *testing.T, h http.Handler, method, url, body string, values map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		req.SetPathValue(key, value)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
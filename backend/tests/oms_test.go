@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"UnlockEdv2/src/oms"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOmsFeed(t *testing.T) {
+	north, south := seedFacility(t, "OMS North"), seedFacility(t, "OMS South")
+	feed, err := os.ReadFile("test_data/oms_feed.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OMS_WEBHOOK_SECRET", "oms-test-secret")
+	send := func(body []byte, signature string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/api/integrations/oms/events", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(oms.SignatureHeader, signature)
+		rr := httptest.NewRecorder()
+		server.HandleOmsWebhook(rr, req)
+		return rr
+	}
+	result := func(rr *httptest.ResponseRecorder) handlers.ResidentFeedResult {
+		var response models.Resource[handlers.ResidentFeedResult]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK || len(response.Data) != 1 {
+			t.Fatalf("unable to read feed result: %v %v %s", rr.Code, err, rr.Body.String())
+		}
+		return response.Data[0]
+	}
+	resident := func(residentID string) *models.User {
+		user, err := server.Db.GetUserByResidentID(residentID)
+		if err != nil {
+			t.Fatalf("expected resident %s to have a user: %v", residentID, err)
+		}
+		return user
+	}
+
+	t.Run("TestUnsignedFeedIsRejected", func(t *testing.T) {
+		if rr := send(feed, oms.Sign(feed, "wrong-secret")); rr.Code != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("TestWebhookAppliesFeed", func(t *testing.T) {
+		applied := result(send(feed, oms.Sign(feed, "oms-test-secret")))
+		if applied.Received != 5 || applied.Applied != 4 || applied.Failed != 1 || applied.Errors[0].ExternalID != "oms-5" {
+			t.Errorf("expected every event but the unknown resident's transfer to apply, got %+v", applied)
+		}
+		transferred := resident("R1001")
+		if transferred.Username != "r1001" || transferred.FacilityID != south.ID || transferred.HousingUnit != "B-3" || transferred.ReleaseDate == nil || !transferred.IsActive() {
+			t.Errorf("expected R1001 to be admitted and moved to %d, got %+v", south.ID, transferred)
+		}
+		released := resident("R1002")
+		if released.FacilityID != north.ID || released.Status != models.UserReleased || released.ReleaseDate.Format("2006-01-02") != "2024-05-01" {
+			t.Errorf("expected R1002 to be released, got %+v", released)
+		}
+		if _, err := server.Db.AuthorizeUser(released.Username, "password"); err == nil || err.Error() != "user is released" {
+			t.Errorf("expected a released resident not to be able to log in, got %v", err)
+		}
+	})
+
+	t.Run("TestResentFeedIsOnlyAppliedOnce", func(t *testing.T) {
+		resent := result(send(feed, oms.Sign(feed, "oms-test-secret")))
+		if resent.Skipped != 4 || resent.Failed != 1 {
+			t.Errorf("expected the applied events to be skipped and the failed one retried, got %+v", resent)
+		}
+		event, err := server.Db.GetResidentEvent("oms-5")
+		if err != nil || event.Status != models.ResidentEventFailed || event.Attempts != 2 {
+			t.Errorf("expected the failed event to be recorded with both attempts, got %+v (%v)", event, err)
+		}
+	})
+
+	t.Run("TestDroppedFeedReadmitsResident", func(t *testing.T) {
+		dir := t.TempDir()
+		readmission := `[{"event_id":"oms-6","type":"admission","resident_id":"R1002","name_first":"Ben","name_last":"Resident","facility":"OMS South","housing_unit":"C-1"}]`
+		if err := os.WriteFile(filepath.Join(dir, "0001-readmission.json"), []byte(readmission), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "0002-broken.json"), []byte(`{"event_id":`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := oms.NewWatcher(dir, 0, server.ApplyResidentFeed).Scan(); err != nil {
+			t.Fatal(err)
+		}
+		readmitted := resident("R1002")
+		if !readmitted.IsActive() || readmitted.FacilityID != south.ID || readmitted.HousingUnit != "C-1" {
+			t.Errorf("expected R1002 to be readmitted to %d, got %+v", south.ID, readmitted)
+		}
+		for _, path := range []string{"processed/0001-readmission.json", "failed/0002-broken.json"} {
+			if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+				t.Errorf("expected the feed to be moved to %s: %v", path, err)
+			}
+		}
+	})
+}
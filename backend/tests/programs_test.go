@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func userCatalogue(t *testing.T, user *models.User) []database.UserCatalogueJoin {
+	rr := serve(t, asUser(user, user.FacilityID, http.HandlerFunc(server.HandleUserCatalogue)), http.MethodGet, "/api/users/{id}/catalogue", "", map[string]string{"id": strconv.Itoa(int(user.ID))})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	catalogue := []database.UserCatalogueJoin{}
+	if err := json.NewDecoder(rr.Body).Decode(&catalogue); err != nil {
+		t.Fatal(err)
+	}
+	return catalogue
+}
+
+func setPublished(t *testing.T, handler http.HandlerFunc, programID, facilityID uint) int {
+	rr := serve(t, server.TestAsAdmin(handler), http.MethodPut, "/api/programs/{id}/facilities/{facility_id}", "",
+		map[string]string{"id": strconv.Itoa(int(programID)), "facility_id": strconv.Itoa(int(facilityID))})
+	return rr.Code
+}
+
+func TestFacilityCatalogue(t *testing.T) {
+	student := seedUser(t, seedFacility(t, "Catalogue Facility"), "catalogue_student", models.Student)
+	_, programs, err := server.Db.GetProgram(1, 1, "")
+	if err != nil || len(programs) == 0 {
+		t.Fatal("expected a program to publish")
+	}
+	programID := programs[0].ID
+	inCatalogue := func() bool {
+		return slices.ContainsFunc(userCatalogue(t, student), func(program database.UserCatalogueJoin) bool { return program.ProgramID == programID })
+	}
+	if len(userCatalogue(t, student)) != 0 {
+		t.Fatal("expected an empty catalogue at a new facility")
+	}
+
+	t.Run("TestPublishProgram", func(t *testing.T) {
+		for range 2 {
+			if code := setPublished(t, server.HandlePublishProgram, programID, student.FacilityID); code != http.StatusNoContent {
+				t.Fatalf("handler returned wrong status code: got %v want %v", code, http.StatusNoContent)
+			}
+		}
+		if !inCatalogue() {
+			t.Error("published program is missing from the facility's catalogue")
+		}
+	})
+
+	t.Run("TestUnpublishProgram", func(t *testing.T) {
+		if code := setPublished(t, server.HandleUnpublishProgram, programID, student.FacilityID); code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", code, http.StatusNoContent)
+		}
+		if inCatalogue() {
+			t.Error("unpublished program is still in the facility's catalogue")
+		}
+	})
+}
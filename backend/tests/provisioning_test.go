@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestProvisioningRetry(t *testing.T) {
+	other := seedUser(t, seedFacility(t, "Provisioning Facility"), "provisioning_other", models.Student)
+	provider := &models.ProviderPlatform{Name: "Provisioning Kolibri", Type: models.Kolibri, State: models.Enabled}
+	if err := server.Db.Conn.Create(provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the account in the provider is already mapped to someone else, so mapping it fails
+	if err := server.Db.CreateProviderUserMapping(&models.ProviderUserMapping{UserID: other.ID, ProviderPlatformID: provider.ID, ExternalUserID: "provisioning-1", ExternalUsername: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	provisioning := models.NewProvisioning(provider, models.ImportUser{Username: "provisioned_user", NameFirst: "Provisioned", NameLast: "User", ExternalUserID: "provisioning-1"})
+	provisioning.Status = models.ProvisioningFailed
+	if err := server.Db.CreateProvisioning(provisioning); err != nil {
+		t.Fatal(err)
+	}
+	provisioningID := map[string]string{"id": strconv.Itoa(int(provisioning.ID))}
+	retry := func() handlers.ProvisioningRetry {
+		rr := serve(t, server.TestAsAdmin(http.HandlerFunc(server.HandleRetryProvisioning)), http.MethodPost, "/api/provisioning/{id}/retry", "", provisioningID)
+		var response models.Resource[handlers.ProvisioningRetry]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK || len(response.Data) != 1 {
+			t.Fatalf("unable to retry provisioning: %v %v %s", rr.Code, err, rr.Body.String())
+		}
+		return response.Data[0]
+	}
+
+	t.Run("TestFailedStepIsCompensated", func(t *testing.T) {
+		result := retry()
+		if result.Status != models.ProvisioningFailed || result.UserID != nil || result.Attempts != 1 {
+			t.Errorf("expected the provisioning to fail and be undone, got %+v", result.Provisioning)
+		}
+		if result.Steps[0].Status != models.StepCompensated || result.Steps[2].Status != models.StepFailed {
+			t.Errorf("expected the user to be removed when mapping failed, got %+v", result.Steps)
+		}
+		if server.Db.UsernameExists("provisioned_user") {
+			t.Error("expected the user created by the failed provisioning to be removed")
+		}
+	})
+
+	t.Run("TestRetryResumesProvisioning", func(t *testing.T) {
+		if err := server.Db.DeleteProviderUserMappingByUserID(int(other.ID), int(provider.ID)); err != nil {
+			t.Fatal(err)
+		}
+		result := retry()
+		if result.Status != models.ProvisioningSucceeded || result.UserID == nil || result.Attempts != 2 || result.TempPassword == "" {
+			t.Fatalf("expected the provisioning to succeed, got %+v", result.Provisioning)
+		}
+		mapping, err := server.Db.GetProviderUserMappingByExternalUserID("provisioning-1", provider.ID)
+		if err != nil || mapping.UserID != *result.UserID {
+			t.Errorf("expected the account to be mapped to the provisioned user, got %+v (%v)", mapping, err)
+		}
+		rr := serve(t, server.TestAsAdmin(http.HandlerFunc(server.HandleRetryProvisioning)), http.MethodPost, "/api/provisioning/{id}/retry", "", provisioningID)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected a finished provisioning not to be retried, got %v", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"UnlockEdv2/src/database"
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	facility := seedFacility(t, "Roles Facility")
+	teacher := seedUser(t, facility, "roles_teacher", models.Teacher)
+
+	t.Run("TestRoleOnlyAppliesAtAssignedFacility", func(t *testing.T) {
+		for _, test := range []struct {
+			facilityID uint
+			want       int
+		}{{1, http.StatusForbidden}, {facility.ID, http.StatusOK}} {
+			rr := serve(t, asUser(teacher, test.facilityID, server.PermissionMiddleware(models.ViewUsers, server.HandleIndexUsers)), http.MethodGet, "/api/users", "", nil)
+			if rr.Code != test.want {
+				t.Errorf("facility %d: handler returned wrong status code: got %v want %v", test.facilityID, rr.Code, test.want)
+			}
+		}
+	})
+
+	t.Run("TestRoleWithoutPermissionIsForbidden", func(t *testing.T) {
+		rr := serve(t, asUser(teacher, facility.ID, server.PermissionMiddleware(models.ManagePrograms, server.HandleCreateProgram)), http.MethodPost, "/api/programs", "{}", nil)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("TestNoRoleEscalation", func(t *testing.T) {
+		manager := seedUser(t, facility, "roles_manager", models.FacilityAdmin)
+		student := seedUser(t, facility, "roles_student", models.Student)
+		for _, test := range []struct {
+			name       string
+			role       models.UserRole
+			facilityID *uint
+			want       int
+		}{
+			{"admin", models.Admin, nil, http.StatusForbidden},
+			{"every facility", models.Teacher, nil, http.StatusForbidden},
+			{"other facility", models.Teacher, ptr(uint(1)), http.StatusForbidden},
+			{"own facility", models.CaseManager, ptr(facility.ID), http.StatusCreated},
+		} {
+			body, err := json.Marshal(handlers.RoleAssignmentRequest{Role: test.role, FacilityID: test.facilityID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := serve(t, asUser(manager, facility.ID, server.PermissionMiddleware(models.ManageRoles, server.HandleCreateRoleAssignment)),
+				http.MethodPost, "/api/users/{id}/roles", string(body), map[string]string{"id": strconv.Itoa(int(student.ID))})
+			if rr.Code != test.want {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, rr.Code, test.want)
+			}
+		}
+		allowed, err := server.Db.UserHasPermission(student.ID, 1, models.ViewUsers)
+		if err != nil || allowed {
+			t.Error("expected no permissions to be granted at another facility")
+		}
+		_, entries, err := server.Db.GetAuditLogs(1, 10, database.AuditLogFilter{Action: models.AuditRoleGranted, ActorID: manager.ID})
+		if err != nil || len(entries) != 1 {
+			t.Errorf("expected the granted role to be audited, got %+v %v", entries, err)
+		}
+	})
+
+	t.Run("TestCannotManageUserWithMoreAccess", func(t *testing.T) {
+		departmentAdmin := seedUser(t, facility, "roles_department_admin", models.DepartmentAdmin)
+		// an admin of the facility by their role, rather than by an assignment
+		facilityAdmin := &models.User{Username: "roles_target_admin", NameFirst: "Roles", NameLast: "Target Admin", Role: models.FacilityAdmin, FacilityID: facility.ID}
+		if err := server.Db.Conn.Create(facilityAdmin).Error; err != nil {
+			t.Fatal(err)
+		}
+		assigned := seedUser(t, facility, "roles_target_assigned", models.FacilityAdmin)
+		student := seedUser(t, facility, "roles_target_student", models.Student)
+		for _, target := range []*models.User{facilityAdmin, assigned} {
+			for _, test := range []struct {
+				method  string
+				handler http.HandlerFunc
+				body    string
+			}{
+				{http.MethodPost, server.HandleResetStudentPassword, `{"user_id": ` + strconv.Itoa(int(target.ID)) + `}`},
+				{http.MethodPatch, server.HandleUpdateUser, `{"name_first": "Renamed"}`},
+				{http.MethodDelete, server.HandleDeleteUser, ""},
+			} {
+				rr := serve(t, asUser(departmentAdmin, facility.ID, server.PermissionMiddleware(models.ManageUsers, test.handler)),
+					test.method, "/api/users/"+strconv.Itoa(int(target.ID)), test.body, map[string]string{"id": strconv.Itoa(int(target.ID))})
+				if rr.Code != http.StatusForbidden {
+					t.Errorf("%s %s: handler returned wrong status code: got %v want %v", test.method, target.Username, rr.Code, http.StatusForbidden)
+				}
+			}
+		}
+		rr := serve(t, asUser(departmentAdmin, facility.ID, server.PermissionMiddleware(models.ManageUsers, server.HandleUpdateUser)),
+			http.MethodPatch, "/api/users/"+strconv.Itoa(int(student.ID)), `{"name_first": "Renamed"}`, map[string]string{"id": strconv.Itoa(int(student.ID))})
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected a department admin to be able to update a student, got %v", rr.Code)
+		}
+		if user, err := server.Db.GetUserByID(facilityAdmin.ID); err != nil || user.NameFirst != facilityAdmin.NameFirst || !user.IsActive() {
+			t.Errorf("expected the facility admin to be left as they were, got %+v %v", user, err)
+		}
+	})
+}
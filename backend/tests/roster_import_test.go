@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRosterImport(t *testing.T) {
+	facility := seedFacility(t, "Roster Facility")
+	staff := seedUser(t, facility, "roster_staff", models.FacilityAdmin)
+	upload := func(query, roster string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("file", "roster.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = file.Write([]byte(roster))
+		form.Close()
+		req, err := http.NewRequest(http.MethodPost, "/api/users/import"+query, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rr := httptest.NewRecorder()
+		asUser(staff, facility.ID, server.PermissionMiddleware(models.ManageUsers, server.HandleImportRoster)).ServeHTTP(rr, req)
+		return rr
+	}
+	invalid := "username,name_first,name_last,email,facility\n" +
+		"roster_one,Roster,One,roster.one@example.com,\n" +
+		"roster_staff,Roster,Taken,,\n" +
+		"roster_two,Roster,Two,not-an-email,\n" +
+		"roster_one,Roster,Again,,\n" +
+		"roster_three,Roster,Three,,Nowhere Facility\n"
+
+	t.Run("TestDryRunReportsEveryProblem", func(t *testing.T) {
+		rr := upload("?dry_run=true", invalid)
+		var report handlers.RosterReport
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("unable to read report: %v %v %s", rr.Code, err, rr.Body.String())
+		}
+		if report.Valid || report.Total != 5 || report.ToCreate != 1 || report.Invalid != 4 {
+			t.Errorf("expected one valid row out of five, got %+v", report)
+		}
+		if server.Db.UsernameExists("roster_one") {
+			t.Error("expected a dry run not to create any users")
+		}
+	})
+
+	t.Run("TestInvalidRosterCreatesNothing", func(t *testing.T) {
+		if rr := upload("", invalid); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		if server.Db.UsernameExists("roster_one") {
+			t.Error("expected an invalid roster not to create any users")
+		}
+	})
+
+	t.Run("TestImportRoster", func(t *testing.T) {
+		rr := upload("", "name_last,name_first,username,role\nOne,Roster,roster_one,\nTwo,Roster,roster_two,student\n")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil || len(records) != 3 {
+			t.Fatalf("expected a password for each user, got %q (%v)", records, err)
+		}
+		for _, record := range records[1:] {
+			user := server.Db.GetUserByUsername(record[0])
+			if user == nil || user.ID == 0 || user.FacilityID != facility.ID || !user.PasswordReset || !user.CheckPasswordHash(record[4]) {
+				t.Errorf("expected %s to be created with the temporary password, got %+v", record[0], user)
+			}
+		}
+	})
+
+	t.Run("TestFailedAccountCanBeRetried", func(t *testing.T) {
+		kolibri := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		t.Cleanup(kolibri.Close)
+		// the http client isn't set up when testing
+		client := server.Client
+		server.Client = kolibri.Client()
+		t.Cleanup(func() { server.Client = client })
+		provider := &models.ProviderPlatform{Name: "Roster Kolibri", Type: models.Kolibri, State: models.Enabled, BaseUrl: kolibri.URL, AccountID: "roster"}
+		if err := server.Db.Conn.Create(provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		rr := upload("", "username,name_first,name_last,providers\nroster_four,Roster,Four,"+strconv.Itoa(int(provider.ID))+"\n")
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil || len(records) != 2 || !strings.Contains(records[1][5], "can be retried") {
+			t.Fatalf("expected the failed account to be noted, got %q (%v)", records, err)
+		}
+		_, failed, err := server.Db.GetProvisionings(1, 10, models.ProvisioningFailed, provider.ID)
+		if err != nil || len(failed) != 1 || failed[0].Username != "roster_four" || failed[0].Steps[0].Name != models.StepCreateAccount {
+			t.Errorf("expected a failed provisioning to retry, got %+v %v", failed, err)
+		}
+		if !server.Db.UsernameExists("roster_four") {
+			t.Error("expected the user to be kept when their account couldn't be created")
+		}
+	})
+}
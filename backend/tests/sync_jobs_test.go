@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"UnlockEdv2/src/jobs"
+	"UnlockEdv2/src/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestErrorResponseStatus(t *testing.T) {
+	t.Parallel()
+	rr := httptest.NewRecorder()
+	server.ErrorResponse(rr, http.StatusConflict, "job is already running")
+	var response models.Resource[interface{}]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.Message != "job is already running" {
+		t.Fatalf("expected the message as json, got %s (%v)", rr.Body.String(), err)
+	}
+	if rr.Code != http.StatusConflict || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a json response with the status, got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestSyncJobs(t *testing.T) {
+	var created models.SyncJob
+	t.Run("TestCreateSyncJob", func(t *testing.T) {
+		body, err := json.Marshal(map[string]interface{}{"provider_platform_id": 2, "type": "import_programs", "schedule": "30 1 * * 1-5"})
+		if err != nil {
+			t.Fatal("error marshalling request body")
+		}
+		req, err := http.NewRequest(http.MethodPost, "/api/sync-jobs", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.TestAsAdmin(http.HandlerFunc(server.HandleCreateSyncJob)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		resp := models.Resource[models.SyncJob]{}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || len(resp.Data) != 1 {
+			t.Fatal("error decoding response body")
+		}
+		created = resp.Data[0]
+		if created.Status != models.JobActive {
+			t.Errorf("expected new job to be active, got %s", created.Status)
+		}
+		if created.NextRunAt == nil {
+			t.Fatal("expected new job to be scheduled")
+		}
+		next := created.NextRunAt.Local()
+		if next.Hour() != 1 || next.Minute() != 30 || next.Weekday() == 0 || next.Weekday() == 6 {
+			t.Errorf("next run at %v does not match schedule", next)
+		}
+	})
+
+	t.Run("TestCreateDuplicateOrInvalidSyncJob", func(t *testing.T) {
+		cases := map[string]int{
+			`{"provider_platform_id": 2, "type": "import_programs"}`:                           http.StatusConflict,
+			`{"provider_platform_id": 2, "type": "import_activity", "schedule": "61 * * * *"}`: http.StatusBadRequest,
+			`{"provider_platform_id": 2, "type": "import_activity", "schedule": "@every 1s"}`:  http.StatusBadRequest,
+			`{"provider_platform_id": 2, "type": "import_grades"}`:                             http.StatusBadRequest,
+		}
+		for body, want := range cases {
+			req, err := http.NewRequest(http.MethodPost, "/api/sync-jobs", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.TestAsAdmin(http.HandlerFunc(server.HandleCreateSyncJob)).ServeHTTP(rr, req)
+			if rr.Code != want {
+				t.Errorf("%s: got status %v want %v", body, rr.Code, want)
+			}
+		}
+	})
+
+	t.Run("TestOneJobPerProviderAndType", func(t *testing.T) {
+		provider := &models.ProviderPlatform{Name: "Scheduled Canvas", Type: models.CanvasCloud, State: models.Enabled}
+		if err := server.Db.Conn.Create(provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			if err := server.Db.CreateDefaultSyncJobs(provider.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		total, jobs, err := server.Db.GetSyncJobs(1, 20, provider.ID)
+		if err != nil || total != int64(len(models.DefaultJobSchedules)) {
+			t.Fatalf("expected one of each default job, got %d (%v)", total, err)
+		}
+		duplicate := &models.SyncJob{ProviderPlatformID: provider.ID, Type: jobs[0].Type, Schedule: "@daily"}
+		if err := server.Db.CreateSyncJob(duplicate); err == nil {
+			t.Error("expected a second job of the same type to be refused")
+		}
+		// a deleted job can be created again
+		if err := server.Db.DeleteSyncJob(jobs[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Db.CreateSyncJob(&models.SyncJob{ProviderPlatformID: provider.ID, Type: jobs[0].Type, Schedule: "@daily"}); err != nil {
+			t.Errorf("expected the deleted job to be replaced, got %v", err)
+		}
+	})
+
+	t.Run("TestPauseSyncJob", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, "/api/sync-jobs/{id}/pause", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetPathValue("id", strconv.Itoa(int(created.ID)))
+		rr := httptest.NewRecorder()
+		server.TestAsAdmin(http.HandlerFunc(server.HandlePauseSyncJob)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		job, err := server.Db.GetSyncJobByID(created.ID)
+		if err != nil {
+			t.Fatal("unable to find sync job")
+		}
+		if job.Status != models.JobPaused {
+			t.Errorf("expected job to be paused, got %s", job.Status)
+		}
+		due, err := server.Db.GetDueSyncJobs(job.NextRunAt.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dueJob := range due {
+			if dueJob.ID == job.ID {
+				t.Error("paused job should never be due")
+			}
+		}
+	})
+}
+
+func TestSyncWorkerPool(t *testing.T) {
+	t.Parallel()
+	t.Run("TestForEachIsBounded", func(t *testing.T) {
+		items := make([]int, 50)
+		var running, peak, done atomic.Int32
+		err := jobs.ForEach(context.Background(), 4, items, func(ctx context.Context, _ int) {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				seen := peak.Load()
+				if current <= seen || peak.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			done.Add(1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done.Load() != 50 {
+			t.Errorf("expected every item to be processed, got %d", done.Load())
+		}
+		if peak.Load() > 4 {
+			t.Errorf("expected at most 4 workers at once, got %d", peak.Load())
+		}
+	})
+
+	t.Run("TestForEachStopsWhenCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var done atomic.Int32
+		err := jobs.ForEach(ctx, 2, make([]int, 100), func(ctx context.Context, _ int) {
+			if done.Add(1) == 10 {
+				cancel()
+			}
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the cancellation to be returned, got %v", err)
+		}
+		if done.Load() >= 100 {
+			t.Error("expected the remaining items to be skipped")
+		}
+	})
+}
+
+func TestSyncCursor(t *testing.T) {
+	provider := &models.ProviderPlatform{Name: "Cursor Canvas", Type: models.CanvasCloud, State: models.Enabled}
+	if err := server.Db.Conn.Create(provider).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("TestGetSetAndResetCursor", func(t *testing.T) {
+		since, err := server.Db.GetSyncCursor(provider.ID, models.ImportActivityJob)
+		if err != nil || !since.IsZero() {
+			t.Fatalf("expected no cursor before the first sync, got %v (%v)", since, err)
+		}
+		first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		for _, at := range []time.Time{first, first.Add(time.Hour)} {
+			if err := server.Db.SetSyncCursor(provider.ID, models.ImportActivityJob, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if since, err := server.Db.GetSyncCursor(provider.ID, models.ImportActivityJob); err != nil || !since.Equal(first.Add(time.Hour)) {
+			t.Errorf("expected the cursor to be moved to the latest sync, got %v (%v)", since, err)
+		}
+		if since, err := server.Db.GetSyncCursor(provider.ID, models.ImportOutcomesJob); err != nil || !since.IsZero() {
+			t.Errorf("expected each type of import to have its own cursor, got %v (%v)", since, err)
+		}
+		if err := server.Db.DeleteSyncCursor(provider.ID, models.ImportActivityJob); err != nil {
+			t.Fatal(err)
+		}
+		if since, err := server.Db.GetSyncCursor(provider.ID, models.ImportActivityJob); err != nil || !since.IsZero() {
+			t.Errorf("expected the cursor to be reset, got %v (%v)", since, err)
+		}
+	})
+
+	t.Run("TestCursorIsSentToTheMiddleware", func(t *testing.T) {
+		var received []string
+		failed := 0
+		middleware := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.URL.Query().Get("since"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"created": 1, "failed": failed})
+		}))
+		t.Cleanup(middleware.Close)
+		t.Setenv("PROVIDER_SERVICE_URL", middleware.URL)
+		job := &models.SyncJob{ProviderPlatformID: provider.ID, Type: models.ImportProgramsJob, Schedule: "0 3 * * *"}
+		if err := server.Db.CreateSyncJob(job); err != nil {
+			t.Fatal(err)
+		}
+		run := func() {
+			if _, err := server.Scheduler.Run(context.Background(), job, models.TriggerManual); err != nil {
+				t.Fatal(err)
+			}
+		}
+		run()
+		cursor, err := server.Db.GetSyncCursor(provider.ID, models.ImportProgramsJob)
+		if err != nil || cursor.IsZero() || len(received) != 1 || received[0] != "" {
+			t.Fatalf("expected the first sync to fetch everything and set the cursor, got %v %q (%v)", cursor, received, err)
+		}
+		// a run with failed rows leaves the cursor, so that they are retried
+		failed = 1
+		run()
+		if len(received) != 2 || received[1] != cursor.UTC().Format(time.RFC3339) {
+			t.Errorf("expected the cursor to be sent as since, got %q", received)
+		}
+		if after, err := server.Db.GetSyncCursor(provider.ID, models.ImportProgramsJob); err != nil || !after.Equal(cursor) {
+			t.Errorf("expected the cursor not to move after a partial import, got %v (%v)", after, err)
+		}
+		failed = 0
+		run()
+		if after, err := server.Db.GetSyncCursor(provider.ID, models.ImportProgramsJob); err != nil || !after.After(cursor) || received[2] != received[1] {
+			t.Errorf("expected a clean import from the same cursor to move it, got %v %q (%v)", after, received, err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"UnlockEdv2/src/handlers"
+	"UnlockEdv2/src/models"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTranscripts(t *testing.T) {
+	facility := seedFacility(t, "Transcript Facility")
+	student := seedUser(t, facility, "transcript_student", models.Student)
+	classmate := seedUser(t, facility, "transcript_classmate", models.Student)
+	program, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: 1, Name: "Transcribed Program"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Db.CreateOutcome(&models.Outcome{Type: models.Certificate, ProgramID: program.ID, ProgramName: program.Name, UserID: student.ID}); err != nil {
+		t.Fatal(err)
+	}
+	studentID := map[string]string{"id": strconv.Itoa(int(student.ID))}
+
+	t.Run("TestClassmateCannotPrintTranscript", func(t *testing.T) {
+		rr := serve(t, asUser(classmate, facility.ID, http.HandlerFunc(server.HandleUserTranscript)), http.MethodGet, "/api/users/{id}/transcript.pdf", "", studentID)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("TestPrintAndVerifyTranscript", func(t *testing.T) {
+		rr := serve(t, asUser(student, facility.ID, http.HandlerFunc(server.HandleUserTranscript)), http.MethodGet, "/api/users/{id}/transcript.pdf", "", studentID)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		document := rr.Body.Bytes()
+		if !bytes.HasPrefix(document, []byte("%PDF-")) || !bytes.HasSuffix(document, []byte("%%EOF\n")) || !bytes.Contains(document, []byte(program.Name)) {
+			t.Fatalf("expected a pdf listing the program, got %q", document)
+		}
+		verify := http.HandlerFunc(server.HandleVerifyTranscript)
+		documentID := map[string]string{"document_id": strings.ToLower(rr.Header().Get("X-Document-ID"))}
+		rr = serve(t, verify, http.MethodGet, "/api/transcripts/{document_id}", "", documentID)
+		var response models.Resource[handlers.TranscriptVerification]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("unable to read verification: %v %s", err, rr.Body.String())
+		}
+		sum := sha256.Sum256(document)
+		if verified := response.Data[0]; verified.Checksum != hex.EncodeToString(sum[:]) || verified.NameLast != student.NameLast || verified.Facility != facility.Name {
+			t.Errorf("expected the transcript to be verified, got %+v", verified)
+		}
+		// the name it was printed with is verified, even once the user's is changed
+		if err := server.Db.Conn.Model(student).Update("name_last", "Renamed").Error; err != nil {
+			t.Fatal(err)
+		}
+		rr = serve(t, verify, http.MethodGet, "/api/transcripts/{document_id}", "", documentID)
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 || response.Data[0].NameLast != "Student" {
+			t.Errorf("expected the name the transcript was printed with, got %s", rr.Body.String())
+		}
+		rr = serve(t, verify, http.MethodGet, "/api/transcripts/{document_id}", "", map[string]string{"document_id": "TR-AAAAA-AAAAA-AAAAA"})
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected an unknown document ID not to be found, got %v", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestTransferUser(t *testing.T) {
+	from, to := seedFacility(t, "Transfer From Facility"), seedFacility(t, "Transfer To Facility")
+	fromAdmin := seedUser(t, from, "transfer_from_admin", models.FacilityAdmin)
+	toAdmin := seedUser(t, to, "transfer_to_admin", models.FacilityAdmin)
+	learner := seedUser(t, from, "transfer_learner", models.Student)
+	offered := &models.ProviderPlatform{Name: "Transfer Kolibri", Type: models.Kolibri, State: models.Enabled}
+	notOffered := &models.ProviderPlatform{Name: "Transfer Canvas", Type: models.CanvasCloud, State: models.Enabled}
+	for _, provider := range []*models.ProviderPlatform{offered, notOffered} {
+		if err := server.Db.Conn.Create(provider).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Db.CreateProviderUserMapping(&models.ProviderUserMapping{UserID: learner.ID, ProviderPlatformID: provider.ID, ExternalUserID: "transfer-" + provider.Name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carried, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: offered.ID, Name: "Offered At Both", Type: models.OpenEnrollment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, err := server.Db.CreateProgram(&models.Program{ProviderPlatformID: notOffered.ID, Name: "Offered At From", Type: models.OpenEnrollment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, published := range []struct{ program, facility uint }{{carried.ID, from.ID}, {carried.ID, to.ID}, {left.ID, from.ID}} {
+		if err := server.Db.PublishProgram(int(published.program), published.facility); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, program := range []*models.Program{carried, left} {
+		if err := server.Db.Conn.Create(&models.Enrollment{UserID: learner.ID, ProgramID: program.ID, Status: models.EnrollmentActive}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	learnerID := map[string]string{"id": strconv.Itoa(int(learner.ID))}
+
+	t.Run("TestTransferWithDecisions", func(t *testing.T) {
+		body, err := json.Marshal(map[string]interface{}{"facility_id": to.ID, "providers": []map[string]interface{}{
+			{"provider_platform_id": offered.ID, "action": "remap", "external_user_id": "transfer-remapped"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := serve(t, server.TestAsAdmin(http.HandlerFunc(server.HandleTransferUser)), http.MethodPost, "/api/users/{id}/transfer", string(body), learnerID)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("unable to transfer user: %v %s", rr.Code, rr.Body.String())
+		}
+		var response models.Resource[models.FacilityTransfer]
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("unable to read transfer: %v", err)
+		}
+		result := response.Data[0]
+		if result.FromFacilityID != from.ID || result.ToFacilityID != to.ID || result.CarriedOver != 1 || len(result.Flagged) != 1 || result.Flagged[0].ProgramID != left.ID {
+			t.Errorf("expected one enrollment carried over and the other flagged, got %+v", result)
+		}
+		for _, mapping := range result.Mappings {
+			want := models.SuspendMapping
+			if mapping.ProviderPlatformID == offered.ID {
+				want = models.RemapMapping
+			}
+			if mapping.Action != want {
+				t.Errorf("expected provider %d to be %s, got %s", mapping.ProviderPlatformID, want, mapping.Action)
+			}
+		}
+	})
+
+	t.Run("TestTransferIsRecorded", func(t *testing.T) {
+		user, err := server.Db.GetUserByID(learner.ID)
+		if err != nil || user.FacilityID != to.ID {
+			t.Fatalf("expected the learner to be at the destination, got %v %v", user, err)
+		}
+		transfers, err := server.Db.GetTransfersForUser(learner.ID)
+		if err != nil || len(transfers) != 1 || transfers[0].TransferredByID == nil || *transfers[0].TransferredByID != 1 {
+			t.Errorf("expected the transfer to be recorded, got %+v %v", transfers, err)
+		}
+		var flagged models.Enrollment
+		if err := server.Db.Conn.First(&flagged, "user_id = ? AND program_id = ?", learner.ID, left.ID).Error; err != nil || flagged.TransferFlag == "" {
+			t.Errorf("expected the enrollment to be flagged, got %+v %v", flagged, err)
+		}
+		var suspended models.ProviderUserMapping
+		if err := server.Db.Conn.First(&suspended, "user_id = ? AND provider_platform_id = ?", learner.ID, notOffered.ID).Error; err != nil || suspended.SuspendedAt == nil {
+			t.Errorf("expected the mapping to be suspended, got %+v %v", suspended, err)
+		}
+	})
+
+	t.Run("TestAdminsAreNotified", func(t *testing.T) {
+		for _, user := range []*models.User{fromAdmin, toAdmin} {
+			total, notifications, err := server.Db.GetNotifications(1, 10, user.ID, true)
+			if err != nil || total != 1 || notifications[0].Title != "Learner transferred" {
+				t.Errorf("expected %s to be notified, got %+v %v", user.Username, notifications, err)
+			}
+		}
+	})
+
+	t.Run("TestTransferToSameFacility", func(t *testing.T) {
+		rr := serve(t, server.TestAsAdmin(http.HandlerFunc(server.HandleTransferUser)), http.MethodPost, "/api/users/{id}/transfer", `{"facility_id": `+strconv.Itoa(int(to.ID))+`}`, learnerID)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
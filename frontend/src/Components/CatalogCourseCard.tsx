@@ -54,6 +54,36 @@ export default function CatalogCourseCard({
             });
     }
 
+    function requestToJoin(e: MouseEvent) {
+        e.preventDefault();
+        axios
+            .post(`/api/programs/${course.program_id}/enrollment-requests`, {})
+            .then(() => {
+                callMutate();
+            })
+            .catch((error) => {
+                console.log(error);
+            });
+    }
+
+    let requestButton: JSX.Element;
+    if (
+        program_type == PillTagType.Permission &&
+        (course.enrollment_status == '' ||
+            course.enrollment_status == 'withdrawn')
+    ) {
+        requestButton = course.is_requested ? (
+            <GreyPill>Requested</GreyPill>
+        ) : (
+            <button
+                className="btn btn-xs btn-primary"
+                onClick={(e) => requestToJoin(e)}
+            >
+                Request to Join
+            </button>
+        );
+    }
+
     let programPill: JSX.Element;
     if (program_type == PillTagType.Open)
         programPill = <LightGreenPill>Open Enrollment</LightGreenPill>;
@@ -106,6 +136,7 @@ export default function CatalogCourseCard({
                         <p className="body">{course.provider_name}</p>
                         {programPill}
                         {outcomePills}
+                        {requestButton}
                     </div>
                     <p className="body-small h-[1rem] line-clamp-2 overflow-hidden">
                         {course.description}
@@ -149,7 +180,7 @@ export default function CatalogCourseCard({
                             {course.description}
                         </p>
                         <div className="flex flex-wrap py-1 mt-2 space-y-2">
-                            {programPill} {outcomePills}
+                            {programPill} {outcomePills} {requestButton}
                         </div>
                     </div>
                 </a>
@@ -1,6 +1,11 @@
 export enum UserRole {
     Admin = 'admin',
-    Student = 'student'
+    Student = 'student',
+    FacilityAdmin = 'facility_admin',
+    DepartmentAdmin = 'department_admin',
+    Teacher = 'teacher',
+    CaseManager = 'case_manager',
+    Auditor = 'auditor'
 }
 
 export const BROWSER_URL = '/self-service/login/browser';
@@ -119,6 +124,8 @@ export interface CourseCatalogue {
     description: string;
     is_favorited: boolean;
     outcome_types: string;
+    enrollment_status: string;
+    is_requested: boolean;
 }
 
 export interface Milestone {
@@ -155,7 +162,8 @@ export enum ProviderPlatformState {
 export enum ProviderPlatformType {
     CANVAS_CLOUD = 'canvas_cloud',
     CANVAS_OSS = 'canvas_oss',
-    KOLIBRI = 'kolibri'
+    KOLIBRI = 'kolibri',
+    MOODLE = 'moodle'
 }
 
 export interface AdminDashboardJoin {
@@ -6,7 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -14,7 +18,7 @@ import (
 
 type CanvasService struct {
 	ProviderPlatformID uint
-	Client             *http.Client
+	Client             *ProviderClient
 	BaseURL            string
 	Token              string
 	AccountID          string
@@ -29,7 +33,7 @@ func newCanvasService(provider *models.ProviderPlatform) *CanvasService {
 	headers["Accept"] = "application/json"
 	return &CanvasService{
 		ProviderPlatformID: provider.ID,
-		Client:             &http.Client{},
+		Client:             clientFor(provider.ID),
 		BaseURL:            provider.BaseUrl,
 		Token:              provider.AccessKey,
 		AccountID:          provider.AccountID,
@@ -52,95 +56,177 @@ func (srv *CanvasService) SendRequest(url string) (*http.Response, error) {
 	return resp, nil
 }
 
-func (srv *CanvasService) GetUsers(db *gorm.DB) ([]models.ImportUser, error) {
-	// TODO: handle sis, prefix, or something that accounts for sheer amt of users
-	url := srv.BaseURL + "/api/v1/accounts/" + srv.AccountID + "/users?per_page=1000"
-	log.Printf("url: %v", url)
-	resp, err := srv.SendRequest(url)
-	if err != nil {
-		log.Printf("Failed to send request: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	users := make([]map[string]interface{}, 0)
-	err = json.NewDecoder(resp.Body).Decode(&users)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("Request sent to canvas Users: %v", users)
-	unlockedUsers := make([]models.ImportUser, 0)
-	for _, user := range users {
-		name := strings.Split(user["name"].(string), " ")
-		sortable := user["sortable_name"].(string)
-		sortableName := strings.Split(sortable, ",")
-		nameFirst, nameLast := "", ""
-		if len(sortableName) > 1 {
-			nameFirst = sortableName[1]
-			nameLast = sortableName[0]
-		} else if nameFirst == "" && len(name) > 1 {
-			nameFirst = name[0]
-			nameLast = name[1]
-		} else {
-			shortName := user["short_name"].(string)
-			nameFirst = shortName
-			nameLast = name[0]
+// the largest page size canvas will honor for list endpoints
+const canvasPageSize = 100
+
+/**
+* Requests every page of a canvas list endpoint by following the rel="next"
+* links canvas sends in the Link header. Each page is decoded and handed to
+* handlePage as it arrives, returning an error from handlePage stops paging.
+**/
+func paginate[T any](srv *CanvasService, url string, handlePage func(page []T) error) error {
+	url = withPageSize(url)
+	for url != "" {
+		resp, err := srv.SendRequest(url)
+		if err != nil {
+			return err
 		}
-		userId, _ := user["id"].(float64)
-		var count int64 = 0
-		err := db.Model(&models.ProviderUserMapping{}).Where("external_user_id = ?", fmt.Sprintf("%d", int(userId))).Where("provider_platform_id = ?", srv.ProviderPlatformID).Count(&count).Error
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return errors.New("canvas responded with code: " + resp.Status)
+		}
+		page := make([]T, 0, canvasPageSize)
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
 		if err != nil {
-			log.Errorf("Error counting provider_user_mappings: %v", err)
-			continue
+			return err
+		}
+		if err := handlePage(page); err != nil {
+			return err
 		}
-		if count > 0 {
-			log.Println("User found in provider_user_mappings, not returning user to client")
-			continue
+		url = nextPageURL(resp.Header)
+	}
+	return nil
+}
+
+// for the (smaller) lists where the caller needs every item at once
+func collectPages[T any](srv *CanvasService, url string) ([]T, error) {
+	all := make([]T, 0)
+	err := paginate(srv, url, func(page []T) error {
+		all = append(all, page...)
+		return nil
+	})
+	return all, err
+}
+
+func withPageSize(url string) string {
+	if strings.Contains(url, "per_page=") {
+		return url
+	}
+	if strings.Contains(url, "?") {
+		return url + "&per_page=" + strconv.Itoa(canvasPageSize)
+	}
+	return url + "?per_page=" + strconv.Itoa(canvasPageSize)
+}
+
+/**
+* Parses an RFC 5988 Link header, e.g.
+* <https://canvas/api/v1/courses?page=2&per_page=100>; rel="next", <https://canvas/api/v1/courses?page=5&per_page=100>; rel="last"
+* returning the target of the rel="next" link, or "" on the last page
+**/
+func nextPageURL(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, found := strings.Cut(link, ";")
+			if !found {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(key, "rel") && slices.Contains(strings.Fields(strings.Trim(val, `"`)), "next") {
+					return strings.Trim(strings.TrimSpace(target), "<>")
+				}
+			}
 		}
-		unlockedUser := models.ImportUser{
-			ExternalUserID:   fmt.Sprintf("%d", int(userId)),
-			ExternalUsername: user["login_id"].(string),
-			NameFirst:        nameFirst,
-			NameLast:         nameLast,
-			Email:            user["login_id"].(string),
-			Username:         nameLast + nameFirst,
+	}
+	return ""
+}
+
+func (srv *CanvasService) GetUsers(db *gorm.DB) ([]models.ImportUser, error) {
+	url := srv.BaseURL + "/api/v1/accounts/" + srv.AccountID + "/users"
+	log.Printf("url: %v", url)
+	unlockedUsers := make([]models.ImportUser, 0)
+	err := paginate(srv, url, func(users []map[string]interface{}) error {
+		for _, user := range users {
+			if unlockedUser := srv.intoImportUser(db, user); unlockedUser != nil {
+				unlockedUsers = append(unlockedUsers, *unlockedUser)
+			}
 		}
-		log.Printf("Unlocked User: %v", unlockedUser)
-		unlockedUsers = append(unlockedUsers, unlockedUser)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to fetch users: %v", err)
+		return nil, err
 	}
 	log.Println("returning Unlocked Users")
 	return unlockedUsers, nil
 }
 
-func (srv *CanvasService) ImportPrograms(db *gorm.DB) error {
-	url := srv.BaseURL + "/api/v1/accounts/" + srv.AccountID + "/courses?include[]=course_image&include[]=public_description"
-	resp, err := srv.SendRequest(url)
+// returns nil for users which are already mapped (and have been updated in place)
+func (srv *CanvasService) intoImportUser(db *gorm.DB, user map[string]interface{}) *models.ImportUser {
+	name := strings.Split(user["name"].(string), " ")
+	sortable := user["sortable_name"].(string)
+	sortableName := strings.Split(sortable, ",")
+	nameFirst, nameLast := "", ""
+	if len(sortableName) > 1 {
+		nameFirst = sortableName[1]
+		nameLast = sortableName[0]
+	} else if nameFirst == "" && len(name) > 1 {
+		nameFirst = name[0]
+		nameLast = name[1]
+	} else {
+		shortName := user["short_name"].(string)
+		nameFirst = shortName
+		nameLast = name[0]
+	}
+	userId, _ := user["id"].(float64)
+	unlockedUser := models.ImportUser{
+		ExternalUserID:   fmt.Sprintf("%d", int(userId)),
+		ExternalUsername: user["login_id"].(string),
+		NameFirst:        nameFirst,
+		NameLast:         nameLast,
+		Email:            user["login_id"].(string),
+		Username:         nameLast + nameFirst,
+	}
+	// canvas has no way of filtering users by when they changed, so existing users are updated in place
+	mapped, err := updateMappedUser(db, srv.ProviderPlatformID, &unlockedUser)
 	if err != nil {
-		log.Printf("Failed to send request: %v", err)
-		return err
+		log.Errorf("Error updating mapped user: %v", err)
+		return nil
+	}
+	if mapped {
+		log.Println("User found in provider_user_mappings, not returning user to client")
+		return nil
 	}
+	log.Printf("Unlocked User: %v", unlockedUser)
+	return &unlockedUser
+}
+
+/**
+* Every course is listed, but a course which hasn't changed since the last sync
+* is skipped. Counting a course's assignments and quizzes takes a request each,
+* so on an incremental sync a course which was already imported keeps its count
+**/
+func (srv *CanvasService) ImportPrograms(db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	url := srv.BaseURL + "/api/v1/accounts/" + srv.AccountID + "/courses?include[]=course_image&include[]=public_description"
 	fields := log.Fields{"provider": srv.ProviderPlatformID, "Function": "ImportPrograms"}
 	log.WithFields(fields).Info("importing programs from provider")
-	defer resp.Body.Close()
-	courses := make([]map[string]interface{}, 0)
-	err = json.NewDecoder(resp.Body).Decode(&courses)
+	report := models.NewImportReport(srv.ProviderPlatformID, models.ImportProgramsJob)
+	err := paginate(srv, url, func(courses []map[string]interface{}) error {
+		for _, course := range courses {
+			srv.importCourse(db, course, since, report)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Failed to decode response: %v", err)
-		return err
+		log.Printf("Failed to fetch courses: %v", err)
+		return nil, err
 	}
-	for _, course := range courses {
-		id := int(course["id"].(float64))
-		var count int64 = 0
-		log.Infof("importing course %d", id)
-		err := db.Table("programs").Where("provider_platform_id = ?", srv.ProviderPlatformID).Where("external_id = ?", fmt.Sprintf("%d", id)).Count(&count).Error
-		if err != nil {
-			log.Error("error getting count of provider courses")
-			continue
-		}
-		if count > 0 {
-			log.Debug("skipping course id: ", id)
-			continue
-		}
-		totalMilestones := 0
+	return report, nil
+}
+
+func (srv *CanvasService) importCourse(db *gorm.DB, course map[string]interface{}, since time.Time, report *models.ImportReport) {
+	id := int(course["id"].(float64))
+	if updated, err := time.Parse(time.RFC3339, fmt.Sprint(course["updated_at"])); err == nil && updated.Before(since) {
+		report.Skip()
+		return
+	}
+	log.Infof("importing course %d", id)
+	totalMilestones := 0
+	var existing models.Program
+	if !since.IsZero() && db.Select("total_progress_milestones").Where("provider_platform_id = ? AND external_id = ?", srv.ProviderPlatformID, fmt.Sprintf("%d", id)).First(&existing).Error == nil {
+		totalMilestones = int(existing.TotalProgressMilestones)
+	} else {
 		assignments, err := srv.getCountAssignmentsForCourse(id)
 		if err != nil {
 			log.Printf("Failed to get assignments for course: %v", err)
@@ -155,54 +241,48 @@ func (srv *CanvasService) ImportPrograms(db *gorm.DB) error {
 			log.Printf("total quizzes: %d", len(quizzes))
 			totalMilestones += len(quizzes)
 		}
-		thumbnailURL := ""
-		if course["image_download_url"] != nil {
-			thumbnailURL = course["image_download_url"].(string)
-		}
-		description := course["course_code"].(string)
-		progType := "fixed_enrollment"
-		is_pub, ok := course["is_public"].(bool)
-		if !ok {
-			is_pub = false
-		}
-		outcome_types := "grade"
-		if is_pub {
-			progType = "open_enrollment"
-		} else {
-			outcome_types += ", college_credit"
-		}
+	}
+	thumbnailURL := ""
+	if course["image_download_url"] != nil {
+		thumbnailURL = course["image_download_url"].(string)
+	}
+	description := course["course_code"].(string)
+	progType := "fixed_enrollment"
+	is_pub, ok := course["is_public"].(bool)
+	if !ok {
+		is_pub = false
+	}
+	outcome_types := "grade"
+	if is_pub {
+		progType = "open_enrollment"
+	} else {
+		outcome_types += ", college_credit"
+	}
 
-		unlockedCourse := models.Program{
-			ProviderPlatformID:      srv.ProviderPlatformID,
-			Name:                    course["name"].(string),
-			AltName:                 course["course_code"].(string),
-			ExternalID:              fmt.Sprintf("%d", id),
-			ExternalURL:             srv.BaseURL + "/courses/" + fmt.Sprintf("%d", id),
-			Type:                    models.ProgramType(progType),
-			OutcomeTypes:            "grade, college_credit",
-			Description:             description,
-			ThumbnailURL:            thumbnailURL,
-			TotalProgressMilestones: uint(totalMilestones),
-		}
-		if err = db.Create(&unlockedCourse).Error; err != nil {
-			log.Printf("Failed to create program: %v", err)
-			continue
-		}
+	unlockedCourse := models.Program{
+		ProviderPlatformID:      srv.ProviderPlatformID,
+		Name:                    course["name"].(string),
+		AltName:                 course["course_code"].(string),
+		ExternalID:              fmt.Sprintf("%d", id),
+		ExternalURL:             srv.BaseURL + "/courses/" + fmt.Sprintf("%d", id),
+		Type:                    models.ProgramType(progType),
+		OutcomeTypes:            "grade, college_credit",
+		Description:             description,
+		ThumbnailURL:            thumbnailURL,
+		TotalProgressMilestones: uint(totalMilestones),
+	}
+	result, err := upsertProgram(db, &unlockedCourse)
+	if err != nil {
+		log.Printf("Failed to upsert program: %v", err)
 	}
-	return nil
+	recordUpsert(report, unlockedCourse.ExternalID, result, err)
 }
 
 func (srv *CanvasService) getQuizzesForCourse(externalCourseId string) ([]map[string]interface{}, error) {
 	url := srv.BaseURL + "/api/v1/courses/" + externalCourseId + "/quizzes"
-	resp, err := srv.SendRequest(url)
-	if err != nil {
-		log.Printf("Failed to send request: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	quizzes := make([]map[string]interface{}, 0)
-	err = json.NewDecoder(resp.Body).Decode(&quizzes)
+	quizzes, err := collectPages[map[string]interface{}](srv, url)
 	if err != nil {
+		log.Printf("Failed to fetch quizzes: %v", err)
 		return nil, err
 	}
 	return quizzes, nil
@@ -246,11 +326,11 @@ func (srv *CanvasService) getCountAssignmentsForCourse(courseId int) (int, error
 		log.Errorln("error sending graphql request for canvas:", err)
 		return 0, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		log.Errorln("response from canvas assignments for course failed with code: ", resp.Status)
-		return 0, err
+		return 0, errors.New("canvas responded with code: " + resp.Status)
 	}
-	defer resp.Body.Close()
 	assignments := make(map[string]interface{})
 	err = json.NewDecoder(resp.Body).Decode(&assignments)
 	if err != nil {
@@ -264,14 +344,7 @@ func (srv *CanvasService) getCountAssignmentsForCourse(courseId int) (int, error
 func (srv *CanvasService) getAssignmentsForCourse(courseId int) ([]interface{}, error) {
 	url := srv.BaseURL + "/api/v1/courses/" + fmt.Sprintf("%d", courseId) + "/assignments"
 	log.Printf("url: %v", url)
-	resp, err := srv.SendRequest(url)
-	if err != nil {
-		log.Printf("Failed to send request: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	assignments := []interface{}{}
-	err = json.NewDecoder(resp.Body).Decode(&assignments)
+	assignments, err := collectPages[interface{}](srv, url)
 	if err != nil {
 		log.Printf("failed to decode response from assignments %v", err)
 		return nil, err
@@ -303,29 +376,45 @@ func (srv *CanvasService) getUserSubmissionForQuiz(courseId, quizId, userId stri
 	return submissions, nil
 }
 
-func (srv *CanvasService) getUserSubmissionsForCourse(userId, courseId string) ([]map[string]interface{}, error) {
-	fields := log.Fields{"handler": "getUserSubmissionForQuiz"}
+/**
+* With a non-zero since, canvas is asked for submissions which were either
+* submitted or graded after it, and the two lists are merged
+**/
+func (srv *CanvasService) getUserSubmissionsForCourse(userId, courseId string, since time.Time) ([]map[string]interface{}, error) {
 	url := srv.BaseURL + "/api/v1/courses/" + courseId + "/students/submissions?student_ids[]=" + userId
-	log.WithFields(fields).Printf("url: %v", url)
-	resp, err := srv.SendRequest(url)
+	if since.IsZero() {
+		return srv.getSubmissions(url)
+	}
+	sinceParam := since.UTC().Format(time.RFC3339)
+	submitted, err := srv.getSubmissions(url + "&submitted_since=" + sinceParam)
 	if err != nil {
-		log.WithFields(fields).Printf("getUserSubmissionForQuiz Failed to send request: %v", err)
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		log.WithFields(fields).Errorln("canvas responded with code: ", resp.Status)
-		return nil, errors.New("canvas responded with code: " + resp.Status)
-	}
-	defer resp.Body.Close()
-	var submission []map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&submission)
+	graded, err := srv.getSubmissions(url + "&graded_since=" + sinceParam)
 	if err != nil {
 		return nil, err
 	}
-	if submission == nil {
-		return nil, fmt.Errorf("submission not found")
+	seen := make(map[float64]bool, len(submitted))
+	for _, submission := range submitted {
+		seen[submission["id"].(float64)] = true
+	}
+	for _, submission := range graded {
+		if !seen[submission["id"].(float64)] {
+			submitted = append(submitted, submission)
+		}
 	}
-	return submission, nil
+	return submitted, nil
+}
+
+func (srv *CanvasService) getSubmissions(url string) ([]map[string]interface{}, error) {
+	fields := log.Fields{"handler": "getSubmissions"}
+	log.WithFields(fields).Printf("url: %v", url)
+	submissions, err := collectPages[map[string]interface{}](srv, url)
+	if err != nil {
+		log.WithFields(fields).Errorf("failed to fetch submissions: %v", err)
+		return nil, err
+	}
+	return submissions, nil
 }
 
 /**
@@ -341,30 +430,31 @@ func (srv *CanvasService) getUserSubmissionsForCourse(userId, courseId string) (
 * get submissions for the user for each quiz
 *  /api/v1/courses/:course_id/quizzes/:quiz_id/submissions/:user_id
 * */
-func (srv *CanvasService) ImportMilestonesForProgramUser(userId, courseId uint, db *gorm.DB) error {
+func (srv *CanvasService) ImportMilestonesForProgramUser(userId, courseId uint, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
 	fields := log.Fields{"handler": "ImportMilestonesForProgramUser", "user_id": userId, "course_id": courseId}
 	var user models.User
 	if err := db.Model(models.User{}).Where("id = ?", userId).First(&user).Error; err != nil {
 		log.WithFields(fields).Errorln("unable to find user")
-		return err
+		return nil, err
 	}
 	externalId, err := user.GetExternalIDFromProvider(db, srv.ProviderPlatformID)
 	if err != nil {
 		log.WithFields(fields).Errorln("unable to find external user login")
-		return err
+		return nil, err
 	}
 	log.WithFields(fields).Debugf("external id for user %d: %s", user.ID, externalId)
 	var courseID string
 	err = db.Model(models.Program{}).Select("external_id").Where("id = ?", courseId).First(&courseID).Error
 	if err != nil {
 		log.Errorln("failed to get program with id in GetMilestonesForProgramUser: ", courseId)
-		return err
+		return nil, err
 	}
-	submissions, err := srv.getUserSubmissionsForCourse(externalId, courseID)
+	submissions, err := srv.getUserSubmissionsForCourse(externalId, courseID, since)
 	if err != nil {
 		log.Printf("Failed to get submission for assignment: %v", err)
-		return err
+		return nil, err
 	}
+	report := models.NewImportReport(srv.ProviderPlatformID, models.ImportMilestonesJob)
 	for _, submission := range submissions {
 		milestone := models.Milestone{
 			UserID:      uint(userId),
@@ -373,16 +463,17 @@ func (srv *CanvasService) ImportMilestonesForProgramUser(userId, courseId uint,
 			Type:        "assignment_submission",
 			IsCompleted: submission["workflow_state"] == "complete" || submission["workflow_state"] == "graded",
 		}
-		err = db.Create(&milestone).Error
+		result, err := upsertMilestone(db, &milestone)
 		if err != nil {
 			log.Errorln("failed to create milestone in GetMilestonesForProgramUser: ", err)
-			continue
 		}
+		recordUpsert(report, milestone.ExternalID, result, err)
 	}
 	quizzes, err := srv.getQuizzesForCourse(courseID)
 	if err != nil {
 		// just return what we have
-		return nil
+		report.Fail("quizzes for course "+courseID, err)
+		return report, nil
 	}
 	for _, quiz := range quizzes {
 		// go through each quiz and see if we have a submission from the user
@@ -390,78 +481,432 @@ func (srv *CanvasService) ImportMilestonesForProgramUser(userId, courseId uint,
 		if submission, err := srv.getUserSubmissionForQuiz(courseID, fmt.Sprintf("%d", quizId), externalId); err == nil {
 			state, ok := submission["workflow_state"].(string)
 			if !ok || state == "untaken" {
+				report.Skip()
 				continue
 			}
-			milestoneType := "quiz_assignment"
-			if state == "complete" {
-				milestoneType = "quiz_submission"
+			if finished, err := time.Parse(time.RFC3339, fmt.Sprint(submission["finished_at"])); err == nil && finished.Before(since) {
+				report.Skip()
+				continue
 			}
+			milestoneType := "quiz_assignment"
 			if state == "complete" {
 				milestoneType = "quiz_completion"
 			}
+			submissionId, ok := submission["id"].(float64)
+			if !ok {
+				report.Fail(fmt.Sprintf("quiz %d", quizId), errors.New("quiz submission is missing its id"))
+				continue
+			}
 			milestone := models.Milestone{
-				ExternalID: submission["id"].(string),
-				UserID:     userId,
-				ProgramID:  courseId,
-				Type:       models.MilestoneType(milestoneType),
+				ExternalID:  fmt.Sprintf("%d", int(submissionId)),
+				UserID:      userId,
+				ProgramID:   courseId,
+				Type:        models.MilestoneType(milestoneType),
+				IsCompleted: state == "complete",
 			}
-			err := db.Create(&milestone).Error
+			result, err := upsertMilestone(db, &milestone)
 			if err != nil {
 				log.Errorln("failed to create milestone in GetMilestonesForProgramUser: ", err)
-				continue
 			}
+			recordUpsert(report, milestone.ExternalID, result, err)
 		}
 	}
-	return nil
+	return report, nil
 }
 
-func (srv *CanvasService) getEnrollmentsForCourse(courseId string) ([]map[string]interface{}, error) {
+// streams each page of student enrollments in the course to handlePage
+func (srv *CanvasService) getEnrollmentsForCourse(courseId string, handlePage func([]map[string]interface{}) error) error {
 	url := srv.BaseURL + "/api/v1/courses/" + courseId + "/enrollments?state[]=active&state[]=invited&type[]=StudentEnrollment"
-	resp, err := srv.SendRequest(url)
+	return paginate(srv, url, handlePage)
+}
+
+func (srv *CanvasService) ImportActivityForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	var program models.Program
+	if err := db.Model(models.Program{}).Select("id").Where("provider_platform_id = ? AND external_id = ?", srv.ProviderPlatformID, courseId).First(&program).Error; err != nil {
+		log.Printf("Failed to get program: %v", err)
+		return nil, err
+	}
+	report := models.NewImportReport(srv.ProviderPlatformID, models.ImportActivityJob)
+	err := srv.getEnrollmentsForCourse(courseId, func(enrollments []map[string]interface{}) error {
+		for _, enrollment := range enrollments {
+			srv.importEnrollmentActivity(db, &program, enrollment, since, report)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Failed to send request: %v", err)
+		log.Printf("Failed to get enrollments for course: %v", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	enrollments := make([]map[string]interface{}, 0)
-	err = json.NewDecoder(resp.Body).Decode(&enrollments)
+	return report, nil
+}
+
+func (srv *CanvasService) importEnrollmentActivity(db *gorm.DB, program *models.Program, enrollment map[string]interface{}, since time.Time, report *models.ImportReport) {
+	courseId := program.ExternalID
+	// no activity since the last sync means total_activity_time hasn't changed either
+	if lastActivity, err := time.Parse(time.RFC3339, fmt.Sprint(enrollment["last_activity_at"])); err == nil && lastActivity.Before(since) {
+		report.Skip()
+		return
+	}
+	userId := fmt.Sprintf("%d", int(enrollment["user_id"].(float64)))
+	unlockedUserID := mappedUserID(db, srv.ProviderPlatformID, userId)
+	if unlockedUserID == 0 {
+		// the user hasn't been imported into UnlockEd, or their account was suspended
+		report.Skip()
+		return
+	}
+	activity := models.Activity{
+		ExternalID: courseId,
+		UserID:     unlockedUserID,
+		Type:       "interaction",
+		TotalTime:  uint(enrollment["total_activity_time"].(float64)),
+		ProgramID:  program.ID,
+	}
+	// NOTE: this is calling a stored procedure to calculate the time delta
+	if err := db.Exec("SELECT insert_daily_activity(?, ?, ?, ?, ?)", activity.UserID, activity.ProgramID, activity.Type, activity.TotalTime, activity.ExternalID).Error; err != nil {
+		log.WithFields(log.Fields{"userId": unlockedUserID, "program_id": courseId, "error": err}).Error("Failed to create activity")
+		report.Fail(userId, err)
+		return
+	}
+	report.Create()
+}
+
+type CanvasEnrollment struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	CourseSectionID int        `json:"course_section_id"`
+	EnrollmentState string     `json:"enrollment_state"`
+	StartAt         *time.Time `json:"start_at"`
+	EndAt           *time.Time `json:"end_at"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	Grades          struct {
+		FinalScore *float64 `json:"final_score"`
+		FinalGrade *string  `json:"final_grade"`
+	} `json:"grades"`
+}
+
+type CanvasCourseUser struct {
+	ID             int `json:"id"`
+	CourseProgress *struct {
+		RequirementCount          int        `json:"requirement_count"`
+		RequirementCompletedCount int        `json:"requirement_completed_count"`
+		CompletedAt               *time.Time `json:"completed_at"`
+	} `json:"course_progress"`
+}
+
+/**
+* A student has completed the course once their enrollment is concluded, or
+* they have met every requirement of the course's modules. Each of them gets
+* a progress_completion outcome, and a grade outcome with their final grade.
+* Grades can change after the fact, so since is ignored and every student is
+* checked on each import.
+**/
+func (srv *CanvasService) ImportOutcomesForProgram(courseId string, db *gorm.DB, _ time.Time) (*models.ImportReport, error) {
+	var program models.Program
+	if err := db.Select("id", "name").First(&program, "provider_platform_id = ? AND external_id = ?", srv.ProviderPlatformID, courseId).Error; err != nil {
+		log.Printf("Failed to get program: %v", err)
+		return nil, err
+	}
+	completed := make(map[int]bool)
+	url := srv.BaseURL + "/api/v1/courses/" + courseId + "/users?enrollment_type[]=student&include[]=course_progress"
+	err := paginate(srv, url, func(users []CanvasCourseUser) error {
+		for _, user := range users {
+			if user.CourseProgress != nil && user.CourseProgress.CompletedAt != nil {
+				completed[user.ID] = true
+			}
+		}
+		return nil
+	})
 	if err != nil {
+		log.Printf("Failed to get course progress: %v", err)
 		return nil, err
 	}
-	return enrollments, nil
+	report := models.NewImportReport(srv.ProviderPlatformID, models.ImportOutcomesJob)
+	url = srv.BaseURL + "/api/v1/courses/" + courseId + "/enrollments?type[]=StudentEnrollment&state[]=active&state[]=completed"
+	err = paginate(srv, url, func(enrollments []CanvasEnrollment) error {
+		for _, enrollment := range enrollments {
+			externalUserID := strconv.Itoa(enrollment.UserID)
+			userID := mappedUserID(db, srv.ProviderPlatformID, externalUserID)
+			if userID == 0 || (enrollment.EnrollmentState != "completed" && !completed[enrollment.UserID]) {
+				report.Skip()
+				continue
+			}
+			completion := models.Outcome{Type: models.ProgressCompletion, ProgramID: program.ID, ProgramName: program.Name, UserID: userID, Value: "100"}
+			result, err := upsertOutcome(db, &completion)
+			recordUpsert(report, externalUserID, result, err)
+			grade := enrollment.finalGrade()
+			if grade == "" {
+				continue
+			}
+			outcome := models.Outcome{Type: models.ProgramCompletion, ProgramID: program.ID, ProgramName: program.Name, UserID: userID, Value: grade}
+			result, err = upsertOutcome(db, &outcome)
+			recordUpsert(report, externalUserID, result, err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to get enrollments for course: %v", err)
+		return nil, err
+	}
+	return report, nil
+}
+
+// the letter grade if the course has a grading scheme, otherwise the score
+func (enrollment *CanvasEnrollment) finalGrade() string {
+	if enrollment.Grades.FinalGrade != nil && *enrollment.Grades.FinalGrade != "" {
+		return *enrollment.Grades.FinalGrade
+	}
+	if enrollment.Grades.FinalScore != nil {
+		return strconv.FormatFloat(*enrollment.Grades.FinalScore, 'f', -1, 64)
+	}
+	return ""
+}
+
+// canvas enrollment states, by the status of the enrollment in UnlockEd
+var canvasEnrollmentStates = map[string]models.EnrollmentStatus{
+	"active":           models.EnrollmentActive,
+	"invited":          models.EnrollmentPending,
+	"creation_pending": models.EnrollmentPending,
+	"completed":        models.EnrollmentCompleted,
+	"inactive":         models.EnrollmentWithdrawn,
+	"rejected":         models.EnrollmentWithdrawn,
+	"deleted":          models.EnrollmentWithdrawn,
 }
 
-func (srv *CanvasService) ImportActivityForProgram(courseId string, db *gorm.DB) error {
-	enrollments, err := srv.getEnrollmentsForCourse(courseId)
+/**
+* Every student enrollment in the course, whatever its state, so that students who
+* leave (or finish) a course are withdrawn (or completed) in UnlockEd as well.
+* Enrollments that haven't changed since the last sync are skipped.
+**/
+func (srv *CanvasService) ImportEnrollmentsForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	var program models.Program
+	if err := db.Select("id").First(&program, "provider_platform_id = ? AND external_id = ?", srv.ProviderPlatformID, courseId).Error; err != nil {
+		log.Printf("Failed to get program: %v", err)
+		return nil, err
+	}
+	report := models.NewImportReport(srv.ProviderPlatformID, models.ImportEnrollmentsJob)
+	url := srv.BaseURL + "/api/v1/courses/" + courseId + "/enrollments?type[]=StudentEnrollment" +
+		"&state[]=active&state[]=invited&state[]=creation_pending&state[]=completed&state[]=inactive&state[]=rejected&state[]=deleted"
+	err := paginate(srv, url, func(enrollments []CanvasEnrollment) error {
+		for _, enrollment := range enrollments {
+			externalUserID := strconv.Itoa(enrollment.UserID)
+			userID := mappedUserID(db, srv.ProviderPlatformID, externalUserID)
+			if userID == 0 || (enrollment.UpdatedAt != nil && enrollment.UpdatedAt.Before(since)) {
+				report.Skip()
+				continue
+			}
+			status, ok := canvasEnrollmentStates[enrollment.EnrollmentState]
+			if !ok {
+				report.Skip()
+				continue
+			}
+			imported := models.Enrollment{
+				UserID:     userID,
+				ProgramID:  program.ID,
+				Status:     status,
+				StartDate:  enrollment.StartAt,
+				EndDate:    enrollment.EndAt,
+				ExternalID: strconv.Itoa(enrollment.ID),
+			}
+			if imported.StartDate == nil {
+				imported.StartDate = enrollment.CreatedAt
+			}
+			if imported.EndDate == nil && status == models.EnrollmentCompleted {
+				imported.EndDate = enrollment.CompletedAt
+			}
+			result, err := upsertEnrollment(db, &imported)
+			recordUpsert(report, imported.ExternalID, result, err)
+		}
+		return nil
+	})
 	if err != nil {
 		log.Printf("Failed to get enrollments for course: %v", err)
+		return nil, err
+	}
+	return report, nil
+}
+
+// sends a form to a canvas endpoint which changes something, decoding the response into result (if not nil)
+func (srv *CanvasService) sendForm(method, url string, form url.Values, result interface{}) error {
+	req, err := http.NewRequest(method, url, strings.NewReader(form.Encode()))
+	if err != nil {
 		return err
 	}
-	for _, enrollment := range enrollments {
-		userId := fmt.Sprintf("%d", int(enrollment["user_id"].(float64)))
-		var user models.ProviderUserMapping
-		err := db.Model(models.ProviderUserMapping{}).Select("user_id").Where("provider_platform_id = ?", srv.ProviderPlatformID).Where("external_user_id = ?", userId).Find(&user).Error
-		if err != nil {
-			log.Printf("Failed to get user: %v", err)
-			continue
+	for key, value := range *srv.BaseHeaders {
+		req.Header.Add(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := srv.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("canvas responded to %s %s with code: %s", method, req.URL.Path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+/**
+* The user's student enrollment in the course: the one UnlockEd knows the id of,
+* otherwise the one which hasn't been deleted (nil if they have never been enrolled)
+**/
+func (srv *CanvasService) findEnrollment(courseId, externalID, externalUserID string) (*CanvasEnrollment, error) {
+	url := srv.BaseURL + "/api/v1/courses/" + courseId + "/enrollments?type[]=StudentEnrollment&user_id=" + externalUserID +
+		"&state[]=active&state[]=invited&state[]=creation_pending&state[]=completed&state[]=inactive&state[]=deleted"
+	enrollments, err := collectPages[CanvasEnrollment](srv, url)
+	if err != nil {
+		return nil, err
+	}
+	var found *CanvasEnrollment
+	for idx := range enrollments {
+		enrollment := &enrollments[idx]
+		if externalID != "" && strconv.Itoa(enrollment.ID) == externalID {
+			return enrollment, nil
 		}
-		var program models.Program
-		if err = db.Model(models.Program{}).Select("id").Where("provider_platform_id = ? AND external_id = ?", []interface{}{srv.ProviderPlatformID, courseId}).First(&program).Error; err != nil {
-			log.Printf("Failed to get program: %v", err)
-			continue
+		if found == nil && enrollment.EnrollmentState != "deleted" {
+			found = enrollment
 		}
-		activity := models.Activity{
-			ExternalID: courseId,
-			UserID:     user.UserID,
-			Type:       "interaction",
-			TotalTime:  uint(enrollment["total_activity_time"].(float64)),
-			ProgramID:  program.ID,
+	}
+	return found, nil
+}
+
+// the id of the course's section for a facility, which is created the first time it's needed
+func (srv *CanvasService) facilitySection(courseId, name string) (int, error) {
+	sections, err := collectPages[struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}](srv, srv.BaseURL+"/api/v1/courses/"+courseId+"/sections")
+	if err != nil {
+		return 0, err
+	}
+	for _, section := range sections {
+		if section.Name == name {
+			return section.ID, nil
 		}
-		// NOTE: this is calling a stored procedure to calculate the time delta
-		if err := db.Exec("SELECT insert_daily_activity(?, ?, ?, ?, ?)", activity.UserID, activity.ProgramID, activity.Type, activity.TotalTime, activity.ExternalID).Error; err != nil {
-			log.WithFields(log.Fields{"userId": user.ID, "program_id": courseId, "error": err}).Error("Failed to create activity")
-			continue
+	}
+	var created struct {
+		ID int `json:"id"`
+	}
+	form := url.Values{"course_section[name]": {name}}
+	if err := srv.sendForm(http.MethodPost, srv.BaseURL+"/api/v1/courses/"+courseId+"/sections", form, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// the task which ends an enrollment, for each status in which a student no longer takes part in the course
+var canvasEnrollmentTasks = map[models.EnrollmentStatus]string{
+	models.EnrollmentPending:    "deactivate",
+	models.EnrollmentWaitlisted: "deactivate",
+	models.EnrollmentWithdrawn:  "deactivate",
+	models.EnrollmentCompleted:  "conclude",
+}
+
+// the state canvas puts an enrollment in for each task
+var canvasTaskStates = map[string]string{
+	"deactivate": "inactive",
+	"conclude":   "completed",
+	"delete":     "deleted",
+}
+
+func (srv *CanvasService) endEnrollment(courseId string, enrollment *CanvasEnrollment, task string) error {
+	if enrollment.EnrollmentState == canvasTaskStates[task] {
+		return nil
+	}
+	url := fmt.Sprintf("%s/api/v1/courses/%s/enrollments/%d?task=%s", srv.BaseURL, courseId, enrollment.ID, task)
+	return srv.sendForm(http.MethodDelete, url, nil, nil)
+}
+
+/**
+* Active learners are enrolled as students in the section for their facility.
+* An inactive enrollment is reactivated, but a concluded one can't be reopened,
+* and canvas can't move an enrollment between sections, so in either case the
+* student is enrolled again (and the old enrollment in another section deleted).
+* In any other status, the student's enrollment is concluded or deactivated.
+**/
+func (srv *CanvasService) PushEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) (string, error) {
+	externalUserID, err := mappedExternalUserID(db, srv.ProviderPlatformID, enrollment.UserID)
+	if err != nil {
+		return "", err
+	}
+	existing, err := srv.findEnrollment(courseId, enrollment.ExternalID, externalUserID)
+	if err != nil {
+		return "", err
+	}
+	if enrollment.Status != models.EnrollmentActive {
+		if existing == nil {
+			return "", nil
 		}
+		return strconv.Itoa(existing.ID), srv.endEnrollment(courseId, existing, canvasEnrollmentTasks[enrollment.Status])
 	}
-	return nil
+	section := 0
+	if name := facilityGroupName(db, enrollment.UserID); name != "" {
+		if section, err = srv.facilitySection(courseId, name); err != nil {
+			return "", err
+		}
+	}
+	if existing != nil && (section == 0 || existing.CourseSectionID == section) {
+		switch existing.EnrollmentState {
+		case "active", "invited", "creation_pending":
+			return strconv.Itoa(existing.ID), nil
+		case "inactive":
+			url := fmt.Sprintf("%s/api/v1/courses/%s/enrollments/%d/reactivate", srv.BaseURL, courseId, existing.ID)
+			return strconv.Itoa(existing.ID), srv.sendForm(http.MethodPut, url, nil, nil)
+		}
+	}
+	form := url.Values{
+		"enrollment[user_id]":          {externalUserID},
+		"enrollment[type]":             {"StudentEnrollment"},
+		"enrollment[enrollment_state]": {"active"},
+		"enrollment[notify]":           {"false"},
+	}
+	if section != 0 {
+		form.Set("enrollment[course_section_id]", strconv.Itoa(section))
+	}
+	if enrollment.StartDate != nil {
+		form.Set("enrollment[start_at]", enrollment.StartDate.UTC().Format(time.RFC3339))
+	}
+	if enrollment.EndDate != nil {
+		form.Set("enrollment[end_at]", enrollment.EndDate.UTC().Format(time.RFC3339))
+	}
+	var created CanvasEnrollment
+	if err := srv.sendForm(http.MethodPost, srv.BaseURL+"/api/v1/courses/"+courseId+"/enrollments", form, &created); err != nil {
+		return "", err
+	}
+	if existing != nil && existing.CourseSectionID != section && existing.ID != created.ID {
+		if err := srv.endEnrollment(courseId, existing, "delete"); err != nil {
+			log.Errorf("failed to delete enrollment %d in the previous section: %v", existing.ID, err)
+		}
+	}
+	return strconv.Itoa(created.ID), nil
+}
+
+func (srv *CanvasService) RemoveEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) error {
+	externalUserID, err := mappedExternalUserID(db, srv.ProviderPlatformID, enrollment.UserID)
+	if err != nil {
+		return err
+	}
+	existing, err := srv.findEnrollment(courseId, enrollment.ExternalID, externalUserID)
+	if err != nil || existing == nil {
+		return err
+	}
+	return srv.endEnrollment(courseId, existing, "delete")
+}
+
+// suspends the user in canvas, so they can't log in, their enrollments and submissions are kept
+func (srv *CanvasService) SetUserActive(userId uint, active bool, db *gorm.DB) error {
+	externalUserID, err := userAccountID(db, srv.ProviderPlatformID, userId)
+	if err != nil {
+		return err
+	}
+	event := "suspend"
+	if active {
+		event = "unsuspend"
+	}
+	return srv.sendForm(http.MethodPut, srv.BaseURL+"/api/v1/users/"+externalUserID, url.Values{"user[event]": {event}}, nil)
 }
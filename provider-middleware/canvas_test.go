@@ -0,0 +1,409 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+* A canvas course with no sections or students, which records the student
+* enrollments made through it (and what happens to them)
+**/
+func newCanvasCourseStub(t *testing.T, enrollments *[]CanvasEnrollment, sections *[]string) *httptest.Server {
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}
+	mux.HandleFunc("GET /api/v1/courses/10/enrollments", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, *enrollments)
+	})
+	mux.HandleFunc("GET /api/v1/courses/10/sections", func(w http.ResponseWriter, r *http.Request) {
+		found := []map[string]interface{}{}
+		for idx, name := range *sections {
+			found = append(found, map[string]interface{}{"id": idx + 1, "name": name})
+		}
+		writeJSON(w, found)
+	})
+	mux.HandleFunc("POST /api/v1/courses/10/sections", func(w http.ResponseWriter, r *http.Request) {
+		*sections = append(*sections, r.FormValue("course_section[name]"))
+		writeJSON(w, map[string]interface{}{"id": len(*sections)})
+	})
+	mux.HandleFunc("POST /api/v1/courses/10/enrollments", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("enrollment[user_id]") != "c-user" || r.FormValue("enrollment[type]") != "StudentEnrollment" {
+			t.Errorf("unexpected enrollment: %v", r.Form)
+		}
+		enrollment := CanvasEnrollment{ID: 70 + len(*enrollments), EnrollmentState: "active"}
+		enrollment.CourseSectionID = len(*sections)
+		*enrollments = append(*enrollments, enrollment)
+		writeJSON(w, enrollment)
+	})
+	mux.HandleFunc("DELETE /api/v1/courses/10/enrollments/{enrollment}", func(w http.ResponseWriter, r *http.Request) {
+		for idx := range *enrollments {
+			if strconv.Itoa((*enrollments)[idx].ID) == r.PathValue("enrollment") {
+				(*enrollments)[idx].EnrollmentState = canvasTaskStates[r.URL.Query().Get("task")]
+			}
+		}
+		writeJSON(w, map[string]interface{}{})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestCanvasPaginate(t *testing.T) {
+	var requested []string
+	links := map[string]string{}
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+		}
+		requested = append(requested, page+"/"+r.URL.Query().Get("per_page"))
+		if link, ok := links[page]; ok {
+			w.Header().Set("Link", link)
+		}
+		first, _ := strconv.Atoi(page)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]int{first*2 - 1, first * 2})
+	}))
+	t.Cleanup(stub.Close)
+	service := newCanvasService(&models.ProviderPlatform{BaseUrl: stub.URL, AccessKey: "token"})
+	page := func(n int) string { return "<" + stub.URL + "/api/v1/items?page=" + strconv.Itoa(n) + "&per_page=100>" }
+
+	t.Run("TestFollowsNextLinks", func(t *testing.T) {
+		requested, links = nil, map[string]string{
+			"1": page(1) + `; rel="current", ` + page(2) + `; rel="next", ` + page(3) + `; rel="last"`,
+			"2": page(1) + `; rel="prev", ` + page(3) + `; rel="next"`,
+			"3": page(2) + `; rel="prev", ` + page(3) + `; rel="last"`,
+		}
+		items, err := collectPages[int](service, stub.URL+"/api/v1/items")
+		if err != nil || len(items) != 6 || items[5] != 6 {
+			t.Errorf("expected the items of all three pages, got %v (%v)", items, err)
+		}
+		if strings.Join(requested, ",") != "1/100,2/100,3/100" {
+			t.Errorf("expected each page to be requested once, at the largest page size, got %v", requested)
+		}
+	})
+
+	t.Run("TestStopsAtMalformedLink", func(t *testing.T) {
+		requested, links = nil, map[string]string{"1": stub.URL + `/api/v1/items?page=2 rel="next"`}
+		items, err := collectPages[int](service, stub.URL+"/api/v1/items")
+		if err != nil || len(items) != 2 || len(requested) != 1 {
+			t.Errorf("expected only the first page, got %v %v (%v)", items, requested, err)
+		}
+	})
+
+	t.Run("TestErrorStopsPaging", func(t *testing.T) {
+		requested, links = nil, map[string]string{"1": page(2) + `; rel="next"`}
+		err := paginate(service, stub.URL+"/api/v1/items", func(page []int) error { return errors.New("stop") })
+		if err == nil || len(requested) != 1 {
+			t.Errorf("expected paging to stop at the first page, got %v (%v)", requested, err)
+		}
+	})
+}
+
+func TestNextPageURL(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		links []string
+		want  string
+	}{
+		{"no link", nil, ""},
+		{"last page", []string{`<https://canvas/a?page=1>; rel="prev", <https://canvas/a?page=2>; rel="last"`}, ""},
+		{"next", []string{`<https://canvas/a?page=1>; rel="prev", <https://canvas/a?page=3>; rel="next"`}, "https://canvas/a?page=3"},
+		{"unquoted rel", []string{`<https://canvas/a?page=2>; rel=next`}, "https://canvas/a?page=2"},
+		{"several rels", []string{`<https://canvas/a?page=2>; rel="next last"`}, "https://canvas/a?page=2"},
+		{"separate headers", []string{`<https://canvas/a?page=1>; rel="first"`, `<https://canvas/a?page=2>; rel="next"`}, "https://canvas/a?page=2"},
+		{"no parameters", []string{`<https://canvas/a?page=2>`}, ""},
+		{"no rel", []string{`<https://canvas/a?page=2>; title="next"`}, ""},
+		{"garbage", []string{`next`}, ""},
+	} {
+		header := http.Header{}
+		for _, link := range test.links {
+			header.Add("Link", link)
+		}
+		if got := nextPageURL(header); got != test.want {
+			t.Errorf("%s: got %q want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCanvasPushEnrollment(t *testing.T) {
+	var (
+		enrollments []CanvasEnrollment
+		sections    []string
+	)
+	stub := newCanvasCourseStub(t, &enrollments, &sections)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.CanvasCloud, Name: "Canvas", BaseUrl: stub.URL, AccessKey: "token", State: models.Enabled}
+	facility := models.Facility{Name: "North Unit"}
+	for _, row := range []interface{}{&provider, &facility} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := models.User{Username: "student", NameFirst: "Sam", NameLast: "Student", Email: "student@unlocked.v2", Password: "password", FacilityID: facility.ID}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	mapping := models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: "c-user", ExternalUsername: "student"}
+	if err := db.Create(&mapping).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := newCanvasService(&provider)
+
+	enrollment := models.Enrollment{UserID: user.ID, Status: models.EnrollmentActive}
+	externalID, err := service.PushEnrollment("10", &enrollment, db)
+	if err != nil {
+		t.Fatalf("unable to push enrollment: %v", err)
+	}
+	if externalID != "70" || len(sections) != 1 || sections[0] != facility.Name || enrollments[0].CourseSectionID != 1 {
+		t.Errorf("expected the student to be enrolled in the section for their facility, got %q %v %+v", externalID, sections, enrollments)
+	}
+	// pushing the same status again changes nothing
+	if externalID, err := service.PushEnrollment("10", &enrollment, db); err != nil || externalID != "70" || len(enrollments) != 1 {
+		t.Errorf("expected the existing enrollment to be kept, got %q (%v) %+v", externalID, err, enrollments)
+	}
+
+	enrollment.Status, enrollment.ExternalID = models.EnrollmentCompleted, externalID
+	if _, err := service.PushEnrollment("10", &enrollment, db); err != nil {
+		t.Fatalf("unable to push enrollment: %v", err)
+	}
+	if enrollments[0].EnrollmentState != "completed" {
+		t.Errorf("expected the enrollment to be concluded, got %+v", enrollments[0])
+	}
+
+	unmapped := models.Enrollment{UserID: user.ID + 1, Status: models.EnrollmentActive}
+	if _, err := service.PushEnrollment("10", &unmapped, db); err != errUnmappedUser {
+		t.Errorf("expected a user without a canvas account to fail, got %v", err)
+	}
+}
+
+func TestCanvasSetUserActive(t *testing.T) {
+	var events []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/users/c-user", func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, r.FormValue("user[event]"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": 5}`))
+	})
+	stub := httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.ProviderPlatform{}, &models.ProviderUserMapping{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.CanvasCloud, Name: "Canvas", BaseUrl: stub.URL, AccessKey: "token", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	// a suspended mapping is still the user's account, and is deactivated with them
+	now := time.Now()
+	mapping := models.ProviderUserMapping{UserID: 7, ProviderPlatformID: provider.ID, ExternalUserID: "c-user", ExternalUsername: "student", SuspendedAt: &now}
+	if err := db.Create(&mapping).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := newCanvasService(&provider)
+	for _, active := range []bool{false, true} {
+		if err := service.SetUserActive(7, active, db); err != nil {
+			t.Fatalf("unable to change the user's account: %v", err)
+		}
+	}
+	if len(events) != 2 || events[0] != "suspend" || events[1] != "unsuspend" {
+		t.Errorf("expected the user to be suspended and unsuspended, got %v", events)
+	}
+	if err := service.SetUserActive(8, false, db); err != errUnmappedUser {
+		t.Errorf("expected a user without a canvas account to fail, got %v", err)
+	}
+}
+
+func TestCanvasImportOutcomes(t *testing.T) {
+	grade := "B"
+	score := 91.5
+	enrollments := []CanvasEnrollment{
+		{ID: 1, UserID: 1, EnrollmentState: "active"},    // has met every requirement, with a letter grade
+		{ID: 2, UserID: 2, EnrollmentState: "completed"}, // concluded, with only a score
+		{ID: 3, UserID: 3, EnrollmentState: "active"},    // still working through the course
+		{ID: 4, UserID: 4, EnrollmentState: "completed"}, // hasn't been imported
+	}
+	enrollments[0].Grades.FinalGrade = &grade
+	enrollments[1].Grades.FinalScore = &score
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/courses/20/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"id": 1, "course_progress": {"requirement_count": 3, "requirement_completed_count": 3, "completed_at": "2024-05-01T10:00:00Z"}},
+			{"id": 3, "course_progress": {"requirement_count": 3, "requirement_completed_count": 1, "completed_at": null}}]`))
+	})
+	mux.HandleFunc("GET /api/v1/courses/20/enrollments", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(enrollments)
+	})
+	stub := httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{}, &models.Program{}, &models.Outcome{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.CanvasCloud, Name: "Canvas", BaseUrl: stub.URL, AccessKey: "token", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	program := models.Program{ProviderPlatformID: provider.ID, Name: "Canvas Course", ExternalID: "20"}
+	if err := db.Create(&program).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := map[uint]string{}
+	for _, external := range []string{"1", "2", "3"} {
+		user := models.User{Username: "canvas" + external, NameFirst: "Canvas", NameLast: "Student", Email: "canvas" + external + "@unlocked.v2", Password: "password"}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: external, ExternalUsername: user.Username}).Error; err != nil {
+			t.Fatal(err)
+		}
+		users[user.ID] = external
+	}
+	service := newCanvasService(&provider)
+
+	report, err := service.ImportOutcomesForProgram("20", db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing outcomes: %v", err)
+	}
+	// a completion and a grade for each of the first two students
+	if report.Created != 4 || report.Skipped != 2 || report.Failed != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var outcomes []models.Outcome
+	db.Order("user_id, type").Find(&outcomes)
+	got := []string{}
+	for _, outcome := range outcomes {
+		got = append(got, users[outcome.UserID]+" "+string(outcome.Type)+" "+outcome.Value)
+	}
+	if want := "1 grade B,1 progress_completion 100,2 grade 91.5,2 progress_completion 100"; strings.Join(got, ",") != want {
+		t.Errorf("expected outcomes %q, got %q", want, strings.Join(got, ","))
+	}
+
+	// a changed grade is updated, the rest are left as they are
+	grade = "A"
+	report, err = service.ImportOutcomesForProgram("20", db, time.Time{})
+	if err != nil || report.Created != 0 || report.Updated != 1 || report.Skipped != 5 {
+		t.Errorf("expected only the changed grade to be updated, got %+v (%v)", report, err)
+	}
+}
+
+func TestCanvasImportQuizMilestones(t *testing.T) {
+	state := "pending_review"
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, body string) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}
+	mux.HandleFunc("GET /api/v1/courses/30/students/submissions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `[]`)
+	})
+	mux.HandleFunc("GET /api/v1/courses/30/quizzes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `[{"id": 5}]`)
+	})
+	// canvas sends the id of the submission as a number
+	mux.HandleFunc("GET /api/v1/courses/30/quizzes/5/submissions/c-quiz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"id": 77, "workflow_state": "`+state+`", "finished_at": "2024-05-01T10:00:00Z"}`)
+	})
+	stub := httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{}, &models.Program{}, &models.Milestone{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.CanvasCloud, Name: "Canvas", BaseUrl: stub.URL, AccessKey: "token", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	program := models.Program{ProviderPlatformID: provider.ID, Name: "Quizzes", ExternalID: "30"}
+	if err := db.Create(&program).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: "quizzer", NameFirst: "Quiz", NameLast: "Taker", Email: "quizzer@unlocked.v2", Password: "password"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: "c-quiz", ExternalUsername: user.Username}).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := newCanvasService(&provider)
+
+	report, err := service.ImportMilestonesForProgramUser(user.ID, program.ID, db, time.Time{})
+	if err != nil || report.Created != 1 || report.Failed != 0 {
+		t.Fatalf("expected the quiz submission to be imported, got %+v (%v)", report, err)
+	}
+	var milestone models.Milestone
+	if err := db.First(&milestone, "external_id = ?", "77").Error; err != nil || milestone.Type != "quiz_assignment" || milestone.IsCompleted {
+		t.Errorf("expected an incomplete quiz milestone, got %+v (%v)", milestone, err)
+	}
+
+	// once the quiz is complete, its completion is imported as well
+	state = "complete"
+	report, err = service.ImportMilestonesForProgramUser(user.ID, program.ID, db, time.Time{})
+	if err != nil || report.Created != 1 {
+		t.Fatalf("expected the completed quiz to be imported, got %+v (%v)", report, err)
+	}
+	var completed models.Milestone
+	if err := db.First(&completed, "external_id = ? AND type = ?", "77", "quiz_completion").Error; err != nil || !completed.IsCompleted {
+		t.Errorf("expected a completed quiz milestone, got %+v (%v)", completed, err)
+	}
+}
+
+func TestCanvasImportPrograms(t *testing.T) {
+	var counted atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/accounts/1/courses", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"id": 40, "name": "Changed Course", "course_code": "CC", "updated_at": "2024-06-01T00:00:00Z"},
+			{"id": 41, "name": "Unchanged Course", "course_code": "UC", "updated_at": "2024-01-01T00:00:00Z"}]`))
+	})
+	mux.HandleFunc("POST /api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		counted.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"course": {"assignmentsConnection": {"nodes": [{"_id": "1"}, {"_id": "2"}]}}}}`))
+	})
+	mux.HandleFunc("GET /api/v1/courses/{id}/quizzes", func(w http.ResponseWriter, r *http.Request) {
+		counted.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"id": 1}]`))
+	})
+	stub := httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.ProviderPlatform{}, &models.Program{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.CanvasCloud, Name: "Canvas", BaseUrl: stub.URL, AccountID: "1", AccessKey: "token", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := newCanvasService(&provider)
+
+	report, err := service.ImportPrograms(db, time.Time{})
+	if err != nil || report.Created != 2 || counted.Load() != 4 {
+		t.Fatalf("expected both courses to be imported and counted, got %+v after %d requests (%v)", report, counted.Load(), err)
+	}
+	// an incremental sync skips the unchanged course, and doesn't count the changed one again
+	report, err = service.ImportPrograms(db, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || report.Updated != 1 || report.Skipped != 1 || counted.Load() != 4 {
+		t.Fatalf("expected only the changed course to be updated, got %+v after %d requests (%v)", report, counted.Load(), err)
+	}
+	var program models.Program
+	if err := db.First(&program, "external_id = ?", "40").Error; err != nil || program.TotalProgressMilestones != 3 {
+		t.Errorf("expected the course to keep its count of milestones, got %+v (%v)", program, err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+* ProviderClient is the outbound HTTP layer for every request to a provider platform.
+* There is one per provider (shared by the services created for each incoming request),
+* which limits the number of requests in flight to the provider, backs off when the
+* provider reports it is being throttled, and retries requests which failed with a
+* network error, 429 or 5xx (with exponential backoff).
+**/
+type ProviderClient struct {
+	*clientLimits
+	client  *http.Client
+	ctx     context.Context
+	timeout time.Duration
+}
+
+type clientLimits struct {
+	slots       chan struct{}
+	mutex       sync.Mutex
+	pausedUntil time.Time
+}
+
+var (
+	providerClients     = make(map[uint]*ProviderClient)
+	providerClientsLock sync.Mutex
+)
+
+const (
+	defaultMaxConcurrency = 4
+	defaultRequestTimeout = 30 * time.Second
+	maxRetries            = 5
+	minBackoff            = 500 * time.Millisecond
+	maxBackoff            = 30 * time.Second
+	// canvas refills its request bucket continuously, below this it's close to being throttled
+	canvasLowRateLimit = 100.0
+)
+
+// the shared client for the provider, the limits are read from the environment
+func clientFor(providerID uint) *ProviderClient {
+	providerClientsLock.Lock()
+	defer providerClientsLock.Unlock()
+	client, ok := providerClients[providerID]
+	if !ok {
+		client = newProviderClient(envInt("PROVIDER_MAX_CONCURRENCY", defaultMaxConcurrency),
+			time.Duration(envInt("PROVIDER_REQUEST_TIMEOUT", int(defaultRequestTimeout.Seconds())))*time.Second)
+		providerClients[providerID] = client
+	}
+	return client
+}
+
+func newProviderClient(concurrency int, timeout time.Duration) *ProviderClient {
+	return &ProviderClient{
+		clientLimits: &clientLimits{slots: make(chan struct{}, max(concurrency, 1))},
+		client:       &http.Client{},
+		ctx:          context.Background(),
+		timeout:      timeout,
+	}
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// a copy of the client whose requests are cancelled along with ctx (e.g. when the backend's request is)
+func (pc *ProviderClient) WithContext(ctx context.Context) *ProviderClient {
+	return &ProviderClient{clientLimits: pc.clientLimits, client: pc.client, ctx: ctx, timeout: pc.timeout}
+}
+
+/**
+* Sends the request, retrying it until it succeeds or the retries run out. A request
+* with a body must be replayable (http.NewRequest sets GetBody for the common readers).
+* The response to the last attempt is returned, so callers still check the status.
+**/
+func (pc *ProviderClient) Do(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(pc.ctx)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("unable to retry a request without GetBody")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := pc.send(req)
+		wait, retry := pc.shouldRetry(resp, err)
+		if !retry || attempt >= maxRetries {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		wait = max(wait, backoff(attempt))
+		log.WithFields(log.Fields{"url": req.URL.Redacted(), "attempt": attempt + 1, "wait": wait, "error": err}).Warn("retrying provider request")
+		if err := pc.sleep(wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sends a single attempt, once a slot is free and the provider isn't throttling us
+func (pc *ProviderClient) send(req *http.Request) (*http.Response, error) {
+	select {
+	case pc.slots <- struct{}{}:
+	case <-pc.ctx.Done():
+		return nil, pc.ctx.Err()
+	}
+	defer func() { <-pc.slots }()
+	pc.mutex.Lock()
+	paused := time.Until(pc.pausedUntil)
+	pc.mutex.Unlock()
+	if err := pc.sleep(paused); err != nil {
+		return nil, err
+	}
+	// the timeout covers reading the body as well, so it's cancelled when the body is closed
+	ctx, cancel := context.WithTimeout(pc.ctx, pc.timeout)
+	resp, err := pc.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	pc.observeRateLimit(resp)
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+// the time to wait before retrying, if the attempt should be retried
+func (pc *ProviderClient) shouldRetry(resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		// the request was cancelled, rather than failing on its own
+		return 0, pc.ctx.Err() == nil
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return retryAfter(resp.Header), true
+	case resp.StatusCode == http.StatusForbidden:
+		// canvas responds to a throttled request with a 403 (Rate Limit Exceeded), and an empty quota
+		remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64)
+		return retryAfter(resp.Header), err == nil && remaining < 1
+	}
+	return 0, false
+}
+
+/**
+* Canvas sends the remaining request quota with every response, when it runs
+* low every request to the provider waits a little for the quota to refill.
+**/
+func (pc *ProviderClient) observeRateLimit(resp *http.Response) {
+	wait := retryAfter(resp.Header)
+	if remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64); err == nil && remaining < canvasLowRateLimit {
+		wait = max(wait, time.Second)
+	}
+	if wait == 0 {
+		return
+	}
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if until := time.Now().Add(wait); until.After(pc.pausedUntil) {
+		pc.pausedUntil = until
+	}
+}
+
+func (pc *ProviderClient) sleep(wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-pc.ctx.Done():
+		return pc.ctx.Err()
+	}
+}
+
+// Retry-After, in seconds (the HTTP date form isn't used by any of the providers)
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxBackoff)
+}
+
+// exponential, with jitter so that concurrent retries don't arrive together
+func backoff(attempt int) time.Duration {
+	wait := minBackoff << attempt
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
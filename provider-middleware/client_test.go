@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProviderClientRetries(t *testing.T) {
+	var unavailable, throttled, forbidden atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/unavailable":
+			if string(body) != "payload" {
+				t.Errorf("expected the body to be sent with every attempt, got %q", body)
+			}
+			if unavailable.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/throttled":
+			w.Header().Set("X-Rate-Limit-Remaining", "500.0")
+			if throttled.Add(1) == 1 {
+				w.Header().Set("X-Rate-Limit-Remaining", "0.0")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		case "/forbidden":
+			forbidden.Add(1)
+			w.Header().Set("X-Rate-Limit-Remaining", "500.0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := newProviderClient(2, time.Second)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/unavailable", strings.NewReader("payload"))
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the request to succeed after retrying, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if unavailable.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", unavailable.Load())
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/throttled", nil)
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || throttled.Load() != 2 {
+		t.Fatalf("expected a throttled request to be retried, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/forbidden", nil)
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden || forbidden.Load() != 1 {
+		t.Fatalf("expected a forbidden request not to be retried, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+}
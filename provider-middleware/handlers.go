@@ -1,7 +1,9 @@
 package main
 
 import (
+	"UnlockEdv2/src/models"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -17,6 +19,11 @@ func (sh *ServiceHandler) registerRoutes() {
 	sh.Mux.Handle("GET /api/programs", sh.applyMiddleware(http.HandlerFunc(sh.handlePrograms)))
 	sh.Mux.Handle("GET /api/users/{id}/programs/{program_id}/milestones", sh.applyMiddleware(http.HandlerFunc(sh.handleMilestonesForProgramUser)))
 	sh.Mux.Handle("GET /api/programs/{id}/activity", sh.applyMiddleware(http.HandlerFunc(sh.handleAcitivityForProgram)))
+	sh.Mux.Handle("GET /api/programs/{id}/outcomes", sh.applyMiddleware(http.HandlerFunc(sh.handleOutcomesForProgram)))
+	sh.Mux.Handle("GET /api/programs/{id}/enrollments", sh.applyMiddleware(http.HandlerFunc(sh.handleEnrollmentsForProgram)))
+	sh.Mux.Handle("PUT /api/programs/{id}/enrollments/{user_id}", sh.applyMiddleware(http.HandlerFunc(sh.handlePushEnrollment)))
+	sh.Mux.Handle("DELETE /api/programs/{id}/enrollments/{user_id}", sh.applyMiddleware(http.HandlerFunc(sh.handleRemoveEnrollment)))
+	sh.Mux.Handle("PUT /api/users/{user_id}/active", sh.applyMiddleware(http.HandlerFunc(sh.handleSetUserActive)))
 }
 
 /**
@@ -28,14 +35,21 @@ func (sh *ServiceHandler) handlePrograms(w http.ResponseWriter, r *http.Request)
 	service, err := sh.initService(r)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, "invalid since parameter", http.StatusBadRequest)
+		return
 	}
-	err = service.ImportPrograms(sh.db)
+	report, err := service.ImportPrograms(sh.db, since)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Failed to retrieve programs", http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeReport(w, report)
 }
 
 /**
@@ -74,6 +88,8 @@ func (sh *ServiceHandler) handleMilestonesForProgramUser(w http.ResponseWriter,
 	service, err := sh.initService(r)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
 	}
 	userId, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
@@ -87,26 +103,197 @@ func (sh *ServiceHandler) handleMilestonesForProgramUser(w http.ResponseWriter,
 		http.Error(w, "failed to parse programID from path", http.StatusBadRequest)
 		return
 	}
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, "invalid since parameter", http.StatusBadRequest)
+		return
+	}
 	log.Println("initiating GetMilestonesForProgramUser milestones")
-	err = service.ImportMilestonesForProgramUser(uint(userId), uint(programId), sh.db)
+	report, err := service.ImportMilestonesForProgramUser(uint(userId), uint(programId), sh.db, since)
 	if err != nil {
 		log.Errorf("Failed to retrieve milestones: %v", err)
+		http.Error(w, fmt.Sprintf("failed to retrieve milestones: %v", err), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeReport(w, report)
 }
 
 func (srv *ServiceHandler) handleAcitivityForProgram(w http.ResponseWriter, r *http.Request) {
 	service, err := srv.initService(r)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
 	}
 	programId := r.PathValue("id")
-	err = service.ImportActivityForProgram(programId, srv.db)
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, "invalid since parameter", http.StatusBadRequest)
+		return
+	}
+	report, err := service.ImportActivityForProgram(programId, srv.db, since)
 	if err != nil {
 		log.Errorf("failed to get program activity: %v", err)
 		http.Error(w, fmt.Sprintf("failed to get program activity: %v", err), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeReport(w, report)
+}
+
+func (sh *ServiceHandler) handleOutcomesForProgram(w http.ResponseWriter, r *http.Request) {
+	service, err := sh.initService(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	programId := r.PathValue("id")
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, "invalid since parameter", http.StatusBadRequest)
+		return
+	}
+	report, err := service.ImportOutcomesForProgram(programId, sh.db, since)
+	if err != nil {
+		log.Errorf("failed to get program outcomes: %v", err)
+		http.Error(w, fmt.Sprintf("failed to get program outcomes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, report)
+}
+
+func (sh *ServiceHandler) handleEnrollmentsForProgram(w http.ResponseWriter, r *http.Request) {
+	service, err := sh.initService(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	programId := r.PathValue("id")
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, "invalid since parameter", http.StatusBadRequest)
+		return
+	}
+	report, err := service.ImportEnrollmentsForProgram(programId, sh.db, since)
+	if err != nil {
+		log.Errorf("failed to get program enrollments: %v", err)
+		http.Error(w, fmt.Sprintf("failed to get program enrollments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, report)
+}
+
+/**
+* PUT: /api/programs/{id}/enrollments/{user_id}
+* The body is the user's enrollment in UnlockEd, which is applied to the course in
+* the provider. Responds with the provider's id for the enrollment.
+**/
+func (sh *ServiceHandler) handlePushEnrollment(w http.ResponseWriter, r *http.Request) {
+	service, err := sh.initService(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	userId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		http.Error(w, "failed to parse userID from path", http.StatusBadRequest)
+		return
+	}
+	var enrollment models.Enrollment
+	if err := json.NewDecoder(r.Body).Decode(&enrollment); err != nil {
+		http.Error(w, "invalid enrollment", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	enrollment.UserID = uint(userId)
+	externalID, err := service.PushEnrollment(r.PathValue("id"), &enrollment, sh.db)
+	if err != nil {
+		writeEnrollmentError(w, "failed to push enrollment", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"external_id": externalID}); err != nil {
+		log.Errorln("failed to write enrollment response", err)
+	}
+}
+
+/**
+* DELETE: /api/programs/{id}/enrollments/{user_id}
+* ?external_id= the provider's id for the enrollment, if UnlockEd has it
+**/
+func (sh *ServiceHandler) handleRemoveEnrollment(w http.ResponseWriter, r *http.Request) {
+	service, err := sh.initService(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	userId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		http.Error(w, "failed to parse userID from path", http.StatusBadRequest)
+		return
+	}
+	enrollment := models.Enrollment{UserID: uint(userId), ExternalID: r.URL.Query().Get("external_id")}
+	if err := service.RemoveEnrollment(r.PathValue("id"), &enrollment, sh.db); err != nil {
+		writeEnrollmentError(w, "failed to remove enrollment", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+* PUT: /api/users/{user_id}/active
+* The body is {"active": false} to deactivate the UnlockEd user's account in the
+* provider, or {"active": true} to reactivate it
+**/
+func (sh *ServiceHandler) handleSetUserActive(w http.ResponseWriter, r *http.Request) {
+	service, err := sh.initService(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error()}).Error("Failed to initialize service")
+		http.Error(w, "failed to initialize service", http.StatusBadRequest)
+		return
+	}
+	userId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		http.Error(w, "failed to parse userID from path", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Active *bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Active == nil {
+		http.Error(w, "active is required", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if err := service.SetUserActive(uint(userId), *body.Active, sh.db); err != nil {
+		writeEnrollmentError(w, "failed to change the user's account", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeEnrollmentError(w http.ResponseWriter, msg string, err error) {
+	log.Errorf("%s: %v", msg, err)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		status = http.StatusNotImplemented
+	case errors.Is(err, errUnmappedUser):
+		status = http.StatusConflict
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", msg, err), status)
+}
+
+// responds with the report of an import, which the backend saves for admins to review
+func writeReport(w http.ResponseWriter, report *models.ImportReport) {
+	if report.Failed > 0 {
+		log.WithFields(log.Fields{"failed": report.Failed, "errors": report.Errors}).Warn("rows failed to import")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorln("failed to write import report", err)
+	}
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImportHandlersRejectUnknownProvider(t *testing.T) {
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.ProviderPlatform{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	handler := newServiceHandler("token", db)
+	handler.registerRoutes()
+	for _, path := range []string{"/api/programs", "/api/users/1/programs/1/milestones", "/api/programs/1/activity", "/api/programs/1/outcomes"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path+"?id=404", nil)
+			req.Header.Set("Authorization", "token")
+			rr := httptest.NewRecorder()
+			handler.Mux.ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected an unknown provider to be rejected, got %d", rr.Code)
+			}
+		})
+	}
+}
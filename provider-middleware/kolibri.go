@@ -2,11 +2,18 @@ package main
 
 import (
 	"UnlockEdv2/src/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
@@ -20,9 +27,13 @@ import (
 type KolibriService struct {
 	ProviderPlatformID uint
 	BaseURL            string
-	Client             *http.Client
+	Client             *ProviderClient
 	AccountID          string
-	db                 *gorm.DB
+	// the facility admin's "username:password", for kolibri's REST API
+	AccessKey string
+	db        *gorm.DB
+	session   []*http.Cookie
+	csrfToken string
 }
 
 /**
@@ -43,11 +54,22 @@ func NewKolibriService(provider *models.ProviderPlatform) *KolibriService {
 	log.Info("Connected to Kolibri's database")
 	return &KolibriService{
 		ProviderPlatformID: provider.ID,
+		BaseURL:            provider.BaseUrl,
+		Client:             clientFor(provider.ID),
 		AccountID:          provider.AccountID,
+		AccessKey:          provider.AccessKey,
 		db:                 conn,
 	}
 }
 
+// a copy of the service whose queries and requests are cancelled along with ctx (e.g. when the backend's request is)
+func (ks *KolibriService) WithContext(ctx context.Context) *KolibriService {
+	service := *ks
+	service.db = ks.db.WithContext(ctx)
+	service.Client = ks.Client.WithContext(ctx)
+	return &service
+}
+
 /**
 * Method to list all users in a Kolibri facility
 * @info - GET /api/auth/facilityuser?member_of=<facilityID>
@@ -63,18 +85,24 @@ func (ks *KolibriService) GetUsers(db *gorm.DB) ([]models.ImportUser, error) {
 	}
 	var importUsers []models.ImportUser
 	for _, user := range users {
-		if db.Where("provider_platform_id = ? AND external_user_id = ?", ks.ProviderPlatformID, user["id"]).First(&models.ProviderUserMapping{}).Error == nil {
+		first, last, _ := strings.Cut(user["full_name"], " ")
+		importUser := models.ImportUser{
+			NameFirst:        first,
+			NameLast:         last,
+			Username:         user["username"],
+			Email:            user["username"] + "@unlocked.v2",
+			ExternalUserID:   user["id"],
+			ExternalUsername: user["username"],
+		}
+		mapped, err := updateMappedUser(db, ks.ProviderPlatformID, &importUser)
+		if err != nil {
+			log.Errorln("error updating mapped kolibri user", err)
 			continue
 		}
-		split := strings.Split(user["full_name"], " ")
-		first, last := split[0], split[1]
-		importUsers = append(importUsers, models.ImportUser{
-			NameFirst:      first,
-			NameLast:       last,
-			Username:       user["username"],
-			Email:          user["username"] + "@unlocked.v2",
-			ExternalUserID: user["id"],
-		})
+		if mapped {
+			continue
+		}
+		importUsers = append(importUsers, importUser)
 	}
 	return importUsers, nil
 }
@@ -83,82 +111,168 @@ func (ks *KolibriService) GetUsers(db *gorm.DB) ([]models.ImportUser, error) {
 * @info - GET /api/content/channel?available=true
 * @return - List of maps, each containing the details of a Content object
 **/
-func (ks *KolibriService) ImportPrograms(db *gorm.DB) error {
+func (ks *KolibriService) ImportPrograms(db *gorm.DB, since time.Time) (*models.ImportReport, error) {
 	log.Println("Importing programs from Kolibri")
 	var programs []map[string]interface{}
 	sql := `SELECT id, author, name, description, thumbnail, total_resource_count, public, root_id FROM content_channelmetadata`
-	if err := ks.db.Raw(sql).Find(&programs).Error; err != nil {
+	args := []interface{}{}
+	if !since.IsZero() {
+		sql += ` WHERE last_updated IS NULL OR last_updated > ?`
+		args = append(args, since)
+	}
+	if err := ks.db.Raw(sql, args...).Find(&programs).Error; err != nil {
 		log.Errorln("error querying kolibri database for programs")
-		return err
+		return nil, err
 	}
 	log.Println(programs)
+	report := models.NewImportReport(ks.ProviderPlatformID, models.ImportProgramsJob)
 	for _, program := range programs {
 		id := program["id"].(string)
-		if db.Where("provider_platform_id = ? AND external_id = ?", ks.ProviderPlatformID, id).First(&models.Program{}).Error == nil {
-			continue
-		}
 		query := `SELECT COUNT(*) FROM content_contentnode WHERE channel_id = ?`
 		var count int
 		if err := ks.db.Raw(query, id).Find(&count).Error; err != nil {
 			log.Errorln("error querying kolibri database for program content count")
+			report.Fail(id, err)
 			continue
 		}
 		prog := ks.IntoCourse(program)
-		if err := db.Create(&prog).Error; err != nil {
-			log.Errorln("error creating program in db")
-			continue
+		result, err := upsertProgram(db, prog)
+		if err != nil {
+			log.Errorln("error upserting program in db")
 		}
+		recordUpsert(report, id, result, err)
 	}
-	return nil
+	return report, nil
 }
 
-func (ks *KolibriService) ImportMilestonesForProgramUser(courseId, userId uint, db *gorm.DB) error {
-	// sql := `SELECT id, complete, time_spent FROM logger_attemptlog where user_id = ? AND content_id = ?`
-	return nil
+// a learner's progress on a single content node of a channel
+type KolibriContentProgress struct {
+	ContentID string
+	Kind      string
+	Progress  float64
+	Mastered  int
+	Attempts  int
+}
+
+/**
+* Every exercise a learner has attempted is an exercise_mastery milestone, completed
+* once they have met its mastery criterion, and any other content they have opened
+* (videos, documents, html5 apps) is a content_completion milestone, completed
+* once its progress reaches 1. A piece of content can have several summary logs
+* (e.g. when it's in more than one topic), so the logs are grouped by content id.
+**/
+func (ks *KolibriService) ImportMilestonesForProgramUser(userId, programId uint, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	fields := log.Fields{"handler": "ImportMilestonesForProgramUser", "user_id": userId, "program_id": programId}
+	var user models.User
+	if err := db.First(&user, "id = ?", userId).Error; err != nil {
+		log.WithFields(fields).Errorln("unable to find user")
+		return nil, err
+	}
+	externalUserID, err := user.GetExternalIDFromProvider(db, ks.ProviderPlatformID)
+	if err != nil || externalUserID == "" {
+		log.WithFields(fields).Errorln("unable to find external user id")
+		return nil, errors.New("user is not mapped to the kolibri provider")
+	}
+	var channelID string
+	if err := db.Model(&models.Program{}).Select("external_id").Where("id = ?", programId).First(&channelID).Error; err != nil {
+		log.WithFields(fields).Errorln("unable to find program")
+		return nil, err
+	}
+	sql := `SELECT s.content_id AS content_id, s.kind AS kind, MAX(s.progress) AS progress,
+		MAX(CASE WHEN m.complete THEN 1 ELSE 0 END) AS mastered, COUNT(a.id) AS attempts
+		FROM logger_contentsummarylog s
+		LEFT JOIN logger_masterylog m ON m.summarylog_id = s.id
+		LEFT JOIN logger_attemptlog a ON a.masterylog_id = m.id
+		WHERE s.user_id = ? AND s.channel_id = ?`
+	args := []interface{}{externalUserID, channelID}
+	if !since.IsZero() {
+		sql += ` AND s.end_timestamp > ?`
+		args = append(args, since)
+	}
+	sql += ` GROUP BY s.content_id, s.kind`
+	var progress []KolibriContentProgress
+	if err := ks.db.Raw(sql, args...).Scan(&progress).Error; err != nil {
+		log.WithFields(fields).Errorln("error querying kolibri database for learner progress", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ks.ProviderPlatformID, models.ImportMilestonesJob)
+	for _, content := range progress {
+		milestone := models.Milestone{
+			UserID:      userId,
+			ProgramID:   programId,
+			ExternalID:  content.ContentID,
+			Type:        models.ContentCompletion,
+			IsCompleted: content.Progress >= 1,
+		}
+		if content.Kind == "exercise" {
+			if content.Attempts == 0 {
+				// opened, but never answered
+				report.Skip()
+				continue
+			}
+			milestone.Type = models.ExerciseMastery
+			milestone.IsCompleted = content.Mastered == 1
+		}
+		result, err := upsertMilestone(db, &milestone)
+		if err != nil {
+			log.WithFields(fields).Errorln("error upserting kolibri milestone", err)
+		}
+		recordUpsert(report, milestone.ExternalID, result, err)
+	}
+	return report, nil
 }
 
 type KolibriActivity struct {
-	UserId              string `json:"user_id"`
-	TimeSpent           string `json:"time_spent"`
-	CompletionTimestamp string `json:"completion_timestamp"`
-	ContentId           string `json:"content_id"`
-	Progress            string `json:"progress"`
-	Kind                string `json:"kind"`
+	UserId       string    `json:"user_id"`
+	ContentId    string    `json:"content_id"`
+	Kind         string    `json:"kind"`
+	TimeSpent    float64   `json:"time_spent"`
+	EndTimestamp time.Time `json:"end_timestamp"`
 }
 
-func (ks *KolibriService) ImportActivityForProgram(courseId string, db *gorm.DB) error {
-	courseID, err := strconv.Atoi(courseId)
-	if err != nil {
-		log.Errorln("error parsing course id in ImportActivityForProgram")
-		return err
-	}
+/**
+* The summary log has the total time a learner has spent on each piece of content,
+* which is recorded as the activity of the day they last used it. A piece of content
+* can have several summary logs (e.g. when it's in more than one topic), so the
+* logs are grouped by content id.
+**/
+func (ks *KolibriService) ImportActivityForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
 	var activities []KolibriActivity
-	var programId string
-	if err := db.Model(&models.Program{}).Select("external_id").First(&programId, "course_id = ? AND provider_platform_id = ?", courseId, ks.ProviderPlatformID).Error; err != nil {
+	var programId uint
+	if err := db.Model(&models.Program{}).Select("id").First(&programId, "external_id = ? AND provider_platform_id = ?", courseId, ks.ProviderPlatformID).Error; err != nil {
 		log.Errorln("error finding program by external id in ImportActivityForProgram")
-		return err
+		return nil, err
+	}
+	sql := `SELECT user_id, content_id, kind, SUM(time_spent) AS time_spent, MAX(end_timestamp) AS end_timestamp
+		FROM logger_contentsummarylog WHERE channel_id = ?`
+	args := []interface{}{courseId}
+	if !since.IsZero() {
+		sql += ` AND end_timestamp > ?`
+		args = append(args, since)
 	}
-	sql := `SELECT user_id, time_spent, completion_timestamp, content_id, progress, kind FROM logger_contentsummarylog WHERE channel_id = ?`
-	if err := ks.db.Raw(sql, courseId).Find(&activities).Error; err != nil {
+	sql += ` GROUP BY user_id, content_id, kind`
+	if err := ks.db.Raw(sql, args...).Scan(&activities).Error; err != nil {
 		log.Errorln("error querying kolibri database for program activities")
-		return err
+		return nil, err
+	}
+	kinds := map[string]models.ActivityType{
+		"video":    models.ContentInteraction,
+		"exercise": models.ProgramInteraction,
+		"html5":    models.ContentInteraction,
+		"h5p":      models.ContentInteraction,
+		"topic":    models.ProgramInteraction,
 	}
+	report := models.NewImportReport(ks.ProviderPlatformID, models.ImportActivityJob)
+	userIDs := make(map[string]uint)
 	for _, activity := range activities {
-		var user_id uint
-		if err := db.Model(&models.ProviderUserMapping{}).Select("user_id").First(&user_id, "external_user_id = ?", activity.UserId).Error; err != nil {
-			log.Errorln("error finding user by external id in ImportActivityForProgram")
-			continue
-		}
-		kinds := map[string]models.ActivityType{
-			"video":    models.ContentInteraction,
-			"exercise": models.ProgramInteraction,
-			"html5":    models.ContentInteraction,
-			"h5p":      models.ContentInteraction,
-			"topic":    models.ProgramInteraction,
+		user_id, ok := userIDs[activity.UserId]
+		if !ok {
+			user_id = mappedUserID(db, ks.ProviderPlatformID, activity.UserId)
+			userIDs[activity.UserId] = user_id
 		}
-		time, err := strconv.ParseFloat(activity.TimeSpent, 64)
-		if err != nil {
-			log.Errorln("error parsing time spent in ImportActivityForProgram")
+		if user_id == 0 {
+			// the user hasn't been imported into UnlockEd
+			report.Skip()
 			continue
 		}
 		kind, ok := kinds[activity.Kind]
@@ -166,15 +280,358 @@ func (ks *KolibriService) ImportActivityForProgram(courseId string, db *gorm.DB)
 			kind = models.ContentInteraction
 		}
 		newActivity := models.Activity{
-			UserID:    user_id,
-			ProgramID: uint(courseID),
-			Type:      kind,
-			TimeDelta: uint(time),
+			UserID:     user_id,
+			ProgramID:  programId,
+			Type:       kind,
+			TotalTime:  uint(activity.TimeSpent),
+			ExternalID: activity.ContentId,
+			CreatedAt:  activity.EndTimestamp,
+		}
+		result, err := upsertActivity(db, &newActivity)
+		if err != nil {
+			log.Errorln("error upserting activity in ImportActivityForProgram", err)
+		}
+		recordUpsert(report, activity.ContentId, result, err)
+	}
+	return report, nil
+}
+
+/**
+* Kolibri has no grades, so a learner's outcome is mastery of the channel: once
+* they have mastered every exercise in it, they get a progress_completion outcome.
+**/
+func (ks *KolibriService) ImportOutcomesForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	var program models.Program
+	if err := db.Select("id", "name").First(&program, "external_id = ? AND provider_platform_id = ?", courseId, ks.ProviderPlatformID).Error; err != nil {
+		log.Errorln("error finding program by external id in ImportOutcomesForProgram")
+		return nil, err
+	}
+	report := models.NewImportReport(ks.ProviderPlatformID, models.ImportOutcomesJob)
+	var exercises int64
+	if err := ks.db.Raw(`SELECT COUNT(DISTINCT content_id) FROM content_contentnode WHERE channel_id = ? AND kind = 'exercise' AND available = true`, courseId).Scan(&exercises).Error; err != nil {
+		log.Errorln("error counting exercises in kolibri channel")
+		return nil, err
+	}
+	if exercises == 0 {
+		// nothing to master
+		return report, nil
+	}
+	var mastery []struct {
+		UserID   string
+		Mastered int64
+	}
+	sql := `SELECT s.user_id AS user_id, COUNT(DISTINCT s.content_id) AS mastered
+		FROM logger_masterylog m JOIN logger_contentsummarylog s ON s.id = m.summarylog_id
+		WHERE s.channel_id = ? AND s.kind = 'exercise' AND m.complete = true
+		GROUP BY s.user_id`
+	args := []interface{}{courseId}
+	if !since.IsZero() {
+		// only learners who have mastered something since the last import
+		sql += ` HAVING MAX(m.completion_timestamp) >= ?`
+		args = append(args, since)
+	}
+	if err := ks.db.Raw(sql, args...).Scan(&mastery).Error; err != nil {
+		log.Errorln("error querying kolibri database for channel mastery")
+		return nil, err
+	}
+	for _, learner := range mastery {
+		if learner.Mastered < exercises {
+			report.Skip()
+			continue
+		}
+		userID := mappedUserID(db, ks.ProviderPlatformID, learner.UserID)
+		if userID == 0 {
+			report.Skip()
+			continue
+		}
+		outcome := models.Outcome{Type: models.ProgressCompletion, ProgramID: program.ID, ProgramName: program.Name, UserID: userID, Value: "100"}
+		result, err := upsertOutcome(db, &outcome)
+		if err != nil {
+			log.Errorln("error upserting kolibri outcome", err)
 		}
-		if err := db.Create(&newActivity).Error; err != nil {
-			log.Errorln("error creating activity in ImportActivityForProgram")
+		recordUpsert(report, learner.UserID, result, err)
+	}
+	return report, nil
+}
+
+/**
+* Channels have no roster, so a learner is enrolled once they start on the
+* channel's content, from the first time they did. Existing enrollments are
+* left for UnlockEd to manage.
+**/
+func (ks *KolibriService) ImportEnrollmentsForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	var programId uint
+	if err := db.Model(&models.Program{}).Select("id").First(&programId, "external_id = ? AND provider_platform_id = ?", courseId, ks.ProviderPlatformID).Error; err != nil {
+		log.Errorln("error finding program by external id in ImportEnrollmentsForProgram")
+		return nil, err
+	}
+	var learners []struct {
+		UserID    string
+		StartedAt time.Time
+	}
+	sql := `SELECT user_id, MIN(start_timestamp) AS started_at FROM logger_contentsummarylog WHERE channel_id = ?`
+	args := []interface{}{courseId}
+	if !since.IsZero() {
+		sql += ` AND end_timestamp > ?`
+		args = append(args, since)
+	}
+	sql += ` GROUP BY user_id`
+	if err := ks.db.Raw(sql, args...).Scan(&learners).Error; err != nil {
+		log.Errorln("error querying kolibri database for channel learners")
+		return nil, err
+	}
+	report := models.NewImportReport(ks.ProviderPlatformID, models.ImportEnrollmentsJob)
+	for _, learner := range learners {
+		userID := mappedUserID(db, ks.ProviderPlatformID, learner.UserID)
+		if userID == 0 {
+			report.Skip()
 			continue
 		}
+		enrollment := models.Enrollment{UserID: userID, ProgramID: programId, Status: models.EnrollmentActive, StartDate: &learner.StartedAt}
+		result, err := ensureEnrollment(db, &enrollment)
+		if err != nil {
+			log.Errorln("error enrolling kolibri learner", err)
+		}
+		recordUpsert(report, learner.UserID, result, err)
+	}
+	return report, nil
+}
+
+// signs in to kolibri's REST API as the facility admin, keeping the session cookies for later requests
+func (ks *KolibriService) login() error {
+	username, password, ok := strings.Cut(ks.AccessKey, ":")
+	if !ok {
+		return errors.New("invalid access key for Kolibri. must be in the format username:password")
+	}
+	body, err := json.Marshal(map[string]string{"username": username, "password": password, "facility": ks.AccountID})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(ks.BaseURL, "/")+"/api/auth/session/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ks.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kolibri login failed with code: %s", resp.Status)
+	}
+	ks.session = resp.Cookies()
+	for _, cookie := range ks.session {
+		if strings.HasSuffix(cookie.Name, "csrftoken") {
+			ks.csrfToken = cookie.Value
+		}
+	}
+	return nil
+}
+
+// sends a request to kolibri's REST API, decoding the response into result (if not nil)
+func (ks *KolibriService) api(method, path string, body, result interface{}) error {
+	if ks.session == nil {
+		if err := ks.login(); err != nil {
+			return err
+		}
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(ks.BaseURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRFToken", ks.csrfToken)
+	req.Header.Set("Referer", ks.BaseURL)
+	for _, cookie := range ks.session {
+		req.AddCookie(cookie)
+	}
+	resp, err := ks.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("kolibri responded to %s %s with code: %s", method, req.URL.Path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// the id of the named classroom (or learner group) in parent, which is created the first time it's needed
+func (ks *KolibriService) findOrCreateCollection(kind, parent, name string) (string, error) {
+	var collections []KolibriCollection
+	if err := ks.api(http.MethodGet, "/api/auth/"+kind+"/?parent="+url.QueryEscape(parent), nil, &collections); err != nil {
+		return "", err
+	}
+	for _, collection := range collections {
+		if collection.Name == name {
+			return collection.ID, nil
+		}
+	}
+	var created KolibriCollection
+	if err := ks.api(http.MethodPost, "/api/auth/"+kind+"/", map[string]string{"name": name, "parent": parent}, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (ks *KolibriService) programClassroom(courseId string, db *gorm.DB) (string, error) {
+	var name string
+	if err := db.Model(&models.Program{}).Select("name").First(&name, "external_id = ? AND provider_platform_id = ?", courseId, ks.ProviderPlatformID).Error; err != nil {
+		return "", err
+	}
+	return ks.findOrCreateCollection("classroom", ks.AccountID, name)
+}
+
+// the user's memberships of the classroom and its learner groups, the classroom's first
+func (ks *KolibriService) classroomMemberships(externalUserID, classroom string) ([]KolibriMembership, error) {
+	var groups []KolibriCollection
+	if err := ks.api(http.MethodGet, "/api/auth/learnergroup/?parent="+url.QueryEscape(classroom), nil, &groups); err != nil {
+		return nil, err
+	}
+	var memberships []KolibriMembership
+	if err := ks.api(http.MethodGet, "/api/auth/membership/?user="+url.QueryEscape(externalUserID), nil, &memberships); err != nil {
+		return nil, err
+	}
+	memberships = slices.DeleteFunc(memberships, func(membership KolibriMembership) bool {
+		return membership.Collection != classroom && !slices.ContainsFunc(groups, func(group KolibriCollection) bool {
+			return group.ID == membership.Collection
+		})
+	})
+	slices.SortStableFunc(memberships, func(a, b KolibriMembership) int {
+		if a.Collection == classroom {
+			return -1
+		} else if b.Collection == classroom {
+			return 1
+		}
+		return 0
+	})
+	return memberships, nil
+}
+
+// takes the user out of the classroom, leaving its learner groups before the classroom itself
+func (ks *KolibriService) leaveClassroom(externalUserID, classroom string) error {
+	memberships, err := ks.classroomMemberships(externalUserID, classroom)
+	if err != nil {
+		return err
+	}
+	for idx := len(memberships) - 1; idx >= 0; idx-- {
+		if err := ks.api(http.MethodDelete, "/api/auth/membership/"+memberships[idx].ID+"/", nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+* A channel has no roster in kolibri, so each program has a classroom in the
+* facility (named after the program), in which the learners from each UnlockEd
+* facility are a learner group. Active and completed learners are members of the
+* classroom and their facility's group (so they keep access to its lessons),
+* anyone else is taken out of the classroom.
+**/
+func (ks *KolibriService) PushEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) (string, error) {
+	externalUserID, err := mappedExternalUserID(db, ks.ProviderPlatformID, enrollment.UserID)
+	if err != nil {
+		return "", err
+	}
+	classroom, err := ks.programClassroom(courseId, db)
+	if err != nil {
+		return "", err
+	}
+	if enrollment.Status != models.EnrollmentActive && enrollment.Status != models.EnrollmentCompleted {
+		return "", ks.leaveClassroom(externalUserID, classroom)
+	}
+	wanted := []string{classroom}
+	if name := facilityGroupName(db, enrollment.UserID); name != "" {
+		group, err := ks.findOrCreateCollection("learnergroup", classroom, name)
+		if err != nil {
+			return "", err
+		}
+		wanted = append(wanted, group)
+	}
+	memberships, err := ks.classroomMemberships(externalUserID, classroom)
+	if err != nil {
+		return "", err
+	}
+	// membership of any other group is from a facility the learner has since left
+	for _, membership := range memberships {
+		if idx := slices.Index(wanted, membership.Collection); idx >= 0 {
+			wanted = slices.Delete(wanted, idx, idx+1)
+		} else if err := ks.api(http.MethodDelete, "/api/auth/membership/"+membership.ID+"/", nil, nil); err != nil {
+			return "", err
+		}
+	}
+	for _, collection := range wanted {
+		if err := ks.api(http.MethodPost, "/api/auth/membership/", map[string]string{"user": externalUserID, "collection": collection}, nil); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (ks *KolibriService) RemoveEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) error {
+	externalUserID, err := mappedExternalUserID(db, ks.ProviderPlatformID, enrollment.UserID)
+	if err != nil {
+		return err
+	}
+	classroom, err := ks.programClassroom(courseId, db)
+	if err != nil {
+		return err
+	}
+	return ks.leaveClassroom(externalUserID, classroom)
+}
+
+/**
+* Kolibri can't disable a learner's login, so a deactivated learner is taken out of
+* every classroom in the facility, and with that loses access to its lessons. When
+* they are reactivated UnlockEd pushes their enrollments again, which puts them
+* back in the classrooms of the programs they are enrolled in.
+**/
+func (ks *KolibriService) SetUserActive(userId uint, active bool, db *gorm.DB) error {
+	if active {
+		return nil
+	}
+	externalUserID, err := userAccountID(db, ks.ProviderPlatformID, userId)
+	if err != nil {
+		return err
+	}
+	var classrooms []KolibriCollection
+	if err := ks.api(http.MethodGet, "/api/auth/classroom/?parent="+url.QueryEscape(ks.AccountID), nil, &classrooms); err != nil {
+		return err
+	}
+	var memberships []KolibriMembership
+	if err := ks.api(http.MethodGet, "/api/auth/membership/?user="+url.QueryEscape(externalUserID), nil, &memberships); err != nil {
+		return err
+	}
+	isClassroom := func(membership KolibriMembership) bool {
+		return slices.ContainsFunc(classrooms, func(classroom KolibriCollection) bool { return classroom.ID == membership.Collection })
+	}
+	// learner groups are left before the classrooms they are in
+	slices.SortStableFunc(memberships, func(a, b KolibriMembership) int {
+		switch {
+		case isClassroom(a) == isClassroom(b):
+			return 0
+		case isClassroom(a):
+			return 1
+		}
+		return -1
+	})
+	for _, membership := range memberships {
+		if err := ks.api(http.MethodDelete, "/api/auth/membership/"+membership.ID+"/", nil, nil); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -104,6 +104,7 @@ func (kc *KolibriService) IntoCourse(data map[string]interface{}) *models.Progra
 		url = ""
 	}
 	return &models.Program{
+		ProviderPlatformID:      kc.ProviderPlatformID,
 		ExternalID:              id,
 		Name:                    name,
 		Description:             description,
@@ -176,3 +177,16 @@ type Role struct {
 	Kind       string `json:"kind"`
 	Id         string `json:"id"`
 }
+
+// a classroom or learner group in a kolibri facility
+type KolibriCollection struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+type KolibriMembership struct {
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	Collection string `json:"collection"`
+}
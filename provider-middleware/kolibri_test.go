@@ -0,0 +1,200 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the columns of kolibri's logger tables which the middleware reads
+var kolibriTestSchema = []string{
+	`CREATE TABLE logger_contentsummarylog (id TEXT PRIMARY KEY, user_id TEXT, content_id TEXT, channel_id TEXT, kind TEXT,
+		progress REAL, time_spent REAL, start_timestamp DATETIME, end_timestamp DATETIME, completion_timestamp DATETIME)`,
+	`CREATE TABLE logger_masterylog (id TEXT PRIMARY KEY, user_id TEXT, summarylog_id TEXT, mastery_level INTEGER, complete BOOLEAN,
+		start_timestamp DATETIME, end_timestamp DATETIME, completion_timestamp DATETIME)`,
+	`CREATE TABLE logger_attemptlog (id TEXT PRIMARY KEY, user_id TEXT, masterylog_id TEXT, item TEXT, complete BOOLEAN, correct REAL,
+		start_timestamp DATETIME, end_timestamp DATETIME)`,
+	`CREATE TABLE content_contentnode (id TEXT PRIMARY KEY, content_id TEXT, channel_id TEXT, kind TEXT, available BOOLEAN)`,
+}
+
+func TestKolibriImportMilestones(t *testing.T) {
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{},
+		&models.Program{}, &models.Milestone{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.Kolibri, Name: "Kolibri", BaseUrl: "http://kolibri.test", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: "learner", NameFirst: "Lee", NameLast: "Learner", Email: "learner@unlocked.v2", Password: "password"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	mapping := models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: "k-user", ExternalUsername: "learner"}
+	program := models.Program{ProviderPlatformID: provider.ID, Name: "Basic Math", ExternalID: "channel-1", Type: models.OpenEnrollment}
+	if err := db.Create(&mapping).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&program).Error; err != nil {
+		t.Fatal(err)
+	}
+	kolibri := openTestDB(t, t.Name()+"_kolibri")
+	for _, table := range kolibriTestSchema {
+		if err := kolibri.Exec(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 6, 20, 13, 0, 0, 0, time.UTC)
+	rows := []string{
+		// ex-1 is mastered, and also appears in a second topic
+		`INSERT INTO logger_contentsummarylog VALUES ('s1', 'k-user', 'ex-1', 'channel-1', 'exercise', 1, 300, ?, ?, ?)`,
+		`INSERT INTO logger_contentsummarylog VALUES ('s2', 'k-user', 'ex-1', 'channel-1', 'exercise', 0.5, 60, ?, ?, NULL)`,
+		`INSERT INTO logger_masterylog VALUES ('m1', 'k-user', 's1', 1, true, ?, ?, ?)`,
+		`INSERT INTO logger_attemptlog VALUES ('a1', 'k-user', 'm1', 'q1', true, 1, ?, ?)`,
+		`INSERT INTO logger_attemptlog VALUES ('a2', 'k-user', 'm1', 'q2', true, 1, ?, ?)`,
+		// ex-2 has been attempted, but not mastered
+		`INSERT INTO logger_contentsummarylog VALUES ('s3', 'k-user', 'ex-2', 'channel-1', 'exercise', 0.4, 120, ?, ?, NULL)`,
+		`INSERT INTO logger_masterylog VALUES ('m2', 'k-user', 's3', 1, false, ?, ?, NULL)`,
+		`INSERT INTO logger_attemptlog VALUES ('a3', 'k-user', 'm2', 'q1', true, 0, ?, ?)`,
+		// ex-3 was opened, but never answered
+		`INSERT INTO logger_contentsummarylog VALUES ('s4', 'k-user', 'ex-3', 'channel-1', 'exercise', 0, 10, ?, ?, NULL)`,
+		// a watched video and one that's half watched
+		`INSERT INTO logger_contentsummarylog VALUES ('s5', 'k-user', 'video-1', 'channel-1', 'video', 1, 600, ?, ?, ?)`,
+		`INSERT INTO logger_contentsummarylog VALUES ('s6', 'k-user', 'video-2', 'channel-1', 'video', 0.5, 200, ?, ?, NULL)`,
+		// another channel
+		`INSERT INTO logger_contentsummarylog VALUES ('s7', 'k-user', 'video-3', 'channel-2', 'video', 1, 600, ?, ?, ?)`,
+	}
+	for _, row := range rows {
+		// every timestamp is the same day
+		args := []interface{}{}
+		for range strings.Count(row, "?") {
+			args = append(args, day)
+		}
+		if err := kolibri.Exec(row, args...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := &KolibriService{ProviderPlatformID: provider.ID, db: kolibri}
+	report, err := service.ImportMilestonesForProgramUser(user.ID, program.ID, db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing milestones: %v", err)
+	}
+	if report.Created != 4 || report.Skipped != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	completed := map[string]bool{}
+	var milestones []models.Milestone
+	db.Find(&milestones, "user_id = ? AND program_id = ?", user.ID, program.ID)
+	for _, milestone := range milestones {
+		completed[milestone.ExternalID+" "+string(milestone.Type)] = milestone.IsCompleted
+	}
+	expected := map[string]bool{"ex-1 exercise_mastery": true, "ex-2 exercise_mastery": false, "video-1 content_completion": true, "video-2 content_completion": false}
+	if len(completed) != len(expected) {
+		t.Fatalf("expected %d milestones, got %v", len(expected), completed)
+	}
+	for key, isCompleted := range expected {
+		if done, ok := completed[key]; !ok || done != isCompleted {
+			t.Errorf("expected milestone %s to have completion %v, got %v", key, isCompleted, completed)
+		}
+	}
+	report, err = service.ImportMilestonesForProgramUser(user.ID, program.ID, db, day.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != 0 {
+		t.Errorf("expected no progress since the last import, got %+v", report)
+	}
+}
+
+func TestKolibriImportOutcomes(t *testing.T) {
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{},
+		&models.Program{}, &models.Outcome{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.Kolibri, Name: "Kolibri", BaseUrl: "http://kolibri.test", State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	program := models.Program{ProviderPlatformID: provider.ID, Name: "Basic Math", ExternalID: "channel-1", Type: models.OpenEnrollment}
+	if err := db.Create(&program).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]uint{}
+	for _, username := range []string{"master", "partial"} {
+		user := models.User{Username: username, NameFirst: "Lee", NameLast: "Learner", Email: username + "@unlocked.v2", Password: "password"}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		mapping := models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: "k-" + username, ExternalUsername: username}
+		if err := db.Create(&mapping).Error; err != nil {
+			t.Fatal(err)
+		}
+		users[username] = user.ID
+	}
+	kolibri := openTestDB(t, t.Name()+"_kolibri")
+	for _, table := range kolibriTestSchema {
+		if err := kolibri.Exec(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 6, 20, 13, 0, 0, 0, time.UTC)
+	rows := []string{
+		// two exercises to master, a third which is no longer available, and a video
+		`INSERT INTO content_contentnode VALUES ('n1', 'ex-1', 'channel-1', 'exercise', true)`,
+		`INSERT INTO content_contentnode VALUES ('n2', 'ex-2', 'channel-1', 'exercise', true)`,
+		`INSERT INTO content_contentnode VALUES ('n3', 'ex-3', 'channel-1', 'exercise', false)`,
+		`INSERT INTO content_contentnode VALUES ('n4', 'video-1', 'channel-1', 'video', true)`,
+	}
+	// master and a learner who was never imported have mastered both, partial only the first
+	for idx, learner := range []struct{ user, exercise string }{{"k-master", "ex-1"}, {"k-master", "ex-2"}, {"k-partial", "ex-1"}, {"k-unmapped", "ex-1"}, {"k-unmapped", "ex-2"}} {
+		id := strconv.Itoa(idx)
+		rows = append(rows,
+			`INSERT INTO logger_contentsummarylog VALUES ('s`+id+`', '`+learner.user+`', '`+learner.exercise+`', 'channel-1', 'exercise', 1, 300, ?, ?, ?)`,
+			`INSERT INTO logger_masterylog VALUES ('m`+id+`', '`+learner.user+`', 's`+id+`', 1, true, ?, ?, ?)`)
+	}
+	for _, row := range rows {
+		args := []interface{}{}
+		for range strings.Count(row, "?") {
+			args = append(args, day)
+		}
+		if err := kolibri.Exec(row, args...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := &KolibriService{ProviderPlatformID: provider.ID, db: kolibri}
+
+	report, err := service.ImportOutcomesForProgram("channel-1", db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing outcomes: %v", err)
+	}
+	if report.Created != 1 || report.Skipped != 2 || report.Failed != 0 {
+		t.Fatalf("expected only the mastered channel to be an outcome, got %+v", report)
+	}
+	var outcomes []models.Outcome
+	db.Find(&outcomes)
+	if len(outcomes) != 1 || outcomes[0].UserID != users["master"] || outcomes[0].Type != models.ProgressCompletion || outcomes[0].ProgramName != program.Name {
+		t.Fatalf("unexpected outcomes: %+v", outcomes)
+	}
+	report, err = service.ImportOutcomesForProgram("channel-1", db, time.Time{})
+	if err != nil || report.Created != 0 || report.Updated != 0 || report.Skipped != 3 {
+		t.Errorf("expected a second import to leave the outcome unchanged, got %+v (%v)", report, err)
+	}
+	report, err = service.ImportOutcomesForProgram("channel-1", db, day.Add(time.Hour))
+	if err != nil || report.Total() != 0 {
+		t.Errorf("expected nothing mastered since the last import, got %+v (%v)", report, err)
+	}
+
+	// a sync the backend has cancelled stops querying kolibri
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.Client = clientFor(provider.ID)
+	if _, err := service.WithContext(ctx).ImportOutcomesForProgram("channel-1", db, time.Time{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled import to stop, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/jackc/pgx"
 	"github.com/joho/godotenv"
@@ -13,12 +14,28 @@ import (
 	"gorm.io/gorm"
 )
 
+/**
+* The import methods receive the time of the last successful sync, and should
+* only fetch records which have changed since then (a zero time means all).
+* They return a report of every row they created, updated, skipped or failed
+* to import, an error is only returned if the import couldn't be done at all.
+**/
 type ProviderServiceInterface interface {
 	GetUsers(db *gorm.DB) ([]models.ImportUser, error)
-	ImportPrograms(db *gorm.DB) error
-	ImportMilestonesForProgramUser(courseId, userId uint, db *gorm.DB) error
-	ImportActivityForProgram(courseId string, db *gorm.DB) error
-	// TODO: GetOutcomes()
+	ImportPrograms(db *gorm.DB, since time.Time) (*models.ImportReport, error)
+	ImportMilestonesForProgramUser(userId, programId uint, db *gorm.DB, since time.Time) (*models.ImportReport, error)
+	ImportActivityForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error)
+	// final grades and completions of the course, from the provider's gradebook
+	ImportOutcomesForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error)
+	// the roster of the course, as UnlockEd enrollments
+	ImportEnrollmentsForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error)
+	// applies the status of an UnlockEd enrollment to the course in the provider,
+	// returning the provider's id for the enrollment (if it has one)
+	PushEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) (string, error)
+	// takes the user off the course's roster in the provider
+	RemoveEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) error
+	// deactivates (or reactivates) the user's account, e.g. when they are released
+	SetUserActive(userId uint, active bool, db *gorm.DB) error
 }
 
 /**
@@ -2,6 +2,7 @@ package main
 
 import (
 	"UnlockEdv2/src/models"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -27,9 +28,19 @@ func (sh *ServiceHandler) initService(r *http.Request) (ProviderServiceInterface
 	}
 	switch provider.Type {
 	case models.Kolibri:
-		return NewKolibriService(provider), nil
+		service := NewKolibriService(provider)
+		if service == nil {
+			return nil, errors.New("failed to connect to kolibri's database")
+		}
+		return service.WithContext(r.Context()), nil
 	case models.CanvasCloud, models.CanvasOSS:
-		return newCanvasService(provider), nil
+		service := newCanvasService(provider)
+		service.Client = service.Client.WithContext(r.Context())
+		return service, nil
+	case models.Moodle:
+		service := newMoodleService(provider)
+		service.Client = service.Client.WithContext(r.Context())
+		return service, nil
 	}
 	return nil, fmt.Errorf("unsupported provider type: %s", provider.Type)
 }
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+/**
+* MoodleService talks to moodle's web service REST API, using the token of a
+* web service user (the provider's access key) with at least these functions:
+* core_user_get_users, core_course_get_courses_by_field, core_course_get_contents,
+* core_completion_get_activities_completion_status,
+* core_completion_get_course_completion_status and gradereport_user_get_grade_items.
+*
+* Moodle has no web service for its logs, so activity is read from the
+* standard log store in moodle's database (see openMoodleLogs).
+**/
+type MoodleService struct {
+	ProviderPlatformID uint
+	BaseURL            string
+	Token              string
+	Client             *ProviderClient
+	logs               *gorm.DB
+	logTable           string
+}
+
+func newMoodleService(provider *models.ProviderPlatform) *MoodleService {
+	return &MoodleService{
+		ProviderPlatformID: provider.ID,
+		BaseURL:            provider.BaseUrl,
+		Token:              provider.AccessKey,
+		Client:             clientFor(provider.ID),
+		logs:               openMoodleLogs(),
+		logTable:           moodleLogTable(),
+	}
+}
+
+var (
+	moodleLogsOnce sync.Once
+	moodleLogsConn *gorm.DB
+)
+
+/**
+* Connects (once) to moodle's postgres database, configured with the
+* MOODLE_DB_HOST, MOODLE_DB_PORT, MOODLE_DB_USER, MOODLE_DB_PASSWORD and
+* MOODLE_DB_NAME env variables. Returns nil if MOODLE_DB_HOST isn't set.
+**/
+func openMoodleLogs() *gorm.DB {
+	moodleLogsOnce.Do(func() {
+		host := os.Getenv("MOODLE_DB_HOST")
+		if host == "" {
+			log.Warnln("MOODLE_DB_HOST is not set, activity can't be imported from moodle")
+			return
+		}
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=prefer TimeZone=UTC",
+			host, envOr("MOODLE_DB_PORT", "5432"), envOr("MOODLE_DB_USER", "moodle"), os.Getenv("MOODLE_DB_PASSWORD"), envOr("MOODLE_DB_NAME", "moodle"))
+		conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			log.Errorln("error connecting to moodle's database", err)
+			return
+		}
+		log.Info("Connected to Moodle's database")
+		moodleLogsConn = conn
+	})
+	return moodleLogsConn
+}
+
+func moodleLogTable() string {
+	return envOr("MOODLE_DB_PREFIX", "mdl_") + "logstore_standard_log"
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// calls a function of moodle's REST web service, decoding the response into result
+func (ms *MoodleService) call(function string, params url.Values, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("wstoken", ms.Token)
+	params.Set("wsfunction", function)
+	params.Set("moodlewsrestformat", "json")
+	req, err := http.NewRequest(http.MethodPost, ms.BaseURL+"/webservice/rest/server.php", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := ms.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("moodle responded to %s with code: %s", function, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var exception MoodleException
+	if err := json.Unmarshal(body, &exception); err == nil && exception.Exception != "" {
+		return fmt.Errorf("moodle %s failed: %s (%s)", function, exception.Message, exception.ErrorCode)
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (ms *MoodleService) GetUsers(db *gorm.DB) ([]models.ImportUser, error) {
+	// the criteria is required, a wildcard email matches every user
+	params := url.Values{"criteria[0][key]": {"email"}, "criteria[0][value]": {"%"}}
+	var resp MoodleUsersResponse
+	if err := ms.call("core_user_get_users", params, &resp); err != nil {
+		log.Errorln("error fetching users from moodle", err)
+		return nil, err
+	}
+	importUsers := make([]models.ImportUser, 0, len(resp.Users))
+	for _, user := range resp.Users {
+		if user.Suspended || user.Username == "guest" || user.Auth == "nologin" {
+			continue
+		}
+		importUser := user.IntoImportUser()
+		mapped, err := updateMappedUser(db, ms.ProviderPlatformID, &importUser)
+		if err != nil {
+			log.Errorln("error updating mapped moodle user", err)
+			continue
+		}
+		if mapped {
+			continue
+		}
+		importUsers = append(importUsers, importUser)
+	}
+	return importUsers, nil
+}
+
+/**
+* Courses not modified since the last sync are skipped. Their milestone total
+* is the number of activities in the course with completion tracking enabled.
+**/
+func (ms *MoodleService) ImportPrograms(db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	var resp MoodleCoursesResponse
+	if err := ms.call("core_course_get_courses_by_field", nil, &resp); err != nil {
+		log.Errorln("error fetching courses from moodle", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ms.ProviderPlatformID, models.ImportProgramsJob)
+	for _, course := range resp.Courses {
+		// the front page of the site is a course too
+		if course.Format == "site" {
+			continue
+		}
+		externalID := strconv.Itoa(course.ID)
+		if !since.IsZero() && course.TimeModified <= since.Unix() {
+			report.Skip()
+			continue
+		}
+		total, err := ms.countTrackedActivities(course.ID)
+		if err != nil {
+			log.Errorln("error fetching contents of moodle course", err)
+			report.Fail(externalID, err)
+			continue
+		}
+		result, err := upsertProgram(db, course.IntoProgram(ms, total))
+		if err != nil {
+			log.Errorln("error upserting moodle course", err)
+		}
+		recordUpsert(report, externalID, result, err)
+	}
+	return report, nil
+}
+
+func (ms *MoodleService) countTrackedActivities(courseID int) (int, error) {
+	var sections []MoodleSection
+	if err := ms.call("core_course_get_contents", url.Values{"courseid": {strconv.Itoa(courseID)}}, &sections); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, section := range sections {
+		for _, module := range section.Modules {
+			if module.Completion > 0 {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+/**
+* Each activity with completion tracking becomes an activity_completion
+* milestone once the user has completed it. Completions from before the last
+* sync are skipped.
+**/
+func (ms *MoodleService) ImportMilestonesForProgramUser(userId, programId uint, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	fields := log.Fields{"handler": "ImportMilestonesForProgramUser", "user_id": userId, "program_id": programId}
+	var user models.User
+	if err := db.First(&user, "id = ?", userId).Error; err != nil {
+		log.WithFields(fields).Errorln("unable to find user")
+		return nil, err
+	}
+	externalUserID, err := user.GetExternalIDFromProvider(db, ms.ProviderPlatformID)
+	if err != nil || externalUserID == "" {
+		log.WithFields(fields).Errorln("unable to find external user id")
+		return nil, errors.New("user is not mapped to the moodle provider")
+	}
+	var courseID string
+	if err := db.Model(&models.Program{}).Select("external_id").Where("id = ?", programId).First(&courseID).Error; err != nil {
+		log.WithFields(fields).Errorln("unable to find program")
+		return nil, err
+	}
+	var resp MoodleCompletionResponse
+	params := url.Values{"courseid": {courseID}, "userid": {externalUserID}}
+	if err := ms.call("core_completion_get_activities_completion_status", params, &resp); err != nil {
+		log.WithFields(fields).Errorln("error fetching activity completion from moodle", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ms.ProviderPlatformID, models.ImportMilestonesJob)
+	for _, status := range resp.Statuses {
+		milestone := models.Milestone{
+			UserID:      userId,
+			ProgramID:   programId,
+			ExternalID:  strconv.Itoa(status.CmID),
+			Type:        models.ActivityCompletion,
+			IsCompleted: status.State == moodleComplete || status.State == moodleCompletePass,
+		}
+		if status.State == moodleIncomplete {
+			// only an activity which has been completed before (and then reset) is recorded
+			var count int64
+			if err := db.Model(&models.Milestone{}).Where("user_id = ? AND program_id = ? AND external_id = ? AND type = ?",
+				userId, programId, milestone.ExternalID, milestone.Type).Count(&count).Error; err != nil || count == 0 {
+				report.Skip()
+				continue
+			}
+		} else if !since.IsZero() && status.TimeCompleted != 0 && status.TimeCompleted <= since.Unix() {
+			report.Skip()
+			continue
+		}
+		result, err := upsertMilestone(db, &milestone)
+		if err != nil {
+			log.WithFields(fields).Errorln("error upserting moodle milestone", err)
+		}
+		recordUpsert(report, milestone.ExternalID, result, err)
+	}
+	return report, nil
+}
+
+// log events of a user further apart than this belong to separate sessions
+const moodleSessionGap = 30 * time.Minute
+
+type moodleDailyTime struct {
+	UserID    int
+	Day       string
+	Seconds   uint
+	LastEvent time.Time
+}
+
+/**
+* Moodle doesn't record how long users spend in a course, so the time is
+* estimated from the log: the time between consecutive events of a user is
+* counted as time spent, unless they are more than moodleSessionGap apart.
+* The events must be ordered by user and time, the result is per user per day.
+**/
+func moodleDailyTimes(events []MoodleLogEvent) []moodleDailyTime {
+	times := make([]moodleDailyTime, 0)
+	index := make(map[string]int)
+	for idx, event := range events {
+		at := time.Unix(event.TimeCreated, 0).UTC()
+		day := at.Format("2006-01-02")
+		key := fmt.Sprintf("%d:%s", event.UserID, day)
+		pos, ok := index[key]
+		if !ok {
+			pos = len(times)
+			index[key] = pos
+			times = append(times, moodleDailyTime{UserID: event.UserID, Day: day})
+		}
+		times[pos].LastEvent = at
+		if idx == 0 || events[idx-1].UserID != event.UserID {
+			continue
+		}
+		gap := at.Sub(time.Unix(events[idx-1].TimeCreated, 0))
+		if gap > 0 && gap <= moodleSessionGap {
+			times[pos].Seconds += uint(gap.Seconds())
+		}
+	}
+	sort.SliceStable(times, func(i, j int) bool {
+		if times[i].UserID != times[j].UserID {
+			return times[i].UserID < times[j].UserID
+		}
+		return times[i].Day < times[j].Day
+	})
+	return times
+}
+
+func (ms *MoodleService) ImportActivityForProgram(courseId string, db *gorm.DB, since time.Time) (*models.ImportReport, error) {
+	if ms.logs == nil {
+		return nil, errors.New("moodle's database is not configured, set MOODLE_DB_HOST to import activity")
+	}
+	var programID uint
+	if err := db.Model(&models.Program{}).Select("id").First(&programID, "external_id = ? AND provider_platform_id = ?", courseId, ms.ProviderPlatformID).Error; err != nil {
+		log.Errorln("error finding program by external id in ImportActivityForProgram")
+		return nil, err
+	}
+	query := ms.logs.Table(ms.logTable).Select("userid, timecreated").Where("courseid = ? AND userid > 0", courseId)
+	if !since.IsZero() {
+		// the day of the last import is counted again in full, and its activity updated
+		query = query.Where("timecreated >= ?", models.ActivityDay(since).Unix())
+	}
+	var events []MoodleLogEvent
+	if err := query.Order("userid, timecreated").Find(&events).Error; err != nil {
+		log.Errorln("error querying moodle's log store", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ms.ProviderPlatformID, models.ImportActivityJob)
+	userIDs := make(map[int]uint)
+	for _, daily := range moodleDailyTimes(events) {
+		if daily.Seconds == 0 {
+			report.Skip()
+			continue
+		}
+		userID, ok := userIDs[daily.UserID]
+		if !ok {
+			userID = mappedUserID(db, ms.ProviderPlatformID, strconv.Itoa(daily.UserID))
+			userIDs[daily.UserID] = userID
+		}
+		if userID == 0 {
+			// the user hasn't been imported into UnlockEd
+			report.Skip()
+			continue
+		}
+		day, _ := time.Parse("2006-01-02", daily.Day)
+		activity := models.Activity{
+			UserID:       userID,
+			ProgramID:    programID,
+			Type:         models.ProgramInteraction,
+			ExternalID:   courseId,
+			ActivityDate: day,
+			CreatedAt:    daily.LastEvent,
+		}
+		externalID := fmt.Sprintf("%d:%s", daily.UserID, daily.Day)
+		total, err := previousTotalTime(db, &activity)
+		if err != nil {
+			report.Fail(externalID, err)
+			continue
+		}
+		activity.TotalTime = total + daily.Seconds
+		result, err := upsertActivity(db, &activity)
+		if err != nil {
+			log.Errorln("error upserting activity in ImportActivityForProgram", err)
+		}
+		recordUpsert(report, externalID, result, err)
+	}
+	return report, nil
+}
+
+/**
+* Students who have completed the course (per its completion criteria) get a
+* progress_completion outcome, and a grade outcome with their course total.
+* Grades can change after the fact, so since is ignored.
+**/
+func (ms *MoodleService) ImportOutcomesForProgram(courseId string, db *gorm.DB, _ time.Time) (*models.ImportReport, error) {
+	var program models.Program
+	if err := db.Select("id", "name").First(&program, "external_id = ? AND provider_platform_id = ?", courseId, ms.ProviderPlatformID).Error; err != nil {
+		log.Errorln("error finding program by external id in ImportOutcomesForProgram")
+		return nil, err
+	}
+	var grades MoodleGradesResponse
+	if err := ms.call("gradereport_user_get_grade_items", url.Values{"courseid": {courseId}}, &grades); err != nil {
+		log.Errorln("error fetching grades from moodle", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ms.ProviderPlatformID, models.ImportOutcomesJob)
+	for _, userGrades := range grades.UserGrades {
+		externalUserID := strconv.Itoa(userGrades.UserID)
+		userID := mappedUserID(db, ms.ProviderPlatformID, externalUserID)
+		if userID == 0 {
+			report.Skip()
+			continue
+		}
+		var completion MoodleCourseCompletionResponse
+		params := url.Values{"courseid": {courseId}, "userid": {externalUserID}}
+		if err := ms.call("core_completion_get_course_completion_status", params, &completion); err != nil {
+			report.Fail(externalUserID, err)
+			continue
+		}
+		if !completion.CompletionStatus.Completed {
+			report.Skip()
+			continue
+		}
+		outcome := models.Outcome{Type: models.ProgressCompletion, ProgramID: program.ID, ProgramName: program.Name, UserID: userID, Value: "100"}
+		result, err := upsertOutcome(db, &outcome)
+		recordUpsert(report, externalUserID, result, err)
+		if grade := userGrades.CourseTotal(); grade != "" {
+			outcome = models.Outcome{Type: models.ProgramCompletion, ProgramID: program.ID, ProgramName: program.Name, UserID: userID, Value: grade}
+			result, err = upsertOutcome(db, &outcome)
+			recordUpsert(report, externalUserID, result, err)
+		}
+	}
+	return report, nil
+}
+
+/**
+* Moodle doesn't report the state of an enrollment, so the course's active students
+* are enrolled if they aren't already, and the status of existing enrollments is
+* left for UnlockEd to manage.
+**/
+func (ms *MoodleService) ImportEnrollmentsForProgram(courseId string, db *gorm.DB, _ time.Time) (*models.ImportReport, error) {
+	var programId uint
+	if err := db.Model(&models.Program{}).Select("id").First(&programId, "external_id = ? AND provider_platform_id = ?", courseId, ms.ProviderPlatformID).Error; err != nil {
+		log.Errorln("error finding program by external id in ImportEnrollmentsForProgram")
+		return nil, err
+	}
+	params := url.Values{"courseid": {courseId}, "options[0][name]": {"onlyactive"}, "options[0][value]": {"1"}}
+	var participants []MoodleEnrolledUser
+	if err := ms.call("core_enrol_get_enrolled_users", params, &participants); err != nil {
+		log.Errorln("error fetching enrolled users from moodle", err)
+		return nil, err
+	}
+	report := models.NewImportReport(ms.ProviderPlatformID, models.ImportEnrollmentsJob)
+	for _, participant := range participants {
+		externalUserID := strconv.Itoa(participant.ID)
+		userID := mappedUserID(db, ms.ProviderPlatformID, externalUserID)
+		if userID == 0 || !participant.IsStudent() {
+			report.Skip()
+			continue
+		}
+		enrollment := models.Enrollment{UserID: userID, ProgramID: programId, Status: models.EnrollmentActive}
+		result, err := ensureEnrollment(db, &enrollment)
+		recordUpsert(report, externalUserID, result, err)
+	}
+	return report, nil
+}
+
+// moodle rosters are managed in moodle (by its enrolment methods), and imported from there
+func (ms *MoodleService) PushEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) (string, error) {
+	return "", fmt.Errorf("enrolling users in moodle: %w", errors.ErrUnsupported)
+}
+
+func (ms *MoodleService) RemoveEnrollment(courseId string, enrollment *models.Enrollment, db *gorm.DB) error {
+	return fmt.Errorf("unenrolling users in moodle: %w", errors.ErrUnsupported)
+}
+
+func (ms *MoodleService) SetUserActive(userId uint, active bool, db *gorm.DB) error {
+	return fmt.Errorf("suspending users in moodle: %w", errors.ErrUnsupported)
+}
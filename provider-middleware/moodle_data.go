@@ -0,0 +1,175 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// moodle responds to a failed web service call with a 200 and this body
+type MoodleException struct {
+	Exception string `json:"exception"`
+	ErrorCode string `json:"errorcode"`
+	Message   string `json:"message"`
+}
+
+type MoodleUser struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	FullName  string `json:"fullname"`
+	Email     string `json:"email"`
+	Auth      string `json:"auth"`
+	Suspended bool   `json:"suspended"`
+}
+
+type MoodleUsersResponse struct {
+	Users []MoodleUser `json:"users"`
+}
+
+func (mu *MoodleUser) IntoImportUser() models.ImportUser {
+	first, last := mu.FirstName, mu.LastName
+	if first == "" && last == "" {
+		first, last, _ = strings.Cut(mu.FullName, " ")
+	}
+	return models.ImportUser{
+		ExternalUserID:   fmt.Sprintf("%d", mu.ID),
+		ExternalUsername: mu.Username,
+		Username:         mu.Username,
+		NameFirst:        first,
+		NameLast:         last,
+		Email:            mu.Email,
+	}
+}
+
+type MoodleCourse struct {
+	ID           int    `json:"id"`
+	FullName     string `json:"fullname"`
+	ShortName    string `json:"shortname"`
+	Summary      string `json:"summary"`
+	Format       string `json:"format"`
+	Visible      int    `json:"visible"`
+	TimeModified int64  `json:"timemodified"`
+}
+
+type MoodleCoursesResponse struct {
+	Courses []MoodleCourse `json:"courses"`
+}
+
+var (
+	htmlBlockTags = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|h[1-6])\b[^>]*>`)
+	htmlTags      = regexp.MustCompile(`<[^>]*>`)
+)
+
+func (mc *MoodleCourse) IntoProgram(service *MoodleService, totalMilestones int) *models.Program {
+	// course summaries are html
+	description := htmlTags.ReplaceAllString(htmlBlockTags.ReplaceAllString(mc.Summary, " "), "")
+	description = strings.Join(strings.Fields(description), " ")
+	return &models.Program{
+		ProviderPlatformID: service.ProviderPlatformID,
+		Name:               truncate(mc.FullName, 60),
+		AltName:            mc.ShortName,
+		Description:        truncate(description, 510),
+		ExternalID:         fmt.Sprintf("%d", mc.ID),
+		ExternalURL:        fmt.Sprintf("%s/course/view.php?id=%d", service.BaseURL, mc.ID),
+		Type:               models.FixedEnrollment,
+		OutcomeTypes:       "completion, grade",
+		// course images are only served to an authenticated moodle session
+		ThumbnailURL:            "",
+		TotalProgressMilestones: uint(totalMilestones),
+	}
+}
+
+func truncate(str string, length int) string {
+	runes := []rune(str)
+	if len(runes) <= length {
+		return str
+	}
+	return string(runes[:length])
+}
+
+type MoodleSection struct {
+	ID      int            `json:"id"`
+	Modules []MoodleModule `json:"modules"`
+}
+
+type MoodleModule struct {
+	ID         int    `json:"id"`
+	ModName    string `json:"modname"`
+	Completion int    `json:"completion"` // 0: none, 1: manual, 2: automatic
+}
+
+// states of an activity completion, moodle's COMPLETION_* constants
+const (
+	moodleIncomplete   = 0
+	moodleComplete     = 1
+	moodleCompletePass = 2
+	moodleCompleteFail = 3
+)
+
+type MoodleCompletionStatus struct {
+	CmID          int    `json:"cmid"`
+	ModName       string `json:"modname"`
+	State         int    `json:"state"`
+	TimeCompleted int64  `json:"timecompleted"`
+}
+
+type MoodleCompletionResponse struct {
+	Statuses []MoodleCompletionStatus `json:"statuses"`
+}
+
+// a row of moodle's standard log store
+type MoodleLogEvent struct {
+	UserID      int   `gorm:"column:userid"`
+	TimeCreated int64 `gorm:"column:timecreated"`
+}
+
+type MoodleGradeItem struct {
+	ItemType       string   `json:"itemtype"`
+	GradeRaw       *float64 `json:"graderaw"`
+	GradeFormatted string   `json:"gradeformatted"`
+}
+
+type MoodleUserGrades struct {
+	UserID     int               `json:"userid"`
+	GradeItems []MoodleGradeItem `json:"gradeitems"`
+}
+
+type MoodleGradesResponse struct {
+	UserGrades []MoodleUserGrades `json:"usergrades"`
+}
+
+// the formatted course total, which uses the course's grade display type (e.g. "88.50" or "B+")
+func (grades *MoodleUserGrades) CourseTotal() string {
+	for _, item := range grades.GradeItems {
+		if item.ItemType == "course" && item.GradeRaw != nil && item.GradeFormatted != "-" {
+			return item.GradeFormatted
+		}
+	}
+	return ""
+}
+
+type MoodleCourseCompletionResponse struct {
+	CompletionStatus struct {
+		Completed bool `json:"completed"`
+	} `json:"completionstatus"`
+}
+
+// a participant of a course, from core_enrol_get_enrolled_users
+type MoodleEnrolledUser struct {
+	ID    int `json:"id"`
+	Roles []struct {
+		ShortName string `json:"shortname"`
+	} `json:"roles"`
+}
+
+func (mu *MoodleEnrolledUser) IsStudent() bool {
+	for _, role := range mu.Roles {
+		if role.ShortName == "student" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const moodleTestToken = "moodle-test-token"
+
+/**
+* Serves the responses recorded from a moodle 4.4 site in test_data/moodle,
+* named after the web service function (and course id, where it matters)
+**/
+func newMoodleStub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webservice/rest/server.php" || r.ParseForm() != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("wstoken") != moodleTestToken {
+			_, _ = w.Write([]byte(`{"exception":"moodle_exception","errorcode":"invalidtoken","message":"Invalid token - token not found"}`))
+			return
+		}
+		function := r.PostForm.Get("wsfunction")
+		candidates := []string{function + ".json"}
+		if courseID := r.PostForm.Get("courseid"); courseID != "" {
+			candidates = append([]string{function + "_" + courseID + ".json"}, candidates...)
+		}
+		for _, name := range candidates {
+			if body, err := os.ReadFile(filepath.Join("test_data", "moodle", name)); err == nil {
+				_, _ = w.Write(body)
+				return
+			}
+		}
+		t.Errorf("no recorded response for %s", function)
+		_, _ = w.Write([]byte(`{"exception":"webservice_access_exception","errorcode":"accessexception","message":"Access control exception"}`))
+	}))
+}
+
+func openTestDB(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("unable to open test database: %v", err)
+	}
+	return db
+}
+
+func setupMoodleTest(t *testing.T) (*MoodleService, *gorm.DB, *gorm.DB) {
+	stub := newMoodleStub(t)
+	t.Cleanup(stub.Close)
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Facility{}, &models.User{}, &models.ProviderPlatform{}, &models.ProviderUserMapping{},
+		&models.Program{}, &models.Milestone{}, &models.Outcome{}, &models.Activity{}, &models.Enrollment{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	provider := models.ProviderPlatform{Type: models.Moodle, Name: "Moodle", BaseUrl: stub.URL, AccessKey: moodleTestToken, State: models.Enabled}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatal(err)
+	}
+	// jdoe has already been imported, msmith has not
+	user := models.User{Username: "janedoe", NameFirst: "Jane", NameLast: "Smith", Email: "janedoe@unlocked.v2", Password: "password"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	mapping := models.ProviderUserMapping{UserID: user.ID, ProviderPlatformID: provider.ID, ExternalUserID: "3", ExternalUsername: "janedoe"}
+	if err := db.Create(&mapping).Error; err != nil {
+		t.Fatal(err)
+	}
+	logs := openTestDB(t, t.Name()+"_moodle")
+	if err := logs.Exec("CREATE TABLE mdl_logstore_standard_log (id INTEGER PRIMARY KEY, userid INTEGER, courseid INTEGER, timecreated INTEGER)").Error; err != nil {
+		t.Fatal(err)
+	}
+	service := newMoodleService(&provider)
+	service.logs = logs
+	service.logTable = "mdl_logstore_standard_log"
+	return service, db, logs
+}
+
+func TestMoodleGetUsers(t *testing.T) {
+	service, db, _ := setupMoodleTest(t)
+	users, err := service.GetUsers(db)
+	if err != nil {
+		t.Fatalf("error getting users: %v", err)
+	}
+	usernames := []string{}
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	// guest and suspended users are left out, jdoe is already mapped
+	if strings.Join(usernames, ",") != "admin,msmith" {
+		t.Errorf("expected users admin and msmith, got %v", usernames)
+	}
+	if users[1].ExternalUserID != "4" || users[1].NameFirst != "Marcus" || users[1].Email != "msmith@moodle.test" {
+		t.Errorf("user was not converted correctly: %+v", users[1])
+	}
+	var mapped models.User
+	if err := db.Joins("JOIN provider_user_mappings m ON m.user_id = users.id").First(&mapped, "m.external_username = ?", "jdoe").Error; err != nil {
+		t.Fatal("expected the existing mapping to be updated with the moodle username")
+	}
+	if mapped.NameLast != "Doe" {
+		t.Errorf("expected the mapped user's name to be updated, got %s", mapped.NameLast)
+	}
+	service.Token = "wrong"
+	if _, err := service.GetUsers(db); err == nil || !strings.Contains(err.Error(), "invalidtoken") {
+		t.Errorf("expected an invalid token error, got %v", err)
+	}
+}
+
+func TestMoodleImportPrograms(t *testing.T) {
+	service, db, _ := setupMoodleTest(t)
+	report, err := service.ImportPrograms(db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing programs: %v", err)
+	}
+	// the site course is left out and course 3 can't be read
+	if report.Created != 1 || report.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].ExternalID != "3" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var program models.Program
+	if err := db.First(&program, "external_id = ?", "2").Error; err != nil {
+		t.Fatal("expected course 2 to be imported")
+	}
+	if program.Name != "Introduction to Business Communication" || program.AltName != "BUS101" {
+		t.Errorf("unexpected program name: %s (%s)", program.Name, program.AltName)
+	}
+	if program.Description != "Writing and presenting in the workplace." {
+		t.Errorf("expected the html to be stripped from the description, got %q", program.Description)
+	}
+	if program.TotalProgressMilestones != 3 {
+		t.Errorf("expected 3 activities with completion tracking, got %d", program.TotalProgressMilestones)
+	}
+	report, err = service.ImportPrograms(db, time.Unix(1718200000, 0))
+	if err != nil {
+		t.Fatalf("error importing programs: %v", err)
+	}
+	if report.Skipped != 2 || report.Total() != 2 {
+		t.Errorf("expected unmodified courses to be skipped, got %+v", report)
+	}
+}
+
+func TestMoodleImportMilestones(t *testing.T) {
+	service, db, _ := setupMoodleTest(t)
+	if _, err := service.ImportPrograms(db, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	var program models.Program
+	if err := db.First(&program, "external_id = ?", "2").Error; err != nil {
+		t.Fatal(err)
+	}
+	var mapping models.ProviderUserMapping
+	if err := db.First(&mapping, "external_user_id = ?", "3").Error; err != nil {
+		t.Fatal(err)
+	}
+	report, err := service.ImportMilestonesForProgramUser(mapping.UserID, program.ID, db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing milestones: %v", err)
+	}
+	// the quiz has never been completed
+	if report.Created != 2 || report.Skipped != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var milestones []models.Milestone
+	db.Order("external_id").Find(&milestones, "user_id = ? AND program_id = ?", mapping.UserID, program.ID)
+	if len(milestones) != 2 || milestones[0].Type != models.ActivityCompletion {
+		t.Fatalf("unexpected milestones: %+v", milestones)
+	}
+	if !milestones[0].IsCompleted || milestones[1].IsCompleted {
+		t.Error("expected the page to be complete, and the failed assignment not to be")
+	}
+	report, err = service.ImportMilestonesForProgramUser(mapping.UserID, program.ID, db, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Skipped != 3 {
+		t.Errorf("expected a second import to leave the milestones unchanged, got %+v", report)
+	}
+}
+
+func TestMoodleImportActivity(t *testing.T) {
+	service, db, logs := setupMoodleTest(t)
+	if _, err := service.ImportPrograms(db, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 6, 20, 13, 0, 0, 0, time.UTC).Unix()
+	events := []struct {
+		user   int
+		offset int64
+	}{
+		// two sessions for jdoe: 10m + 15m, then 5m after a break of over half an hour
+		{3, 0}, {3, 600}, {3, 1500}, {3, 7200}, {3, 7500},
+		// msmith hasn't been imported
+		{4, 0}, {4, 300},
+	}
+	for _, event := range events {
+		if err := logs.Exec("INSERT INTO mdl_logstore_standard_log (userid, courseid, timecreated) VALUES (?, ?, ?)", event.user, 2, start+event.offset).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := service.ImportActivityForProgram("2", db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing activity: %v", err)
+	}
+	if report.Created != 1 || report.Skipped != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var activity models.Activity
+	if err := db.First(&activity, "external_id = ?", "2").Error; err != nil {
+		t.Fatal("expected activity to be created")
+	}
+	if activity.TimeDelta != 1800 || activity.TotalTime != 1800 {
+		t.Errorf("expected 30 minutes of activity, got delta %d total %d", activity.TimeDelta, activity.TotalTime)
+	}
+	if activity.CreatedAt.Unix() != start+7500 {
+		t.Errorf("expected the activity to be dated by the last event, got %v", activity.CreatedAt)
+	}
+	// a later event on the same day updates that day's activity
+	if err := logs.Exec("INSERT INTO mdl_logstore_standard_log (userid, courseid, timecreated) VALUES (?, ?, ?)", 3, 2, start+7800).Error; err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := service.ImportActivityForProgram("2", db, time.Unix(start+7500, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var activities []models.Activity
+	db.Find(&activities, "external_id = ?", "2")
+	if len(activities) != 1 || activities[0].TimeDelta != 2100 || activities[0].TotalTime != 2100 {
+		t.Errorf("expected re-imports to update the day's activity to 35 minutes, got %+v", activities)
+	}
+}
+
+func TestMoodleImportOutcomes(t *testing.T) {
+	service, db, _ := setupMoodleTest(t)
+	if _, err := service.ImportPrograms(db, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := service.ImportOutcomesForProgram("2", db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing outcomes: %v", err)
+	}
+	// jdoe gets a completion and a grade, msmith hasn't been imported
+	if report.Created != 2 || report.Skipped != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var outcomes []models.Outcome
+	db.Order("type").Find(&outcomes)
+	if len(outcomes) != 2 || outcomes[0].Type != models.ProgramCompletion || outcomes[0].Value != "88.50" {
+		t.Fatalf("unexpected outcomes: %+v", outcomes)
+	}
+	if outcomes[1].Type != models.ProgressCompletion || outcomes[1].ProgramName != "Introduction to Business Communication" {
+		t.Errorf("unexpected completion outcome: %+v", outcomes[1])
+	}
+	report, err = service.ImportOutcomesForProgram("2", db, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Updated != 0 {
+		t.Errorf("expected a second import to leave the outcomes unchanged, got %+v", report)
+	}
+}
+
+func TestMoodleImportEnrollments(t *testing.T) {
+	service, db, _ := setupMoodleTest(t)
+	if _, err := service.ImportPrograms(db, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := service.ImportEnrollmentsForProgram("2", db, time.Time{})
+	if err != nil {
+		t.Fatalf("error importing enrollments: %v", err)
+	}
+	// jdoe is enrolled, the teacher and msmith (not imported) are skipped
+	if report.Created != 1 || report.Skipped != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	var enrollment models.Enrollment
+	if err := db.First(&enrollment).Error; err != nil || enrollment.Status != models.EnrollmentActive {
+		t.Fatalf("expected an active enrollment, got %+v (%v)", enrollment, err)
+	}
+	// a withdrawal made in UnlockEd isn't undone by the next import
+	db.Model(&enrollment).Update("status", models.EnrollmentWithdrawn)
+	if _, err := service.ImportEnrollmentsForProgram("2", db, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&enrollment, enrollment.ID)
+	if enrollment.Status != models.EnrollmentWithdrawn {
+		t.Errorf("expected the enrollment to stay withdrawn, got %s", enrollment.Status)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+* The backend keeps a cursor for each provider and import type, and passes it
+* as ?since=<RFC3339> so that only records changed after the last successful
+* sync are fetched. A zero time means a full import.
+**/
+func parseSince(r *http.Request) (time.Time, error) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, since)
+}
+
+/**
+* Updates the mapping (and the user's name) for a provider user that has
+* already been imported. Returns false if the user has no mapping yet.
+**/
+func updateMappedUser(db *gorm.DB, providerID uint, user *models.ImportUser) (bool, error) {
+	var mapping models.ProviderUserMapping
+	err := db.Where("provider_platform_id = ? AND external_user_id = ?", providerID, user.ExternalUserID).First(&mapping).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if user.ExternalUsername != "" && mapping.ExternalUsername != user.ExternalUsername {
+		if err := db.Model(&mapping).Update("external_username", user.ExternalUsername).Error; err != nil {
+			return true, err
+		}
+	}
+	if user.NameFirst == "" || user.NameLast == "" {
+		return true, nil
+	}
+	return true, db.Model(&models.User{}).Where("id = ? AND (name_first <> ? OR name_last <> ?)", mapping.UserID, user.NameFirst, user.NameLast).
+		Updates(map[string]interface{}{"name_first": user.NameFirst, "name_last": user.NameLast}).Error
+}
+
+type upsertResult int
+
+const (
+	rowUnchanged upsertResult = iota
+	rowCreated
+	rowUpdated
+)
+
+// counts the row in the report, by what the upsert did with it
+func recordUpsert(report *models.ImportReport, externalID string, result upsertResult, err error) {
+	switch {
+	case err != nil:
+		report.Fail(externalID, err)
+	case result == rowCreated:
+		report.Create()
+	case result == rowUpdated:
+		report.Update()
+	default:
+		report.Skip()
+	}
+}
+
+// creates the program, or updates the existing program with the same external ID
+func upsertProgram(db *gorm.DB, program *models.Program) (upsertResult, error) {
+	var existing models.Program
+	err := db.Where("provider_platform_id = ? AND external_id = ?", program.ProviderPlatformID, program.ExternalID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rowCreated, db.Create(program).Error
+	} else if err != nil {
+		return rowUnchanged, err
+	}
+	program.ID = existing.ID
+	if program.ThumbnailURL == "" {
+		program.ThumbnailURL = existing.ThumbnailURL
+	}
+	return rowUpdated, db.Model(&existing).Select("name", "alt_name", "description", "thumbnail_url", "type", "outcome_types", "external_url", "total_progress_milestones").Updates(program).Error
+}
+
+// insert on conflict update, for a row which was created since it was looked up (e.g. by an overlapping import)
+func onConflictUpdate(key []string, columns ...string) clause.OnConflict {
+	conflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns(append(columns, "updated_at"))}
+	for _, column := range key {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: column})
+	}
+	return conflict
+}
+
+/**
+* Creates the milestone, or updates the completion of an existing one with the same
+* external ID. A milestone which was deleted still holds its key, so it is restored
+* rather than created again.
+**/
+func upsertMilestone(db *gorm.DB, milestone *models.Milestone) (upsertResult, error) {
+	var existing models.Milestone
+	err := db.Unscoped().Where("user_id = ? AND program_id = ? AND external_id = ? AND type = ?", milestone.UserID, milestone.ProgramID, milestone.ExternalID, milestone.Type).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rowCreated, db.Clauses(onConflictUpdate(models.MilestoneKey, "is_completed", "deleted_at")).Create(milestone).Error
+	} else if err != nil {
+		return rowUnchanged, err
+	}
+	milestone.ID = existing.ID
+	if existing.DeletedAt.Valid {
+		return rowUpdated, db.Unscoped().Model(&existing).Updates(map[string]interface{}{"deleted_at": nil, "is_completed": milestone.IsCompleted}).Error
+	}
+	if existing.IsCompleted == milestone.IsCompleted {
+		return rowUnchanged, nil
+	}
+	return rowUpdated, db.Model(&existing).Update("is_completed", milestone.IsCompleted).Error
+}
+
+// the user's total time on the content as of the last activity before the day of this one
+func previousTotalTime(db *gorm.DB, activity *models.Activity) (uint, error) {
+	var total uint
+	err := db.Model(&models.Activity{}).Select("total_time").
+		Where("user_id = ? AND program_id = ? AND external_id = ? AND activity_date < ?", activity.UserID, activity.ProgramID, activity.ExternalID, activity.ActivityDate).
+		Order("activity_date DESC").Limit(1).Scan(&total).Error
+	return total, err
+}
+
+/**
+* There is one activity per day for each piece of content a user spends time on.
+* The activity's TotalTime is the user's total time on the content, and the delta
+* is the time since the previous day's activity, so importing the same total
+* again (on the same day) leaves the activity unchanged.
+**/
+func upsertActivity(db *gorm.DB, activity *models.Activity) (upsertResult, error) {
+	if activity.ActivityDate.IsZero() {
+		at := activity.CreatedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		activity.ActivityDate = models.ActivityDay(at)
+	}
+	previous, err := previousTotalTime(db, activity)
+	if err != nil {
+		return rowUnchanged, err
+	}
+	activity.TimeDelta = 0
+	if activity.TotalTime > previous {
+		activity.TimeDelta = activity.TotalTime - previous
+	}
+	var existing models.Activity
+	err = db.Where("user_id = ? AND program_id = ? AND external_id = ? AND activity_date = ?", activity.UserID, activity.ProgramID, activity.ExternalID, activity.ActivityDate).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rowCreated, db.Clauses(onConflictUpdate(models.ActivityKey, "total_time", "time_delta")).Create(activity).Error
+	} else if err != nil {
+		return rowUnchanged, err
+	}
+	activity.ID = existing.ID
+	if existing.TotalTime == activity.TotalTime && existing.TimeDelta == activity.TimeDelta {
+		return rowUnchanged, nil
+	}
+	return rowUpdated, db.Model(&existing).Select("total_time", "time_delta").Updates(activity).Error
+}
+
+/**
+* A user has a single outcome of each type per program, so a grade which
+* changed in the provider's gradebook replaces the old one
+**/
+func upsertOutcome(db *gorm.DB, outcome *models.Outcome) (upsertResult, error) {
+	var existing models.Outcome
+	err := db.Where("user_id = ? AND program_id = ? AND type = ?", outcome.UserID, outcome.ProgramID, outcome.Type).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rowCreated, db.Create(outcome).Error
+	} else if err != nil {
+		return rowUnchanged, err
+	}
+	outcome.ID = existing.ID
+	if existing.Value == outcome.Value {
+		return rowUnchanged, nil
+	}
+	return rowUpdated, db.Model(&existing).Update("value", outcome.Value).Error
+}
+
+// looks up the UnlockEd user mapped to a user of the provider, 0 if they haven't been imported or their mapping is suspended
+func mappedUserID(db *gorm.DB, providerID uint, externalUserID string) uint {
+	var userID uint
+	if err := db.Model(&models.ProviderUserMapping{}).Select("user_id").First(&userID, "external_user_id = ? AND provider_platform_id = ? AND suspended_at IS NULL", externalUserID, providerID).Error; err != nil {
+		return 0
+	}
+	return userID
+}
+
+/**
+* Creates the enrollment, or updates the status and dates of the user's existing
+* enrollment in the program, for providers which track the state of enrollments
+**/
+func upsertEnrollment(db *gorm.DB, enrollment *models.Enrollment) (upsertResult, error) {
+	var existing models.Enrollment
+	err := db.Where("user_id = ? AND program_id = ?", enrollment.UserID, enrollment.ProgramID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rowCreated, db.Create(enrollment).Error
+	} else if err != nil {
+		return rowUnchanged, err
+	}
+	enrollment.ID = existing.ID
+	if existing.Status == enrollment.Status && existing.ExternalID == enrollment.ExternalID &&
+		sameTime(existing.StartDate, enrollment.StartDate) && sameTime(existing.EndDate, enrollment.EndDate) {
+		return rowUnchanged, nil
+	}
+	return rowUpdated, db.Model(&existing).Select("status", "start_date", "end_date", "external_id").Updates(enrollment).Error
+}
+
+/**
+* For providers with no enrollment states: enrolls the user if they aren't on the
+* roster yet, and otherwise leaves the enrollment (and its status) as it is
+**/
+func ensureEnrollment(db *gorm.DB, enrollment *models.Enrollment) (upsertResult, error) {
+	var count int64
+	if err := db.Model(&models.Enrollment{}).Where("user_id = ? AND program_id = ?", enrollment.UserID, enrollment.ProgramID).Count(&count).Error; err != nil {
+		return rowUnchanged, err
+	}
+	if count > 0 {
+		return rowUnchanged, nil
+	}
+	return rowCreated, db.Create(enrollment).Error
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+var errUnmappedUser = errors.New("user has no account in the provider")
+
+// the provider's id for an UnlockEd user, who must have an account there (that isn't suspended) to be enrolled
+func mappedExternalUserID(db *gorm.DB, providerID, userID uint) (string, error) {
+	var externalUserID string
+	err := db.Model(&models.ProviderUserMapping{}).Select("external_user_id").First(&externalUserID, "user_id = ? AND provider_platform_id = ? AND suspended_at IS NULL", userID, providerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errUnmappedUser
+	}
+	return externalUserID, err
+}
+
+// the provider's id for the user's account, whether or not its mapping is suspended
+func userAccountID(db *gorm.DB, providerID, userID uint) (string, error) {
+	var externalUserID string
+	err := db.Model(&models.ProviderUserMapping{}).Select("external_user_id").First(&externalUserID, "user_id = ? AND provider_platform_id = ?", userID, providerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errUnmappedUser
+	}
+	return externalUserID, err
+}
+
+/**
+* Learners are grouped in the provider's course by the facility they are at
+* (a canvas section, or a kolibri learner group), named after the facility.
+* An empty name means the user isn't at a facility, and isn't put in a group.
+**/
+func facilityGroupName(db *gorm.DB, userID uint) string {
+	var name string
+	db.Model(&models.User{}).Select("facilities.name").
+		Joins("JOIN facilities ON facilities.id = users.facility_id").
+		Where("users.id = ?", userID).Scan(&name)
+	return name
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"UnlockEdv2/src/models"
+	"testing"
+)
+
+func TestUpsertMilestoneRestoresDeleted(t *testing.T) {
+	db := openTestDB(t, t.Name())
+	if err := db.AutoMigrate(&models.Milestone{}); err != nil {
+		t.Fatalf("unable to migrate test database: %v", err)
+	}
+	milestone := func(completed bool) *models.Milestone {
+		return &models.Milestone{UserID: 1, ProgramID: 1, ExternalID: "quiz-1", Type: models.QuizSubmission, IsCompleted: completed}
+	}
+	if result, err := upsertMilestone(db, milestone(false)); err != nil || result != rowCreated {
+		t.Fatalf("expected the milestone to be created, got %v (%v)", result, err)
+	}
+	if err := db.Where("external_id = ?", "quiz-1").Delete(&models.Milestone{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// imported again, the deleted milestone is brought back with its new completion
+	if result, err := upsertMilestone(db, milestone(true)); err != nil || result != rowUpdated {
+		t.Fatalf("expected the deleted milestone to be restored, got %v (%v)", result, err)
+	}
+	var milestones []models.Milestone
+	if err := db.Find(&milestones).Error; err != nil || len(milestones) != 1 || !milestones[0].IsCompleted {
+		t.Errorf("expected one completed milestone, got %+v (%v)", milestones, err)
+	}
+	if result, err := upsertMilestone(db, milestone(true)); err != nil || result != rowUnchanged {
+		t.Errorf("expected the restored milestone to be left as it is, got %v (%v)", result, err)
+	}
+}